@@ -0,0 +1,324 @@
+// See Also:
+// https://git-scm.com/docs/git-add
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type AddOptions struct {
+	All         bool // -A
+	Update      bool // -u
+	DryRun      bool // -n
+	Verbose     bool // -v
+	Force       bool // -f
+	IntentToAdd bool // -N
+}
+
+func add_cmd(opts AddOptions, args []string) {
+	repop, prefix, err := setup_git_directory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
+		exit(128)
+	}
+	root := filepath.Dir(repop)
+
+	if len(args) == 0 {
+		if opts.All == false && opts.Update == false {
+			fmt.Fprintf(os.Stderr, "Nothing specified, nothing added.\n")
+			fmt.Fprintf(os.Stderr, "hint: Maybe you wanted to say 'git add .'?\n")
+			if get_config_bool(repop, "advice.addemptypathspec", true) {
+				fmt.Fprintf(os.Stderr, "hint: Turn this message off by running\n")
+				fmt.Fprintf(os.Stderr, "hint: \"git config advice.addEmptyPathspec false\"\n")
+			}
+			return
+		}
+		// -A and -u without pathspec work on the whole tree
+		args = []string{":/"}
+	}
+
+	d, err := lock_dircache(repop)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		exit(128)
+	}
+
+	pathspec, err := parse_pathspec(root, prefix, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		exit(128)
+	}
+
+	x := new_ignore_rules(repop)
+	if err := x.add_exclude_standard(repop); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+		exit(128)
+	}
+
+	// items which match nothing are errors
+	matched := make(map[*PathspecItem]bool)
+	match := func(p string) bool {
+		if pathspec.match(p) == false {
+			return false
+		}
+		for _, item := range pathspec.Items {
+			if item.Magic&PATHSPEC_EXCLUDE == 0 && item.match(p) {
+				matched[item] = true
+			}
+		}
+		return true
+	}
+
+	// tracked files are updated or removed in index order
+	type add_action struct {
+		path   string
+		remove bool
+	}
+	var actions []add_action
+	for i, e := range d.Entries {
+		p := string(e.PathName)
+		if e.removed || (i > 0 && bytes.Equal(d.Entries[i-1].PathName, e.PathName)) {
+			continue
+		}
+		if match(p) == false || e.skip_worktree() {
+			continue
+		}
+
+		deleted, err := is_deleted(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+			exit(128)
+		}
+		if deleted {
+			actions = append(actions, add_action{p, true})
+			continue
+		}
+
+		modified := e.stage() != 0 || e.intent_to_add()
+		if modified == false {
+			modified, err = is_modified(d, e)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+				exit(128)
+			}
+		}
+		if modified {
+			actions = append(actions, add_action{p, false})
+		}
+	}
+
+	// untracked files are added after tracked ones
+	var news []string
+	if opts.Update == false {
+		files, err := list_untracked_files(root, "", d, x, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+			exit(128)
+		}
+		if opts.Force {
+			ignored, err := list_untracked_files(root, "", d, x, true)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+				exit(128)
+			}
+			files = append(files, ignored...)
+			sort.Strings(files)
+		}
+		for _, f := range files {
+			if match(f) {
+				news = append(news, f)
+			}
+		}
+	}
+
+	// ignored paths named explicitly are reported unless -f is given
+	var ignored_paths []string
+	for _, item := range pathspec.Items {
+		if item.Magic&PATHSPEC_EXCLUDE != 0 || item.Wildcard || matched[item] {
+			continue
+		}
+		p := strings.TrimSuffix(item.Match, "/")
+		info, err := os.Lstat(filepath.Join(root, p))
+		if err != nil {
+			continue
+		}
+		// the item is satisfied by an existing path (ex. an empty directory)
+		matched[item] = true
+
+		if opts.Force || opts.Update || len(p) == 0 {
+			continue
+		}
+		if ig := ignored_leading_path(x, p, info.IsDir()); len(ig) > 0 {
+			ignored_paths = append(ignored_paths, ig)
+		}
+	}
+
+	for _, item := range pathspec.Items {
+		if item.Magic&PATHSPEC_EXCLUDE == 0 && matched[item] == false {
+			fmt.Fprintf(os.Stderr, "fatal: pathspec '%s' did not match any files\n", item.Original)
+			exit(128)
+		}
+	}
+
+	if len(ignored_paths) > 0 {
+		fmt.Fprintf(os.Stderr, "The following paths are ignored by one of your .gitignore files:\n")
+		for _, p := range ignored_paths {
+			fmt.Fprintf(os.Stderr, "%s\n", p)
+		}
+		fmt.Fprintf(os.Stderr, "hint: Use -f if you really want to add them.\n")
+		if get_config_bool(repop, "advice.addignoredfile", true) {
+			fmt.Fprintf(os.Stderr, "hint: Turn this message off by running\n")
+			fmt.Fprintf(os.Stderr, "hint: \"git config advice.addIgnoredFile false\"\n")
+		}
+	}
+
+	// files are stat'ed and hashed concurrently
+	if opts.DryRun == false {
+		var paths []string
+		for _, a := range actions {
+			if a.remove == false {
+				paths = append(paths, a.path)
+			}
+		}
+		for _, p := range news {
+			if strings.HasSuffix(p, "/") {
+				paths = append(paths, strings.TrimSuffix(p, "/"))
+			} else if opts.IntentToAdd == false {
+				paths = append(paths, p)
+			}
+		}
+		preload_worktree_files(d, paths, true, false)
+	}
+
+	verbose := opts.Verbose || opts.DryRun
+	for _, a := range actions {
+		if a.remove {
+			if verbose {
+				fmt.Printf("remove '%s'\n", a.path)
+			}
+			if opts.DryRun == false {
+				remove_dircache_entry(d, a.path)
+			}
+			continue
+		}
+		if verbose {
+			fmt.Printf("add '%s'\n", a.path)
+		}
+		if opts.DryRun == false {
+			update_dircache(d, a.path, true, false)
+		}
+	}
+	advised := false
+	for _, p := range news {
+		// nested repositories ('dir/') are added as gitlinks
+		if strings.HasSuffix(p, "/") {
+			p = strings.TrimSuffix(p, "/")
+			if _, err := resolve_gitlink_head(p); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				fmt.Fprintf(os.Stderr, "fatal: adding files failed\n")
+				exit(128)
+			}
+			warn_embedded_repository(repop, p, advised)
+			advised = true
+			if verbose {
+				fmt.Printf("add '%s'\n", p)
+			}
+			if opts.DryRun == false {
+				update_dircache(d, p, true, false)
+			}
+			continue
+		}
+
+		if verbose {
+			fmt.Printf("add '%s'\n", p)
+		}
+		if opts.DryRun {
+			continue
+		}
+		if opts.IntentToAdd {
+			if err := add_intent_to_add(d, p); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+				exit(128)
+			}
+			continue
+		}
+		update_dircache(d, p, true, false)
+	}
+
+	if opts.DryRun == false {
+		if err := write_dircache(d, repop); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+			exit(128)
+		}
+	}
+
+	if len(ignored_paths) > 0 {
+		exit(1)
+	}
+}
+
+// add_intent_to_add records only the path of the file with the empty blob. (add -N)
+func add_intent_to_add(d *Dircache, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	// the empty blob is written so that the index refers an existing object
+	sha, err := hash_object(true, strings.NewReader(""))
+	if err != nil {
+		return err
+	}
+
+	var key [20]byte
+	copy(key[:], sha)
+	e := new_dircache_entry(path, key, dircache_mode(info), 0)
+	e.ExtendedFlags |= DIRCACHE_EXTENDED_FLAG_INTENT_TO_ADD
+	add_dircache_entry(d, e)
+	return nil
+}
+
+// warn_embedded_repository warns that the nested repository is added as a gitlink.
+// the hint is shown only for the first one.
+func warn_embedded_repository(repop string, path string, advised bool) {
+	fmt.Fprintf(os.Stderr, "warning: adding embedded git repository: %s\n", path)
+	if advised || get_config_bool(repop, "advice.addembeddedrepo", true) == false {
+		return
+	}
+	hint := `You've added another git repository inside your current repository.
+Clones of the outer repository will not contain the contents of
+the embedded repository and will not know how to obtain it.
+If you meant to add a submodule, use:
+
+	git submodule add <url> %s
+
+If you added this path by mistake, you can remove it from the
+index with:
+
+	git rm --cached %s
+
+See "git help submodule" for more information.`
+	for _, line := range strings.Split(fmt.Sprintf(hint, path, path), "\n") {
+		fmt.Fprintf(os.Stderr, "hint: %s\n", line)
+	}
+}
+
+// ignored_leading_path returns the path or its leading directory which is ignored. "" is returned if not ignored.
+func ignored_leading_path(x *IgnoreRules, path string, is_dir bool) string {
+	components := strings.Split(path, "/")
+	for i := 1; i < len(components); i++ {
+		dir := strings.Join(components[:i], "/")
+		if x.is_ignored(dir, true) {
+			return dir
+		}
+	}
+	if x.is_ignored(path, is_dir) {
+		return path
+	}
+	return ""
+}
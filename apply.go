@@ -0,0 +1,2436 @@
+// See Also:
+// https://git-scm.com/docs/git-apply
+// https://github.com/git/git/blob/master/apply.c
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	BINARY_DELTA_DEFLATED   = 1
+	BINARY_LITERAL_DEFLATED = 2
+
+	// flags of the lines of images
+	LINE_COMMON  = 1 // context line of the hunk
+	LINE_PATCHED = 2 // changed by a hunk already. later hunks don't match it
+
+	// results of check_to_create
+	EXISTS_IN_INDEX        = 1
+	EXISTS_IN_INDEX_AS_ITA = 2
+	EXISTS_IN_WORKTREE     = 3
+
+	// characters which terminate names in headers besides newline
+	TERM_SPACE = 1
+	TERM_TAB   = 2
+
+	// changes of symbolic links made by the patches
+	APPLY_SYMLINK_GOES_AWAY = 1
+	APPLY_SYMLINK_IN_RESULT = 2
+
+	SUBMODULE_PATCH_WITHOUT_INDEX = 1
+)
+
+type ApplyOptions struct {
+	Check    bool // --check: only see if the patches are applicable
+	Index    bool // --index: apply the patches to both the index and the work tree
+	Cached   bool // --cached: apply the patches to the index only
+	Reverse  bool // -R: apply the patches in reverse
+	ThreeWay bool // --3way: merge with the blobs the patches are made for, if the patches don't apply
+	Verbose  bool // -v: report progress and the offsets of the hunks
+	PValue   int  // -p<n>: leading path components to remove. -1 if not given
+	Context  int  // -C<n>: context lines which must match around each change. -1 for all lines
+}
+
+// Fragment is a hunk of a patch.
+type Fragment struct {
+	oldpos, oldlines  int
+	newpos, newlines  int
+	leading, trailing int // context lines before and after the changes
+	linenr            int // line number of the hunk header in the input
+	patch             []byte
+
+	binary_patch_method int // BINARY_*. the inflated data is in patch
+}
+
+// Patch is the changes of a file.
+type Patch struct {
+	old_name, new_name string // "" for /dev/null
+	def_name           string // the name in the 'diff --git' line
+	old_mode, new_mode uint32
+	is_new, is_delete  int // -1 if not known yet
+	is_rename, is_copy bool
+	is_binary          bool
+	score              int
+
+	old_oid_prefix, new_oid_prefix string // abbreviated object names of the index line
+
+	fragments []*Fragment
+
+	lines_added, lines_deleted int
+	extension_linenr           int
+	is_toplevel_relative       bool
+
+	rejected bool
+	result   []byte
+
+	direct_to_threeway  bool
+	conflicted_threeway bool
+	threeway_stage      [3]*[20]byte // base, ours and theirs. nil for missing stages
+}
+
+// ApplyImage is the contents of a file being patched.
+// binary contents are held as a single line.
+type ApplyImage struct {
+	lines [][]byte
+	flags []int
+}
+
+type ApplyState struct {
+	opts   ApplyOptions
+	repop  string
+	prefix string
+	d      *Dircache
+
+	apply         bool // write the results
+	check_index   bool // the files must match the index
+	update_index  bool
+	no_symlinks   bool
+	p_value       int
+	p_value_known bool
+	p_context     int
+	linenr        int
+
+	fn_table        map[string]*Patch // results of the patches applied already
+	symlink_changes map[string]int
+}
+
+// markers in fn_table
+var path_to_be_deleted = &Patch{}
+var path_was_deleted = &Patch{}
+
+func apply_cmd(opts ApplyOptions, args []string) {
+	state := &ApplyState{opts: opts, linenr: 1, p_value: 1, p_context: math.MaxInt32}
+	if opts.PValue >= 0 {
+		state.p_value = opts.PValue
+		state.p_value_known = true
+	}
+	if opts.Context >= 0 {
+		state.p_context = opts.Context
+	}
+	state.apply = opts.Check == false
+	state.check_index = opts.Index || opts.Cached || opts.ThreeWay
+
+	// patches can be applied to files out of repositories, but not to the index
+	repop, prefix, err := setup_git_directory()
+	if err != nil && state.check_index {
+		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
+		exit(128)
+	}
+	if err == nil {
+		state.repop = repop
+		state.prefix = prefix
+		state.no_symlinks = get_config_bool(repop, "core.symlinks", true) == false
+	}
+	state.update_index = state.check_index && state.apply
+
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	errs := 0
+	for _, arg := range args {
+		var b []byte
+		if arg == "-" {
+			b, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			p := arg
+			if filepath.IsAbs(p) == false {
+				p = filepath.Join(prefix, p)
+			}
+			b, err = ioutil.ReadFile(p)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: can't open patch '%s': %s\n", arg, strerror(err))
+			exit(128)
+		}
+
+		res := apply_patch(state, b)
+		if res == -128 {
+			exit(128)
+		}
+		if res < 0 {
+			exit(1)
+		}
+		errs |= res
+	}
+
+	if state.update_index {
+		if err := write_dircache(state.d, state.repop); err != nil {
+			fmt.Fprintf(os.Stderr, "error: Unable to write new index file\n")
+			exit(128)
+		}
+	}
+	exit(errs)
+}
+
+// apply_patch parses the patches in b, checks them and writes the results.
+// It returns 0 on success, 1 if some patches are applied with conflicts, -1 on errors and -128 on fatal errors.
+func apply_patch(state *ApplyState, b []byte) int {
+	var list []*Patch
+	skipped_patch := 0
+	for offset := 0; offset < len(b); {
+		patch := &Patch{}
+		nr := parse_chunk(state, b[offset:], patch)
+		if nr < 0 {
+			if nr == -128 {
+				return -128
+			}
+			break
+		}
+		if state.opts.Reverse {
+			reverse_patch(patch)
+		}
+		if use_patch(state, patch) {
+			// patches are applied in the reverse order with -R
+			if state.opts.Reverse {
+				list = append([]*Patch{patch}, list...)
+			} else {
+				list = append(list, patch)
+			}
+		} else {
+			if state.opts.Verbose {
+				fmt.Fprintf(os.Stderr, "Skipped patch '%s'.\n", patch_name(patch))
+			}
+			skipped_patch++
+		}
+		offset += nr
+	}
+
+	if len(list) == 0 && skipped_patch == 0 {
+		fmt.Fprintf(os.Stderr, "error: No valid patches in input (allow with \"--allow-empty\")\n")
+		return -128
+	}
+
+	if state.check_index && state.d == nil {
+		var err error
+		if state.update_index {
+			state.d, err = lock_dircache(state.repop)
+		} else {
+			state.d, err = load_dircache(state.repop)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+			return -128
+		}
+	}
+
+	if res := check_patch_list(state, list); res == -128 {
+		return -128
+	} else if res < 0 {
+		return -1
+	}
+
+	if state.apply {
+		if res := write_out_results(state, list); res < 0 {
+			return -128
+		} else if res > 0 {
+			// the index is written with the conflicts
+			return 1
+		}
+	}
+	return 0
+}
+
+// apply_error prints the error message and returns -1.
+func apply_error(format string, a ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", a...)
+	return -1
+}
+
+// strerror returns the message of the system error, as C strerror does.
+func strerror(err error) string {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	}
+	s := err.Error()
+	if len(s) > 0 {
+		s = strings.ToUpper(s[:1]) + s[1:]
+	}
+	return s
+}
+
+// linelen returns the length of the first line of b including the newline.
+func linelen(b []byte) int {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		return i + 1
+	}
+	return len(b)
+}
+
+func is_space(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+}
+
+//////////////////////////////
+// parse patches
+//////////////////////////////
+
+// parse_chunk parses a patch at the beginning of b. It returns the length of the patch,
+// -1 if no patch is found and -128 if the patch is corrupt.
+func parse_chunk(state *ApplyState, b []byte, patch *Patch) int {
+	offset, hdrsize := find_header(state, b, patch)
+	if offset < 0 {
+		return offset
+	}
+	prefix_patch(state, patch)
+
+	patchsize := parse_single_patch(state, b[offset+hdrsize:], patch)
+	if patchsize < 0 {
+		return -128
+	}
+
+	if patchsize == 0 {
+		hd := offset + hdrsize
+		llen := linelen(b[hd:])
+		if string(b[hd:hd+llen]) == "GIT binary patch\n" {
+			state.linenr++
+			used := parse_binary(state, b[hd+llen:], patch)
+			if used < 0 {
+				return -1
+			}
+			if used > 0 {
+				patchsize = used + llen
+			}
+		} else if llen >= 8 && string(b[hd+llen-8:hd+llen]) == " differ\n" {
+			for _, binhdr := range []string{"Binary files ", "Files "} {
+				if bytes.HasPrefix(b[hd:], []byte(binhdr)) {
+					state.linenr++
+					patch.is_binary = true
+					patchsize = llen
+					break
+				}
+			}
+		}
+
+		// a text patch without changes of the metadata can't be applied. a binary patch appears empty here
+		if patch.is_binary == false && metadata_changes(patch) == false {
+			apply_error("patch with only garbage at line %d", state.linenr)
+			return -128
+		}
+	}
+	return offset + hdrsize + patchsize
+}
+
+func metadata_changes(patch *Patch) bool {
+	return patch.is_rename || patch.is_copy || patch.is_new > 0 || patch.is_delete > 0 ||
+		(patch.old_mode != 0 && patch.new_mode != 0 && patch.old_mode != patch.new_mode)
+}
+
+// find_header finds the header of the next patch. a git diff, or a unified diff which has '---' and '+++' lines.
+// It returns the offset of the header and the size of the header.
+func find_header(state *ApplyState, b []byte, patch *Patch) (int, int) {
+	patch.is_new = -1
+	patch.is_delete = -1
+
+	for offset := 0; offset < len(b); state.linenr++ {
+		line := b[offset:]
+		l := linelen(line)
+		if l == 0 {
+			break
+		}
+		offset += l
+
+		// testing this early allows us to take a few shortcuts
+		if l < 6 {
+			continue
+		}
+
+		// unconnected hunks mean the patch is broken
+		if bytes.HasPrefix(line, []byte("@@ -")) {
+			var dummy Fragment
+			if parse_fragment_header(line[:l], &dummy) < 0 {
+				continue
+			}
+			apply_error("patch fragment without header at line %d: %s", state.linenr, line[:l-1])
+			return -128, 0
+		}
+
+		if len(line) < l+6 {
+			break
+		}
+
+		// git patch. it may not have a real patch, just a rename or mode change
+		if bytes.HasPrefix(line, []byte("diff --git ")) {
+			git_hdr_len := parse_git_diff_header(state, line, l, patch)
+			if git_hdr_len < 0 {
+				return -128, 0
+			}
+			if git_hdr_len <= l {
+				continue
+			}
+			return offset - l, git_hdr_len
+		}
+
+		// '---' followed by '+++'
+		if bytes.HasPrefix(line, []byte("--- ")) == false || bytes.HasPrefix(line[l:], []byte("+++ ")) == false {
+			continue
+		}
+		// we only accept unified patches, which have at least "@@ -a +b @@\n"
+		nextlen := linelen(line[l:])
+		if len(line) < l+nextlen+14 || bytes.HasPrefix(line[l+nextlen:], []byte("@@ -")) == false {
+			continue
+		}
+
+		if parse_traditional_patch(state, line[:l], line[l:l+nextlen], patch) < 0 {
+			return -128, 0
+		}
+		state.linenr += 2
+		return offset - l, l + nextlen
+	}
+	return -1, 0
+}
+
+// prefix_patch prepends the current directory to the names of the patches made by diff(1).
+func prefix_patch(state *ApplyState, p *Patch) {
+	if len(state.prefix) == 0 || p.is_toplevel_relative {
+		return
+	}
+	if len(p.new_name) > 0 {
+		p.new_name = state.prefix + p.new_name
+	}
+	if len(p.old_name) > 0 {
+		p.old_name = state.prefix + p.old_name
+	}
+}
+
+// use_patch reports whether the patch is applied. paths out of the current directory are not touched.
+func use_patch(state *ApplyState, p *Patch) bool {
+	pathname := p.new_name
+	if len(pathname) == 0 {
+		pathname = p.old_name
+	}
+	if len(state.prefix) > 0 {
+		if strings.HasPrefix(pathname, state.prefix) == false || len(pathname) == len(state.prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// git_diff_header_handlers parse the extended header lines of git diffs.
+// a handler returns 1 at the end of the header, and -1 on errors.
+var git_diff_header_handlers = []struct {
+	op string
+	fn func(state *ApplyState, line []byte, patch *Patch) int
+}{
+	{"@@ -", gitdiff_hdrend},
+	{"--- ", gitdiff_oldname},
+	{"+++ ", gitdiff_newname},
+	{"old mode ", gitdiff_oldmode},
+	{"new mode ", gitdiff_newmode},
+	{"deleted file mode ", gitdiff_delete},
+	{"new file mode ", gitdiff_newfile},
+	{"copy from ", gitdiff_copysrc},
+	{"copy to ", gitdiff_copydst},
+	{"rename old ", gitdiff_renamesrc},
+	{"rename new ", gitdiff_renamedst},
+	{"rename from ", gitdiff_renamesrc},
+	{"rename to ", gitdiff_renamedst},
+	{"similarity index ", gitdiff_similarity},
+	{"dissimilarity index ", gitdiff_similarity},
+	{"index ", gitdiff_index},
+	{"", gitdiff_unrecognized},
+}
+
+// parse_git_diff_header parses the 'diff --git' line of l bytes and the extended header lines.
+// It returns the length of the header.
+func parse_git_diff_header(state *ApplyState, b []byte, l int, patch *Patch) int {
+	// a git diff has explicit new/delete information, so we don't guess
+	patch.is_new = 0
+	patch.is_delete = 0
+
+	// some things may not have the old name in the "diff --git" line
+	patch.def_name = git_header_name(state.p_value, b[:l])
+
+	state.linenr++
+	offset := l
+	for offset < len(b) {
+		line := b[offset:]
+		l = linelen(line)
+		if l == 0 || line[l-1] != '\n' {
+			break
+		}
+		res := 0
+		for _, h := range git_diff_header_handlers {
+			if bytes.HasPrefix(line[:l], []byte(h.op)) == false {
+				continue
+			}
+			res = h.fn(state, line[len(h.op):l], patch)
+			if res < 0 {
+				return -1
+			}
+			if check_header_line(state.linenr, patch) < 0 {
+				return -1
+			}
+			break
+		}
+		if res > 0 {
+			break
+		}
+		offset += l
+		state.linenr++
+	}
+
+	if len(patch.old_name) == 0 && len(patch.new_name) == 0 {
+		if len(patch.def_name) == 0 {
+			components := "components"
+			if state.p_value == 1 {
+				components = "component"
+			}
+			apply_error("git diff header lacks filename information when removing %d leading pathname %s (line %d)", state.p_value, components, state.linenr)
+			return -128
+		}
+		patch.old_name = patch.def_name
+		patch.new_name = patch.def_name
+	}
+	if (len(patch.new_name) == 0 && patch.is_delete <= 0) || (len(patch.old_name) == 0 && patch.is_new <= 0) {
+		apply_error("git diff header lacks filename information (line %d)", state.linenr)
+		return -128
+	}
+	patch.is_toplevel_relative = true
+	return offset
+}
+
+func check_header_line(linenr int, patch *Patch) int {
+	extensions := 0
+	for _, v := range []bool{patch.is_delete == 1, patch.is_new == 1, patch.is_rename, patch.is_copy} {
+		if v {
+			extensions++
+		}
+	}
+	if extensions > 1 {
+		return apply_error("inconsistent header lines %d and %d", patch.extension_linenr, linenr)
+	}
+	if extensions > 0 && patch.extension_linenr == 0 {
+		patch.extension_linenr = linenr
+	}
+	return 0
+}
+
+func gitdiff_hdrend(state *ApplyState, line []byte, patch *Patch) int {
+	return 1
+}
+
+func gitdiff_unrecognized(state *ApplyState, line []byte, patch *Patch) int {
+	return 1
+}
+
+// gitdiff_verify_name checks the name in '---' or '+++' line matches the name in the header.
+func gitdiff_verify_name(state *ApplyState, line []byte, isnull bool, name *string, side string) int {
+	if len(*name) == 0 && isnull == false {
+		*name = find_name(line, "", state.p_value, TERM_TAB)
+		return 0
+	}
+	if len(*name) > 0 {
+		if isnull {
+			return apply_error("git apply: bad git-diff - expected /dev/null, got %s on line %d", *name, state.linenr)
+		}
+		if another := find_name(line, "", state.p_value, TERM_TAB); another != *name {
+			return apply_error("git apply: bad git-diff - inconsistent %s filename on line %d", side, state.linenr)
+		}
+	} else if is_dev_null(line) == false {
+		return apply_error("git apply: bad git-diff - expected /dev/null on line %d", state.linenr)
+	}
+	return 0
+}
+
+func gitdiff_oldname(state *ApplyState, line []byte, patch *Patch) int {
+	return gitdiff_verify_name(state, line, patch.is_new > 0, &patch.old_name, "old")
+}
+
+func gitdiff_newname(state *ApplyState, line []byte, patch *Patch) int {
+	return gitdiff_verify_name(state, line, patch.is_delete > 0, &patch.new_name, "new")
+}
+
+// parse_mode_line parses the octal mode followed by a space or newline.
+func parse_mode_line(line []byte, linenr int, mode *uint32) int {
+	end := 0
+	for end < len(line) && '0' <= line[end] && line[end] <= '7' {
+		end++
+	}
+	v, err := strconv.ParseUint(string(line[:end]), 8, 32)
+	if end == 0 || err != nil || end >= len(line) || is_space(line[end]) == false {
+		return apply_error("invalid mode on line %d: %s", linenr, bytes.TrimSuffix(line, []byte("\n")))
+	}
+	*mode = uint32(v)
+	return 0
+}
+
+func gitdiff_oldmode(state *ApplyState, line []byte, patch *Patch) int {
+	return parse_mode_line(line, state.linenr, &patch.old_mode)
+}
+
+func gitdiff_newmode(state *ApplyState, line []byte, patch *Patch) int {
+	return parse_mode_line(line, state.linenr, &patch.new_mode)
+}
+
+func gitdiff_delete(state *ApplyState, line []byte, patch *Patch) int {
+	patch.is_delete = 1
+	patch.old_name = patch.def_name
+	return gitdiff_oldmode(state, line, patch)
+}
+
+func gitdiff_newfile(state *ApplyState, line []byte, patch *Patch) int {
+	patch.is_new = 1
+	patch.new_name = patch.def_name
+	return gitdiff_newmode(state, line, patch)
+}
+
+// copy_rename_p_value is the p_value for the names in 'copy from' and 'rename from' lines, which have no 'a/' prefix.
+func copy_rename_p_value(state *ApplyState) int {
+	if state.p_value > 0 {
+		return state.p_value - 1
+	}
+	return 0
+}
+
+func gitdiff_copysrc(state *ApplyState, line []byte, patch *Patch) int {
+	patch.is_copy = true
+	patch.old_name = find_name(line, "", copy_rename_p_value(state), 0)
+	return 0
+}
+
+func gitdiff_copydst(state *ApplyState, line []byte, patch *Patch) int {
+	patch.is_copy = true
+	patch.new_name = find_name(line, "", copy_rename_p_value(state), 0)
+	return 0
+}
+
+func gitdiff_renamesrc(state *ApplyState, line []byte, patch *Patch) int {
+	patch.is_rename = true
+	patch.old_name = find_name(line, "", copy_rename_p_value(state), 0)
+	return 0
+}
+
+func gitdiff_renamedst(state *ApplyState, line []byte, patch *Patch) int {
+	patch.is_rename = true
+	patch.new_name = find_name(line, "", copy_rename_p_value(state), 0)
+	return 0
+}
+
+func gitdiff_similarity(state *ApplyState, line []byte, patch *Patch) int {
+	end := 0
+	for end < len(line) && '0' <= line[end] && line[end] <= '9' {
+		end++
+	}
+	patch.score, _ = strconv.Atoi(string(line[:end]))
+	if patch.score > 100 {
+		patch.score = 0
+	}
+	return 0
+}
+
+// gitdiff_index parses "<old>..<new> [<mode>]".
+func gitdiff_index(state *ApplyState, line []byte, patch *Patch) int {
+	dots := bytes.IndexByte(line, '.')
+	if dots < 0 || dots+1 >= len(line) || line[dots+1] != '.' || dots > 40 {
+		return 0
+	}
+	old := string(line[:dots])
+
+	line = line[dots+2:]
+	end := bytes.IndexAny(line, " \n")
+	if end < 0 {
+		end = len(line)
+	}
+	if end > 40 {
+		return 0
+	}
+	patch.old_oid_prefix = old
+	patch.new_oid_prefix = string(line[:end])
+	if end < len(line) && line[end] == ' ' {
+		return gitdiff_oldmode(state, line[end+1:], patch)
+	}
+	return 0
+}
+
+// is_dev_null reports whether the name in the header is /dev/null.
+func is_dev_null(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("/dev/null")) && len(line) > 9 && is_space(line[9])
+}
+
+// skip_tree_prefix returns the position after p_value leading components. -1 if there are not so many.
+func skip_tree_prefix(p_value int, line []byte) int {
+	if p_value == 0 {
+		if len(line) > 0 && line[0] == '/' {
+			return -1
+		}
+		return 0
+	}
+	nslash := p_value
+	for i, c := range line {
+		if c == '/' {
+			nslash--
+			if nslash <= 0 {
+				if i == 0 {
+					return -1
+				}
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// git_header_name returns the name in the 'diff --git' line, if both names are the same.
+// the names of renames and copies are found in the extended header lines instead.
+func git_header_name(p_value int, line []byte) string {
+	line = line[len("diff --git "):]
+
+	if len(line) > 0 && line[0] == '"' {
+		first, n, ok := unquote_c_style(line)
+		if ok == false {
+			return ""
+		}
+		// strip the a/b prefix including trailing slash
+		cp := skip_tree_prefix(p_value, []byte(first))
+		if cp < 0 {
+			return ""
+		}
+		first = first[cp:]
+
+		second := line[n:]
+		for len(second) > 0 && is_space(second[0]) {
+			second = second[1:]
+		}
+		if len(second) == 0 {
+			return ""
+		}
+		if second[0] == '"' {
+			sp, _, ok := unquote_c_style(second)
+			if ok == false {
+				return ""
+			}
+			cp := skip_tree_prefix(p_value, []byte(sp))
+			if cp < 0 || sp[cp:] != first {
+				return ""
+			}
+			return first
+		}
+
+		// unquoted second
+		cp = skip_tree_prefix(p_value, second)
+		if cp < 0 || string(bytes.TrimSuffix(second[cp:], []byte("\n"))) != first {
+			return ""
+		}
+		return first
+	}
+
+	// unquoted first name
+	cp := skip_tree_prefix(p_value, line)
+	if cp < 0 {
+		return ""
+	}
+	name := line[cp:]
+
+	// since the first name is unquoted, a dq if exists must be the beginning of the second name
+	if i := bytes.IndexByte(name, '"'); i >= 0 {
+		sp, _, ok := unquote_c_style(name[i:])
+		if ok == false {
+			return ""
+		}
+		np := skip_tree_prefix(p_value, []byte(sp))
+		if np < 0 {
+			return ""
+		}
+		sp = sp[np:]
+		if len(sp) < i && bytes.HasPrefix(name, []byte(sp)) && is_space(name[len(sp)]) {
+			return sp
+		}
+		return ""
+	}
+
+	// accept a name only if it shows up twice, exactly the same form
+	for l := 0; l < len(name); l++ {
+		switch name[l] {
+		case '\n':
+			return ""
+		case '\t', ' ':
+			// the separator between the names, if both names are the same
+			if l+1 >= len(name) {
+				return ""
+			}
+			sp := skip_tree_prefix(p_value, name[l+1:])
+			if sp < 0 {
+				continue
+			}
+			second := name[l+1+sp:]
+			if len(second) > l && second[l] == '\n' && bytes.Equal(name[:l], second[:l]) {
+				return string(name[:l])
+			}
+		}
+	}
+	return ""
+}
+
+// find_name finds the name in the header line after removing p_value leading components.
+// the name may be quoted.
+func find_name(line []byte, def string, p_value int, terminate int) string {
+	if len(line) > 0 && line[0] == '"' {
+		if name := find_name_gnu(line, p_value); len(name) > 0 {
+			return name
+		}
+	}
+	return find_name_common(line, def, p_value, -1, terminate)
+}
+
+func find_name_gnu(line []byte, p_value int) string {
+	name, _, ok := unquote_c_style(line)
+	if ok == false {
+		return ""
+	}
+	for ; p_value > 0; p_value-- {
+		i := strings.IndexByte(name, '/')
+		if i < 0 {
+			return ""
+		}
+		name = name[i+1:]
+	}
+	return squash_slash(name)
+}
+
+func name_terminate(c byte, terminate int) bool {
+	if c == ' ' && terminate&TERM_SPACE == 0 {
+		return false
+	}
+	if c == '\t' && terminate&TERM_TAB == 0 {
+		return false
+	}
+	return true
+}
+
+// find_name_common finds the unquoted name which ends at end (if not -1), a newline or a terminating character.
+// the shorter def is preferred if the name is def with something tacked on to the end. (like "file.orig")
+func find_name_common(line []byte, def string, p_value int, end int, terminate int) string {
+	start := -1
+	if p_value == 0 {
+		start = 0
+	}
+	i := 0
+	for ; i < len(line) && i != end; i++ {
+		c := line[i]
+		if end < 0 && is_space(c) {
+			if c == '\n' || name_terminate(c, terminate) {
+				break
+			}
+		}
+		if c == '/' {
+			p_value--
+			if p_value == 0 {
+				start = i + 1
+			}
+		}
+	}
+	if start < 0 || i-start <= 0 {
+		return squash_slash(def)
+	}
+	name := string(line[start:i])
+	if len(def) > 0 && len(def) < len(name) && strings.HasPrefix(name, def) {
+		return squash_slash(def)
+	}
+	return squash_slash(name)
+}
+
+// squash_slash replaces consecutive slashes with a slash.
+func squash_slash(name string) string {
+	for strings.Contains(name, "//") {
+		name = strings.ReplaceAll(name, "//", "/")
+	}
+	return name
+}
+
+// find_name_traditional finds the name in '---' or '+++' line of diff(1). a tab separates the timestamp.
+func find_name_traditional(line []byte, def string, p_value int) string {
+	if len(line) > 0 && line[0] == '"' {
+		if name := find_name_gnu(line, p_value); len(name) > 0 {
+			return name
+		}
+	}
+	return find_name_common(line, def, p_value, -1, TERM_TAB)
+}
+
+// guess_p_value returns 0 if the name has no directory, as the patch is made in the directory. -1 if unknown.
+func guess_p_value(state *ApplyState, nameline []byte) int {
+	if is_dev_null(nameline) {
+		return -1
+	}
+	name := find_name_traditional(nameline, "", 0)
+	if len(name) == 0 {
+		return -1
+	}
+	if strings.Contains(name, "/") == false {
+		return 0
+	}
+	if len(state.prefix) > 0 {
+		// does it begin with "a/$our-prefix" and such?
+		if strings.HasPrefix(name, state.prefix) {
+			return 0
+		}
+		if i := strings.IndexByte(name, '/'); strings.HasPrefix(name[i+1:], state.prefix) {
+			return 1
+		}
+	}
+	return -1
+}
+
+var epoch_stamp_regexp = regexp.MustCompile(`^[0-2][0-9]:([0-5][0-9]):00(\.0+)? ([-+][0-2][0-9]:?[0-5][0-9])\n`)
+
+// has_epoch_timestamp reports whether the timestamp of the name line is the epoch.
+// diff(1) shows created and deleted files with the epoch timestamp.
+func has_epoch_timestamp(nameline []byte) bool {
+	nameline = nameline[:linelen(nameline)]
+	i := bytes.LastIndexByte(nameline, '\t')
+	if i < 0 {
+		return false
+	}
+	timestamp := string(nameline[i+1:])
+
+	// YYYY-MM-DD must be 1969-12-31 (west of GMT) or 1970-01-01 (east of GMT)
+	epoch_hour := 0
+	switch {
+	case strings.HasPrefix(timestamp, "1969-12-31 "):
+		epoch_hour = 24
+	case strings.HasPrefix(timestamp, "1970-01-01 "):
+		epoch_hour = 0
+	default:
+		return false
+	}
+	timestamp = timestamp[len("1970-01-01 "):]
+	m := epoch_stamp_regexp.FindStringSubmatch(timestamp)
+	if m == nil {
+		return false
+	}
+
+	hour, _ := strconv.Atoi(timestamp[:2])
+	minute, _ := strconv.Atoi(m[1])
+	zone := m[3]
+	var zoneoffset int
+	if zone[3] == ':' {
+		h, _ := strconv.Atoi(zone[1:3])
+		mi, _ := strconv.Atoi(zone[4:])
+		zoneoffset = h*60 + mi
+	} else {
+		v, _ := strconv.Atoi(zone[1:])
+		zoneoffset = v/100*60 + v%100
+	}
+	if zone[0] == '-' {
+		zoneoffset = -zoneoffset
+	}
+	return hour*60+minute-epoch_hour*60 == zoneoffset
+}
+
+// parse_traditional_patch parses '---' and '+++' lines of diff(1).
+func parse_traditional_patch(state *ApplyState, first []byte, second []byte, patch *Patch) int {
+	first = first[4:]
+	second = second[4:]
+	if state.p_value_known == false {
+		p := guess_p_value(state, first)
+		q := guess_p_value(state, second)
+		if p < 0 {
+			p = q
+		}
+		if 0 <= p && p == q {
+			state.p_value = p
+			state.p_value_known = true
+		}
+	}
+
+	var name string
+	switch {
+	case is_dev_null(first):
+		patch.is_new = 1
+		patch.is_delete = 0
+		name = find_name_traditional(second, "", state.p_value)
+		patch.new_name = name
+	case is_dev_null(second):
+		patch.is_new = 0
+		patch.is_delete = 1
+		name = find_name_traditional(first, "", state.p_value)
+		patch.old_name = name
+	default:
+		first_name := find_name_traditional(first, "", state.p_value)
+		name = find_name_traditional(second, first_name, state.p_value)
+		switch {
+		case has_epoch_timestamp(first):
+			patch.is_new = 1
+			patch.is_delete = 0
+			patch.new_name = name
+		case has_epoch_timestamp(second):
+			patch.is_new = 0
+			patch.is_delete = 1
+			patch.old_name = name
+		default:
+			patch.old_name = name
+			patch.new_name = name
+		}
+	}
+	if len(name) == 0 {
+		return apply_error("unable to find filename in patch at line %d", state.linenr)
+	}
+	return 0
+}
+
+// parse_single_patch parses the hunks of the patch, and returns the length of them.
+func parse_single_patch(state *ApplyState, b []byte, patch *Patch) int {
+	offset := 0
+	oldlines, newlines, context := 0, 0, 0
+	for len(b) > 4 && bytes.HasPrefix(b, []byte("@@ -")) {
+		frag := &Fragment{linenr: state.linenr}
+		l := parse_fragment(state, b, patch, frag)
+		if l <= 0 {
+			return apply_error("corrupt patch at line %d", state.linenr)
+		}
+		frag.patch = b[:l]
+		oldlines += frag.oldlines
+		newlines += frag.newlines
+		context += frag.leading + frag.trailing
+		patch.fragments = append(patch.fragments, frag)
+
+		offset += l
+		b = b[l:]
+	}
+
+	// if something was removed, it cannot be creation. and if something was added it cannot be deletion.
+	// the reverse is not true because --unified=0 patches can only add or remove lines.
+	// at least if the patch has more than one hunk it is not creation or deletion.
+	if patch.is_new < 0 && (oldlines > 0 || len(patch.fragments) > 1) {
+		patch.is_new = 0
+	}
+	if patch.is_delete < 0 && (newlines > 0 || len(patch.fragments) > 1) {
+		patch.is_delete = 0
+	}
+
+	if patch.is_new > 0 && oldlines > 0 {
+		return apply_error("new file %s depends on old contents", patch.new_name)
+	}
+	if patch.is_delete > 0 && newlines > 0 {
+		return apply_error("deleted file %s still has contents", patch.old_name)
+	}
+	if patch.is_delete <= 0 && newlines == 0 && context > 0 {
+		fmt.Fprintf(os.Stderr, "** warning: file %s becomes empty but is not deleted\n", patch.new_name)
+	}
+	return offset
+}
+
+// parse_fragment parses a hunk and returns the length of it.
+func parse_fragment(state *ApplyState, b []byte, patch *Patch, frag *Fragment) int {
+	l := linelen(b)
+	offset := parse_fragment_header(b[:l], frag)
+	if offset < 0 {
+		return -1
+	}
+	oldlines := frag.oldlines
+	newlines := frag.newlines
+	leading, trailing := 0, 0
+
+	b = b[l:]
+	state.linenr++
+	added, deleted := 0, 0
+	for offset = l; len(b) > 0; offset, b = offset+l, b[l:] {
+		if oldlines == 0 && newlines == 0 {
+			break
+		}
+		l = linelen(b)
+		if l == 0 || b[l-1] != '\n' {
+			return -1
+		}
+		switch b[0] {
+		case '\n', ' ':
+			// '\n' is an empty context line of newer GNU diff
+			oldlines--
+			newlines--
+			if deleted == 0 && added == 0 {
+				leading++
+			}
+			trailing++
+		case '-':
+			deleted++
+			oldlines--
+			trailing = 0
+		case '+':
+			added++
+			newlines--
+			trailing = 0
+		case '\\':
+			// "\ No newline at end of file". it may be localized, and at least 12 bytes
+			if l < 12 || bytes.HasPrefix(b, []byte("\\ ")) == false {
+				return -1
+			}
+		default:
+			return -1
+		}
+		state.linenr++
+	}
+	if oldlines != 0 || newlines != 0 {
+		return -1
+	}
+	if deleted == 0 && added == 0 {
+		return -1
+	}
+
+	frag.leading = leading
+	frag.trailing = trailing
+
+	// the incomplete line at the end is not read in the loop because it ends when oldlines and newlines are 0
+	if len(b) > 12 && bytes.HasPrefix(b, []byte("\\ ")) {
+		offset += linelen(b)
+	}
+
+	patch.lines_added += added
+	patch.lines_deleted += deleted
+	return offset
+}
+
+// parse_fragment_header parses "@@ -a,b +c,d @@". It returns the length of the parsed part.
+func parse_fragment_header(line []byte, frag *Fragment) int {
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		return -1
+	}
+	offset := parse_range(line, 4, " +", &frag.oldpos, &frag.oldlines)
+	return parse_range(line, offset, " @@", &frag.newpos, &frag.newlines)
+}
+
+// parse_range parses "<pos>[,<lines>]" followed by expect. lines is 1 if omitted.
+func parse_range(line []byte, offset int, expect string, p1 *int, p2 *int) int {
+	if offset < 0 || offset >= len(line) {
+		return -1
+	}
+	digits := parse_num(line[offset:], p1)
+	if digits == 0 {
+		return -1
+	}
+	offset += digits
+	*p2 = 1
+	if offset < len(line) && line[offset] == ',' {
+		digits = parse_num(line[offset+1:], p2)
+		if digits == 0 {
+			return -1
+		}
+		offset += digits + 1
+	}
+	if bytes.HasPrefix(line[offset:], []byte(expect)) == false {
+		return -1
+	}
+	return offset + len(expect)
+}
+
+func parse_num(b []byte, p *int) int {
+	n := 0
+	for n < len(b) && '0' <= b[n] && b[n] <= '9' {
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	v, err := strconv.Atoi(string(b[:n]))
+	if err != nil {
+		return 0
+	}
+	*p = v
+	return n
+}
+
+// parse_binary parses the forward hunk and the optional reverse hunk after "GIT binary patch".
+func parse_binary(state *ApplyState, b []byte, patch *Patch) int {
+	forward, used, status := parse_binary_hunk(state, &b)
+	if forward == nil && status == 0 {
+		// there has to be one hunk (forward hunk)
+		return apply_error("unrecognized binary patch at line %d", state.linenr-1)
+	}
+	if status != 0 {
+		return status
+	}
+
+	reverse, used_1, status := parse_binary_hunk(state, &b)
+	if reverse != nil {
+		used += used_1
+	} else if status != 0 {
+		// not having reverse hunk is not an error, but having a corrupt reverse hunk is
+		return status
+	}
+	patch.fragments = []*Fragment{forward}
+	if reverse != nil {
+		patch.fragments = append(patch.fragments, reverse)
+	}
+	patch.is_binary = true
+	return used
+}
+
+// parse_binary_hunk parses "literal <size>" or "delta <size>" followed by the base85 lines of deflated data.
+// each line begins with the length of the data ('A'-'Z' for 1-26 bytes and 'a'-'z' for 27-52 bytes).
+func parse_binary_hunk(state *ApplyState, bp *[]byte) (*Fragment, int, int) {
+	b := *bp
+	llen := linelen(b)
+	used := llen
+
+	var method int
+	var origlen int
+	switch {
+	case bytes.HasPrefix(b, []byte("delta ")):
+		method = BINARY_DELTA_DEFLATED
+		origlen, _ = strconv.Atoi(string(bytes.TrimSpace(b[6:llen])))
+	case bytes.HasPrefix(b, []byte("literal ")):
+		method = BINARY_LITERAL_DEFLATED
+		origlen, _ = strconv.Atoi(string(bytes.TrimSpace(b[8:llen])))
+	default:
+		return nil, 0, 0
+	}
+
+	state.linenr++
+	b = b[llen:]
+	var data []byte
+	corrupt := func() (*Fragment, int, int) {
+		apply_error("corrupt binary patch at line %d: %s", state.linenr-1, bytes.TrimSuffix(b[:llen], []byte("\n")))
+		return nil, 0, -1
+	}
+	for {
+		llen = linelen(b)
+		used += llen
+		state.linenr++
+		if llen == 1 {
+			// consume the blank line
+			b = b[1:]
+			break
+		}
+		// the minimum line is "A00000\n", and the length is a multiple of 5 plus 2
+		if llen < 7 || (llen-2)%5 != 0 {
+			return corrupt()
+		}
+		max_byte_length := (llen - 2) / 5 * 4
+		byte_length := int(b[0])
+		switch {
+		case 'A' <= byte_length && byte_length <= 'Z':
+			byte_length = byte_length - 'A' + 1
+		case 'a' <= byte_length && byte_length <= 'z':
+			byte_length = byte_length - 'a' + 27
+		default:
+			return corrupt()
+		}
+		// the filler never exceeds 3 bytes
+		if max_byte_length < byte_length || byte_length <= max_byte_length-4 {
+			return corrupt()
+		}
+		d, err := decode_85(b[1:llen-1], byte_length)
+		if err != nil {
+			apply_error("%v", err)
+			return corrupt()
+		}
+		data = append(data, d...)
+		b = b[llen:]
+	}
+
+	inflated := inflate_it(data, origlen)
+	if inflated == nil {
+		return corrupt()
+	}
+	*bp = b
+	return &Fragment{binary_patch_method: method, patch: inflated}, used, 0
+}
+
+// inflate_it inflates the data. nil is returned if the size is not the expected one.
+func inflate_it(data []byte, inflated_size int) []byte {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil || len(out) != inflated_size {
+		return nil
+	}
+	return out
+}
+
+// reverse_patch swaps the preimage and the postimage of the patch for -R.
+func reverse_patch(p *Patch) {
+	p.old_name, p.new_name = p.new_name, p.old_name
+	p.old_mode, p.new_mode = p.new_mode, p.old_mode
+	p.is_new, p.is_delete = p.is_delete, p.is_new
+	p.lines_added, p.lines_deleted = p.lines_deleted, p.lines_added
+	p.old_oid_prefix, p.new_oid_prefix = p.new_oid_prefix, p.old_oid_prefix
+	for _, frag := range p.fragments {
+		frag.oldpos, frag.newpos = frag.newpos, frag.oldpos
+		frag.oldlines, frag.newlines = frag.newlines, frag.oldlines
+	}
+}
+
+// patch_name returns "old => new" for renames and copies, or the name.
+func patch_name(p *Patch) string {
+	if len(p.old_name) > 0 && len(p.new_name) > 0 && p.old_name != p.new_name {
+		return quote_c_style(p.old_name, true, false) + " => " + quote_c_style(p.new_name, true, false)
+	}
+	n := p.new_name
+	if len(n) == 0 {
+		n = p.old_name
+	}
+	return quote_c_style(n, true, false)
+}
+
+//////////////////////////////
+// apply hunks to images
+//////////////////////////////
+
+// prepare_image splits the contents into lines. binary contents are kept as a line.
+func prepare_image(data []byte, prepare_linetable bool) *ApplyImage {
+	img := &ApplyImage{}
+	if prepare_linetable {
+		img.lines = split_lines(data)
+	} else if len(data) > 0 {
+		img.lines = [][]byte{data}
+	}
+	img.flags = make([]int, len(img.lines))
+	return img
+}
+
+func (img *ApplyImage) contents() []byte {
+	return bytes.Join(img.lines, nil)
+}
+
+func (img *ApplyImage) add_line(line []byte, flag int) {
+	img.lines = append(img.lines, line)
+	img.flags = append(img.flags, flag)
+}
+
+// hash_line hashes the line ignoring whitespaces.
+func hash_line(line []byte) uint32 {
+	var h uint32
+	for _, c := range line {
+		if is_space(c) == false {
+			h = h*3 + uint32(c)
+		}
+	}
+	return h
+}
+
+// apply_fragments applies the hunks of the patch to the image.
+func apply_fragments(state *ApplyState, img *ApplyImage, patch *Patch) int {
+	name := patch.old_name
+	if len(name) == 0 {
+		name = patch.new_name
+	}
+	if patch.is_binary {
+		return apply_binary(state, img, patch)
+	}
+	for nth, frag := range patch.fragments {
+		if apply_one_fragment(state, img, frag, nth+1) != 0 {
+			return apply_error("patch failed: %s:%d", name, frag.oldpos)
+		}
+	}
+	return 0
+}
+
+// apply_one_fragment finds where the preimage of the hunk is, and replaces it with the postimage.
+// context lines are reduced to find the preimage down to -C<n>.
+func apply_one_fragment(state *ApplyState, img *ApplyImage, frag *Fragment, nth_fragment int) int {
+	preimage := &ApplyImage{}
+	postimage := &ApplyImage{}
+
+	patch := frag.patch
+	for len(patch) > 0 {
+		l := linelen(patch)
+		// the newline of the line followed by "\ No newline" is not a part of the line
+		plen := l - 1
+		if l < len(patch) && patch[l] == '\\' {
+			plen--
+		}
+		first := patch[0]
+		if state.opts.Reverse {
+			if first == '-' {
+				first = '+'
+			} else if first == '+' {
+				first = '-'
+			}
+		}
+
+		switch first {
+		case '\n':
+			// an empty context line of newer GNU diff
+			if plen >= 0 {
+				preimage.add_line([]byte("\n"), LINE_COMMON)
+				postimage.add_line([]byte("\n"), LINE_COMMON)
+			}
+		case ' ', '-', '+':
+			flag := 0
+			if first == ' ' {
+				flag = LINE_COMMON
+			}
+			if first != '+' {
+				preimage.add_line(patch[1:1+plen], flag)
+			}
+			if first != '-' {
+				postimage.add_line(patch[1:1+plen], flag)
+			}
+		case '@', '\\':
+			// ignore it, we already handled it
+		default:
+			if state.opts.Verbose {
+				apply_error("invalid start of line: '%c'", first)
+			}
+			return 1
+		}
+		patch = patch[l:]
+	}
+
+	leading := frag.leading
+	trailing := frag.trailing
+
+	// a hunk which begins at the first line must match at the beginning,
+	// and a hunk without trailing context lines must match at the end
+	match_beginning := frag.oldpos == 0 || frag.oldpos == 1
+	match_end := trailing == 0
+
+	pos := 0
+	if frag.newpos > 0 {
+		pos = frag.newpos - 1
+	}
+	var applied_pos int
+	for {
+		applied_pos = find_pos(img, preimage, pos, match_beginning, match_end)
+		if applied_pos >= 0 {
+			break
+		}
+
+		// am I at my context limits?
+		if leading <= state.p_context && trailing <= state.p_context {
+			break
+		}
+		if match_beginning || match_end {
+			match_beginning = false
+			match_end = false
+			continue
+		}
+
+		// reduce both leading and trailing if they are equal, otherwise just reduce the larger context
+		if leading >= trailing {
+			remove_first_line(preimage)
+			remove_first_line(postimage)
+			pos--
+			leading--
+		}
+		if trailing > leading {
+			remove_last_line(preimage)
+			remove_last_line(postimage)
+			trailing--
+		}
+	}
+
+	if applied_pos < 0 {
+		if state.opts.Verbose {
+			apply_error("while searching for:\n%s", preimage.contents())
+		}
+		return 1
+	}
+
+	if state.opts.Verbose {
+		offset := applied_pos - pos
+		if state.opts.Reverse {
+			offset = -offset
+		}
+		if offset != 0 {
+			lines := "lines"
+			if offset == 1 || offset == -1 {
+				lines = "line"
+			}
+			fmt.Fprintf(os.Stderr, "Hunk #%d succeeded at %d (offset %d %s).\n", nth_fragment, applied_pos+1, offset, lines)
+		}
+	}
+	// warn if it was necessary to reduce the number of context lines
+	if leading != frag.leading || trailing != frag.trailing {
+		fmt.Fprintf(os.Stderr, "Context reduced to (%d/%d) to apply fragment at %d\n", leading, trailing, applied_pos+1)
+	}
+	update_image(img, applied_pos, preimage, postimage)
+	return 0
+}
+
+func remove_first_line(img *ApplyImage) {
+	img.lines = img.lines[1:]
+	img.flags = img.flags[1:]
+}
+
+func remove_last_line(img *ApplyImage) {
+	img.lines = img.lines[:len(img.lines)-1]
+	img.flags = img.flags[:len(img.flags)-1]
+}
+
+// find_pos searches the preimage from the line, going forward and backward alternately.
+func find_pos(img *ApplyImage, preimage *ApplyImage, line int, match_beginning bool, match_end bool) int {
+	// no point starting from a wrong line that will never match
+	if match_beginning {
+		line = 0
+	} else if match_end {
+		line = len(img.lines) - len(preimage.lines)
+	}
+	if line > len(img.lines) || line < 0 {
+		line = len(img.lines)
+	}
+
+	backwards_lno := line
+	forwards_lno := line
+	current_lno := line
+	for i := 0; ; i++ {
+		if match_fragment(img, preimage, current_lno, match_beginning, match_end) {
+			return current_lno
+		}
+
+		for {
+			if backwards_lno == 0 && forwards_lno == len(img.lines) {
+				return -1
+			}
+			if i&1 != 0 {
+				if backwards_lno == 0 {
+					i++
+					continue
+				}
+				backwards_lno--
+				current_lno = backwards_lno
+			} else {
+				if forwards_lno == len(img.lines) {
+					i++
+					continue
+				}
+				forwards_lno++
+				current_lno = forwards_lno
+			}
+			break
+		}
+	}
+}
+
+// match_fragment reports whether the preimage is at the line of the image.
+func match_fragment(img *ApplyImage, preimage *ApplyImage, current_lno int, match_beginning bool, match_end bool) bool {
+	// the hunk must fall within the boundaries of the image
+	if len(preimage.lines)+current_lno > len(img.lines) {
+		return false
+	}
+	if match_end && len(preimage.lines)+current_lno != len(img.lines) {
+		return false
+	}
+	if match_beginning && current_lno != 0 {
+		return false
+	}
+
+	for i, l := range preimage.lines {
+		if img.flags[current_lno+i]&LINE_PATCHED != 0 || hash_line(l) != hash_line(img.lines[current_lno+i]) {
+			return false
+		}
+	}
+
+	// the old piece must match the preimage exactly. with match_end, it must be at the end
+	pre := preimage.contents()
+	rest := bytes.Join(img.lines[current_lno:], nil)
+	if match_end {
+		return bytes.Equal(rest, pre)
+	}
+	return bytes.HasPrefix(rest, pre)
+}
+
+// update_image replaces the preimage at applied_pos in the image with the postimage.
+func update_image(img *ApplyImage, applied_pos int, preimage *ApplyImage, postimage *ApplyImage) {
+	preimage_limit := len(preimage.lines)
+	if preimage_limit > len(img.lines)-applied_pos {
+		preimage_limit = len(img.lines) - applied_pos
+	}
+
+	lines := append([][]byte{}, img.lines[:applied_pos]...)
+	lines = append(lines, postimage.lines...)
+	lines = append(lines, img.lines[applied_pos+preimage_limit:]...)
+	flags := append([]int{}, img.flags[:applied_pos]...)
+	for _, f := range postimage.flags {
+		if f&LINE_COMMON == 0 {
+			f |= LINE_PATCHED
+		}
+		flags = append(flags, f)
+	}
+	flags = append(flags, img.flags[applied_pos+preimage_limit:]...)
+	img.lines = lines
+	img.flags = flags
+}
+
+// apply_binary applies the binary patch. the index line must have full object names to verify the result.
+func apply_binary(state *ApplyState, img *ApplyImage, patch *Patch) int {
+	name := patch.old_name
+	if len(name) == 0 {
+		name = patch.new_name
+	}
+
+	old_sha, ok1 := parse_sha1_hex(patch.old_oid_prefix)
+	new_sha, ok2 := parse_sha1_hex(patch.new_oid_prefix)
+	if ok1 == false || ok2 == false {
+		return apply_error("cannot apply binary patch to '%s' without full index line", name)
+	}
+
+	if len(patch.old_name) > 0 {
+		// see if the old one matches what the patch applies to
+		if sha := blob_sha1(img.contents()); sha != old_sha {
+			return apply_error("the patch applies to '%s' (%x), which does not match the current contents.", name, sha)
+		}
+	} else if len(img.lines) > 0 {
+		// otherwise, the old one must be empty
+		return apply_error("the patch applies to an empty '%s' but it is not empty", name)
+	}
+
+	if new_sha == [20]byte{} {
+		// deletion patch
+		*img = ApplyImage{}
+		return 0
+	}
+
+	var result []byte
+	if t, b, err := read_object_file(state.repop, patch.new_oid_prefix); len(state.repop) > 0 && err == nil {
+		// we already have the postimage
+		if t != "blob" {
+			return apply_error("the necessary postimage %s for '%s' cannot be read", patch.new_oid_prefix, name)
+		}
+		result = b
+	} else {
+		var st int
+		result, st = apply_binary_fragment(state, img, patch)
+		if st != 0 {
+			return apply_error("binary patch does not apply to '%s'", name)
+		}
+		// verify that the result matches
+		if sha := blob_sha1(result); sha != new_sha {
+			return apply_error("binary patch to '%s' creates incorrect result (expecting %s, got %x)", name, patch.new_oid_prefix, sha)
+		}
+	}
+	*img = *prepare_image(result, false)
+	return 0
+}
+
+// apply_binary_fragment applies the forward hunk, or the reverse hunk with -R.
+func apply_binary_fragment(state *ApplyState, img *ApplyImage, patch *Patch) ([]byte, int) {
+	name := patch.new_name
+	if len(name) == 0 {
+		name = patch.old_name
+	}
+	if len(patch.fragments) == 0 {
+		return nil, apply_error("missing binary patch data for '%s'", name)
+	}
+	frag := patch.fragments[0]
+
+	// binary patch is irreversible without the optional second hunk
+	if state.opts.Reverse {
+		if len(patch.fragments) < 2 {
+			return nil, apply_error("cannot reverse-apply a binary patch without the reverse hunk to '%s'", name)
+		}
+		frag = patch.fragments[1]
+	}
+	switch frag.binary_patch_method {
+	case BINARY_DELTA_DEFLATED:
+		dst, err := patch_delta(img.contents(), frag.patch)
+		if err != nil {
+			return nil, -1
+		}
+		return dst, 0
+	case BINARY_LITERAL_DEFLATED:
+		return frag.patch, 0
+	}
+	return nil, -1
+}
+
+//////////////////////////////
+// check patches
+//////////////////////////////
+
+// check_patch_list checks all patches can be applied. the results are made in memory.
+func check_patch_list(state *ApplyState, list []*Patch) int {
+	prepare_symlink_changes(state, list)
+	prepare_fn_table(state, list)
+	err := 0
+	for _, patch := range list {
+		if state.opts.Verbose {
+			fmt.Fprintf(os.Stderr, "Checking patch %s...\n", patch_name(patch))
+		}
+		res := check_patch(state, patch)
+		if res == -128 {
+			return -128
+		}
+		err |= res
+	}
+	return err
+}
+
+// prepare_fn_table marks the paths which will be removed by renames and deletions.
+func prepare_fn_table(state *ApplyState, list []*Patch) {
+	state.fn_table = make(map[string]*Patch)
+	for _, patch := range list {
+		if patch.is_rename || patch.is_delete > 0 {
+			state.fn_table[patch.old_name] = path_to_be_deleted
+		}
+	}
+}
+
+// add_to_fn_table records the result of the patch for later patches of the same path.
+func add_to_fn_table(state *ApplyState, patch *Patch) {
+	if len(patch.new_name) > 0 {
+		state.fn_table[patch.new_name] = patch
+	}
+	if len(patch.new_name) == 0 || patch.is_rename {
+		state.fn_table[patch.old_name] = path_was_deleted
+	}
+}
+
+// previous_patch returns the earlier patch of the preimage. gone is set if the path is removed by it.
+func previous_patch(state *ApplyState, patch *Patch) (*Patch, bool) {
+	// "git" patches do not depend on the order
+	if patch.is_copy || patch.is_rename {
+		return nil, false
+	}
+	previous, ok := state.fn_table[patch.old_name]
+	if ok == false || previous == path_to_be_deleted {
+		// the deletion hasn't happened yet
+		return nil, false
+	}
+	if previous == path_was_deleted {
+		return nil, true
+	}
+	return previous, false
+}
+
+// prepare_symlink_changes records the symbolic links which go away or are created by the patches.
+func prepare_symlink_changes(state *ApplyState, list []*Patch) {
+	state.symlink_changes = make(map[string]int)
+	for _, patch := range list {
+		if len(patch.old_name) > 0 && patch.old_mode&0170000 == 0120000 && (patch.is_rename || patch.is_delete > 0) {
+			state.symlink_changes[patch.old_name] |= APPLY_SYMLINK_GOES_AWAY
+		}
+		if len(patch.new_name) > 0 && patch.new_mode&0170000 == 0120000 {
+			state.symlink_changes[patch.new_name] |= APPLY_SYMLINK_IN_RESULT
+		}
+	}
+}
+
+// path_is_beyond_symlink reports whether a leading directory of the path is a symbolic link after the patches.
+func path_is_beyond_symlink(state *ApplyState, name string) bool {
+	for i := strings.LastIndex(name, "/"); i > 0; i = strings.LastIndex(name, "/") {
+		name = name[:i]
+		change := state.symlink_changes[name]
+		if change&APPLY_SYMLINK_IN_RESULT != 0 {
+			return true
+		}
+		if change&APPLY_SYMLINK_GOES_AWAY != 0 {
+			continue
+		}
+		// otherwise, check the preimage
+		if state.check_index {
+			if pos := find_dircache_entry(state.d, name); pos >= 0 && state.d.Entries[pos].Mode&0170000 == 0120000 {
+				return true
+			}
+		} else if info, err := os.Lstat(name); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// has_symlink_leading_path reports whether a leading directory of the path in the work tree is a symbolic link.
+func has_symlink_leading_path(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] != '/' {
+			continue
+		}
+		info, err := os.Lstat(name[:i])
+		if err != nil {
+			return false
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// check_patch checks the preimage of the patch and the path to create, and makes the result.
+func check_patch(state *ApplyState, patch *Patch) int {
+	old_name := patch.old_name
+	new_name := patch.new_name
+	name := old_name
+	if len(name) == 0 {
+		name = new_name
+	}
+
+	patch.rejected = true // we will drop this after we succeed
+
+	ce, info, status := check_preimage(state, patch)
+	if status != 0 {
+		return status
+	}
+	old_name = patch.old_name
+
+	// a type change is a deletion followed by a creation, and a swap of names is a rename followed by another.
+	// the path to be removed by other patches can be created.
+	ok_if_exists := false
+	if tpatch, ok := state.fn_table[new_name]; ok && (tpatch == path_was_deleted || tpatch == path_to_be_deleted) {
+		ok_if_exists = true
+	}
+
+	if len(new_name) > 0 && (patch.is_new > 0 || patch.is_rename || patch.is_copy) {
+		err := check_to_create(state, new_name, ok_if_exists)
+		if err != 0 && state.opts.ThreeWay {
+			patch.direct_to_threeway = true
+		} else {
+			switch err {
+			case 0:
+				// happy
+			case EXISTS_IN_INDEX:
+				return apply_error("%s: already exists in index", new_name)
+			case EXISTS_IN_INDEX_AS_ITA:
+				return apply_error("%s: does not match index", new_name)
+			case EXISTS_IN_WORKTREE:
+				return apply_error("%s: already exists in working directory", new_name)
+			default:
+				return err
+			}
+		}
+
+		if patch.new_mode == 0 {
+			if patch.is_new > 0 {
+				patch.new_mode = 0100644
+			} else {
+				patch.new_mode = patch.old_mode
+			}
+		}
+	}
+
+	if len(new_name) > 0 && len(old_name) > 0 {
+		if patch.new_mode == 0 {
+			patch.new_mode = patch.old_mode
+		}
+		if (patch.old_mode^patch.new_mode)&0170000 != 0 {
+			if old_name == new_name {
+				return apply_error("new mode (%o) of %s does not match old mode (%o)", patch.new_mode, new_name, patch.old_mode)
+			}
+			return apply_error("new mode (%o) of %s does not match old mode (%o) of %s", patch.new_mode, new_name, patch.old_mode, old_name)
+		}
+	}
+
+	if check_unsafe_path(patch) < 0 {
+		return -128
+	}
+
+	// reading from or deleting a path beyond a symbolic link is prevented by load_patch_target
+	if patch.is_delete <= 0 && path_is_beyond_symlink(state, patch.new_name) {
+		return apply_error("affected file '%s' is beyond a symbolic link", patch.new_name)
+	}
+
+	if apply_data(state, patch, info, ce) < 0 {
+		return apply_error("%s: patch does not apply", name)
+	}
+	patch.rejected = false
+	return 0
+}
+
+// check_preimage checks the file to be patched exists and matches the index, and fills the old mode.
+func check_preimage(state *ApplyState, patch *Patch) (*DircacheEntry, os.FileInfo, int) {
+	old_name := patch.old_name
+	if len(old_name) == 0 {
+		return nil, nil, 0
+	}
+
+	var ce *DircacheEntry
+	var info os.FileInfo
+	var st_mode uint32
+	previous, gone := previous_patch(state, patch)
+	if gone {
+		return nil, nil, apply_error("path %s has been renamed/deleted", old_name)
+	}
+
+	var stat_err error
+	if previous != nil {
+		st_mode = previous.new_mode
+	} else if state.opts.Cached == false {
+		info, stat_err = os.Lstat(old_name)
+		if stat_err != nil && os.IsNotExist(stat_err) == false {
+			return nil, nil, apply_error("%s: %s", old_name, strerror(stat_err))
+		}
+	}
+
+	if state.check_index && previous == nil {
+		pos := find_dircache_entry(state.d, old_name)
+		if pos < 0 {
+			if patch.is_new < 0 {
+				return check_preimage_is_new(patch)
+			}
+			return nil, nil, apply_error("%s: does not exist in index", old_name)
+		}
+		ce = state.d.Entries[pos]
+		if stat_err != nil {
+			// the file removed from the work tree is checked out
+			if err := checkout_entry(state.repop, state.d, ce, "", false); err != nil {
+				return nil, nil, apply_error("cannot checkout %s", old_name)
+			}
+			if info, stat_err = os.Lstat(old_name); stat_err != nil {
+				return nil, nil, apply_error("%s: %s", old_name, strerror(stat_err))
+			}
+		}
+		if state.opts.Cached == false && verify_index_match(state, ce) == false {
+			return nil, nil, apply_error("%s: does not match index", old_name)
+		}
+		if state.opts.Cached {
+			st_mode = ce.Mode
+		}
+	} else if stat_err != nil {
+		if patch.is_new < 0 {
+			return check_preimage_is_new(patch)
+		}
+		return nil, nil, apply_error("%s: %s", old_name, strerror(stat_err))
+	}
+
+	if state.opts.Cached == false && previous == nil {
+		if ce != nil && state.d.no_symlinks {
+			st_mode = worktree_mode(state.d, ce, info)
+		} else {
+			st_mode = dircache_mode(info)
+		}
+	}
+
+	if patch.is_new < 0 {
+		patch.is_new = 0
+	}
+	if patch.old_mode == 0 {
+		patch.old_mode = st_mode
+	}
+	if (st_mode^patch.old_mode)&0170000 != 0 {
+		return nil, nil, apply_error("%s: wrong type", old_name)
+	}
+	if st_mode != patch.old_mode {
+		fmt.Fprintf(os.Stderr, "warning: %s has type %o, expected %o\n", old_name, st_mode, patch.old_mode)
+	}
+	if patch.new_mode == 0 && patch.is_delete <= 0 {
+		patch.new_mode = st_mode
+	}
+	return ce, info, 0
+}
+
+// check_preimage_is_new turns the patch of a missing file into a creation. (only for diff(1) patches)
+func check_preimage_is_new(patch *Patch) (*DircacheEntry, os.FileInfo, int) {
+	patch.is_new = 1
+	patch.is_delete = 0
+	patch.old_name = ""
+	return nil, nil, 0
+}
+
+// verify_index_match reports whether the file in the work tree matches the index entry.
+func verify_index_match(state *ApplyState, ce *DircacheEntry) bool {
+	if ce.Mode == 0160000 {
+		info, err := os.Lstat(string(ce.PathName))
+		return err == nil && info.IsDir()
+	}
+	modified, err := is_modified(state.d, ce)
+	return err == nil && modified == false
+}
+
+// check_to_create checks the path to create doesn't exist in the index and the work tree.
+func check_to_create(state *ApplyState, new_name string, ok_if_exists bool) int {
+	if state.check_index && (ok_if_exists == false || state.opts.Cached == false) {
+		if pos := find_dircache_entry(state.d, new_name); pos >= 0 {
+			ce := state.d.Entries[pos]
+			// allow intent-to-add entries, as they do not yet exist in the index
+			if ok_if_exists == false && ce.intent_to_add() == false {
+				return EXISTS_IN_INDEX
+			}
+			// intent-to-add entries can never match working tree files
+			if state.opts.Cached == false && ce.intent_to_add() {
+				return EXISTS_IN_INDEX_AS_ITA
+			}
+		}
+	}
+
+	if state.opts.Cached {
+		return 0
+	}
+	info, err := os.Lstat(new_name)
+	if err == nil {
+		if info.IsDir() || ok_if_exists {
+			return 0
+		}
+		// a leading directory might be a symbolic link which is going to be removed by the patches
+		if has_symlink_leading_path(new_name) {
+			return 0
+		}
+		return EXISTS_IN_WORKTREE
+	} else if os.IsNotExist(err) == false && err.(*os.PathError).Err != syscall.ENOTDIR {
+		return apply_error("%s: %s", new_name, strerror(err))
+	}
+	return 0
+}
+
+// check_unsafe_path checks the paths can be recorded in the index.
+func check_unsafe_path(patch *Patch) int {
+	old_name, new_name := "", ""
+	if patch.is_delete > 0 || (patch.is_new <= 0 && patch.is_copy == false) {
+		old_name = patch.old_name
+	}
+	if patch.is_delete <= 0 {
+		new_name = patch.new_name
+	}
+	if len(old_name) > 0 && verify_path(old_name) == false {
+		return apply_error("invalid path '%s'", old_name)
+	}
+	if len(new_name) > 0 && verify_path(new_name) == false {
+		return apply_error("invalid path '%s'", new_name)
+	}
+	return 0
+}
+
+// read_file_or_gitlink reads the blob of the entry. gitlinks are read as "Subproject commit <sha1>".
+func read_file_or_gitlink(state *ApplyState, ce *DircacheEntry) ([]byte, error) {
+	if ce == nil {
+		return nil, nil
+	}
+	if ce.Mode == 0160000 {
+		return []byte(fmt.Sprintf("Subproject commit %x\n", ce.Sha1)), nil
+	}
+	_, b, err := read_object_file(state.repop, fmt.Sprintf("%x", ce.Sha1))
+	return b, err
+}
+
+// load_patch_target reads the file to be patched from the index or the work tree.
+func load_patch_target(state *ApplyState, ce *DircacheEntry, info os.FileInfo, name string, expected_mode uint32) ([]byte, int) {
+	if state.opts.Cached || state.check_index {
+		b, err := read_file_or_gitlink(state, ce)
+		if err != nil {
+			return nil, apply_error("failed to read %s", name)
+		}
+		return b, 0
+	}
+	if len(name) == 0 {
+		return nil, 0
+	}
+	if expected_mode == 0160000 {
+		if ce != nil {
+			b, _ := read_file_or_gitlink(state, ce)
+			return b, 0
+		}
+		return nil, SUBMODULE_PATCH_WITHOUT_INDEX
+	}
+	if has_symlink_leading_path(name) {
+		return nil, apply_error("reading from '%s' beyond a symbolic link", name)
+	}
+
+	var b []byte
+	var err error
+	switch {
+	case info == nil:
+		err = fmt.Errorf("%s: not found", name)
+	case info.Mode()&os.ModeSymlink != 0:
+		var target string
+		if target, err = os.Readlink(name); err != nil {
+			return nil, apply_error("unable to read symlink %s", name)
+		}
+		b = []byte(target)
+	case info.Mode().IsRegular():
+		if b, err = ioutil.ReadFile(name); err != nil {
+			return nil, apply_error("unable to open or read %s", name)
+		}
+	default:
+		err = fmt.Errorf("%s: not a file", name)
+	}
+	if err != nil {
+		return nil, apply_error("failed to read %s", name)
+	}
+	return b, 0
+}
+
+// load_preimage reads the contents to be patched. the result of the earlier patch is used if any.
+func load_preimage(state *ApplyState, patch *Patch, info os.FileInfo, ce *DircacheEntry) (*ApplyImage, int) {
+	previous, gone := previous_patch(state, patch)
+	if gone {
+		return nil, apply_error("path %s has been renamed/deleted", patch.old_name)
+	}
+
+	var b []byte
+	if previous != nil {
+		// we have a patched copy in memory; use that
+		b = previous.result
+	} else {
+		var status int
+		b, status = load_patch_target(state, ce, info, patch.old_name, patch.old_mode)
+		if status < 0 {
+			return nil, status
+		} else if status == SUBMODULE_PATCH_WITHOUT_INDEX {
+			// there is no way to apply subproject patch without looking at the index
+			patch.fragments = nil
+		}
+	}
+	return prepare_image(b, patch.is_binary == false), 0
+}
+
+// load_current reads the file which exists at the path the patch creates, for the three-way merge.
+func load_current(state *ApplyState, patch *Patch) (*ApplyImage, int) {
+	name := patch.new_name
+	pos := find_dircache_entry(state.d, name)
+	if pos < 0 {
+		return nil, apply_error("%s: does not exist in index", name)
+	}
+	ce := state.d.Entries[pos]
+	info, err := os.Lstat(name)
+	if err != nil {
+		if os.IsNotExist(err) == false {
+			return nil, apply_error("%s: %s", name, strerror(err))
+		}
+		if err := checkout_entry(state.repop, state.d, ce, "", false); err != nil {
+			return nil, apply_error("cannot checkout %s", name)
+		}
+		if info, err = os.Lstat(name); err != nil {
+			return nil, apply_error("%s: %s", name, strerror(err))
+		}
+	}
+	if state.opts.Cached == false && verify_index_match(state, ce) == false {
+		return nil, apply_error("%s: does not match index", name)
+	}
+
+	b, status := load_patch_target(state, ce, info, name, patch.new_mode)
+	if status != 0 {
+		return nil, -1
+	}
+	return prepare_image(b, patch.is_binary == false), 0
+}
+
+// try_threeway applies the patch to the blob it was made for, and merges the result and the current contents.
+// It returns -1 if the merge can't be done, and the patch is applied directly.
+func try_threeway(state *ApplyState, img *ApplyImage, patch *Patch, info os.FileInfo, ce *DircacheEntry) int {
+	if patch.is_delete > 0 || patch.old_mode == 0160000 || patch.new_mode == 0160000 ||
+		(patch.is_new > 0 && patch.direct_to_threeway == false) ||
+		(patch.is_rename && patch.lines_added == 0 && patch.lines_deleted == 0) {
+		return -1
+	}
+
+	// preimage the patch was prepared for
+	var pre []byte
+	var pre_oid [20]byte
+	if patch.is_new > 0 {
+		key, _ := hash_object(true, bytes.NewReader(nil))
+		copy(pre_oid[:], key)
+	} else {
+		sha, err := resolve_object_name(state.repop, patch.old_oid_prefix)
+		var t string
+		if err == nil {
+			t, pre, err = read_object_file(state.repop, sha)
+		}
+		if err != nil || t != "blob" {
+			return apply_error("repository lacks the necessary blob to perform 3-way merge.")
+		}
+		pre_oid, _ = parse_sha1_hex(sha)
+	}
+	if state.opts.Verbose && patch.direct_to_threeway {
+		fmt.Fprintf(os.Stderr, "Performing three-way merge...\n")
+	}
+
+	// apply the patch to get the postimage (theirs)
+	tmp_image := prepare_image(pre, true)
+	if apply_fragments(state, tmp_image, patch) < 0 {
+		return -1
+	}
+	theirs := tmp_image.contents()
+	key, err := hash_object(true, bytes.NewReader(theirs))
+	if err != nil {
+		return apply_error("%v", err)
+	}
+	var post_oid [20]byte
+	copy(post_oid[:], key)
+
+	// the current contents (ours)
+	var status int
+	if patch.is_new > 0 {
+		tmp_image, status = load_current(state, patch)
+		if status < 0 {
+			return apply_error("cannot read the current contents of '%s'", patch.new_name)
+		}
+	} else {
+		tmp_image, status = load_preimage(state, patch, info, ce)
+		if status < 0 {
+			return apply_error("cannot read the current contents of '%s'", patch.old_name)
+		}
+	}
+	ours := tmp_image.contents()
+	key, err = hash_object(true, bytes.NewReader(ours))
+	if err != nil {
+		return apply_error("%v", err)
+	}
+	var our_oid [20]byte
+	copy(our_oid[:], key)
+
+	// in-core three-way merge between post and our using pre as base
+	result, conflicts := merge_contents(patch.new_name, pre, ours, theirs)
+	*img = *prepare_image(result, true)
+	if conflicts > 0 {
+		patch.conflicted_threeway = true
+		if patch.is_new <= 0 {
+			patch.threeway_stage[0] = &pre_oid
+		}
+		patch.threeway_stage[1] = &our_oid
+		patch.threeway_stage[2] = &post_oid
+		fmt.Fprintf(os.Stderr, "Applied patch to '%s' with conflicts.\n", patch.new_name)
+	} else {
+		fmt.Fprintf(os.Stderr, "Applied patch to '%s' cleanly.\n", patch.new_name)
+	}
+	return 0
+}
+
+// merge_contents merges the contents as the default merge driver does. binary files are not merged and ours is taken.
+func merge_contents(path string, base []byte, ours []byte, theirs []byte) ([]byte, int) {
+	if buffer_is_binary(base) || buffer_is_binary(ours) || buffer_is_binary(theirs) {
+		fmt.Fprintf(os.Stderr, "warning: Cannot merge binary files: %s (%s vs. %s)\n", path, "ours", "theirs")
+		return ours, 1
+	}
+	return xdl_merge(MergeFile{Data: base, Label: "base"}, MergeFile{Data: ours, Label: "ours"}, MergeFile{Data: theirs, Label: "theirs"}, XDL_MERGE_ZEALOUS)
+}
+
+// apply_data makes the result of the patch.
+func apply_data(state *ApplyState, patch *Patch, info os.FileInfo, ce *DircacheEntry) int {
+	img, status := load_preimage(state, patch, info, ce)
+	if status < 0 {
+		return -1
+	}
+
+	if state.opts.ThreeWay == false || try_threeway(state, img, patch, info, ce) < 0 {
+		if state.opts.ThreeWay && patch.direct_to_threeway == false {
+			fmt.Fprintf(os.Stderr, "Falling back to direct application...\n")
+		}
+		if patch.direct_to_threeway || apply_fragments(state, img, patch) < 0 {
+			return -1
+		}
+	}
+	patch.result = img.contents()
+	add_to_fn_table(state, patch)
+
+	if patch.is_delete > 0 && len(patch.result) > 0 {
+		return apply_error("removal patch leaves file contents")
+	}
+	return 0
+}
+
+//////////////////////////////
+// write results
+//////////////////////////////
+
+// write_out_results removes the old files first, and then creates the new files,
+// so that renames to each other's names work. It returns 1 if some patches have conflicts.
+func write_out_results(state *ApplyState, list []*Patch) int {
+	errs := 0
+	var cpath []string
+	for phase := 0; phase < 2; phase++ {
+		for _, l := range list {
+			if l.rejected {
+				errs = 1
+				continue
+			}
+			if write_out_one_result(state, l, phase) < 0 {
+				return -1
+			}
+			if phase == 1 {
+				if state.opts.Verbose {
+					fmt.Fprintf(os.Stderr, "Applied patch %s cleanly.\n", patch_name(l))
+				}
+				if l.conflicted_threeway {
+					cpath = append(cpath, l.new_name)
+					errs = 1
+				}
+			}
+		}
+	}
+
+	sort.Strings(cpath)
+	for _, p := range cpath {
+		fmt.Fprintf(os.Stderr, "U %s\n", p)
+	}
+	return errs
+}
+
+func write_out_one_result(state *ApplyState, patch *Patch, phase int) int {
+	if patch.is_delete > 0 {
+		if phase == 0 {
+			return remove_file(state, patch, true)
+		}
+		return 0
+	}
+	if patch.is_new > 0 || patch.is_copy {
+		if phase == 1 {
+			return create_file(state, patch)
+		}
+		return 0
+	}
+	// rename or modification boils down to the same thing: remove the old, write the new
+	if phase == 0 {
+		return remove_file(state, patch, patch.is_rename)
+	}
+	return create_file(state, patch)
+}
+
+// remove_file removes the old file from the index and the work tree.
+// empty leading directories are removed as well with rmdir_empty.
+func remove_file(state *ApplyState, patch *Patch, rmdir_empty bool) int {
+	if state.update_index {
+		remove_dircache_entry(state.d, patch.old_name)
+	}
+	if state.opts.Cached {
+		return 0
+	}
+
+	var err error
+	if patch.old_mode == 0160000 {
+		err = syscall.Rmdir(patch.old_name)
+	} else {
+		err = os.Remove(patch.old_name)
+	}
+	if err != nil {
+		if os.IsNotExist(err) == false {
+			fmt.Fprintf(os.Stderr, "warning: unable to unlink '%s': %s\n", patch.old_name, strerror(err))
+		}
+		return 0
+	}
+	if rmdir_empty {
+		for dir := filepath.Dir(patch.old_name); dir != "."; dir = filepath.Dir(dir) {
+			if syscall.Rmdir(dir) != nil {
+				break
+			}
+		}
+	}
+	return 0
+}
+
+// create_file writes the result to the work tree and the index.
+func create_file(state *ApplyState, patch *Patch) int {
+	mode := patch.new_mode
+	if mode == 0 {
+		mode = 0100644
+	}
+	if create_one_file(state, patch.new_name, mode, patch.result) < 0 {
+		return -1
+	}
+	if patch.conflicted_threeway {
+		return add_conflicted_stages_file(state, patch)
+	} else if state.update_index {
+		return add_index_file(state, patch.new_name, mode, patch.result)
+	}
+	return 0
+}
+
+// create_one_file writes the file. a file or an empty directory at the path is replaced.
+func create_one_file(state *ApplyState, path string, mode uint32, buf []byte) int {
+	if state.opts.Cached {
+		return 0
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return apply_error("unable to write file '%s' mode %o", path, mode)
+	}
+	// we may be trying to create a file where a directory used to be
+	if info, err := os.Lstat(path); err == nil && (info.IsDir() == false || mode != 0160000) {
+		if info.IsDir() {
+			syscall.Rmdir(path)
+		} else {
+			os.Remove(path)
+		}
+	}
+
+	var err error
+	switch {
+	case mode == 0160000:
+		if info, e := os.Lstat(path); e != nil || info.IsDir() == false {
+			err = os.Mkdir(path, 0777)
+		}
+	case mode&0170000 == 0120000 && state.no_symlinks == false:
+		err = os.Symlink(string(buf), path)
+	default:
+		perm := os.FileMode(0666)
+		if mode&0100 != 0 {
+			perm = 0777
+		}
+		err = ioutil.WriteFile(path, buf, perm)
+	}
+	if err != nil {
+		return apply_error("unable to write file '%s' mode %o: %s", path, mode, strerror(err))
+	}
+	return 0
+}
+
+// create_ce_mode returns the mode recorded in the index.
+func create_ce_mode(mode uint32) uint32 {
+	switch mode & 0170000 {
+	case 0120000:
+		return 0120000
+	case 0040000, 0160000:
+		return 0160000
+	}
+	if mode&0100 != 0 {
+		return 0100755
+	}
+	return 0100644
+}
+
+// add_index_file adds the result to the index. the stat data is taken unless --cached.
+func add_index_file(state *ApplyState, path string, mode uint32, buf []byte) int {
+	var sha [20]byte
+	if mode == 0160000 {
+		s := string(bytes.TrimSuffix(buf, []byte("\n")))
+		var ok bool
+		if strings.HasPrefix(s, "Subproject commit ") == false {
+			return apply_error("corrupt patch for submodule %s", path)
+		}
+		if sha, ok = parse_sha1_hex(s[len("Subproject commit "):]); ok == false {
+			return apply_error("corrupt patch for submodule %s", path)
+		}
+	} else {
+		key, err := hash_object(true, bytes.NewReader(buf))
+		if err != nil {
+			return apply_error("unable to create backing store for newly created file %s", path)
+		}
+		copy(sha[:], key)
+	}
+
+	e := new_dircache_entry(path, sha, create_ce_mode(mode), 0)
+	if state.opts.Cached == false && mode != 0160000 {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return apply_error("unable to stat newly created file '%s': %s", path, strerror(err))
+		}
+		fill_dircache_stat(e, info)
+	}
+	add_dircache_entry(state.d, e)
+	return 0
+}
+
+// add_conflicted_stages_file records the base, ours and theirs of the three-way merge in the index.
+func add_conflicted_stages_file(state *ApplyState, patch *Patch) int {
+	if state.update_index == false {
+		return 0
+	}
+	mode := patch.new_mode
+	if mode == 0 {
+		mode = 0100644
+	}
+	remove_dircache_entry(state.d, patch.new_name)
+	for stage := 1; stage < 4; stage++ {
+		sha := patch.threeway_stage[stage-1]
+		if sha == nil {
+			continue
+		}
+		add_dircache_entry(state.d, new_dircache_entry(patch.new_name, *sha, create_ce_mode(mode), stage))
+	}
+	return 0
+}
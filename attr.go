@@ -0,0 +1,201 @@
+// See Also:
+// https://git-scm.com/docs/gitattributes
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ATTR_UNSPECIFIED = iota // '!attr' or not mentioned
+	ATTR_SET                // 'attr'
+	ATTR_UNSET              // '-attr'
+	ATTR_VALUE              // 'attr=value'
+)
+
+// attr_macros are the built-in macro attributes. setting a macro sets the attributes.
+var attr_macros = map[string]string{
+	"binary": "-diff -merge -text",
+}
+
+type AttrAssign struct {
+	Name  string
+	State int // ATTR_*
+	Value string
+}
+
+type AttrRule struct {
+	Pattern string // pattern without leading '/'
+	NoDir   bool   // pattern without '/' matches the basename at any level
+	DirOnly bool   // 'pattern/' matches only directories, so never matches files
+	Base    string // directory of the source file relative to the work tree ("" or "dir/")
+	Attrs   []AttrAssign
+}
+
+// AttrCheck looks up the attributes of paths.
+// $GIT_DIR/info/attributes wins over .gitattributes, and deeper .gitattributes win over shallower ones.
+type AttrCheck struct {
+	root    string
+	repop   string
+	flags   int // wildmatch flags (WM_CASEFOLD for core.ignorecase)
+	info    []*AttrRule
+	per_dir map[string][]*AttrRule
+
+	// .gitattributes which are not in the work tree are read from the index
+	index        *Dircache
+	index_loaded bool
+}
+
+func new_attr_check(repop string) *AttrCheck {
+	a := &AttrCheck{}
+	a.root = filepath.Dir(repop)
+	a.repop = repop
+	a.per_dir = make(map[string][]*AttrRule)
+	if get_config_bool(repop, "core.ignorecase", false) {
+		a.flags |= WM_CASEFOLD
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(repop, "info", "attributes")); err == nil {
+		a.info = parse_attr_rules(b, "")
+	}
+	return a
+}
+
+// parse_attr_rules parses lines of gitattributes format. macro definitions ('[attr]') and negative patterns are ignored.
+func parse_attr_rules(b []byte, base string) []*AttrRule {
+	var rules []*AttrRule
+	for _, l := range bytes.Split(b, []byte("\n")) {
+		fields := strings.Fields(strings.TrimSuffix(string(l), "\r"))
+		if len(fields) == 0 || fields[0][0] == '#' || fields[0][0] == '!' || strings.HasPrefix(fields[0], "[attr]") {
+			continue
+		}
+
+		r := &AttrRule{Base: base}
+		p := fields[0]
+		if strings.HasSuffix(p, "/") {
+			r.DirOnly = true
+			p = strings.TrimSuffix(p, "/")
+		}
+		if strings.Contains(p, "/") == false {
+			r.NoDir = true
+		}
+		r.Pattern = strings.TrimPrefix(p, "/")
+
+		for _, f := range fields[1:] {
+			r.Attrs = append(r.Attrs, parse_attr_assign(f)...)
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// parse_attr_assign parses 'attr', '-attr', '!attr' or 'attr=value'. a macro is followed by the attributes it sets.
+func parse_attr_assign(s string) []AttrAssign {
+	var x AttrAssign
+	switch {
+	case s[0] == '-':
+		x = AttrAssign{Name: s[1:], State: ATTR_UNSET}
+	case s[0] == '!':
+		x = AttrAssign{Name: s[1:], State: ATTR_UNSPECIFIED}
+	case strings.Contains(s, "="):
+		kv := strings.SplitN(s, "=", 2)
+		x = AttrAssign{Name: kv[0], State: ATTR_VALUE, Value: kv[1]}
+	default:
+		x = AttrAssign{Name: s, State: ATTR_SET}
+	}
+
+	attrs := []AttrAssign{x}
+	if macro, ok := attr_macros[x.Name]; ok && x.State == ATTR_SET {
+		for _, f := range strings.Fields(macro) {
+			attrs = append(attrs, parse_attr_assign(f)...)
+		}
+	}
+	return attrs
+}
+
+// check returns the state and the value of the attribute of the path.
+// later lines take precedence within a file.
+func (a *AttrCheck) check(path string, name string) (int, string) {
+	lists := [][]*AttrRule{a.info}
+	dir := path
+	for len(dir) > 0 {
+		if idx := strings.LastIndex(dir, "/"); idx >= 0 {
+			dir = dir[:idx]
+		} else {
+			dir = ""
+		}
+		lists = append(lists, a.per_directory_rules(dir))
+	}
+
+	for _, rules := range lists {
+		for i := len(rules) - 1; i >= 0; i-- {
+			r := rules[i]
+			if a.match_rule(r, path) == false {
+				continue
+			}
+			for k := len(r.Attrs) - 1; k >= 0; k-- {
+				if r.Attrs[k].Name == name {
+					return r.Attrs[k].State, r.Attrs[k].Value
+				}
+			}
+		}
+	}
+	return ATTR_UNSPECIFIED, ""
+}
+
+func (a *AttrCheck) match_rule(r *AttrRule, path string) bool {
+	if r.DirOnly {
+		return false
+	}
+	if r.NoDir {
+		return wildmatch(r.Pattern, path[strings.LastIndex(path, "/")+1:], a.flags)
+	}
+	// anchored to the directory of the source file
+	if strings.HasPrefix(path, r.Base) == false {
+		return false
+	}
+	return wildmatch(r.Pattern, path[len(r.Base):], a.flags|WM_PATHNAME)
+}
+
+// per_directory_rules returns the rules in .gitattributes of dir.
+func (a *AttrCheck) per_directory_rules(dir string) []*AttrRule {
+	if r, ok := a.per_dir[dir]; ok {
+		return r
+	}
+
+	base := ""
+	if len(dir) > 0 {
+		base = dir + "/"
+	}
+	var rules []*AttrRule
+	if b, err := a.read_attr_file(base + ".gitattributes"); err == nil {
+		rules = parse_attr_rules(b, base)
+	}
+	a.per_dir[dir] = rules
+	return rules
+}
+
+// read_attr_file reads the file in the work tree, or the blob in the index if the file doesn't exist.
+func (a *AttrCheck) read_attr_file(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(a.root, path))
+	if err == nil {
+		return b, nil
+	}
+
+	if a.index_loaded == false {
+		a.index, _ = load_dircache(a.repop)
+		a.index_loaded = true
+	}
+	if a.index == nil {
+		return nil, err
+	}
+	pos := find_dircache_entry(a.index, path)
+	if pos < 0 {
+		return nil, err
+	}
+	_, b, err = read_object_file(a.repop, fmt.Sprintf("%x", a.index.Entries[pos].Sha1))
+	return b, err
+}
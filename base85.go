@@ -0,0 +1,65 @@
+// See Also:
+// https://github.com/git/git/blob/master/base85.c
+package main
+
+import "fmt"
+
+var en85 = []byte("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~")
+
+// encode_85 encodes each 4 bytes of data to 5 characters. the last group is padded with zero.
+func encode_85(data []byte) []byte {
+	var buf []byte
+	for len(data) > 0 {
+		var acc uint32
+		for cnt := 24; cnt >= 0 && len(data) > 0; cnt -= 8 {
+			acc |= uint32(data[0]) << uint(cnt)
+			data = data[1:]
+		}
+		var group [5]byte
+		for cnt := 4; cnt >= 0; cnt-- {
+			group[cnt] = en85[acc%85]
+			acc /= 85
+		}
+		buf = append(buf, group[:]...)
+	}
+	return buf
+}
+
+// decode_85 decodes base85 characters into len bytes.
+func decode_85(buffer []byte, length int) ([]byte, error) {
+	var de85 [256]int
+	for i, c := range en85 {
+		de85[c] = i + 1
+	}
+
+	dst := make([]byte, 0, length)
+	for length > 0 {
+		if len(buffer) < 5 {
+			return nil, fmt.Errorf("invalid base85 sequence %s", buffer)
+		}
+		var acc uint32
+		for cnt := 0; cnt < 5; cnt++ {
+			de := de85[buffer[cnt]] - 1
+			if de < 0 {
+				return nil, fmt.Errorf("invalid base85 alphabet %c", buffer[cnt])
+			}
+			// detect overflow
+			if cnt == 4 && (0xffffffff/85 < acc || 0xffffffff-uint32(de) < acc*85) {
+				return nil, fmt.Errorf("invalid base85 sequence %s", buffer[:5])
+			}
+			acc = acc*85 + uint32(de)
+		}
+		buffer = buffer[5:]
+
+		cnt := 4
+		if length < 4 {
+			cnt = length
+		}
+		length -= cnt
+		for ; cnt > 0; cnt-- {
+			acc = acc<<8 | acc>>24
+			dst = append(dst, byte(acc))
+		}
+	}
+	return dst, nil
+}
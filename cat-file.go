@@ -14,7 +14,7 @@ import (
 type GitObject interface {
 	obj_type() string
 	obj_size() int
-	obj_string() string
+	obj_data() []byte
 }
 
 type BlobObject struct {
@@ -31,8 +31,9 @@ func (obj BlobObject) obj_size() int {
 	return obj.size
 }
 
-func (obj BlobObject) obj_string() string {
-	return string(obj.data)
+// obj_data returns the contents as they are. binary contents and the last line without newline are kept.
+func (obj BlobObject) obj_data() []byte {
+	return obj.data
 }
 
 func cat_file_cmd(opt_t bool, opt_s bool, opt_p bool, sha_strs []string) {
@@ -77,7 +78,7 @@ func cat_file_cmd(opt_t bool, opt_s bool, opt_p bool, sha_strs []string) {
 		}
 		// pretty print
 		if opt_p == true {
-			fmt.Println(obj.obj_string())
+			os.Stdout.Write(obj.obj_data())
 		}
 	}
 }
@@ -120,7 +121,49 @@ func read_object(f io.Reader) (GitObject, error) {
 	}
 
 	// blob
-	obj.data = b[size_sep+1 : size_sep+1+obj.size-1]
+	if obj.size != len(b)-size_sep-1 {
+		return nil, fmt.Errorf("Size mismatch.%d", obj.size)
+	}
+	obj.data = b[size_sep+1:]
 
 	return obj, nil
 }
+
+func read_object_file(repo_path string, sha string) (string, []byte, error) {
+	if len(sha) < 3 {
+		return "", nil, fmt.Errorf("Not a valid object name %s", sha)
+	}
+
+	// open object
+	p := filepath.Join(repo_path, "objects", sha[:2], sha[2:])
+	f, err := os.Open(p)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	// uncompress zlib
+	zreader, err := zlib.NewReader(f)
+	if err != nil {
+		return "", nil, err
+	}
+	defer zreader.Close()
+
+	b, err := ioutil.ReadAll(zreader)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// '<type> <size>\x00<contents>'
+	type_sep := bytes.IndexByte(b, ' ')
+	size_sep := bytes.IndexByte(b, 0)
+	if type_sep < 0 || size_sep < type_sep {
+		return "", nil, fmt.Errorf("%s: bad object header", sha)
+	}
+	size, err := strconv.Atoi(string(b[type_sep+1 : size_sep]))
+	if err != nil || size != len(b)-size_sep-1 {
+		return "", nil, fmt.Errorf("%s: bad object size", sha)
+	}
+
+	return string(b[:type_sep]), b[size_sep+1:], nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func check_ignore_cmd(verbose bool, no_index bool, stdin bool, paths []string) {
+	repop, prefix, err := setup_git_directory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
+		os.Exit(128)
+	}
+
+	x := new_ignore_rules(repop)
+	if err := x.add_exclude_standard(repop); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+		os.Exit(128)
+	}
+
+	// tracked files are not subject to exclude rules
+	var d *Dircache
+	if no_index == false {
+		d, err = load_dircache(repop)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+			os.Exit(128)
+		}
+	}
+
+	num_ignored := 0
+	check := func(arg string) {
+		path, err := normalize_path(x.root, prefix, arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+			os.Exit(128)
+		}
+		if d != nil && find_dircache_entry(d, path) >= 0 {
+			return
+		}
+
+		is_dir := strings.HasSuffix(path, "/")
+		path = strings.TrimSuffix(path, "/")
+		if info, err := os.Lstat(filepath.Join(x.root, path)); err == nil && info.IsDir() {
+			is_dir = true
+		}
+
+		p := x.match(path, is_dir)
+		if p == nil || (p.Negative && verbose == false) {
+			return
+		}
+		num_ignored++
+
+		if verbose {
+			fmt.Printf("%s:%d:%s\t%s\n", p.Source, p.LineNo, p.Text, arg)
+		} else {
+			fmt.Println(arg)
+		}
+	}
+
+	if stdin {
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			check(s.Text())
+		}
+		if err := s.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+			os.Exit(128)
+		}
+	} else {
+		for _, p := range paths {
+			check(p)
+		}
+	}
+
+	if num_ignored == 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func checkout_index_cmd(all bool, force bool, prefix string, paths []string) {
+	repop, cwd_prefix, err := setup_git_directory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
+		exit(128)
+	}
+
+	// lock and read dircache
+	d, err := lock_dircache(repop)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		exit(128)
+	}
+
+	var targets []*DircacheEntry
+	failed := false
+	if all {
+		for _, e := range d.Entries {
+			// unmerged and skip-worktree entries are skipped
+			if e.stage() == 0 && e.skip_worktree() == false {
+				targets = append(targets, e)
+			}
+		}
+	}
+	for _, arg := range paths {
+		p, err := normalize_path(filepath.Dir(repop), cwd_prefix, arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+			exit(128)
+		}
+		idx := find_dircache_entry(d, p)
+		if idx < 0 {
+			fmt.Fprintf(os.Stderr, "error: %s is not in the cache\n", p)
+			failed = true
+			continue
+		}
+		if d.Entries[idx].stage() != 0 {
+			fmt.Fprintf(os.Stderr, "error: %s is unmerged\n", p)
+			failed = true
+			continue
+		}
+		targets = append(targets, d.Entries[idx])
+	}
+
+	for _, e := range targets {
+		if err := checkout_entry(repop, d, e, prefix, force); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			failed = true
+		}
+	}
+
+	// write refreshed stat data
+	if len(prefix) == 0 {
+		err = write_dircache(d, repop)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+			exit(128)
+		}
+	}
+
+	if failed {
+		exit(1)
+	}
+}
+
+// checkout_entry writes the blob of the entry to the working tree.
+// the stat data of the entry is refreshed when prefix is empty.
+func checkout_entry(repop string, d *Dircache, e *DircacheEntry, prefix string, force bool) error {
+	path := prefix + string(e.PathName)
+
+	// gitlinks are checked out as empty directories. the nested repository is not cloned
+	if e.Mode == 0160000 {
+		if err := os.MkdirAll(path, 0777); err != nil {
+			return err
+		}
+		if len(prefix) == 0 {
+			info, err := os.Lstat(path)
+			if err != nil {
+				return err
+			}
+			fill_dircache_stat(e, info)
+		}
+		return nil
+	}
+
+	// refuse to overwrite modified files
+	if info, err := os.Lstat(path); err == nil {
+		if info.IsDir() == false && len(prefix) == 0 {
+			m, err := is_modified(d, e)
+			if err != nil {
+				return err
+			}
+			if m == false {
+				fill_dircache_stat(e, info)
+				return nil
+			}
+		}
+		if force == false {
+			return fmt.Errorf("%s already exists, no checkout", path)
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	} else if os.IsNotExist(err) == false {
+		return err
+	}
+
+	t, b, err := read_object_file(repop, fmt.Sprintf("%x", e.Sha1))
+	if err != nil {
+		return fmt.Errorf("unable to read sha1 file of %s (%x)", path, e.Sha1)
+	}
+	if t != "blob" {
+		return fmt.Errorf("%s: %x is not a blob", path, e.Sha1)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+
+	switch {
+	case e.Mode&0170000 == 0120000 && d.no_symlinks == false:
+		// symbolic link. the blob is the link target
+		if err := os.Symlink(string(b), path); err != nil {
+			return err
+		}
+	default:
+		// a symbolic link is written as a plain file of the link target without core.symlinks
+		perm := os.FileMode(0666)
+		if e.Mode&0111 != 0 {
+			perm = 0777
+		}
+		if err := ioutil.WriteFile(path, b, perm); err != nil {
+			return err
+		}
+	}
+
+	if len(prefix) == 0 {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		fill_dircache_stat(e, info)
+	}
+	return nil
+}
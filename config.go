@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// get_config returns the value of 'section.key' (ex. 'core.filemode') in the repository config.
+// the last one wins if the key is defined multiple times.
+func get_config(repop string, name string) (string, bool) {
+	b, err := ioutil.ReadFile(filepath.Join(repop, "config"))
+	if err != nil {
+		return "", false
+	}
+
+	name = strings.ToLower(name)
+	value := ""
+	found := false
+	for _, c := range parse_config(b) {
+		if c.Name == name {
+			value = c.Value
+			found = true
+		}
+	}
+	return value, found
+}
+
+type ConfigEntry struct {
+	Name  string // 'section.key' or 'section.subsection.key'. section and key are lower case.
+	Value string
+}
+
+// parse_config parses the git config format. (.git/config and .gitmodules)
+func parse_config(b []byte) []ConfigEntry {
+	var entries []ConfigEntry
+	section := ""
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		// [section] or [section "subsection"]
+		if line[0] == '[' {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				continue
+			}
+			h := strings.TrimSpace(line[1:end])
+			if sp := strings.Index(h, " "); sp >= 0 {
+				section = strings.ToLower(h[:sp]) + "." + strings.Trim(strings.TrimSpace(h[sp+1:]), "\"")
+			} else {
+				section = strings.ToLower(h)
+			}
+			continue
+		}
+
+		// key = value
+		k := line
+		v := "true"
+		if eq := strings.Index(line, "="); eq >= 0 {
+			k = strings.TrimSpace(line[:eq])
+			v = strings.Trim(strings.TrimSpace(line[eq+1:]), "\"")
+		}
+		entries = append(entries, ConfigEntry{section + "." + strings.ToLower(k), v})
+	}
+	return entries
+}
+
+func get_config_int(repop string, name string, def int) int {
+	v, ok := get_config(repop, name)
+	if ok == false {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+func get_config_bool(repop string, name string, def bool) bool {
+	v, ok := get_config(repop, name)
+	if ok == false {
+		return def
+	}
+	switch strings.ToLower(v) {
+	case "true", "yes", "on", "1":
+		return true
+	case "false", "no", "off", "0", "":
+		return false
+	}
+	return def
+}
@@ -0,0 +1,184 @@
+// See Also:
+// https://github.com/git/git/blob/master/diff-delta.c
+// https://git-scm.com/docs/pack-format#_deltified_representation
+package main
+
+import "fmt"
+
+const (
+	DELTA_WINDOW     = 16      // bytes of the blocks of the source indexed
+	DELTA_HASH_LIMIT = 64      // blocks kept for the same contents
+	DELTA_MAX_COPY   = 0x10000 // bytes copied by one instruction
+	DELTA_MAX_INSERT = 0x7f    // bytes inserted by one instruction
+)
+
+// diff_delta returns the delta which makes trg from src. the delta is a sequence of instructions
+// to copy a part of src or to insert bytes, after the sizes of src and trg.
+// nil is returned if the delta would be larger than max_size (if not 0).
+func diff_delta(src []byte, trg []byte, max_size int) []byte {
+	index := make(map[string][]int)
+	for i := 0; i+DELTA_WINDOW <= len(src); i += DELTA_WINDOW {
+		k := string(src[i : i+DELTA_WINDOW])
+		if len(index[k]) < DELTA_HASH_LIMIT {
+			index[k] = append(index[k], i)
+		}
+	}
+
+	out := append(encode_delta_size(len(src)), encode_delta_size(len(trg))...)
+	var insert []byte
+	flush_insert := func() {
+		for len(insert) > 0 {
+			n := len(insert)
+			if n > DELTA_MAX_INSERT {
+				n = DELTA_MAX_INSERT
+			}
+			out = append(out, byte(n))
+			out = append(out, insert[:n]...)
+			insert = insert[n:]
+		}
+	}
+
+	for i := 0; i < len(trg); {
+		best_off, best_len := 0, 0
+		if i+DELTA_WINDOW <= len(trg) {
+			for _, off := range index[string(trg[i:i+DELTA_WINDOW])] {
+				l := 0
+				for off+l < len(src) && i+l < len(trg) && l < DELTA_MAX_COPY && src[off+l] == trg[i+l] {
+					l++
+				}
+				if l > best_len {
+					best_off, best_len = off, l
+				}
+			}
+		}
+		if best_len == 0 {
+			insert = append(insert, trg[i])
+			i++
+			continue
+		}
+
+		// the bytes before the block may match too
+		for len(insert) > 0 && best_off > 0 && best_len < DELTA_MAX_COPY && src[best_off-1] == insert[len(insert)-1] {
+			insert = insert[:len(insert)-1]
+			best_off--
+			best_len++
+			i--
+		}
+		flush_insert()
+		out = append(out, encode_delta_copy(best_off, best_len)...)
+		i += best_len
+		if max_size > 0 && len(out) > max_size {
+			return nil
+		}
+	}
+	flush_insert()
+	if max_size > 0 && len(out) > max_size {
+		return nil
+	}
+	return out
+}
+
+// encode_delta_size encodes the size by 7 bits from the least significant bits. the MSB means more bytes follow.
+func encode_delta_size(size int) []byte {
+	var b []byte
+	for size >= 0x80 {
+		b = append(b, byte(size)|0x80)
+		size >>= 7
+	}
+	return append(b, byte(size))
+}
+
+// encode_delta_copy encodes the copy instruction. only non-zero bytes of the offset and the size are written,
+// and the lower bits of the first byte tell which bytes are written. the size 0x10000 is written as 0.
+func encode_delta_copy(off int, size int) []byte {
+	if size == DELTA_MAX_COPY {
+		size = 0
+	}
+	b := []byte{0x80}
+	for i := uint(0); i < 4; i++ {
+		if v := byte(off >> (8 * i)); v != 0 {
+			b[0] |= 1 << i
+			b = append(b, v)
+		}
+	}
+	for i := uint(0); i < 3; i++ {
+		if v := byte(size >> (8 * i)); v != 0 {
+			b[0] |= 0x10 << i
+			b = append(b, v)
+		}
+	}
+	return b
+}
+
+// patch_delta applies the delta made by diff_delta to src.
+func patch_delta(src []byte, delta []byte) ([]byte, error) {
+	src_size, delta, ok := decode_delta_size(delta)
+	if ok == false || src_size != len(src) {
+		return nil, fmt.Errorf("delta source size mismatch")
+	}
+	size, delta, ok := decode_delta_size(delta)
+	if ok == false {
+		return nil, fmt.Errorf("delta replay has gone wild")
+	}
+
+	dst := make([]byte, 0, size)
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+		switch {
+		case cmd&0x80 != 0:
+			// copy from src. the bits tell which bytes of the offset and the size follow
+			off, cp_size := 0, 0
+			for i := uint(0); i < 7; i++ {
+				if cmd&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, fmt.Errorf("delta replay has gone wild")
+				}
+				if i < 4 {
+					off |= int(delta[0]) << (8 * i)
+				} else {
+					cp_size |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if cp_size == 0 {
+				cp_size = DELTA_MAX_COPY
+			}
+			if off+cp_size > len(src) || len(dst)+cp_size > size {
+				return nil, fmt.Errorf("delta replay has gone wild")
+			}
+			dst = append(dst, src[off:off+cp_size]...)
+		case cmd != 0:
+			// insert the following bytes
+			n := int(cmd)
+			if n > len(delta) || len(dst)+n > size {
+				return nil, fmt.Errorf("delta replay has gone wild")
+			}
+			dst = append(dst, delta[:n]...)
+			delta = delta[n:]
+		default:
+			// reserved for future encoding extensions
+			return nil, fmt.Errorf("unexpected delta opcode 0")
+		}
+	}
+	if len(dst) != size {
+		return nil, fmt.Errorf("delta replay has gone wild")
+	}
+	return dst, nil
+}
+
+// decode_delta_size decodes the size encoded by encode_delta_size and returns the rest.
+func decode_delta_size(b []byte) (int, []byte, bool) {
+	size := 0
+	for shift := uint(0); len(b) > 0; shift += 7 {
+		c := b[0]
+		b = b[1:]
+		size |= int(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return size, b, true
+		}
+	}
+	return 0, b, false
+}
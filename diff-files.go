@@ -0,0 +1,139 @@
+// See Also:
+// https://git-scm.com/docs/git-diff-files
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func diff_files_cmd(opts DiffOptions, args []string) {
+	repop, prefix, err := setup_git_directory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
+		os.Exit(128)
+	}
+
+	d, err := load_dircache(repop)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+		os.Exit(128)
+	}
+
+	pathspec, err := parse_pathspec(filepath.Dir(repop), prefix, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+
+	diff_setup_done(repop, &opts)
+	q, err := run_diff_files(d, pathspec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+	if q, err = diffcore_std(q, &opts); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+	if err := diff_flush(q, &opts); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+}
+
+// run_diff_files compares the index with the work tree.
+// unmerged paths are compared by the stage 2 (ours) entry.
+func run_diff_files(d *Dircache, pathspec *Pathspec) (DiffQueue, error) {
+	var q DiffQueue
+	for i := 0; i < len(d.Entries); i++ {
+		e := d.Entries[i]
+		path := string(e.PathName)
+		if e.removed || pathspec.match(path) == false {
+			continue
+		}
+
+		if e.stage() != 0 {
+			var ours *DircacheEntry
+			for ; i < len(d.Entries) && string(d.Entries[i].PathName) == path; i++ {
+				if d.Entries[i].stage() == 2 {
+					ours = d.Entries[i]
+				}
+			}
+			i--
+
+			mode := uint32(0)
+			if info, err := os.Lstat(path); err == nil {
+				mode = worktree_mode(d, ours, info)
+			}
+			q.diff_unmerge(path, mode)
+			if ours == nil {
+				continue
+			}
+			e = ours
+		}
+
+		if e.skip_worktree() {
+			continue
+		}
+		// the file is assumed to be unchanged (update-index --assume-unchanged)
+		if e.assume_valid() {
+			continue
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil && os.IsNotExist(err) {
+			q.diff_addremove(DIFF_STATUS_DELETED, &DiffFilespec{Path: path, Mode: e.Mode, Sha1: e.Sha1})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		mode := worktree_mode(d, e, info)
+		if e.intent_to_add() {
+			q.diff_addremove(DIFF_STATUS_ADDED, worktree_filespec(path, mode))
+			continue
+		}
+
+		changed, err := is_worktree_changed(d, e, info)
+		if err != nil {
+			return nil, err
+		}
+		if changed == false {
+			continue
+		}
+		q.diff_change(&DiffFilespec{Path: path, Mode: e.Mode, Sha1: e.Sha1}, worktree_filespec(path, mode))
+	}
+	return q, nil
+}
+
+// is_worktree_changed reports whether the file may differ from the entry.
+// unlike is_modified, a file whose stat data differs is changed without being hashed.
+// racily clean entries are compared by the contents.
+func is_worktree_changed(d *Dircache, e *DircacheEntry, info os.FileInfo) (bool, error) {
+	if e.stage() != 0 || e.intent_to_add() || worktree_mode(d, e, info) != e.Mode {
+		return true, nil
+	}
+	// gitlinks are compared by the checked out commit
+	if e.Mode == 0160000 {
+		head, err := resolve_gitlink_head(string(e.PathName))
+		if err != nil {
+			return false, nil
+		}
+		return !bytes.Equal(head, e.Sha1[:]), nil
+	}
+	if is_stat_changed(e, info) {
+		return true, nil
+	}
+	if is_racily_clean(d, e) == false {
+		return false, nil
+	}
+
+	b, err := hash_worktree_file(string(e.PathName), info, false)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(b, e.Sha1[:]), nil
+}
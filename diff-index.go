@@ -0,0 +1,145 @@
+// See Also:
+// https://git-scm.com/docs/git-diff-index
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func diff_index_cmd(opts DiffOptions, cached bool, tree_ish string, args []string) {
+	repop, prefix, err := setup_git_directory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
+		os.Exit(128)
+	}
+
+	tree, err := resolve_tree_ish(repop, tree_ish)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: bad tree object %s\n", tree_ish)
+		os.Exit(128)
+	}
+
+	d, err := load_dircache(repop)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+		os.Exit(128)
+	}
+
+	pathspec, err := parse_pathspec(filepath.Dir(repop), prefix, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+
+	diff_setup_done(repop, &opts)
+	q, err := run_diff_index(repop, d, tree, cached, pathspec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+	if q, err = diffcore_std(q, &opts); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+	if err := diff_flush(q, &opts); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+}
+
+// run_diff_index compares the tree with the index (cached) or with the work tree.
+// only files in the index are compared with the work tree. files which are not changed since the index are shown by the object name in the index.
+func run_diff_index(repop string, d *Dircache, tree string, cached bool, pathspec *Pathspec) (DiffQueue, error) {
+	entries, err := read_tree_dircache_entries(repop, tree, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var q DiffQueue
+	i, k := 0, 0
+	for i < len(entries) || k < len(d.Entries) {
+		if k < len(d.Entries) && d.Entries[k].removed {
+			k++
+			continue
+		}
+
+		var old, e *DircacheEntry
+		switch {
+		case k >= len(d.Entries):
+			old = entries[i]
+		case i >= len(entries):
+			e = d.Entries[k]
+		default:
+			c := compare_dircache_entry(entries[i].PathName, 0, d.Entries[k].PathName, 0)
+			if c <= 0 {
+				old = entries[i]
+			}
+			if c >= 0 {
+				e = d.Entries[k]
+			}
+		}
+		path := ""
+		if old != nil {
+			path = string(old.PathName)
+			i++
+		} else {
+			path = string(e.PathName)
+		}
+		// all stages of an unmerged path
+		unmerged := false
+		for ; k < len(d.Entries) && string(d.Entries[k].PathName) == path; k++ {
+			unmerged = unmerged || d.Entries[k].stage() != 0
+		}
+
+		if pathspec.match(path) == false {
+			continue
+		}
+
+		// the entry is not checked out, or the work tree is not examined
+		index_only := cached || (e != nil && (e.assume_valid() || e.skip_worktree()))
+		if index_only && unmerged {
+			q.diff_unmerge(path, 0)
+			if old != nil {
+				q[len(q)-1].One = &DiffFilespec{Path: path, Mode: old.Mode, Sha1: old.Sha1}
+			}
+			continue
+		}
+
+		if e == nil {
+			q.diff_addremove(DIFF_STATUS_DELETED, &DiffFilespec{Path: path, Mode: old.Mode, Sha1: old.Sha1})
+			continue
+		}
+
+		two := &DiffFilespec{Path: path, Mode: e.Mode, Sha1: e.Sha1}
+		if index_only == false {
+			info, err := os.Lstat(path)
+			if err != nil && os.IsNotExist(err) {
+				if old != nil {
+					q.diff_addremove(DIFF_STATUS_DELETED, &DiffFilespec{Path: path, Mode: old.Mode, Sha1: old.Sha1})
+				}
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			changed, err := is_worktree_changed(d, e, info)
+			if err != nil {
+				return nil, err
+			}
+			if changed {
+				two = worktree_filespec(path, worktree_mode(d, e, info))
+			}
+		}
+
+		if old == nil {
+			q.diff_addremove(DIFF_STATUS_ADDED, two)
+			continue
+		}
+		if two.worktree == false && two.Mode == old.Mode && two.Sha1 == old.Sha1 {
+			continue
+		}
+		q.diff_change(&DiffFilespec{Path: path, Mode: old.Mode, Sha1: old.Sha1}, two)
+	}
+	return q, nil
+}
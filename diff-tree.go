@@ -0,0 +1,173 @@
+// See Also:
+// https://git-scm.com/docs/git-diff-tree
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func diff_tree_cmd(opts DiffOptions, args []string) {
+	repop, prefix, err := setup_git_directory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
+		os.Exit(128)
+	}
+
+	// '<tree-ish> <tree-ish> [<path>...]' or '<commit> [<path>...]' to compare the commit with its parent
+	var commit string
+	var trees [2]string
+	tree, err := resolve_tree_ish(repop, args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: bad object %s\n", args[0])
+		os.Exit(128)
+	}
+	if len(args) > 1 {
+		if trees[1], err = resolve_tree_ish(repop, args[1]); err == nil {
+			trees[0] = tree
+			args = args[2:]
+		}
+	}
+	if len(trees[1]) == 0 {
+		commit, err = resolve_object_name(repop, args[0])
+		if t, _, err2 := read_object_file(repop, commit); err != nil || err2 != nil || t != "commit" {
+			fmt.Fprintf(os.Stderr, "fatal: %s is not a commit\n", args[0])
+			os.Exit(128)
+		}
+		parents := commit_parents(repop, commit)
+		if len(parents) == 0 {
+			// a root commit has nothing to compare with
+			return
+		}
+		if trees[0], err = resolve_tree_ish(repop, parents[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+			os.Exit(128)
+		}
+		trees[1] = tree
+		args = args[1:]
+	}
+
+	pathspec, err := parse_pathspec(filepath.Dir(repop), prefix, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+
+	diff_setup_done(repop, &opts)
+	var q DiffQueue
+	if err := diff_tree(repop, trees[0], trees[1], "", pathspec, &opts, &q); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+	if q, err = diffcore_std(q, &opts); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+	if len(commit) > 0 && len(q) > 0 {
+		fmt.Printf("%s\n", commit)
+	}
+	if err := diff_flush(q, &opts); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+}
+
+// diff_tree compares two trees and queues the entries changed. "" is an empty tree.
+// sub trees are shown as entries unless recursive. with ShowTrees, they are shown even when recursing.
+func diff_tree(repop string, sha1 string, sha2 string, base string, pathspec *Pathspec, opts *DiffOptions, q *DiffQueue) error {
+	var entries1, entries2 []*FileEntry
+	var err error
+	if len(sha1) > 0 {
+		if entries1, err = read_tree_entries(repop, sha1); err != nil {
+			return err
+		}
+	}
+	if len(sha2) > 0 {
+		if entries2, err = read_tree_entries(repop, sha2); err != nil {
+			return err
+		}
+	}
+
+	for i, k := 0, 0; i < len(entries1) || k < len(entries2); {
+		var one, two *FileEntry
+		switch {
+		case k >= len(entries2):
+			one = entries1[i]
+		case i >= len(entries1):
+			two = entries2[k]
+		default:
+			c := compare_tree_entry(entries1[i], entries2[k])
+			if c <= 0 {
+				one = entries1[i]
+			}
+			if c >= 0 {
+				two = entries2[k]
+			}
+		}
+		if one != nil {
+			i++
+		}
+		if two != nil {
+			k++
+		}
+
+		name := ""
+		if one != nil {
+			name = one.Name
+		} else {
+			name = two.Name
+		}
+		path := base + name
+
+		is_tree := (one != nil && one.Mode == 040000) || (two != nil && two.Mode == 040000)
+		if is_tree {
+			if pathspec.match(path) == false && pathspec.match_leading(path) == false {
+				continue
+			}
+		} else if pathspec.match(path) == false {
+			continue
+		}
+		if one != nil && two != nil && one.Mode == two.Mode && one.Hash == two.Hash {
+			continue
+		}
+
+		if is_tree == false || opts.Recursive == false || opts.ShowTrees {
+			switch {
+			case one == nil:
+				q.diff_addremove(DIFF_STATUS_ADDED, &DiffFilespec{Path: path, Mode: two.Mode, Sha1: two.Hash})
+			case two == nil:
+				q.diff_addremove(DIFF_STATUS_DELETED, &DiffFilespec{Path: path, Mode: one.Mode, Sha1: one.Hash})
+			default:
+				q.diff_change(&DiffFilespec{Path: path, Mode: one.Mode, Sha1: one.Hash}, &DiffFilespec{Path: path, Mode: two.Mode, Sha1: two.Hash})
+			}
+		}
+
+		if is_tree && opts.Recursive {
+			sub1, sub2 := "", ""
+			if one != nil {
+				sub1 = fmt.Sprintf("%x", one.Hash)
+			}
+			if two != nil {
+				sub2 = fmt.Sprintf("%x", two.Hash)
+			}
+			if err := diff_tree(repop, sub1, sub2, path+"/", pathspec, opts, q); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compare_tree_entry compares entries in the order of tree objects. names of sub trees are compared as if they end with '/'.
+func compare_tree_entry(a *FileEntry, b *FileEntry) int {
+	name1, name2 := a.Name, b.Name
+	if a.Mode == 040000 {
+		name1 += "/"
+	}
+	if b.Mode == 040000 {
+		name2 += "/"
+	}
+	return strings.Compare(name1, name2)
+}
@@ -0,0 +1,785 @@
+// See Also:
+// https://github.com/git/git/blob/master/diff.c
+// https://git-scm.com/docs/diff-format
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	DIFF_FORMAT_RAW         = 1 << iota // --raw
+	DIFF_FORMAT_NAME_STATUS             // --name-status
+	DIFF_FORMAT_NAME                    // --name-only
+	DIFF_FORMAT_DIFFSTAT                // --stat
+	DIFF_FORMAT_PATCH                   // -p
+)
+
+const (
+	DIFF_STATUS_ADDED        = 'A'
+	DIFF_STATUS_COPIED       = 'C'
+	DIFF_STATUS_DELETED      = 'D'
+	DIFF_STATUS_MODIFIED     = 'M'
+	DIFF_STATUS_RENAMED      = 'R'
+	DIFF_STATUS_TYPE_CHANGED = 'T'
+	DIFF_STATUS_UNMERGED     = 'U'
+)
+
+const (
+	DEFAULT_ABBREV  = 7
+	STAT_WIDTH      = 80
+	FIRST_FEW_BYTES = 8000 // bytes examined to tell binary files
+)
+
+type DiffOptions struct {
+	Format    int // DIFF_FORMAT_* bits. --raw if 0
+	Context   int // lines of context in patches. 3 if negative
+	Xdiff     XdiffOptions
+	Recursive bool // diff-tree: recurse into sub trees
+	ShowTrees bool // diff-tree: show tree entries even when recursing
+
+	DetectRename int // DIFF_DETECT_RENAME (-M) or DIFF_DETECT_COPY (-C). 0 if not detected
+	RenameScore  int // minimum similarity in MAX_SCORE. DEFAULT_RENAME_SCORE if 0
+
+	// "on" or "off" by --indent-heuristic and --no-indent-heuristic. diff.indentHeuristic is used if ""
+	IndentHeuristic string
+
+	Binary bool // --binary: changes of binary files are shown as binary patches instead of 'Binary files differ'
+
+	repop      string
+	quote_path bool
+	attrs      *AttrCheck
+}
+
+// DiffFilespec is one side of a file pair.
+type DiffFilespec struct {
+	Path     string
+	Mode     uint32   // 0 if the file does not exist on this side
+	Sha1     [20]byte // zero if the file in the work tree is not hashed yet
+	worktree bool     // the contents are read from the work tree
+
+	data   []byte
+	loaded bool
+
+	rename_used int            // the number of pairs which use the file as the source of a rename or a copy
+	cnt_data    map[uint32]int // bytes of the file by the hash of chunks, for estimating similarity
+}
+
+// DiffFilepair is a file compared.
+type DiffFilepair struct {
+	One    *DiffFilespec
+	Two    *DiffFilespec
+	Status byte // DIFF_STATUS_*
+	Score  int  // similarity of a rename or a copy in MAX_SCORE
+
+	renamed bool // One was paired with Two by the rename detection
+}
+
+// DiffQueue is the file pairs to be printed in order.
+type DiffQueue []*DiffFilepair
+
+// diff_setup_done resolves the defaults of the options from the configuration.
+func diff_setup_done(repop string, opts *DiffOptions) {
+	opts.repop = repop
+	opts.quote_path = get_config_bool(repop, "core.quotePath", true)
+	opts.attrs = new_attr_check(repop)
+
+	if opts.Format == 0 {
+		opts.Format = DIFF_FORMAT_RAW
+	}
+	// --name-only and --name-status win over the other formats
+	if opts.Format&(DIFF_FORMAT_NAME|DIFF_FORMAT_NAME_STATUS) != 0 {
+		opts.Format &^= DIFF_FORMAT_RAW | DIFF_FORMAT_DIFFSTAT | DIFF_FORMAT_PATCH
+	}
+	// diff.context and diff.algorithm are for porcelain commands, and are not used by plumbing
+	if opts.Context < 0 {
+		opts.Context = 3
+	}
+	if len(opts.Xdiff.Algorithm) == 0 {
+		opts.Xdiff.Algorithm = DIFF_ALGORITHM_MYERS
+	}
+	switch opts.IndentHeuristic {
+	case "on":
+		opts.Xdiff.IndentHeuristic = true
+	case "off":
+		opts.Xdiff.IndentHeuristic = false
+	default:
+		opts.Xdiff.IndentHeuristic = get_config_bool(repop, "diff.indentHeuristic", true)
+	}
+	// patches and stat need the files in sub trees
+	if opts.Format&(DIFF_FORMAT_PATCH|DIFF_FORMAT_DIFFSTAT) != 0 {
+		opts.Recursive = true
+	}
+}
+
+// parse_diff_algorithm checks the name given by --diff-algorithm.
+func parse_diff_algorithm(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case DIFF_ALGORITHM_MYERS, "default":
+		return DIFF_ALGORITHM_MYERS, nil
+	case DIFF_ALGORITHM_MINIMAL:
+		return DIFF_ALGORITHM_MINIMAL, nil
+	case DIFF_ALGORITHM_PATIENCE:
+		return DIFF_ALGORITHM_PATIENCE, nil
+	case DIFF_ALGORITHM_HISTOGRAM:
+		return DIFF_ALGORITHM_HISTOGRAM, nil
+	}
+	return "", fmt.Errorf("option diff-algorithm accepts \"myers\", \"minimal\", \"patience\" and \"histogram\"")
+}
+
+// diff_addremove queues a file which exists only on one side.
+func (q *DiffQueue) diff_addremove(status byte, spec *DiffFilespec) {
+	null := &DiffFilespec{Path: spec.Path}
+	if status == DIFF_STATUS_ADDED {
+		*q = append(*q, &DiffFilepair{One: null, Two: spec, Status: status})
+	} else {
+		*q = append(*q, &DiffFilepair{One: spec, Two: null, Status: status})
+	}
+}
+
+// diff_change queues a file which exists on both sides.
+func (q *DiffQueue) diff_change(one *DiffFilespec, two *DiffFilespec) {
+	status := byte(DIFF_STATUS_MODIFIED)
+	if one.Mode&0170000 != two.Mode&0170000 {
+		status = DIFF_STATUS_TYPE_CHANGED
+	}
+	*q = append(*q, &DiffFilepair{One: one, Two: two, Status: status})
+}
+
+// diff_unmerge queues an unmerged path. mode is the mode of the file in the work tree if any.
+func (q *DiffQueue) diff_unmerge(path string, mode uint32) {
+	*q = append(*q, &DiffFilepair{One: &DiffFilespec{Path: path}, Two: &DiffFilespec{Path: path, Mode: mode}, Status: DIFF_STATUS_UNMERGED})
+}
+
+// worktree_filespec returns the file in the work tree. it is read and hashed only when needed.
+func worktree_filespec(path string, mode uint32) *DiffFilespec {
+	return &DiffFilespec{Path: path, Mode: mode, worktree: true}
+}
+
+// fill_filespec_data reads the contents of the file. submodules are shown by the commit checked out.
+func fill_filespec_data(repop string, s *DiffFilespec) error {
+	if s.loaded || s.Mode == 0 {
+		return nil
+	}
+
+	switch {
+	case s.Mode == 0160000:
+		if s.worktree {
+			head, err := resolve_gitlink_head(s.Path)
+			if err != nil {
+				return err
+			}
+			copy(s.Sha1[:], head)
+		}
+		s.data = []byte(fmt.Sprintf("Subproject commit %x\n", s.Sha1))
+	case s.worktree:
+		info, err := os.Lstat(s.Path)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(s.Path)
+			if err != nil {
+				return err
+			}
+			s.data = []byte(target)
+		} else if s.data, err = ioutil.ReadFile(s.Path); err != nil {
+			return err
+		}
+		s.Sha1 = blob_sha1(s.data)
+	default:
+		t, b, err := read_object_file(repop, fmt.Sprintf("%x", s.Sha1))
+		if err != nil {
+			return err
+		}
+		if t != "blob" {
+			return fmt.Errorf("%x is not a blob", s.Sha1)
+		}
+		s.data = b
+	}
+	s.loaded = true
+	return nil
+}
+
+// buffer_is_binary reports whether the contents look binary. (NUL in the first few bytes)
+func buffer_is_binary(data []byte) bool {
+	if len(data) > FIRST_FEW_BYTES {
+		data = data[:FIRST_FEW_BYTES]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// diff_filespec_is_binary reports whether the file is shown as binary.
+// the diff attribute decides if it is set or unset ('binary' unsets it). otherwise the contents decide.
+func diff_filespec_is_binary(opts *DiffOptions, s *DiffFilespec) (bool, error) {
+	switch state, _ := opts.attrs.check(s.Path, "diff"); state {
+	case ATTR_SET:
+		return false, nil
+	case ATTR_UNSET:
+		return true, nil
+	}
+	if err := fill_filespec_data(opts.repop, s); err != nil {
+		return false, err
+	}
+	return buffer_is_binary(s.data), nil
+}
+
+// fill_filespec_sha1 hashes the file in the work tree.
+func fill_filespec_sha1(repop string, s *DiffFilespec) error {
+	if s.worktree == false || s.Mode == 0 {
+		return nil
+	}
+	return fill_filespec_data(repop, s)
+}
+
+// find_unique_abbrev abbreviates the object name to the shortest prefix which is not ambiguous. (at least min characters)
+func find_unique_abbrev(repop string, sha [20]byte, min int) string {
+	hex := fmt.Sprintf("%x", sha)
+	files, err := ioutil.ReadDir(filepath.Join(repop, "objects", hex[:2]))
+	if err != nil {
+		return hex[:min]
+	}
+	n := min
+	for _, f := range files {
+		name := hex[:2] + f.Name()
+		if len(name) != 40 || name == hex {
+			continue
+		}
+		common := 0
+		for common < 40 && name[common] == hex[common] {
+			common++
+		}
+		if common+1 > n {
+			n = common + 1
+		}
+	}
+	return hex[:n]
+}
+
+// diffcore_std transforms the queue before it is printed. renames and copies are paired up if they are detected.
+func diffcore_std(q DiffQueue, opts *DiffOptions) (DiffQueue, error) {
+	if opts.DetectRename != 0 {
+		return diffcore_rename(q, opts)
+	}
+	return q, nil
+}
+
+// diff_flush prints the queued pairs in the output formats.
+// raw, name-status and name-only come first, then stat and patches separated by a blank line.
+func diff_flush(q DiffQueue, opts *DiffOptions) error {
+	if len(q) == 0 {
+		return nil
+	}
+
+	separator := false
+	if opts.Format&(DIFF_FORMAT_RAW|DIFF_FORMAT_NAME_STATUS|DIFF_FORMAT_NAME) != 0 {
+		for _, p := range q {
+			diff_flush_raw(p, opts)
+		}
+		separator = true
+	}
+	if opts.Format&DIFF_FORMAT_DIFFSTAT != 0 {
+		if err := diff_flush_stat(q, opts); err != nil {
+			return err
+		}
+		separator = true
+	}
+	if opts.Format&DIFF_FORMAT_PATCH != 0 {
+		if separator {
+			fmt.Printf("\n")
+		}
+		for _, p := range q {
+			if err := diff_flush_patch(p, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func diff_flush_raw(p *DiffFilepair, opts *DiffOptions) {
+	path := quote_c_style(p.Two.Path, opts.quote_path, false)
+	if opts.Format&DIFF_FORMAT_NAME != 0 {
+		fmt.Printf("%s\n", path)
+		return
+	}
+
+	// renames and copies are shown with the similarity and both names
+	status := string(p.Status)
+	if p.Status == DIFF_STATUS_RENAMED || p.Status == DIFF_STATUS_COPIED {
+		status = fmt.Sprintf("%c%03d", p.Status, similarity_index(p))
+		path = quote_c_style(p.One.Path, opts.quote_path, false) + "\t" + path
+	}
+	switch {
+	case opts.Format&DIFF_FORMAT_NAME_STATUS != 0:
+		fmt.Printf("%s\t%s\n", status, path)
+	default:
+		// files in the work tree are shown with the null object name
+		sha1, sha2 := p.One.Sha1, p.Two.Sha1
+		if p.One.worktree {
+			sha1 = [20]byte{}
+		}
+		if p.Two.worktree {
+			sha2 = [20]byte{}
+		}
+		fmt.Printf(":%06o %06o %x %x %s\t%s\n", p.One.Mode, p.Two.Mode, sha1, sha2, status, path)
+	}
+}
+
+// similarity_index returns the score of the pair in percent.
+func similarity_index(p *DiffFilepair) int {
+	return p.Score * 100 / MAX_SCORE
+}
+
+// diff_flush_patch prints the pair in the git patch format.
+// a change between a file and a symbolic link is shown as a deletion and a creation.
+func diff_flush_patch(p *DiffFilepair, opts *DiffOptions) error {
+	if p.Status == DIFF_STATUS_UNMERGED {
+		fmt.Printf("* Unmerged path %s\n", p.Two.Path)
+		return nil
+	}
+	if err := fill_filespec_sha1(opts.repop, p.One); err != nil {
+		return err
+	}
+	if err := fill_filespec_sha1(opts.repop, p.Two); err != nil {
+		return err
+	}
+	if p.One.Mode != 0 && p.Two.Mode != 0 && p.One.Mode&0170000 != p.Two.Mode&0170000 {
+		if err := builtin_diff(p.One, &DiffFilespec{Path: p.One.Path}, p, opts); err != nil {
+			return err
+		}
+		return builtin_diff(&DiffFilespec{Path: p.Two.Path}, p.Two, p, opts)
+	}
+	return builtin_diff(p.One, p.Two, p, opts)
+}
+
+func builtin_diff(one *DiffFilespec, two *DiffFilespec, p *DiffFilepair, opts *DiffOptions) error {
+	a := quote_c_style("a/"+one.Path, opts.quote_path, false)
+	b := quote_c_style("b/"+two.Path, opts.quote_path, false)
+
+	var header bytes.Buffer
+	must_show_header := false
+	fmt.Fprintf(&header, "diff --git %s %s\n", a, b)
+	switch {
+	case one.Mode == 0:
+		fmt.Fprintf(&header, "new file mode %06o\n", two.Mode)
+		must_show_header = true
+		a = "/dev/null"
+	case two.Mode == 0:
+		fmt.Fprintf(&header, "deleted file mode %06o\n", one.Mode)
+		must_show_header = true
+		b = "/dev/null"
+	case one.Mode != two.Mode:
+		fmt.Fprintf(&header, "old mode %06o\n", one.Mode)
+		fmt.Fprintf(&header, "new mode %06o\n", two.Mode)
+		must_show_header = true
+	}
+	if p.Status == DIFF_STATUS_RENAMED || p.Status == DIFF_STATUS_COPIED {
+		verb := "rename"
+		if p.Status == DIFF_STATUS_COPIED {
+			verb = "copy"
+		}
+		fmt.Fprintf(&header, "similarity index %d%%\n", similarity_index(p))
+		fmt.Fprintf(&header, "%s from %s\n", verb, quote_c_style(one.Path, opts.quote_path, false))
+		fmt.Fprintf(&header, "%s to %s\n", verb, quote_c_style(two.Path, opts.quote_path, false))
+		must_show_header = true
+	}
+	binary := false
+	if one.Sha1 != two.Sha1 {
+		b1, err := diff_filespec_is_binary(opts, one)
+		if err != nil {
+			return err
+		}
+		b2, err := diff_filespec_is_binary(opts, two)
+		if err != nil {
+			return err
+		}
+		binary = b1 || b2
+	}
+	if one.Sha1 != two.Sha1 {
+		// binary patches are applied to the objects of the full names
+		abbrev := DEFAULT_ABBREV
+		if opts.Binary && binary {
+			abbrev = 40
+		}
+		fmt.Fprintf(&header, "index %s..%s", find_unique_abbrev(opts.repop, one.Sha1, abbrev), find_unique_abbrev(opts.repop, two.Sha1, abbrev))
+		if one.Mode == two.Mode {
+			fmt.Fprintf(&header, " %06o", one.Mode)
+		}
+		fmt.Fprintf(&header, "\n")
+	}
+
+	if one.Sha1 == two.Sha1 {
+		if must_show_header {
+			os.Stdout.Write(header.Bytes())
+		}
+		return nil
+	}
+
+	if err := fill_filespec_data(opts.repop, one); err != nil {
+		return err
+	}
+	if err := fill_filespec_data(opts.repop, two); err != nil {
+		return err
+	}
+	if binary {
+		os.Stdout.Write(header.Bytes())
+		if opts.Binary {
+			return emit_binary_diff(one.data, two.data)
+		}
+		fmt.Printf("Binary files %s and %s differ\n", a, b)
+		return nil
+	}
+	env, changes := xdiff(split_lines(one.data), split_lines(two.data), opts.Xdiff)
+	if len(changes) == 0 {
+		if must_show_header {
+			os.Stdout.Write(header.Bytes())
+		}
+		return nil
+	}
+
+	os.Stdout.Write(header.Bytes())
+	fmt.Printf("--- %s%s\n", a, label_tab(a))
+	fmt.Printf("+++ %s%s\n", b, label_tab(b))
+	emit_unified_diff(os.Stdout, env, changes, opts.Context)
+	return nil
+}
+
+// emit_binary_diff prints the binary patch from one to two, and the reverse patch so that it can be reverted.
+func emit_binary_diff(one []byte, two []byte) error {
+	fmt.Printf("GIT binary patch\n")
+	if err := emit_binary_diff_body(one, two); err != nil {
+		return err
+	}
+	return emit_binary_diff_body(two, one)
+}
+
+// emit_binary_diff_body prints the deflated delta from one to two or the deflated contents of two, whichever is smaller.
+// the data is encoded in base85 by 52 bytes a line. the first character of a line is the length. ('A'-'Z' for 1-26, 'a'-'z' for 27-52)
+func emit_binary_diff_body(one []byte, two []byte) error {
+	deflated, err := deflate_it(two)
+	if err != nil {
+		return err
+	}
+	var delta []byte
+	orig_size := 0
+	if len(one) > 0 && len(two) > 0 {
+		if d := diff_delta(one, two, len(deflated)); d != nil {
+			orig_size = len(d)
+			if delta, err = deflate_it(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	data := deflated
+	if delta != nil && len(delta) < len(deflated) {
+		fmt.Printf("delta %d\n", orig_size)
+		data = delta
+	} else {
+		fmt.Printf("literal %d\n", len(two))
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > 52 {
+			n = 52
+		}
+		c := byte(n) + 'A' - 1
+		if n > 26 {
+			c = byte(n-26) + 'a' - 1
+		}
+		fmt.Printf("%c%s\n", c, encode_85(data[:n]))
+		data = data[n:]
+	}
+	fmt.Printf("\n")
+	return nil
+}
+
+func deflate_it(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := zlib.NewWriterLevel(&b, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// label_tab returns a tab to be put after a file name with spaces in '---' and '+++' lines.
+func label_tab(label string) string {
+	if strings.Contains(label, " ") {
+		return "\t"
+	}
+	return ""
+}
+
+// DiffStat is the number of lines changed in a file. the sizes of binary files are in bytes.
+type DiffStat struct {
+	name     string
+	added    int
+	deleted  int
+	unmerged bool
+	binary   bool
+}
+
+// diff_flush_stat prints the histogram of changed lines.
+// files whose contents and mode are not changed are not counted.
+func diff_flush_stat(q DiffQueue, opts *DiffOptions) error {
+	var stats []*DiffStat
+	for _, p := range q {
+		st := &DiffStat{name: quote_c_style(p.Two.Path, opts.quote_path, false)}
+		if p.One.Path != p.Two.Path {
+			st.name = pprint_rename(p.One.Path, p.Two.Path, opts.quote_path)
+		}
+		if p.Status == DIFF_STATUS_UNMERGED {
+			st.unmerged = true
+			stats = append(stats, st)
+			continue
+		}
+		if err := fill_filespec_sha1(opts.repop, p.One); err != nil {
+			return err
+		}
+		if err := fill_filespec_sha1(opts.repop, p.Two); err != nil {
+			return err
+		}
+		if p.One.Sha1 == p.Two.Sha1 && p.One.Mode == p.Two.Mode && p.One.Path == p.Two.Path {
+			continue
+		}
+		b1, err := diff_filespec_is_binary(opts, p.One)
+		if err != nil {
+			return err
+		}
+		b2, err := diff_filespec_is_binary(opts, p.Two)
+		if err != nil {
+			return err
+		}
+		if b1 || b2 {
+			// files in the work tree are not known to be the same
+			st.binary = true
+			if p.One.Sha1 != p.Two.Sha1 || p.One.worktree || p.Two.worktree {
+				if err := fill_filespec_data(opts.repop, p.One); err != nil {
+					return err
+				}
+				if err := fill_filespec_data(opts.repop, p.Two); err != nil {
+					return err
+				}
+				st.added, st.deleted = len(p.Two.data), len(p.One.data)
+			}
+		} else if p.One.Sha1 != p.Two.Sha1 {
+			if err := fill_filespec_data(opts.repop, p.One); err != nil {
+				return err
+			}
+			if err := fill_filespec_data(opts.repop, p.Two); err != nil {
+				return err
+			}
+			_, changes := xdiff(split_lines(p.One.data), split_lines(p.Two.data), opts.Xdiff)
+			for _, c := range changes {
+				st.added += c.chg2
+				st.deleted += c.chg1
+			}
+		}
+		stats = append(stats, st)
+	}
+	if len(stats) == 0 {
+		return nil
+	}
+	show_stats(stats)
+	return nil
+}
+
+// pprint_rename returns the names of a rename for the stat. the common leading directories and
+// trailing part are written once. ('dir/{a => b}/file')
+func pprint_rename(a string, b string, quote_path bool) string {
+	qa, qb := quote_c_style(a, quote_path, false), quote_c_style(b, quote_path, false)
+	if qa != a || qb != b {
+		return qa + " => " + qb
+	}
+
+	pfx_length := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '/' {
+			pfx_length = i + 1
+		}
+	}
+
+	// the suffix may overlap the slash at the end of the prefix
+	sfx_length := 0
+	pfx_adjust_for_slash := 0
+	if pfx_length > 0 {
+		pfx_adjust_for_slash = 1
+	}
+	for i, k := len(a)-1, len(b)-1; pfx_length-pfx_adjust_for_slash <= i && pfx_length-pfx_adjust_for_slash <= k; i, k = i-1, k-1 {
+		if a[i] != b[k] {
+			break
+		}
+		if a[i] == '/' {
+			sfx_length = len(a) - i
+		}
+	}
+
+	a_midlen := len(a) - pfx_length - sfx_length
+	b_midlen := len(b) - pfx_length - sfx_length
+	if a_midlen < 0 {
+		a_midlen = 0
+	}
+	if b_midlen < 0 {
+		b_midlen = 0
+	}
+	if pfx_length+sfx_length == 0 {
+		return a[:a_midlen] + " => " + b[:b_midlen]
+	}
+	return a[:pfx_length] + "{" + a[pfx_length:pfx_length+a_midlen] + " => " + b[pfx_length:pfx_length+b_midlen] + "}" + a[len(a)-sfx_length:]
+}
+
+// show_stats prints the stat lines in 80 columns.
+// the file names and the graph are shortened as git does if they do not fit.
+func show_stats(stats []*DiffStat) {
+	// 'Bin XXX -> YYY bytes' and 'Unmerged' are not scaled
+	max_len, max_change, bin_width, number_width := 0, 0, 0, 0
+	for _, st := range stats {
+		if len(st.name) > max_len {
+			max_len = len(st.name)
+		}
+		if st.unmerged {
+			if bin_width < 8 {
+				bin_width = 8
+			}
+			continue
+		}
+		if st.binary {
+			if w := 14 + len(fmt.Sprintf("%d%d", st.added, st.deleted)); bin_width < w {
+				bin_width = w
+			}
+			number_width = 3
+			continue
+		}
+		if st.added+st.deleted > max_change {
+			max_change = st.added + st.deleted
+		}
+	}
+
+	width := STAT_WIDTH
+	if w := len(fmt.Sprintf("%d", max_change)); w > number_width {
+		number_width = w
+	}
+	if width < 16+6+number_width {
+		width = 16 + 6 + number_width
+	}
+	graph_width := max_change
+	if max_change+4 <= bin_width {
+		graph_width = bin_width - 4
+	}
+	name_width := max_len
+	if name_width+number_width+6+graph_width > width {
+		if graph_width > width*3/8-number_width-6 {
+			graph_width = width*3/8 - number_width - 6
+			if graph_width < 6 {
+				graph_width = 6
+			}
+		}
+		if name_width > width-number_width-6-graph_width {
+			name_width = width - number_width - 6 - graph_width
+		} else {
+			graph_width = width - number_width - 6 - name_width
+		}
+	}
+
+	scale := func(it int) int {
+		if it == 0 {
+			return 0
+		}
+		return 1 + it*(graph_width-1)/max_change
+	}
+
+	files, insertions, deletions := 0, 0, 0
+	for _, st := range stats {
+		name := st.name
+		prefix := ""
+		if len(name) > name_width {
+			// keep the tail of the name from a directory boundary
+			prefix = "..."
+			n := name_width - 3
+			if n < 0 {
+				n = 0
+			}
+			name = name[len(name)-n:]
+			if i := strings.IndexByte(name, '/'); i >= 0 {
+				name = name[i:]
+			}
+		}
+		padding := name_width - len(prefix) - len(name)
+		if padding < 0 {
+			padding = 0
+		}
+
+		if st.unmerged {
+			fmt.Printf(" %s%s%s | Unmerged\n", prefix, name, strings.Repeat(" ", padding))
+			continue
+		}
+		files++
+		if st.binary {
+			fmt.Printf(" %s%s%s | %*s", prefix, name, strings.Repeat(" ", padding), number_width, "Bin")
+			if st.added == 0 && st.deleted == 0 {
+				fmt.Printf("\n")
+			} else {
+				fmt.Printf(" %d -> %d bytes\n", st.deleted, st.added)
+			}
+			continue
+		}
+
+		add, del := st.added, st.deleted
+		insertions += add
+		deletions += del
+		if graph_width <= max_change {
+			total := scale(add + del)
+			if total < 2 && add > 0 && del > 0 {
+				total = 2
+			}
+			if add < del {
+				add = scale(add)
+				del = total - add
+			} else {
+				del = scale(del)
+				add = total - del
+			}
+		}
+		sp := ""
+		if st.added+st.deleted > 0 {
+			sp = " "
+		}
+		fmt.Printf(" %s%s%s | %*d%s%s%s\n", prefix, name, strings.Repeat(" ", padding), number_width, st.added+st.deleted, sp,
+			strings.Repeat("+", add), strings.Repeat("-", del))
+	}
+	print_stat_summary(files, insertions, deletions)
+}
+
+func print_stat_summary(files int, insertions int, deletions int) {
+	if files == 0 {
+		fmt.Printf(" 0 files changed\n")
+		return
+	}
+	plural := func(n int, one string, many string) string {
+		if n == 1 {
+			return fmt.Sprintf(one, n)
+		}
+		return fmt.Sprintf(many, n)
+	}
+	s := plural(files, " %d file changed", " %d files changed")
+	if insertions > 0 || deletions == 0 {
+		s += plural(insertions, ", %d insertion(+)", ", %d insertions(+)")
+	}
+	if deletions > 0 || insertions == 0 {
+		s += plural(deletions, ", %d deletion(-)", ", %d deletions(-)")
+	}
+	fmt.Printf("%s\n", s)
+}
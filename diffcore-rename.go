@@ -0,0 +1,434 @@
+// See Also:
+// https://github.com/git/git/blob/master/diffcore-rename.c
+// https://github.com/git/git/blob/master/diffcore-delta.c
+// https://git-scm.com/docs/gitdiffcore#_diffcore_rename_for_detecting_renames_and_copies
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	DIFF_DETECT_RENAME = 1
+	DIFF_DETECT_COPY   = 2
+)
+
+const (
+	MAX_SCORE             = 60000
+	DEFAULT_RENAME_SCORE  = 30000 // 50%
+	NUM_CANDIDATE_PER_DST = 4
+	DIFF_RENAME_LIMIT     = 1000 // inexact renames are not detected if there are more than limit*limit pairs to compare
+	SPANHASH_BASE         = 107927
+)
+
+// diff_rename_dst is a created file which may be renamed or copied from a source.
+type diff_rename_dst struct {
+	p         *DiffFilepair
+	is_rename bool
+}
+
+// diff_score is a candidate of the source of a destination. dst is -1 if it is not used.
+type diff_score struct {
+	src, dst   int
+	score      int
+	name_score int
+}
+
+// parse_rename_score parses the similarity given to -M and -C. ('50', '50%' or '.5' are all 50%)
+// it returns the score in MAX_SCORE and the rest of the string.
+func parse_rename_score(arg string) (int, string) {
+	num, scale := 0, 1
+	dot := false
+	i := 0
+	for ; i < len(arg); i++ {
+		ch := arg[i]
+		if dot == false && ch == '.' {
+			scale = 1
+			dot = true
+		} else if ch == '%' {
+			if dot {
+				scale *= 100
+			} else {
+				scale = 100
+			}
+			i++
+			break
+		} else if '0' <= ch && ch <= '9' {
+			if scale < 100000 {
+				scale *= 10
+				num = num*10 + int(ch-'0')
+			}
+		} else {
+			break
+		}
+	}
+	if num >= scale {
+		return MAX_SCORE, arg[i:]
+	}
+	return MAX_SCORE * num / scale, arg[i:]
+}
+
+// diffcore_rename pairs up deleted files with created files which have the same or similar contents.
+// with copies detected, modified files are also the sources. a pair is shown as a copy if the source remains.
+func diffcore_rename(q DiffQueue, opts *DiffOptions) (DiffQueue, error) {
+	want_copies := opts.DetectRename == DIFF_DETECT_COPY
+	minimum_score := opts.RenameScore
+	if minimum_score == 0 {
+		minimum_score = DEFAULT_RENAME_SCORE
+	}
+
+	var srcs []*DiffFilespec
+	var dsts []*diff_rename_dst
+	for _, p := range q {
+		switch {
+		case p.Status == DIFF_STATUS_UNMERGED:
+		case p.One.Mode == 0:
+			if p.Two.Mode != 0 {
+				dsts = append(dsts, &diff_rename_dst{p: p})
+			}
+		case p.Two.Mode == 0:
+			srcs = append(srcs, p.One)
+		case want_copies:
+			// a modified file remains, so it can only be the source of copies
+			p.One.rename_used++
+			srcs = append(srcs, p.One)
+		}
+	}
+	if len(dsts) == 0 || len(srcs) == 0 {
+		return q, nil
+	}
+	for _, one := range srcs {
+		if err := fill_filespec_sha1(opts.repop, one); err != nil {
+			return nil, err
+		}
+	}
+	for _, dst := range dsts {
+		if err := fill_filespec_sha1(opts.repop, dst.p.Two); err != nil {
+			return nil, err
+		}
+	}
+
+	rename_count := 0
+	for _, dst := range dsts {
+		if src := find_identical_file(srcs, dst.p.Two, want_copies); src >= 0 {
+			record_rename_pair(dst, srcs[src], MAX_SCORE)
+			rename_count++
+		}
+	}
+
+	if want_copies == false {
+		srcs = remove_used_sources(srcs)
+		// files which have the same unique base name are compared first with a higher minimum score
+		n, err := find_basename_matches(opts, srcs, dsts, minimum_score+(MAX_SCORE-minimum_score)/2)
+		if err != nil {
+			return nil, err
+		}
+		rename_count += n
+		srcs = remove_used_sources(srcs)
+	}
+
+	num_destinations := len(dsts) - rename_count
+	if num_destinations > 0 && len(srcs) > 0 && num_destinations*len(srcs) <= DIFF_RENAME_LIMIT*DIFF_RENAME_LIMIT {
+		// the best candidates of each destination, sorted by the similarity
+		mx := make([]diff_score, 0, num_destinations*NUM_CANDIDATE_PER_DST)
+		for i, dst := range dsts {
+			if dst.is_rename {
+				continue
+			}
+			m := make([]diff_score, NUM_CANDIDATE_PER_DST)
+			for k := range m {
+				m[k].dst = -1
+			}
+			for k, one := range srcs {
+				score, err := estimate_similarity(opts, one, dst.p.Two, minimum_score)
+				if err != nil {
+					return nil, err
+				}
+				record_if_better(m, diff_score{src: k, dst: i, score: score, name_score: basename_same(one, dst.p.Two)})
+			}
+			mx = append(mx, m...)
+		}
+		sort.SliceStable(mx, func(i, k int) bool {
+			return score_compare(mx[i], mx[k]) < 0
+		})
+
+		find_renames(mx, srcs, dsts, minimum_score, false)
+		if want_copies {
+			find_renames(mx, srcs, dsts, minimum_score, true)
+		}
+	}
+
+	// deleted files which are renamed are not shown
+	var outq DiffQueue
+	for _, p := range q {
+		if p.Status != DIFF_STATUS_UNMERGED && p.One.Mode != 0 && p.Two.Mode == 0 && p.One.rename_used > 0 {
+			continue
+		}
+		outq = append(outq, p)
+	}
+
+	// the last pair of a deleted source is the rename, and the others are copies
+	for _, p := range outq {
+		if p.renamed == false {
+			continue
+		}
+		p.One.rename_used--
+		if p.One.Path == p.Two.Path {
+			p.Status = DIFF_STATUS_MODIFIED
+		} else if p.One.rename_used > 0 {
+			p.Status = DIFF_STATUS_COPIED
+		} else {
+			p.Status = DIFF_STATUS_RENAMED
+		}
+	}
+	return outq, nil
+}
+
+// find_identical_file returns the index of the source which has the same contents as the target.
+// a source not used yet and with the same base name is preferred. it returns -1 if there is no such source.
+func find_identical_file(srcs []*DiffFilespec, target *DiffFilespec, want_copies bool) int {
+	best, best_score := -1, -1
+	tries := 100
+	for i, source := range srcs {
+		if source.Sha1 != target.Sha1 {
+			continue
+		}
+		// other than regular files, the modes must match
+		if (is_regular_mode(source.Mode) == false || is_regular_mode(target.Mode) == false) && source.Mode != target.Mode {
+			continue
+		}
+		score := 0
+		if source.rename_used == 0 {
+			score = 1
+		} else if want_copies == false {
+			continue
+		}
+		score += basename_same(source, target)
+		if score > best_score {
+			best, best_score = i, score
+			if score == 2 {
+				break
+			}
+		}
+		// too many identical alternatives
+		if tries--; tries == 0 {
+			break
+		}
+	}
+	return best
+}
+
+// find_basename_matches pairs up the files whose base name is unique among both sources and destinations
+// if they are similar enough.
+func find_basename_matches(opts *DiffOptions, srcs []*DiffFilespec, dsts []*diff_rename_dst, minimum_score int) (int, error) {
+	sources := map[string]int{}
+	for i, one := range srcs {
+		base := get_basename(one.Path)
+		if _, ok := sources[base]; ok {
+			sources[base] = -1
+		} else {
+			sources[base] = i
+		}
+	}
+	dests := map[string]int{}
+	for i, dst := range dsts {
+		if dst.is_rename {
+			continue
+		}
+		base := get_basename(dst.p.Two.Path)
+		if _, ok := dests[base]; ok {
+			dests[base] = -1
+		} else {
+			dests[base] = i
+		}
+	}
+
+	renames := 0
+	for _, one := range srcs {
+		base := get_basename(one.Path)
+		dst_index, ok := dests[base]
+		if ok == false || sources[base] < 0 || dst_index < 0 || dsts[dst_index].is_rename {
+			continue
+		}
+		dst := dsts[dst_index]
+		score, err := estimate_similarity(opts, one, dst.p.Two, minimum_score)
+		if err != nil {
+			return 0, err
+		}
+		if score < minimum_score {
+			continue
+		}
+		record_rename_pair(dst, one, score)
+		renames++
+	}
+	return renames, nil
+}
+
+// find_renames pairs up the destinations with the best sources in the sorted candidates.
+// sources already used are skipped unless copies are detected.
+func find_renames(mx []diff_score, srcs []*DiffFilespec, dsts []*diff_rename_dst, minimum_score int, copies bool) int {
+	count := 0
+	for _, m := range mx {
+		if m.dst < 0 || m.score < minimum_score {
+			break
+		}
+		dst := dsts[m.dst]
+		if dst.is_rename {
+			continue
+		}
+		if copies == false && srcs[m.src].rename_used > 0 {
+			continue
+		}
+		record_rename_pair(dst, srcs[m.src], m.score)
+		count++
+	}
+	return count
+}
+
+// record_rename_pair makes the created file a pair with the source.
+func record_rename_pair(dst *diff_rename_dst, src *DiffFilespec, score int) {
+	src.rename_used++
+	dst.p.One = src
+	dst.p.Score = score
+	dst.p.renamed = true
+	dst.is_rename = true
+}
+
+// remove_used_sources removes the sources which are already renamed.
+func remove_used_sources(srcs []*DiffFilespec) []*DiffFilespec {
+	var rest []*DiffFilespec
+	for _, one := range srcs {
+		if one.rename_used == 0 {
+			rest = append(rest, one)
+		}
+	}
+	return rest
+}
+
+// record_if_better replaces the worst candidate with o if o is better.
+func record_if_better(m []diff_score, o diff_score) {
+	worst := 0
+	for i := 1; i < len(m); i++ {
+		if score_compare(m[i], m[worst]) > 0 {
+			worst = i
+		}
+	}
+	if score_compare(m[worst], o) > 0 {
+		m[worst] = o
+	}
+}
+
+// score_compare orders the candidates from the most similar. unused candidates come last.
+func score_compare(a diff_score, b diff_score) int {
+	if a.dst < 0 {
+		if b.dst >= 0 {
+			return 1
+		}
+		return 0
+	} else if b.dst < 0 {
+		return -1
+	}
+	if a.score == b.score {
+		return b.name_score - a.name_score
+	}
+	return b.score - a.score
+}
+
+// basename_same returns 1 if the files have the same base name.
+func basename_same(src *DiffFilespec, dst *DiffFilespec) int {
+	if get_basename(src.Path) == get_basename(dst.Path) {
+		return 1
+	}
+	return 0
+}
+
+func get_basename(path string) string {
+	return path[strings.LastIndexByte(path, '/')+1:]
+}
+
+func is_regular_mode(mode uint32) bool {
+	return mode&0170000 == 0100000
+}
+
+// estimate_similarity returns how much of the contents of dst comes from src in MAX_SCORE.
+// only regular files are compared, and files whose sizes differ too much are not similar.
+func estimate_similarity(opts *DiffOptions, src *DiffFilespec, dst *DiffFilespec, minimum_score int) (int, error) {
+	if is_regular_mode(src.Mode) == false || is_regular_mode(dst.Mode) == false {
+		return 0, nil
+	}
+	if err := fill_filespec_data(opts.repop, src); err != nil {
+		return 0, err
+	}
+	if err := fill_filespec_data(opts.repop, dst); err != nil {
+		return 0, err
+	}
+
+	max_size, base_size := len(src.data), len(dst.data)
+	if max_size < base_size {
+		max_size, base_size = base_size, max_size
+	}
+	delta_size := max_size - base_size
+	if max_size*(MAX_SCORE-minimum_score) < delta_size*MAX_SCORE {
+		return 0, nil
+	}
+
+	for _, s := range []*DiffFilespec{src, dst} {
+		if s.cnt_data != nil {
+			continue
+		}
+		binary, err := diff_filespec_is_binary(opts, s)
+		if err != nil {
+			return 0, err
+		}
+		s.cnt_data = hash_chars(s.data, binary == false)
+	}
+	if len(dst.data) == 0 {
+		return 0, nil
+	}
+	return count_copied(src.cnt_data, dst.cnt_data) * MAX_SCORE / max_size, nil
+}
+
+// hash_chars counts the bytes of the contents by the hash of chunks. a chunk is a line, or 64 bytes if the line is longer.
+// CR before LF is ignored in text files.
+func hash_chars(data []byte, is_text bool) map[uint32]int {
+	hash := map[uint32]int{}
+
+	n := 0
+	var accum1, accum2 uint32
+	for i := 0; i < len(data); i++ {
+		c := uint32(data[i])
+		old_1 := accum1
+		if is_text && c == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			continue
+		}
+
+		accum1 = (accum1 << 7) ^ (accum2 >> 25)
+		accum2 = (accum2 << 7) ^ (old_1 >> 25)
+		accum1 += c
+		if n++; n < 64 && c != '\n' {
+			continue
+		}
+		hash[(accum1+accum2*0x61)%SPANHASH_BASE] += n
+		n = 0
+		accum1, accum2 = 0, 0
+	}
+	if n > 0 {
+		hash[(accum1+accum2*0x61)%SPANHASH_BASE] += n
+	}
+	return hash
+}
+
+// count_copied returns the bytes of the chunks in dst which are also in src.
+func count_copied(src map[uint32]int, dst map[uint32]int) int {
+	copied := 0
+	for h, src_cnt := range src {
+		dst_cnt := dst[h]
+		if src_cnt < dst_cnt {
+			copied += src_cnt
+		} else {
+			copied += dst_cnt
+		}
+	}
+	return copied
+}
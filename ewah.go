@@ -0,0 +1,147 @@
+// See Also:
+// https://github.com/git/git/blob/master/ewah/ewah_io.c
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	EWAH_RUNNING_LENGTH_BITS = 32 // [1-bit: running bit] [32-bit: running length] [31-bit: number of literal words]
+	EWAH_LITERAL_BITS        = 31
+	EWAH_WORD_BITS           = 64
+)
+
+// EwahBitmap is an uncompressed form of the EWAH compressed bitmap used by index extensions.
+type EwahBitmap struct {
+	Bits []bool // n-th bit of the bitmap
+}
+
+// set sets the n-th bit. the bitmap grows as necessary.
+func (b *EwahBitmap) set(n int) {
+	for len(b.Bits) <= n {
+		b.Bits = append(b.Bits, false)
+	}
+	b.Bits[n] = true
+}
+
+func (b *EwahBitmap) get(n int) bool {
+	return n < len(b.Bits) && b.Bits[n]
+}
+
+// each calls f with the positions of set bits in ascending order.
+func (b *EwahBitmap) each(f func(n int) error) error {
+	for i, v := range b.Bits {
+		if v {
+			if err := f(i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// read_ewah_bitmap reads the serialized bitmap.
+//
+//	32-bit bit count, 32-bit word count, 64-bit words and 32-bit position of the last running length word
+func read_ewah_bitmap(r io.Reader) (*EwahBitmap, error) {
+	var bit_size, word_count uint32
+	if err := binary.Read(r, binary.BigEndian, &bit_size); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &word_count); err != nil {
+		return nil, err
+	}
+	words := make([]uint64, word_count)
+	if err := binary.Read(r, binary.BigEndian, words); err != nil {
+		return nil, err
+	}
+	var rlw uint32
+	if err := binary.Read(r, binary.BigEndian, &rlw); err != nil {
+		return nil, err
+	}
+
+	b := &EwahBitmap{Bits: make([]bool, 0, bit_size)}
+	put := func(w uint64) {
+		for i := 0; i < EWAH_WORD_BITS && len(b.Bits) < int(bit_size); i++ {
+			b.Bits = append(b.Bits, w&(1<<uint(i)) != 0)
+		}
+	}
+	for i := 0; i < len(words); {
+		running_bit := words[i]&1 != 0
+		running_len := (words[i] >> 1) & (1<<EWAH_RUNNING_LENGTH_BITS - 1)
+		literals := int(words[i] >> (1 + EWAH_RUNNING_LENGTH_BITS))
+		i++
+		if i+literals > len(words) {
+			return nil, fmt.Errorf("corrupt ewah bitmap")
+		}
+
+		run := uint64(0)
+		if running_bit {
+			run = ^uint64(0)
+		}
+		for k := uint64(0); k < running_len && len(b.Bits) < int(bit_size); k++ {
+			put(run)
+		}
+		for k := 0; k < literals; k++ {
+			put(words[i+k])
+		}
+		i += literals
+	}
+	// trailing zero bits are not stored
+	for len(b.Bits) < int(bit_size) {
+		b.Bits = append(b.Bits, false)
+	}
+	return b, nil
+}
+
+// ewah_bitmap_bytes serializes the bitmap. runs of empty or full words are compressed.
+func ewah_bitmap_bytes(b *EwahBitmap) []byte {
+	var words []uint64
+	for i := 0; i < len(b.Bits); i += EWAH_WORD_BITS {
+		var w uint64
+		for k := 0; k < EWAH_WORD_BITS && i+k < len(b.Bits); k++ {
+			if b.Bits[i+k] {
+				w |= 1 << uint(k)
+			}
+		}
+		words = append(words, w)
+	}
+
+	// a running length word followed by literal words, repeatedly.
+	// an empty bitmap is a single running length word.
+	var out []uint64
+	rlw := 0
+	for i := 0; i < len(words) || len(out) == 0; {
+		rlw = len(out)
+		out = append(out, 0)
+
+		var running_bit, running_len, literals uint64
+		if i < len(words) && (words[i] == 0 || words[i] == ^uint64(0)) {
+			clean := words[i]
+			for i < len(words) && words[i] == clean && running_len < 1<<EWAH_RUNNING_LENGTH_BITS-1 {
+				running_len++
+				i++
+			}
+			if clean != 0 {
+				running_bit = 1
+			}
+		}
+		for i < len(words) && words[i] != 0 && words[i] != ^uint64(0) && literals < 1<<EWAH_LITERAL_BITS-1 {
+			out = append(out, words[i])
+			literals++
+			i++
+		}
+		out[rlw] = running_bit | running_len<<1 | literals<<(1+EWAH_RUNNING_LENGTH_BITS)
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint32(len(b.Bits)))
+	binary.Write(buf, binary.BigEndian, uint32(len(out)))
+	binary.Write(buf, binary.BigEndian, out)
+	binary.Write(buf, binary.BigEndian, uint32(rlw))
+	return buf.Bytes()
+}
@@ -6,6 +6,7 @@ import (
 	"compress/zlib"
 	"crypto/sha1"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -35,12 +36,10 @@ func hash_obj_cmd_sub(write bool, f *os.File) {
 		fmt.Fprintf(os.Stderr, "Error: 'git hash-object' failed. %v\n", err)
 		os.Exit(1)
 	}
-	if write == false {
-		fmt.Printf("%x\n", sha)
-	}
+	fmt.Printf("%x\n", sha)
 }
 
-func hash_object(write bool, f *os.File) ([]byte, error) {
+func hash_object(write bool, f io.Reader) ([]byte, error) {
 	// contents
 	content, err := ioutil.ReadAll(f)
 	if err != nil {
@@ -110,9 +109,24 @@ func write_hash_object(key []byte, obj []byte) error {
 	w.Write(obj)
 	w.Close()
 
-	// store to file
-	err = ioutil.WriteFile(fpath, b.Bytes(), 0644)
-	if err != nil && os.IsNotExist(err) == true {
+	// store to file. the object is written to a temporary file and renamed,
+	// so that files hashed concurrently never leave a partially written object
+	f, err := ioutil.TempFile(obj_dir, "tmp_obj_")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fpath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
 		return err
 	}
 
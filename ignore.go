@@ -0,0 +1,299 @@
+// See Also:
+// https://git-scm.com/docs/gitignore
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type IgnorePattern struct {
+	Pattern  string // pattern without '!', leading '/' and trailing '/'
+	Negative bool   // '!pattern' re-includes the path
+	DirOnly  bool   // 'pattern/' matches only directories
+	NoDir    bool   // pattern without '/' matches the basename at any level
+	Base     string // directory of the source file relative to the work tree ("" or "dir/")
+	Source   string // file which defines the pattern ("" for command line)
+	LineNo   int    // line number in the source file
+	Text     string // original text of the pattern
+}
+
+type IgnoreRules struct {
+	root        string                      // work tree
+	flags       int                         // wildmatch flags (WM_CASEFOLD for core.ignorecase)
+	cmdline     []*IgnorePattern            // --exclude
+	files       [][]*IgnorePattern          // --exclude-from, info/exclude and core.excludesFile
+	per_dir     string                      // --exclude-per-directory (ex. '.gitignore')
+	per_dir_pat map[string][]*IgnorePattern // loaded patterns of each directory
+	per_dir_sha map[string][20]byte         // blob of the per-directory exclude file of each directory (all zero if not exist)
+
+	// only the standard exclusions are used. (the untracked cache can be used)
+	standard      bool
+	excludes_file string // core.excludesFile
+	info_exclude  string // $GIT_DIR/info/exclude
+}
+
+type ExcludeOptions struct {
+	Patterns     []string // --exclude=<pattern>
+	Files        []string // --exclude-from=<file>
+	PerDirectory string   // --exclude-per-directory=<file>
+	Standard     bool     // --exclude-standard
+}
+
+func (o *ExcludeOptions) is_empty() bool {
+	return len(o.Patterns) == 0 && len(o.Files) == 0 && len(o.PerDirectory) == 0 && o.Standard == false
+}
+
+func new_ignore_rules(repop string) *IgnoreRules {
+	x := &IgnoreRules{}
+	x.root = filepath.Dir(repop)
+	x.per_dir_pat = make(map[string][]*IgnorePattern)
+	x.per_dir_sha = make(map[string][20]byte)
+	if get_config_bool(repop, "core.ignorecase", false) {
+		x.flags |= WM_CASEFOLD
+	}
+	return x
+}
+
+// build_ignore_rules sets up ignore rules by command line options.
+func build_ignore_rules(repop string, opts ExcludeOptions) (*IgnoreRules, error) {
+	x := new_ignore_rules(repop)
+
+	for _, p := range opts.Patterns {
+		x.cmdline = append(x.cmdline, parse_ignore_patterns([]byte(p), "", "")...)
+	}
+	for _, f := range opts.Files {
+		if err := x.add_exclude_file(f, f); err != nil {
+			return nil, fmt.Errorf("cannot use %s as an exclude file", f)
+		}
+	}
+	if len(opts.PerDirectory) > 0 {
+		x.per_dir = opts.PerDirectory
+	}
+	if opts.Standard {
+		if err := x.add_exclude_standard(repop); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+// add_exclude_standard adds the standard git exclusions.
+// .git/info/exclude, core.excludesFile and .gitignore in each directory.
+func (x *IgnoreRules) add_exclude_standard(repop string) error {
+	x.standard = len(x.cmdline) == 0 && len(x.files) == 0
+
+	// core.excludesFile (default: $XDG_CONFIG_HOME/git/ignore)
+	excludes_file, ok := get_config(repop, "core.excludesfile")
+	if ok && strings.HasPrefix(excludes_file, "~/") {
+		excludes_file = filepath.Join(os.Getenv("HOME"), excludes_file[2:])
+	}
+	if ok == false {
+		xdg := os.Getenv("XDG_CONFIG_HOME")
+		if len(xdg) == 0 {
+			xdg = filepath.Join(os.Getenv("HOME"), ".config")
+		}
+		excludes_file = filepath.Join(xdg, "git", "ignore")
+	}
+	x.excludes_file = excludes_file
+	if err := x.add_exclude_file(excludes_file, excludes_file); err != nil && os.IsNotExist(err) == false {
+		return err
+	}
+
+	// info/exclude
+	p := filepath.Join(repop, "info", "exclude")
+	x.info_exclude = p
+	src, err := filepath.Rel(x.root, p)
+	if err != nil {
+		src = p
+	}
+	if err := x.add_exclude_file(p, src); err != nil && os.IsNotExist(err) == false {
+		return err
+	}
+
+	x.per_dir = ".gitignore"
+	return nil
+}
+
+// add_exclude_file adds patterns in the file. later files take precedence.
+func (x *IgnoreRules) add_exclude_file(path string, source string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	x.files = append(x.files, parse_ignore_patterns(b, "", source))
+	return nil
+}
+
+// parse_ignore_patterns parses lines of gitignore format.
+func parse_ignore_patterns(b []byte, base string, source string) []*IgnorePattern {
+	var patterns []*IgnorePattern
+
+	for i, l := range bytes.Split(b, []byte("\n")) {
+		line := strings.TrimSuffix(string(l), "\r")
+
+		// comment or blank line
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		// trailing spaces are ignored unless they are quoted with backslash
+		line = trim_trailing_spaces(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		x := &IgnorePattern{}
+		x.Text = line
+		x.Base = base
+		x.Source = source
+		x.LineNo = i + 1
+
+		p := line
+		if p[0] == '!' {
+			x.Negative = true
+			p = p[1:]
+		}
+		if strings.HasSuffix(p, "/") {
+			x.DirOnly = true
+			p = strings.TrimSuffix(p, "/")
+		}
+		if strings.Contains(p, "/") == false {
+			x.NoDir = true
+		}
+		x.Pattern = strings.TrimPrefix(p, "/")
+
+		patterns = append(patterns, x)
+	}
+	return patterns
+}
+
+func trim_trailing_spaces(s string) string {
+	end := len(s)
+	for end > 0 && s[end-1] == ' ' {
+		// count backslashes before the space
+		k := end - 1
+		for k > 0 && s[k-1] == '\\' {
+			k--
+		}
+		if (end-1-k)%2 == 1 {
+			// escaped space
+			break
+		}
+		end--
+	}
+	return s[:end]
+}
+
+// match returns the pattern which decides whether the path is ignored.
+// path is relative to the work tree. nil is returned if no patterns match.
+// a path is ignored when one of the leading directories is ignored.
+func (x *IgnoreRules) match(path string, is_dir bool) *IgnorePattern {
+	components := strings.Split(path, "/")
+	for i := 1; i < len(components); i++ {
+		dir := strings.Join(components[:i], "/")
+		if p := x.last_matching(dir, true); p != nil && p.Negative == false {
+			return p
+		}
+	}
+	return x.last_matching(path, is_dir)
+}
+
+func (x *IgnoreRules) is_ignored(path string, is_dir bool) bool {
+	p := x.match(path, is_dir)
+	return p != nil && p.Negative == false
+}
+
+// last_matching searches the patterns by precedence.
+// command line, .gitignore (deeper directories first), and then exclude files.
+func (x *IgnoreRules) last_matching(path string, is_dir bool) *IgnorePattern {
+	if p := x.match_list(x.cmdline, path, is_dir); p != nil {
+		return p
+	}
+
+	if len(x.per_dir) > 0 {
+		dir := path
+		for {
+			idx := strings.LastIndex(dir, "/")
+			if idx < 0 {
+				dir = ""
+			} else {
+				dir = dir[:idx]
+			}
+			if p := x.match_list(x.per_directory_patterns(dir), path, is_dir); p != nil {
+				return p
+			}
+			if len(dir) == 0 {
+				break
+			}
+		}
+	}
+
+	for i := len(x.files) - 1; i >= 0; i-- {
+		if p := x.match_list(x.files[i], path, is_dir); p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// per_directory_patterns returns patterns in the per-directory exclude file (ex. '.gitignore') of dir.
+func (x *IgnoreRules) per_directory_patterns(dir string) []*IgnorePattern {
+	if p, ok := x.per_dir_pat[dir]; ok {
+		return p
+	}
+
+	base := ""
+	if len(dir) > 0 {
+		base = dir + "/"
+	}
+	var patterns []*IgnorePattern
+	b, err := ioutil.ReadFile(filepath.Join(x.root, dir, x.per_dir))
+	if err == nil {
+		patterns = parse_ignore_patterns(b, base, base+x.per_dir)
+		x.per_dir_sha[dir] = blob_sha1(b)
+	}
+	x.per_dir_pat[dir] = patterns
+	return patterns
+}
+
+// per_directory_sha1 returns the blob of the per-directory exclude file of dir. all zero if it doesn't exist.
+func (x *IgnoreRules) per_directory_sha1(dir string) [20]byte {
+	x.per_directory_patterns(dir)
+	return x.per_dir_sha[dir]
+}
+
+// match_list returns the last pattern in the list which matches the path.
+func (x *IgnoreRules) match_list(patterns []*IgnorePattern, path string, is_dir bool) *IgnorePattern {
+	basename := path
+	if idx := strings.LastIndex(path, "/"); idx >= 0 {
+		basename = path[idx+1:]
+	}
+
+	for i := len(patterns) - 1; i >= 0; i-- {
+		p := patterns[i]
+		if p.DirOnly && is_dir == false {
+			continue
+		}
+
+		if p.NoDir {
+			if wildmatch(p.Pattern, basename, x.flags) {
+				return p
+			}
+			continue
+		}
+
+		// anchored to the directory of the source file
+		if strings.HasPrefix(path, p.Base) == false {
+			continue
+		}
+		if wildmatch(p.Pattern, path[len(p.Base):], x.flags|WM_PATHNAME) {
+			return p
+		}
+	}
+	return nil
+}
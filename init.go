@@ -94,7 +94,7 @@ func init_cmd() {
 		fmt.Fprintf(os.Stderr, "Error: 'git init' failed. %v\n", err)
 		os.Exit(1)
 	}
-	p = filepath.Join(repo_path, "exclude")
+	p = filepath.Join(repo_path, "info", "exclude")
 	if _, err := os.Stat(p); os.IsNotExist(err) {
 		err = ioutil.WriteFile(p, []byte(`# git ls-files --others --exclude-from=.git/info/exclude
 # Lines that start with '#' are comments.
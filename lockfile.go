@@ -0,0 +1,106 @@
+// See Also:
+// https://github.com/git/git/blob/master/Documentation/technical/api-lockfile.txt
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const LOCK_SUFFIX = ".lock"
+
+// LockFile is '<path>.lock' created exclusively to update <path>.
+// The new contents are written to the lock file and it is renamed to <path> on commit.
+type LockFile struct {
+	path string   // path of the file to be updated
+	f    *os.File // nil after commit or rollback
+}
+
+// lock files not committed yet. they are removed when the process exits.
+var held_lock_files []*LockFile
+var lock_signal_handled bool
+
+// hold_lock_file creates '<path>.lock'. it fails if the lock file already exists.
+func hold_lock_file(path string) (*LockFile, error) {
+	lockp := path + LOCK_SUFFIX
+	f, err := os.OpenFile(lockp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("Unable to create '%s': File exists.\n\n"+
+				"Another toy-git process seems to be running in this repository, e.g.\n"+
+				"an update-index invoked in parallel. Please make sure all processes\n"+
+				"are terminated then try again. If it still fails, a toy-git process\n"+
+				"may have crashed in this repository earlier:\n"+
+				"remove the file manually to continue.", lockp)
+		}
+		return nil, fmt.Errorf("Unable to create '%s': %v", lockp, err)
+	}
+
+	l := &LockFile{path: path, f: f}
+	held_lock_files = append(held_lock_files, l)
+	if lock_signal_handled == false {
+		lock_signal_handled = true
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGPIPE)
+		go func() {
+			s := <-c
+			exit(128 + int(s.(syscall.Signal)))
+		}()
+	}
+	return l, nil
+}
+
+// write replaces the contents of the lock file.
+func (l *LockFile) write(b []byte) error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.f.WriteAt(b, 0)
+	return err
+}
+
+// commit flushes the lock file to the disk and renames it to the locked path.
+func (l *LockFile) commit() error {
+	if l.f == nil {
+		return fmt.Errorf("BUG: %s%s is not held", l.path, LOCK_SUFFIX)
+	}
+	if err := l.f.Sync(); err != nil {
+		l.rollback()
+		return err
+	}
+	if err := l.f.Close(); err != nil {
+		l.rollback()
+		return err
+	}
+	l.f = nil
+	if err := os.Rename(l.path+LOCK_SUFFIX, l.path); err != nil {
+		os.Remove(l.path + LOCK_SUFFIX)
+		return err
+	}
+	return nil
+}
+
+// rollback removes the lock file. the locked path is left untouched.
+func (l *LockFile) rollback() {
+	if l.f == nil {
+		return
+	}
+	l.f.Close()
+	l.f = nil
+	os.Remove(l.path + LOCK_SUFFIX)
+}
+
+// rollback_lock_files removes lock files not committed.
+func rollback_lock_files() {
+	for _, l := range held_lock_files {
+		l.rollback()
+	}
+}
+
+// exit removes lock files not committed and exits. use this instead of os.Exit while holding lock files.
+func exit(code int) {
+	rollback_lock_files()
+	os.Exit(code)
+}
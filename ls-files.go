@@ -3,31 +3,267 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
-func ls_files_cmd(cached bool, deleted bool, modified bool) {
-	repop, err := find_git_repository(".")
+type LsFilesOptions struct {
+	Cached        bool // -c
+	Deleted       bool // -d
+	Modified      bool // -m
+	Others        bool // -o
+	Ignored       bool // -i
+	Stage         bool // -s: show mode, object name and stage number
+	Unmerged      bool // -u: show only unmerged entries (implies -s)
+	ShowTag       bool // -t: show status tags
+	ShowValidBit  bool // -v: show status tags. lowercase for assume unchanged files
+	Debug         bool // --debug: show stat data of each entry
+	NulTerminated bool // -z: terminate lines with NUL
+	Exclude       ExcludeOptions
+	quote_path    bool // core.quotePath
+}
+
+func ls_files_cmd(opts LsFilesOptions, args []string) {
+	repop, prefix, err := setup_git_directory()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
 		os.Exit(128)
 	}
 
+	// the untracked cache may be written back. the lock is taken before loading
+	// so that the index updated by another process in the meantime is not overwritten
+	var lock *LockFile
+	if opts.Others {
+		lock, _ = hold_lock_file(filepath.Join(repop, "index"))
+	}
+
 	// read dircache
 	d, err := load_dircache(repop)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
-		os.Exit(128)
+		exit(128)
+	}
+	d.lock = lock
+
+	// list files under the current directory by default
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	pathspec, err := parse_pathspec(filepath.Dir(repop), prefix, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		exit(128)
+	}
+
+	x, err := build_ignore_rules(repop, opts.Exclude)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		exit(128)
+	}
+
+	term := "\n"
+	if opts.NulTerminated {
+		term = "\x00"
+	}
+	opts.quote_path = get_config_bool(repop, "core.quotePath", true)
+
+	if opts.Others {
+		files, err := list_untracked_files(filepath.Dir(repop), "", d, x, opts.Ignored)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+			exit(128)
+		}
+		for _, f := range files {
+			if pathspec.match(f) {
+				if opts.ShowTag || opts.ShowValidBit {
+					fmt.Print("? ")
+				}
+				fmt.Print(ls_files_path(f, opts, prefix) + term)
+			}
+		}
+
+		// the untracked cache is updated for the next time unless another process is updating the index
+		if d.lock != nil && d.untracked != nil && d.untracked.changed {
+			if err := write_dircache(d, repop); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+				exit(128)
+			}
+		}
+		if lock != nil {
+			lock.rollback()
+		}
+	}
+
+	var entries []*DircacheEntry
+	for _, e := range d.Entries {
+		if pathspec.match(string(e.PathName)) == false {
+			continue
+		}
+		// show only ignored files with -i
+		if opts.Ignored && x.is_ignored(string(e.PathName), false) == false {
+			continue
+		}
+		entries = append(entries, e)
 	}
+	d.Entries = entries
 
-	if err := print_dircache(d, cached, deleted, modified); err != nil {
+	if err := print_dircache(d, opts, prefix, term); err != nil {
 		fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
-		os.Exit(128)
+		exit(128)
 	}
 }
 
+// list_untracked_files returns files under dir ("" for the whole work tree) which are not in the index.
+// With 'ignored', only ignored files are returned. Otherwise ignored files are excluded.
+// The untracked cache is used for the whole work tree with the standard exclusions.
+func list_untracked_files(root string, dir string, d *Dircache, x *IgnoreRules, ignored bool) ([]string, error) {
+	tracked := make(map[string]bool)
+	for _, e := range d.Entries {
+		tracked[string(e.PathName)] = true
+	}
+
+	var uc *UntrackedCache
+	if len(dir) == 0 && ignored == false {
+		uc = usable_untracked_cache(d, x)
+	}
+
+	var files []string
+	var walk func(dir string, dir_ignored bool, ucd *UntrackedCacheDir) error
+	walk = func(dir string, dir_ignored bool, ucd *UntrackedCacheDir) error {
+		join := func(name string) string {
+			if len(dir) > 0 {
+				return dir + "/" + name
+			}
+			return name
+		}
+
+		// the directory is not changed since it was scanned
+		if ucd != nil && uc.valid_dir(d, x, dir, ucd) {
+			for _, name := range ucd.Untracked {
+				if tracked[strings.TrimSuffix(join(name), "/")] == false {
+					files = append(files, join(name))
+				}
+			}
+			for _, sub := range ucd.Dirs {
+				// the cache written by git may have the repository
+				if sub.Name == REPOSITORY_DIR_NAME || sub.Name == ".git" {
+					continue
+				}
+				if err := walk(join(sub.Name), false, sub); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		infos, err := ioutil.ReadDir(filepath.Join(root, dir))
+		if err != nil {
+			return err
+		}
+
+		var untracked []string
+		var subdirs []*UntrackedCacheDir
+		for _, info := range infos {
+			name := info.Name()
+			if name == REPOSITORY_DIR_NAME || name == ".git" {
+				continue
+			}
+			path := join(name)
+
+			// nested repositories are shown as 'dir/' without their files
+			if info.IsDir() && len(nested_repository(filepath.Join(root, path))) > 0 {
+				if tracked[path] == false && (dir_ignored || x.is_ignored(path, true)) == ignored {
+					files = append(files, path+"/")
+					untracked = append(untracked, name+"/")
+				}
+				continue
+			}
+
+			if info.IsDir() {
+				sub_ignored := dir_ignored || x.is_ignored(path, true)
+				// ignored directories are not traversed unless ignored files are required
+				if sub_ignored && ignored == false {
+					continue
+				}
+				var sub *UntrackedCacheDir
+				if ucd != nil {
+					if sub = ucd.lookup(name); sub == nil {
+						sub = &UntrackedCacheDir{Name: name}
+					}
+					subdirs = append(subdirs, sub)
+				}
+				if err := walk(path, sub_ignored, sub); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if tracked[path] {
+				continue
+			}
+			if (dir_ignored || x.is_ignored(path, false)) == ignored {
+				files = append(files, path)
+				untracked = append(untracked, name)
+			}
+		}
+
+		if ucd != nil {
+			uc.update_dir(ucd, untracked, subdirs)
+		}
+		return nil
+	}
+
+	var ucd *UntrackedCacheDir
+	if uc != nil {
+		ucd = uc.Root
+	}
+	if err := walk(dir, len(dir) > 0 && x.is_ignored(dir, true), ucd); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// list_directory_files returns files to be added under the directory.
+// tracked files which exist and untracked files which are not ignored.
+func list_directory_files(root string, dir string, d *Dircache, x *IgnoreRules) ([]string, error) {
+	files, err := list_untracked_files(root, dir, d, x, false)
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		files[i] = strings.TrimSuffix(files[i], "/")
+	}
+
+	prefix := ""
+	if len(dir) > 0 {
+		prefix = dir + "/"
+	}
+	idx := dircache_name_pos(d, prefix, 0)
+	if idx < 0 {
+		idx = -idx - 1
+	}
+	for ; idx < len(d.Entries) && strings.HasPrefix(string(d.Entries[idx].PathName), prefix); idx++ {
+		e := d.Entries[idx]
+		if e.removed || (idx > 0 && bytes.Equal(d.Entries[idx-1].PathName, e.PathName)) {
+			continue
+		}
+		if _, err := os.Lstat(string(e.PathName)); err == nil {
+			files = append(files, string(e.PathName))
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// is_deleted reports whether the file is removed from the work tree. a dangling symbolic link is not deleted.
 func is_deleted(path string) (bool, error) {
-	_, err := os.Stat(path)
+	_, err := os.Lstat(path)
 	if err != nil && os.IsNotExist(err) {
 		return true, nil
 	} else if err != nil {
@@ -36,17 +272,45 @@ func is_deleted(path string) (bool, error) {
 	return false, nil
 }
 
-func is_modified(e *DircacheEntry) (bool, error) {
+// is_modified reports whether the file differs from the entry.
+// The file is rehashed only when the stat data differs or the entry is racily clean.
+// Files marked as assume unchanged are not modified unless they are deleted.
+func is_modified(d *Dircache, e *DircacheEntry) (bool, error) {
 	path := e.PathName
 
-	f, err := os.Open(string(path))
+	info, err := os.Lstat(string(path))
 	if err != nil && os.IsNotExist(err) {
 		return true, nil
 	} else if err != nil {
 		return false, err
 	}
 
-	b, err := hash_object(false, f)
+	// the file is assumed to be unchanged (update-index --assume-unchanged)
+	if e.assume_valid() {
+		return false, nil
+	}
+
+	// type or executable bit is changed
+	if worktree_mode(d, e, info) != e.Mode {
+		return true, nil
+	}
+	// gitlinks are compared by the checked out commit. stat data is ignored
+	if e.Mode == 0160000 {
+		head, err := resolve_gitlink_head(string(path))
+		if err != nil {
+			return false, nil
+		}
+		return !bytes.Equal(head, e.Sha1[:]), nil
+	}
+	// size is changed
+	if uint32(info.Size()) != e.Size {
+		return true, nil
+	}
+	if is_stat_changed(e, info) == false && is_racily_clean(d, e) == false {
+		return false, nil
+	}
+
+	b, err := hash_worktree_file(string(path), info, false)
 	if err != nil {
 		return false, err
 	}
@@ -54,31 +318,103 @@ func is_modified(e *DircacheEntry) (bool, error) {
 	return !bytes.Equal(b, e.Sha1[:]), nil
 }
 
-func print_dircache(d *Dircache, cached bool, deleted bool, modified bool) error {
-	for _, e := range d.Entries {
-		if cached {
-			fmt.Println(string(e.PathName))
-		}
+// is_stat_changed compares stat data of the file with the entry.
+func is_stat_changed(e *DircacheEntry, info os.FileInfo) bool {
+	st := &DircacheEntry{}
+	fill_dircache_stat(st, info)
 
-		if deleted {
-			d, err := is_deleted(string(e.PathName))
-			if err != nil {
-				return err
-			}
-			if d {
-				fmt.Println(string(e.PathName))
+	return st.MTimeSeconds != e.MTimeSeconds ||
+		st.MTimeNanoSeconds != e.MTimeNanoSeconds ||
+		st.CTimeSeconds != e.CTimeSeconds ||
+		st.CTimeNanoSeconds != e.CTimeNanoSeconds ||
+		st.Inode != e.Inode ||
+		st.UID != e.UID ||
+		st.GID != e.GID ||
+		st.Size != e.Size
+}
+
+// is_racily_clean reports whether the file may be modified in the same timestamp as the index was written.
+// the stat data of such entry can not be trusted.
+func is_racily_clean(d *Dircache, e *DircacheEntry) bool {
+	if d.MTime.IsZero() {
+		return false
+	}
+	sec := uint32(d.MTime.Unix())
+	nsec := uint32(d.MTime.Nanosecond())
+
+	return sec < e.MTimeSeconds || (sec == e.MTimeSeconds && nsec <= e.MTimeNanoSeconds)
+}
+
+func print_dircache(d *Dircache, opts LsFilesOptions, prefix string, term string) error {
+	// files are checked concurrently and printed in order
+	var status []WorktreeStatus
+	if opts.Deleted || opts.Modified {
+		status = preload_worktree_status(d, opts.Deleted, opts.Modified, func(e *DircacheEntry) bool {
+			return e.skip_worktree()
+		})
+	}
+
+	for i, e := range d.Entries {
+		if opts.Cached || opts.Stage || opts.Unmerged {
+			if opts.Unmerged == false || e.stage() != 0 {
+				tag := "H "
+				if e.stage() != 0 {
+					tag = "M "
+				} else if e.skip_worktree() {
+					tag = "S "
+				}
+				print_dircache_entry(e, opts, tag, prefix, term)
 			}
 		}
 
-		if modified {
-			m, err := is_modified(e)
-			if err != nil {
-				return err
-			}
-			if m {
-				fmt.Println(string(e.PathName))
-			}
+		// files out of the work tree are neither deleted nor modified
+		if e.skip_worktree() {
+			continue
+		}
+
+		if status != nil && status[i].err != nil {
+			return status[i].err
+		}
+		if opts.Deleted && status[i].deleted {
+			print_dircache_entry(e, opts, "R ", prefix, term)
+		}
+		if opts.Modified && status[i].modified {
+			print_dircache_entry(e, opts, "C ", prefix, term)
 		}
 	}
 	return nil
 }
+
+// ls_files_path returns the path to be shown. the path is relative to the current directory and quoted unless -z is given.
+func ls_files_path(path string, opts LsFilesOptions, prefix string) string {
+	if opts.NulTerminated {
+		return relative_path(prefix, path)
+	}
+	return quote_c_style(relative_path(prefix, path), opts.quote_path, false)
+}
+
+func print_dircache_entry(e *DircacheEntry, opts LsFilesOptions, tag string, prefix string, term string) {
+	if opts.ShowTag || opts.ShowValidBit {
+		if opts.ShowValidBit && e.assume_valid() {
+			tag = strings.ToLower(tag)
+		}
+		fmt.Print(tag)
+	}
+
+	path := ls_files_path(string(e.PathName), opts, prefix)
+	if opts.Stage || opts.Unmerged {
+		fmt.Printf("%06o %x %d\t%s%s", e.Mode, e.Sha1, e.stage(), path, term)
+	} else {
+		fmt.Printf("%s%s", path, term)
+	}
+
+	if opts.Debug {
+		// flags are shown as git holds them in memory. extended flags are in the upper 16 bits
+		flags := uint32(e.Flags&^DIRCACHE_FLAG_NAME_LENGTH) | uint32(e.ExtendedFlags)<<16
+		fmt.Printf("  ctime: %d:%d\n", e.CTimeSeconds, e.CTimeNanoSeconds)
+		fmt.Printf("  mtime: %d:%d\n", e.MTimeSeconds, e.MTimeNanoSeconds)
+		fmt.Printf("  dev: %d\tino: %d\n", e.Dev, e.Inode)
+		fmt.Printf("  uid: %d\tgid: %d\n", e.UID, e.GID)
+		fmt.Printf("  size: %d\tflags: %x\n", e.Size, flags)
+	}
+}
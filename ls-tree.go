@@ -0,0 +1,148 @@
+// See Also:
+// https://git-scm.com/docs/git-ls-tree
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type LsTreeOptions struct {
+	OnlyTrees     bool // -d
+	Recurse       bool // -r
+	ShowTrees     bool // -t
+	Long          bool // -l
+	NameOnly      bool // --name-only
+	NulTerminated bool // -z
+}
+
+func ls_tree_cmd(opts LsTreeOptions, tree_ish string, args []string) {
+	repop, prefix, err := setup_git_directory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
+		os.Exit(128)
+	}
+
+	sha, err := resolve_tree_ish(repop, tree_ish)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: Not a valid object name %s\n", tree_ish)
+		os.Exit(128)
+	}
+
+	// paths are relative to the current directory. a trailing '/' lists the contents of the directory
+	var paths []string
+	for _, arg := range args {
+		p, err := normalize_path(filepath.Dir(repop), prefix, arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+			os.Exit(128)
+		}
+		paths = append(paths, p)
+	}
+	if len(args) == 0 && len(prefix) > 0 {
+		paths = []string{prefix}
+	}
+
+	term := "\n"
+	if opts.NulTerminated {
+		term = "\x00"
+	}
+
+	if err := ls_tree(repop, sha, "", paths, opts, prefix, term); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+}
+
+// ls_tree prints entries of the tree whose path begins with base.
+func ls_tree(repop string, sha string, base string, paths []string, opts LsTreeOptions, prefix string, term string) error {
+	entries, err := read_tree_entries(repop, sha)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		path := base + e.Name
+		is_tree := e.Mode == 040000
+		matched, leading := ls_tree_match(path, paths)
+
+		if is_tree && leading {
+			// go down to the paths given
+			if opts.ShowTrees {
+				ls_tree_print(repop, e, path, opts, prefix, term)
+			}
+			if err := ls_tree(repop, fmt.Sprintf("%x", e.Hash), path+"/", paths, opts, prefix, term); err != nil {
+				return err
+			}
+			continue
+		}
+		if matched == false {
+			continue
+		}
+
+		if is_tree && opts.Recurse {
+			if opts.ShowTrees || opts.OnlyTrees {
+				ls_tree_print(repop, e, path, opts, prefix, term)
+			}
+			if err := ls_tree(repop, fmt.Sprintf("%x", e.Hash), path+"/", paths, opts, prefix, term); err != nil {
+				return err
+			}
+			continue
+		}
+		// gitlinks are shown with -d as well as trees
+		if opts.OnlyTrees && is_tree == false && e.Mode != 0160000 {
+			continue
+		}
+		ls_tree_print(repop, e, path, opts, prefix, term)
+	}
+	return nil
+}
+
+// ls_tree_match reports whether the path is shown and whether it is a leading directory of the paths given.
+func ls_tree_match(path string, paths []string) (bool, bool) {
+	if len(paths) == 0 {
+		return true, false
+	}
+
+	matched := false
+	leading := false
+	for _, p := range paths {
+		if p == path || strings.HasPrefix(path, p+"/") || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			matched = true
+		}
+		if strings.HasPrefix(p, path+"/") {
+			leading = true
+		}
+	}
+	return matched, leading
+}
+
+func ls_tree_print(repop string, e *FileEntry, path string, opts LsTreeOptions, prefix string, term string) {
+	path = relative_path(prefix, path)
+	if opts.NameOnly {
+		fmt.Print(path + term)
+		return
+	}
+
+	t := "blob"
+	switch e.Mode {
+	case 040000:
+		t = "tree"
+	case 0160000:
+		t = "commit"
+	}
+
+	if opts.Long {
+		size := "-"
+		if t == "blob" {
+			if _, b, err := read_object_file(repop, fmt.Sprintf("%x", e.Hash)); err == nil {
+				size = fmt.Sprintf("%d", len(b))
+			}
+		}
+		fmt.Printf("%06o %s %x %7s\t%s%s", e.Mode, t, e.Hash, size, path, term)
+		return
+	}
+	fmt.Printf("%06o %s %x\t%s%s", e.Mode, t, e.Hash, path, term)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
@@ -12,11 +13,25 @@ const (
 )
 
 func main() {
+	// lock files are left only by a crash
+	defer rollback_lock_files()
+
 	hash_obj_flag := flag.NewFlagSet("hash-object", flag.ExitOnError)
 	cat_file_flag := flag.NewFlagSet("cat-file", flag.ExitOnError)
 	update_index_flag := flag.NewFlagSet("update-index", flag.ExitOnError)
 	ls_files_flag := flag.NewFlagSet("ls-files", flag.ExitOnError)
 	commit_tree_flag := flag.NewFlagSet("commit-tree", flag.ExitOnError)
+	read_tree_flag := flag.NewFlagSet("read-tree", flag.ExitOnError)
+	checkout_index_flag := flag.NewFlagSet("checkout-index", flag.ExitOnError)
+	check_ignore_flag := flag.NewFlagSet("check-ignore", flag.ExitOnError)
+	add_flag := flag.NewFlagSet("add", flag.ExitOnError)
+	ls_tree_flag := flag.NewFlagSet("ls-tree", flag.ExitOnError)
+	submodule_flag := flag.NewFlagSet("submodule status", flag.ExitOnError)
+	status_flag := flag.NewFlagSet("status", flag.ExitOnError)
+	diff_files_flag := flag.NewFlagSet("diff-files", flag.ExitOnError)
+	diff_index_flag := flag.NewFlagSet("diff-index", flag.ExitOnError)
+	diff_tree_flag := flag.NewFlagSet("diff-tree", flag.ExitOnError)
+	apply_flag := flag.NewFlagSet("apply", flag.ExitOnError)
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `toy-git 
@@ -33,6 +48,17 @@ toy-git is sets of subcommands.
  * toy-git write-tree
  * toy-git commit-tree
  * toy-git update-ref
+ * toy-git read-tree
+ * toy-git checkout-index
+ * toy-git check-ignore
+ * toy-git add
+ * toy-git ls-tree
+ * toy-git submodule status
+ * toy-git status
+ * toy-git diff-files
+ * toy-git diff-index
+ * toy-git diff-tree
+ * toy-git apply
 
 See also each subcommands help.
 
@@ -73,32 +99,96 @@ Ex) toy-git hash-object -h
 
 		cat_file_cmd(*t, *s, *p, cat_file_flag.Args())
 	case "update-index":
-		add := update_index_flag.Bool("add", false, "If a specified file isn't in the index already then it's added. Default behaviour is to ignore new files.")
-		remove := update_index_flag.Bool("remove", false, "If a specified file is in the index but is missing then it's removed. Default behavior is to ignore removed file.")
+		var opts UpdateIndexOptions
+		update_index_flag.BoolVar(&opts.Add, "add", false, "If a specified file isn't in the index already then it's added. Default behaviour is to ignore new files.")
+		update_index_flag.BoolVar(&opts.Remove, "remove", false, "If a specified file is in the index but is missing then it's removed. Default behavior is to ignore removed file.")
+		update_index_flag.BoolVar(&opts.ForceRemove, "force-remove", false, "Remove the file from the index even when the working directory still has such a file.")
+		update_index_flag.BoolVar(&opts.Refresh, "refresh", false, "Looks at the current index and checks to see if merges or updates are needed by checking stat() information.")
+		update_index_flag.BoolVar(&opts.AssumeUnchanged, "assume-unchanged", false, "Set the \"assume unchanged\" bit for the paths. Git stops checking the working tree files for possible modifications.")
+		update_index_flag.BoolVar(&opts.NoAssumeUnchanged, "no-assume-unchanged", false, "Unset the \"assume unchanged\" bit for the paths.")
+		update_index_flag.BoolVar(&opts.SkipWorktree, "skip-worktree", false, "Set the \"skip-worktree\" bit for the paths. The index is written in version 3 or later.")
+		update_index_flag.BoolVar(&opts.NoSkipWorktree, "no-skip-worktree", false, "Unset the \"skip-worktree\" bit for the paths.")
+		update_index_flag.StringVar(&opts.Chmod, "chmod", "", "Set the execute permissions on the updated files. (+x or -x)")
+		update_index_flag.BoolVar(&opts.InfoOnly, "info-only", false, "Do not create objects in the object database for all <file> arguments that follow; just insert their object IDs into the index. Objects given by --cacheinfo and --index-info are not verified.")
+		update_index_flag.IntVar(&opts.IndexVersion, "index-version", 0, "Write the resulting index out in the named on-disk format version. Supported versions are 2, 3 and 4.")
+		update_index_flag.Var((*string_list)(&opts.CacheInfo), "cacheinfo", "Directly insert the specified info into the index. (<mode>,<object>,<path>)")
+		update_index_flag.BoolVar(&opts.IndexInfo, "index-info", false, "Read index information from stdin.")
+		update_index_flag.BoolVar(&opts.NulTerminated, "z", false, "Only meaningful with --index-info; paths are separated with NUL character instead of LF.")
+		update_index_flag.BoolVar(&opts.SplitIndex, "split-index", false, "Enable split index mode. Entries are written to a shared index file ($GIT_DIR/sharedindex.<SHA-1>) and the index records only changes from it.")
+		update_index_flag.BoolVar(&opts.NoSplitIndex, "no-split-index", false, "Disable split index mode. All entries are written to the index.")
+		update_index_flag.BoolVar(&opts.UntrackedCache, "untracked-cache", false, "Enable the untracked cache feature. Untracked files of unchanged directories are not scanned again.")
+		update_index_flag.BoolVar(&opts.NoUntrackedCache, "no-untracked-cache", false, "Disable the untracked cache feature.")
 		update_index_flag.Parse(os.Args[2:])
 
-		if *add == true && *remove == true {
+		if opts.Add == true && opts.Remove == true {
+			update_index_flag.Usage()
+			return
+		}
+
+		if (opts.AssumeUnchanged == true && opts.NoAssumeUnchanged == true) ||
+			(opts.SkipWorktree == true && opts.NoSkipWorktree == true) ||
+			(opts.SplitIndex == true && opts.NoSplitIndex == true) ||
+			(opts.UntrackedCache == true && opts.NoUntrackedCache == true) {
 			update_index_flag.Usage()
 			return
 		}
 
-		if len(update_index_flag.Args()) < 1 {
+		if len(opts.Chmod) > 0 && opts.Chmod != "+x" && opts.Chmod != "-x" {
+			fmt.Fprintf(os.Stderr, "fatal: git update-index: --chmod param '%s' must be either -x or +x\n", opts.Chmod)
+			os.Exit(128)
+		}
+
+		if opts.IndexVersion != 0 && (opts.IndexVersion < 2 || opts.IndexVersion > 4) {
+			fmt.Fprintf(os.Stderr, "fatal: index-version %d not in range: 2..4\n", opts.IndexVersion)
+			os.Exit(128)
+		}
+
+		if len(update_index_flag.Args()) < 1 && opts.IndexVersion == 0 && opts.Refresh == false &&
+			len(opts.CacheInfo) == 0 && opts.IndexInfo == false &&
+			opts.SplitIndex == false && opts.NoSplitIndex == false &&
+			opts.UntrackedCache == false && opts.NoUntrackedCache == false {
 			update_index_flag.Usage()
 			return
 		}
 
-		update_index_cmd(*add, *remove, update_index_flag.Args())
+		update_index_cmd(opts, update_index_flag.Args())
 	case "ls-files":
-		cached := ls_files_flag.Bool("c", false, "Show cached files in the output (default)")
-		deleted := ls_files_flag.Bool("d", false, "Show deleted files in the output")
-		modified := ls_files_flag.Bool("m", false, "Show modified files in the output")
+		var opts LsFilesOptions
+		ls_files_flag.BoolVar(&opts.Cached, "c", false, "Show cached files in the output (default)")
+		ls_files_flag.BoolVar(&opts.Deleted, "d", false, "Show deleted files in the output")
+		ls_files_flag.BoolVar(&opts.Modified, "m", false, "Show modified files in the output")
+		ls_files_flag.BoolVar(&opts.Others, "o", false, "Show other (i.e. untracked) files in the output")
+		ls_files_flag.BoolVar(&opts.Ignored, "i", false, "Show only ignored files in the output. Must be used with either an explicit '-c' or '-o'.")
+		ls_files_flag.BoolVar(&opts.Stage, "s", false, "Show staged contents' mode bits, object name and stage number in the output.")
+		ls_files_flag.BoolVar(&opts.Stage, "stage", false, "Same as -s.")
+		ls_files_flag.BoolVar(&opts.Unmerged, "u", false, "Show information about unmerged files in the output, but do not show any other tracked files (forces --stage).")
+		ls_files_flag.BoolVar(&opts.Unmerged, "unmerged", false, "Same as -u.")
+		ls_files_flag.BoolVar(&opts.ShowTag, "t", false, "Show status tags together with filenames. (H: cached, S: skip-worktree, M: unmerged, R: removed/deleted, C: modified/changed, ?: other)")
+		ls_files_flag.BoolVar(&opts.ShowValidBit, "v", false, "Similar to -t, but use lowercase letters for files that are marked as assume unchanged.")
+		ls_files_flag.BoolVar(&opts.Debug, "debug", false, "After each line that describes a file, add more data about its cache entry.")
+		ls_files_flag.BoolVar(&opts.NulTerminated, "z", false, "\\0 line termination on output and do not quote filenames.")
+		ls_files_flag.Var((*string_list)(&opts.Exclude.Patterns), "exclude", "Skip untracked files matching pattern.")
+		ls_files_flag.Var((*string_list)(&opts.Exclude.Files), "exclude-from", "Read exclude patterns from <file>; 1 per line.")
+		ls_files_flag.StringVar(&opts.Exclude.PerDirectory, "exclude-per-directory", "", "Read additional exclude patterns that apply only to the directory and its subdirectories in <file>.")
+		ls_files_flag.BoolVar(&opts.Exclude.Standard, "exclude-standard", false, "Add the standard Git exclusions: .git/info/exclude, .gitignore in each directory, and the user's global exclusion file.")
 		ls_files_flag.Parse(os.Args[2:])
 
-		if *cached == false && *deleted == false && *modified == false {
-			*cached = true
+		if opts.Ignored == true && opts.Cached == false && opts.Others == false {
+			fmt.Fprintf(os.Stderr, "fatal: ls-files -i must be used with either -o or -c\n")
+			os.Exit(128)
 		}
 
-		ls_files_cmd(*cached, *deleted, *modified)
+		if opts.Ignored == true && opts.Exclude.is_empty() {
+			fmt.Fprintf(os.Stderr, "fatal: ls-files --ignored needs some exclude pattern\n")
+			os.Exit(128)
+		}
+
+		if opts.Cached == false && opts.Deleted == false && opts.Modified == false && opts.Others == false &&
+			opts.Stage == false && opts.Unmerged == false {
+			opts.Cached = true
+		}
+
+		ls_files_cmd(opts, ls_files_flag.Args())
 	case "write-tree":
 		write_tree_cmd()
 	case "commit-tree":
@@ -119,12 +209,354 @@ Ex) toy-git hash-object -h
 		}
 
 		update_ref_cmd(os.Args[2], os.Args[3])
+	case "read-tree":
+		prefix := read_tree_flag.String("prefix", "", "Keep the current index contents, and read the contents of the named tree-ish under the directory at <prefix>.")
+		empty := read_tree_flag.Bool("empty", false, "Instead of reading tree object(s) into the index, just empty it.")
+		merge := read_tree_flag.Bool("m", false, "Perform a merge, not just a read. (1 tree: read, 2 trees: two-way merge, 3 trees: three-way merge)")
+		read_tree_flag.Parse(os.Args[2:])
+
+		if *empty == true && len(read_tree_flag.Args()) > 0 {
+			read_tree_flag.Usage()
+			return
+		}
+
+		if *empty == false && len(read_tree_flag.Args()) < 1 {
+			read_tree_flag.Usage()
+			return
+		}
+
+		if *merge == true && (len(read_tree_flag.Args()) > 3 || len(*prefix) > 0) {
+			read_tree_flag.Usage()
+			return
+		}
+
+		if len(*prefix) > 0 && len(read_tree_flag.Args()) > 1 {
+			read_tree_flag.Usage()
+			return
+		}
+
+		read_tree_cmd(*prefix, *empty, *merge, read_tree_flag.Args())
+	case "checkout-index":
+		all := checkout_index_flag.Bool("a", false, "checks out all files in the index except for those with the skip-worktree bit set. Cannot be used together with explicit <file>s.")
+		force := checkout_index_flag.Bool("f", false, "forces overwrite of existing files")
+		prefix := checkout_index_flag.String("prefix", "", "When creating files, prepend <string> (usually a directory including a trailing /)")
+		checkout_index_flag.Parse(os.Args[2:])
+
+		if *all == true && len(checkout_index_flag.Args()) > 0 {
+			checkout_index_flag.Usage()
+			return
+		}
+
+		checkout_index_cmd(*all, *force, *prefix, checkout_index_flag.Args())
+	case "check-ignore":
+		verbose := check_ignore_flag.Bool("v", false, "Instead of printing the paths that are excluded, for each path that matches an exclude pattern, print the exclude pattern together with the path.")
+		no_index := check_ignore_flag.Bool("no-index", false, "Don't look in the index when undertaking the checks.")
+		stdin := check_ignore_flag.Bool("stdin", false, "Read pathnames from the standard input, one per line, instead of from the command-line.")
+		check_ignore_flag.Parse(os.Args[2:])
+
+		if *stdin == true && len(check_ignore_flag.Args()) > 0 {
+			fmt.Fprintf(os.Stderr, "fatal: cannot specify pathnames with --stdin\n")
+			os.Exit(128)
+		}
+
+		if *stdin == false && len(check_ignore_flag.Args()) < 1 {
+			fmt.Fprintf(os.Stderr, "fatal: no path specified\n")
+			os.Exit(128)
+		}
+
+		check_ignore_cmd(*verbose, *no_index, *stdin, check_ignore_flag.Args())
+	case "add":
+		var opts AddOptions
+		add_flag.BoolVar(&opts.All, "A", false, "Update the index not only where the working tree has a file matching <pathspec> but also where the index already has an entry.")
+		add_flag.BoolVar(&opts.All, "all", false, "Same as -A.")
+		add_flag.BoolVar(&opts.Update, "u", false, "Update the index just where it already has an entry matching <pathspec>. This removes as well as modifies index entries, but adds no new files.")
+		add_flag.BoolVar(&opts.Update, "update", false, "Same as -u.")
+		add_flag.BoolVar(&opts.DryRun, "n", false, "Don't actually add the file(s), just show if they exist and/or will be ignored.")
+		add_flag.BoolVar(&opts.DryRun, "dry-run", false, "Same as -n.")
+		add_flag.BoolVar(&opts.Verbose, "v", false, "Be verbose.")
+		add_flag.BoolVar(&opts.Verbose, "verbose", false, "Same as -v.")
+		add_flag.BoolVar(&opts.Force, "f", false, "Allow adding otherwise ignored files.")
+		add_flag.BoolVar(&opts.Force, "force", false, "Same as -f.")
+		add_flag.BoolVar(&opts.IntentToAdd, "N", false, "Record only the fact that the path will be added later. An entry for the path is placed in the index with no content.")
+		add_flag.BoolVar(&opts.IntentToAdd, "intent-to-add", false, "Same as -N.")
+		add_flag.Parse(os.Args[2:])
+
+		if opts.All && opts.Update {
+			fmt.Fprintf(os.Stderr, "fatal: options '-A' and '-u' cannot be used together\n")
+			os.Exit(128)
+		}
+
+		add_cmd(opts, add_flag.Args())
+	case "ls-tree":
+		var opts LsTreeOptions
+		ls_tree_flag.BoolVar(&opts.OnlyTrees, "d", false, "Show only the named tree entry itself, not its children.")
+		ls_tree_flag.BoolVar(&opts.Recurse, "r", false, "Recurse into sub-trees.")
+		ls_tree_flag.BoolVar(&opts.ShowTrees, "t", false, "Show tree entries even when going to recurse them. Has no effect if -r was not passed. -d implies -t.")
+		ls_tree_flag.BoolVar(&opts.Long, "l", false, "Show object size of blob (file) entries.")
+		ls_tree_flag.BoolVar(&opts.Long, "long", false, "Same as -l.")
+		ls_tree_flag.BoolVar(&opts.NameOnly, "name-only", false, "List only filenames, one per line.")
+		ls_tree_flag.BoolVar(&opts.NulTerminated, "z", false, "\\0 line termination on output and do not quote filenames.")
+		ls_tree_flag.Parse(os.Args[2:])
+
+		if len(ls_tree_flag.Args()) < 1 {
+			ls_tree_flag.Usage()
+			return
+		}
+
+		ls_tree_cmd(opts, ls_tree_flag.Args()[0], ls_tree_flag.Args()[1:])
+	case "submodule":
+		// 'status' is the default subcommand
+		args := os.Args[2:]
+		if len(args) > 0 && args[0] == "status" {
+			args = args[1:]
+		} else if len(args) > 0 && strings.HasPrefix(args[0], "-") == false {
+			fmt.Fprintf(os.Stderr, "toy-git submodule status [--cached] [<path>...]\n")
+			os.Exit(1)
+		}
+		cached := submodule_flag.Bool("cached", false, "Use the commit stored in the index instead of the commit stored in the submodule HEAD.")
+		submodule_flag.Parse(args)
+
+		submodule_status_cmd(*cached, submodule_flag.Args())
+	case "status":
+		var opts StatusOptions
+		short := false
+		porcelain := optional_string{def: "v1"}
+		untracked := optional_string{def: "all"}
+		status_flag.BoolVar(&short, "s", false, "Give the output in the short-format.")
+		status_flag.BoolVar(&short, "short", false, "Same as -s.")
+		status_flag.Var(&porcelain, "porcelain", "Give the output in an easy-to-parse format for scripts. (v1 or v2)")
+		status_flag.BoolVar(&opts.Branch, "b", false, "Show the branch and tracking info even in short-format.")
+		status_flag.BoolVar(&opts.Branch, "branch", false, "Same as -b.")
+		status_flag.BoolVar(&opts.NulTerminated, "z", false, "Terminate entries with NUL, instead of LF. This implies --porcelain=v1 if no other format is given.")
+		status_flag.Var(&untracked, "u", "Show untracked files. (no, normal or all)")
+		status_flag.Var(&untracked, "untracked-files", "Same as -u.")
+		// '-u<mode>' is written without '=' in git
+		status_flag.Parse(split_short_option_values(status_flag, os.Args[2:], "u"))
+
+		switch {
+		case porcelain.value == "v1":
+			opts.Format = STATUS_FORMAT_PORCELAIN
+		case porcelain.value == "v2":
+			opts.Format = STATUS_FORMAT_PORCELAIN_V2
+		case len(porcelain.value) > 0:
+			fmt.Fprintf(os.Stderr, "fatal: unsupported porcelain version '%s'\n", porcelain.value)
+			os.Exit(128)
+		case short:
+			opts.Format = STATUS_FORMAT_SHORT
+		case opts.NulTerminated:
+			opts.Format = STATUS_FORMAT_PORCELAIN
+		default:
+			opts.Format = STATUS_FORMAT_LONG
+		}
+		opts.UntrackedFiles = untracked.value
+
+		status_cmd(opts, status_flag.Args())
+	case "diff-files":
+		diff_options := add_diff_flags(diff_files_flag)
+		diff_files_flag.Parse(split_short_option_values(diff_files_flag, os.Args[2:], "UMC"))
+
+		diff_files_cmd(diff_options(), diff_files_flag.Args())
+	case "diff-index":
+		diff_options := add_diff_flags(diff_index_flag)
+		cached := diff_index_flag.Bool("cached", false, "Do not consider the on-disk file at all.")
+		diff_index_flag.Parse(split_short_option_values(diff_index_flag, os.Args[2:], "UMC"))
+
+		if len(diff_index_flag.Args()) < 1 {
+			fmt.Fprintf(os.Stderr, "usage: toy-git diff-index [-m] [--cached] [<common-diff-options>] <tree-ish> [<path>...]\n")
+			os.Exit(129)
+		}
+
+		diff_index_cmd(diff_options(), *cached, diff_index_flag.Args()[0], diff_index_flag.Args()[1:])
+	case "diff-tree":
+		diff_options := add_diff_flags(diff_tree_flag)
+		recursive := diff_tree_flag.Bool("r", false, "Recurse into sub-trees.")
+		show_trees := diff_tree_flag.Bool("t", false, "Show tree entry itself as well as subtrees. Implies -r.")
+		diff_tree_flag.Parse(split_short_option_values(diff_tree_flag, os.Args[2:], "UMC"))
+
+		if len(diff_tree_flag.Args()) < 1 {
+			fmt.Fprintf(os.Stderr, "usage: toy-git diff-tree [<options>] <tree-ish> [<tree-ish>] [<path>...]\n")
+			os.Exit(129)
+		}
+
+		opts := diff_options()
+		opts.Recursive = *recursive || *show_trees
+		opts.ShowTrees = *show_trees
+		diff_tree_cmd(opts, diff_tree_flag.Args())
+	case "apply":
+		var opts ApplyOptions
+		apply_flag.BoolVar(&opts.Check, "check", false, "Instead of applying the patch, see if the patch is applicable.")
+		apply_flag.BoolVar(&opts.Index, "index", false, "Apply the patch to both the index and the working tree.")
+		apply_flag.BoolVar(&opts.Cached, "cached", false, "Apply the patch just to the index, without touching the working tree.")
+		apply_flag.BoolVar(&opts.ThreeWay, "3", false, "Attempt 3-way merge if the patch records the identity of blobs it is supposed to apply to. Implies --index.")
+		apply_flag.BoolVar(&opts.ThreeWay, "3way", false, "Same as -3.")
+		apply_flag.BoolVar(&opts.Reverse, "R", false, "Apply the patch in reverse.")
+		apply_flag.BoolVar(&opts.Reverse, "reverse", false, "Same as -R.")
+		apply_flag.BoolVar(&opts.Verbose, "v", false, "Report progress to stderr.")
+		apply_flag.BoolVar(&opts.Verbose, "verbose", false, "Same as -v.")
+		apply_flag.IntVar(&opts.PValue, "p", -1, "Remove <n> leading path components from traditional diff paths. (default 1)")
+		apply_flag.IntVar(&opts.Context, "C", -1, "Ensure at least <n> lines of surrounding context match before and after each change.")
+		apply_flag.Parse(split_short_option_values(apply_flag, os.Args[2:], "pC"))
+		apply_cmd(opts, apply_flag.Args())
 	default:
 		flag.Usage()
 	}
 }
 
+// add_diff_flags registers the options common to the diff commands.
+// the returned function builds the options after the flags are parsed.
+func add_diff_flags(fs *flag.FlagSet) func() DiffOptions {
+	patch := false
+	raw := false
+	name_status := false
+	name_only := false
+	stat := false
+	context := -1
+	patience := false
+	histogram := false
+	minimal := false
+	algorithm := ""
+	indent_heuristic := false
+	no_indent_heuristic := false
+	binary := false
+	find_renames := optional_string{def: "0"}
+	find_copies := optional_string{def: "0"}
+	fs.BoolVar(&patch, "p", false, "Generate patch.")
+	fs.BoolVar(&patch, "u", false, "Same as -p.")
+	fs.BoolVar(&patch, "patch", false, "Same as -p.")
+	fs.BoolVar(&raw, "raw", false, "Generate the diff in raw format. This is the default.")
+	fs.BoolVar(&name_status, "name-status", false, "Show only names and status of changed files.")
+	fs.BoolVar(&name_only, "name-only", false, "Show only names of changed files.")
+	fs.BoolVar(&stat, "stat", false, "Generate a diffstat.")
+	fs.IntVar(&context, "U", -1, "Generate diffs with <n> lines of context instead of the usual three. Implies -p.")
+	fs.IntVar(&context, "unified", -1, "Same as -U.")
+	fs.BoolVar(&minimal, "minimal", false, "Spend extra time to make sure the smallest possible diff is produced.")
+	fs.BoolVar(&patience, "patience", false, "Generate a diff using the \"patience diff\" algorithm.")
+	fs.BoolVar(&histogram, "histogram", false, "Generate a diff using the \"histogram diff\" algorithm.")
+	fs.StringVar(&algorithm, "diff-algorithm", "", "Choose a diff algorithm. (default, myers, minimal, patience or histogram)")
+	fs.BoolVar(&indent_heuristic, "indent-heuristic", false, "Enable the heuristic that shifts diff hunk boundaries to make patches easier to read. This is the default.")
+	fs.BoolVar(&no_indent_heuristic, "no-indent-heuristic", false, "Disable the indent heuristic.")
+	fs.BoolVar(&binary, "binary", false, "Output a binary diff that can be applied with git-apply. Implies --patch.")
+	fs.Var(&find_renames, "M", "Detect renames. If n is specified, it is a threshold on the similarity index. (-M<n>)")
+	fs.Var(&find_renames, "find-renames", "Same as -M.")
+	fs.Var(&find_copies, "C", "Detect copies as well as renames. (-C<n>)")
+	fs.Var(&find_copies, "find-copies", "Same as -C.")
+
+	return func() DiffOptions {
+		opts := DiffOptions{Context: context, Binary: binary}
+		if patch || context >= 0 || binary {
+			opts.Format |= DIFF_FORMAT_PATCH
+		}
+		if raw {
+			opts.Format |= DIFF_FORMAT_RAW
+		}
+		if name_status {
+			opts.Format |= DIFF_FORMAT_NAME_STATUS
+		}
+		if name_only {
+			opts.Format |= DIFF_FORMAT_NAME
+		}
+		if stat {
+			opts.Format |= DIFF_FORMAT_DIFFSTAT
+		}
+
+		switch {
+		case len(algorithm) > 0:
+			a, err := parse_diff_algorithm(algorithm)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(129)
+			}
+			opts.Xdiff.Algorithm = a
+		case histogram:
+			opts.Xdiff.Algorithm = DIFF_ALGORITHM_HISTOGRAM
+		case patience:
+			opts.Xdiff.Algorithm = DIFF_ALGORITHM_PATIENCE
+		case minimal:
+			opts.Xdiff.Algorithm = DIFF_ALGORITHM_MINIMAL
+		}
+
+		if no_indent_heuristic {
+			opts.IndentHeuristic = "off"
+		} else if indent_heuristic {
+			opts.IndentHeuristic = "on"
+		}
+
+		if len(find_copies.value) > 0 {
+			opts.DetectRename = DIFF_DETECT_COPY
+			opts.RenameScore = rename_score_flag("find-copies", find_copies.value)
+		} else if len(find_renames.value) > 0 {
+			opts.DetectRename = DIFF_DETECT_RENAME
+			opts.RenameScore = rename_score_flag("find-renames", find_renames.value)
+		}
+		return opts
+	}
+}
+
+// rename_score_flag returns the similarity given to -M or -C. it exits if the value is not a score.
+func rename_score_flag(name string, v string) int {
+	score, rest := parse_rename_score(v)
+	if len(rest) > 0 {
+		fmt.Fprintf(os.Stderr, "error: invalid argument to %s\n", name)
+		os.Exit(129)
+	}
+	return score
+}
+
+// split_short_option_values rewrites '-<c><value>' to '-<c>=<value>' for the one letter options
+// whose values are written without '=' in git. ('-U5' or '-uall') options defined in fs are kept.
+func split_short_option_values(fs *flag.FlagSet, args []string, letters string) []string {
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		if len(a) > 2 && a[0] == '-' && strings.IndexByte(letters, a[1]) >= 0 && a[2] != '=' && fs.Lookup(strings.SplitN(a[1:], "=", 2)[0]) == nil {
+			args[i] = a[:2] + "=" + a[2:]
+		}
+	}
+	return args
+}
+
+// string_list is a flag value which can be specified multiple times.
+type string_list []string
+
+func (l *string_list) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *string_list) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// optional_string is a string option whose value can be omitted. ('--porcelain' or '--porcelain=v2')
+type optional_string struct {
+	value string
+	def   string // the value if omitted
+}
+
+func (s *optional_string) String() string {
+	return s.value
+}
+
+func (s *optional_string) Set(v string) error {
+	if v == "true" {
+		v = s.def
+	}
+	s.value = v
+	return nil
+}
+
+// IsBoolFlag makes the flag package accept the option without a value.
+func (s *optional_string) IsBoolFlag() bool {
+	return true
+}
+
 func find_git_repository(path string) (string, error) {
+	// walk up from the absolute path. filepath.Dir(".") is "."
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
 	repo_path := filepath.Join(path, REPOSITORY_DIR_NAME)
 	if _, err := os.Stat(repo_path); os.IsNotExist(err) {
 		if path == filepath.Dir(path) {
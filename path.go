@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// setup_git_directory finds the repository and moves to the top of the work tree as git does.
+// It returns the repository and the original current directory relative to the work tree ("" or "dir/").
+// paths given by user must be normalized with the prefix.
+func setup_git_directory() (string, string, error) {
+	repop, err := find_git_repository(".")
+	if err != nil {
+		return "", "", err
+	}
+	prefix, err := work_tree_prefix(repop)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.Chdir(filepath.Dir(repop)); err != nil {
+		return "", "", err
+	}
+	return repop, prefix, nil
+}
+
+// work_tree_prefix returns the current directory relative to the work tree ("" or "dir/").
+func work_tree_prefix(repop string) (string, error) {
+	root, err := filepath.EvalSymlinks(filepath.Dir(repop))
+	if err != nil {
+		return "", err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	cwd, err = filepath.EvalSymlinks(cwd)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, cwd)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return "", nil
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%s is outside of the work tree", cwd)
+	}
+	return filepath.ToSlash(rel) + "/", nil
+}
+
+// normalize_path converts a path given by user to the path relative to the work tree.
+// relative paths are joined with prefix. '.', '..' and absolute paths are resolved.
+// a trailing slash is kept to tell directories.
+func normalize_path(root string, prefix string, p string) (string, error) {
+	orig := p
+	dir_only := strings.HasSuffix(p, "/")
+
+	if filepath.IsAbs(p) {
+		// symbolic links are resolved only when the path is not in the work tree literally
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return "", err
+		}
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			real_root, err := filepath.EvalSymlinks(root)
+			if err != nil {
+				return "", err
+			}
+			rel, err = filepath.Rel(real_root, eval_symlinks(p))
+			if err != nil {
+				return "", err
+			}
+		}
+		p = filepath.ToSlash(rel)
+	} else {
+		p = prefix + p
+	}
+
+	if len(p) == 0 {
+		return "", nil
+	}
+	p = path.Clean(p)
+	if p == ".." || strings.HasPrefix(p, "../") || strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("'%s' is outside repository at '%s'", orig, root)
+	}
+	if p == "." {
+		return "", nil
+	}
+	if dir_only {
+		p += "/"
+	}
+	return p, nil
+}
+
+// eval_symlinks resolves symbolic links in the leading directories of the absolute path.
+// the path itself may not exist.
+func eval_symlinks(p string) string {
+	p = filepath.Clean(p)
+	if r, err := filepath.EvalSymlinks(p); err == nil {
+		return r
+	}
+	dir := filepath.Dir(p)
+	if dir == p {
+		return p
+	}
+	return filepath.Join(eval_symlinks(dir), filepath.Base(p))
+}
+
+// relative_path returns the path relative to the work tree as the path relative to the current directory.
+// prefix is the current directory relative to the work tree ("" or "dir/").
+func relative_path(prefix string, p string) string {
+	if strings.HasPrefix(p, prefix) {
+		if len(p) == len(prefix) {
+			return "./"
+		}
+		return p[len(prefix):]
+	}
+
+	// strip common leading directories and go up for the rest of prefix
+	common := 0
+	for i := 0; i < len(prefix) && i < len(p) && prefix[i] == p[i]; i++ {
+		if prefix[i] == '/' {
+			common = i + 1
+		}
+	}
+	up := strings.Count(prefix[common:], "/")
+	return strings.Repeat("../", up) + p[common:]
+}
@@ -0,0 +1,260 @@
+// See Also:
+// https://git-scm.com/docs/gitglossary#Documentation/gitglossary.txt-aiddefpathspecapathspec
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	PATHSPEC_TOP     = 1 << iota // ':(top)' or ':/' matches from the root of the work tree
+	PATHSPEC_LITERAL             // ':(literal)' wildcards are treated as literal characters
+	PATHSPEC_GLOB                // ':(glob)' wildcards do not match '/' except '**'
+	PATHSPEC_ICASE               // ':(icase)' case insensitive match
+	PATHSPEC_EXCLUDE             // ':(exclude)', ':!' or ':^' paths matched are excluded
+)
+
+type PathspecItem struct {
+	Match    string // pattern relative to the work tree
+	Original string // pathspec given by user
+	Magic    int
+	Wildcard bool // pattern has wildcards
+}
+
+type Pathspec struct {
+	Items    []*PathspecItem
+	excludes []*PathspecItem // exclude items of Items
+}
+
+// parse_pathspec parses pathspecs given by user.
+// root is the work tree and prefix is the current directory relative to it ("" or "dir/").
+func parse_pathspec(root string, prefix string, args []string) (*Pathspec, error) {
+	ps := &Pathspec{}
+	only_exclude := len(args) > 0
+	for _, arg := range args {
+		item, err := parse_pathspec_item(root, prefix, arg)
+		if err != nil {
+			return nil, err
+		}
+		if item.Magic&PATHSPEC_EXCLUDE == 0 {
+			only_exclude = false
+		} else {
+			ps.excludes = append(ps.excludes, item)
+		}
+		ps.Items = append(ps.Items, item)
+	}
+
+	// exclude only pathspecs are applied to the current directory
+	if only_exclude {
+		item, err := parse_pathspec_item(root, prefix, ".")
+		if err != nil {
+			return nil, err
+		}
+		ps.Items = append(ps.Items, item)
+	}
+	return ps, nil
+}
+
+func parse_pathspec_item(root string, prefix string, arg string) (*PathspecItem, error) {
+	item := &PathspecItem{}
+	item.Original = arg
+
+	p := arg
+	if strings.HasPrefix(p, ":(") {
+		// long form. ':(top,icase)pattern'
+		end := strings.Index(p, ")")
+		if end < 0 {
+			return nil, fmt.Errorf("Missing ')' at the end of pathspec magic in '%s'", arg)
+		}
+		for _, m := range strings.Split(p[2:end], ",") {
+			switch strings.TrimSpace(m) {
+			case "top":
+				item.Magic |= PATHSPEC_TOP
+			case "literal":
+				item.Magic |= PATHSPEC_LITERAL
+			case "glob":
+				item.Magic |= PATHSPEC_GLOB
+			case "icase":
+				item.Magic |= PATHSPEC_ICASE
+			case "exclude":
+				item.Magic |= PATHSPEC_EXCLUDE
+			case "":
+			default:
+				return nil, fmt.Errorf("Invalid pathspec magic '%s' in '%s'", m, arg)
+			}
+		}
+		p = p[end+1:]
+	} else if strings.HasPrefix(p, ":") {
+		// short form. ':/pattern', ':!pattern' or ':^pattern'. an optional ':' ends the magic
+		i := 1
+	short_magic:
+		for ; i < len(p); i++ {
+			switch p[i] {
+			case '/':
+				item.Magic |= PATHSPEC_TOP
+			case '!', '^':
+				item.Magic |= PATHSPEC_EXCLUDE
+			case ':':
+				i++
+				break short_magic
+			default:
+				break short_magic
+			}
+		}
+		p = p[i:]
+	}
+
+	if item.Magic&PATHSPEC_LITERAL != 0 && item.Magic&PATHSPEC_GLOB != 0 {
+		return nil, fmt.Errorf("'literal' and 'glob' are incompatible")
+	}
+
+	if item.Magic&PATHSPEC_TOP != 0 {
+		prefix = ""
+	}
+	m, err := normalize_path(root, prefix, p)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", arg, err)
+	}
+	item.Match = m
+	if item.Magic&PATHSPEC_LITERAL == 0 {
+		item.Wildcard = strings.ContainsAny(m, "*?[\\")
+	}
+	return item, nil
+}
+
+// match reports whether the path is matched by the pathspec.
+// a path is matched when one of the items matches it and no exclude items match it.
+// all paths are matched if the pathspec is empty.
+func (ps *Pathspec) match(path string) bool {
+	if ps == nil || len(ps.Items) == 0 {
+		return true
+	}
+
+	matched := false
+	for _, item := range ps.Items {
+		if item.Magic&PATHSPEC_EXCLUDE != 0 {
+			continue
+		}
+		if item.match(path) {
+			matched = true
+			break
+		}
+	}
+	return matched && ps.excluded(path) == false
+}
+
+// excluded reports whether the path is matched by one of the exclude items.
+func (ps *Pathspec) excluded(path string) bool {
+	for _, item := range ps.excludes {
+		if item.match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (item *PathspecItem) match(path string) bool {
+	m := item.Match
+	has_prefix := strings.HasPrefix
+	equal := func(a, b string) bool { return a == b }
+	flags := 0
+	if item.Magic&PATHSPEC_ICASE != 0 {
+		has_prefix = func(s, prefix string) bool {
+			return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+		}
+		equal = strings.EqualFold
+		flags |= WM_CASEFOLD
+	}
+
+	if len(m) == 0 || equal(m, path) {
+		return true
+	}
+
+	// leading directory
+	if strings.HasSuffix(m, "/") {
+		if has_prefix(path, m) {
+			return true
+		}
+	} else if has_prefix(path, m+"/") {
+		return true
+	}
+
+	if item.Wildcard == false {
+		return false
+	}
+	// '*' matches '/' unless ':(glob)' is given
+	if item.Magic&PATHSPEC_GLOB != 0 {
+		flags |= WM_PATHNAME
+	}
+	return wildmatch(m, path, flags)
+}
+
+// match_leading reports whether some paths under the directory may be matched by the pathspec.
+// it is used to decide whether to read a sub tree.
+func (ps *Pathspec) match_leading(dir string) bool {
+	if ps == nil || len(ps.Items) == 0 {
+		return true
+	}
+	if ps.excluded(dir) {
+		return false
+	}
+
+	for _, item := range ps.Items {
+		if item.Magic&PATHSPEC_EXCLUDE != 0 {
+			continue
+		}
+		if item.Wildcard || item.Magic&PATHSPEC_ICASE != 0 || item.match(dir) || strings.HasPrefix(item.Match, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// expand resolves the pathspec to paths relative to the work tree for commands which take file names.
+// a plain file name is kept as it is even if it is not in the index.
+// other items (wildcards, directories and ':(icase)') are expanded to the matching entries in the index.
+// a wildcard item which matches no entries is taken as a file name.
+func (ps *Pathspec) expand(d *Dircache) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(p string) {
+		if seen[p] == false {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	for _, item := range ps.Items {
+		if item.Magic&PATHSPEC_EXCLUDE != 0 {
+			continue
+		}
+
+		if item.is_plain() {
+			if ps.excluded(item.Match) == false {
+				add(item.Match)
+			}
+			continue
+		}
+
+		matched := false
+		for _, e := range d.Entries {
+			p := string(e.PathName)
+			if item.match(p) && ps.excluded(p) == false {
+				add(p)
+				matched = true
+			}
+		}
+		// a new file may have wildcard characters in its name
+		if matched == false && item.Wildcard && strings.HasSuffix(item.Match, "/") == false && ps.excluded(item.Match) == false {
+			add(item.Match)
+		}
+	}
+	return paths
+}
+
+// is_plain reports whether the item names a single file.
+func (item *PathspecItem) is_plain() bool {
+	return item.Wildcard == false && item.Magic&PATHSPEC_ICASE == 0 &&
+		len(item.Match) > 0 && strings.HasSuffix(item.Match, "/") == false
+}
@@ -0,0 +1,128 @@
+// See Also:
+// https://github.com/git/git/blob/master/preload-index.c
+package main
+
+import (
+	"os"
+	"runtime"
+	"sync"
+)
+
+// preload_workers returns the number of goroutines to check n files.
+// files are checked one by one with core.preloadIndex=false.
+func preload_workers(d *Dircache, n int) int {
+	workers := 1
+	if d.preload_index {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > n {
+		workers = n
+	}
+	return workers
+}
+
+// run_workers calls f(i) for 0 <= i < n on the workers.
+// f stores its result by i, so that the results are used in order after all calls return.
+func run_workers(workers int, n int, f func(i int)) {
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+
+	jobs := make(chan int, n)
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// WorktreeFile is the stat data and the object name of a file to be added to the index.
+type WorktreeFile struct {
+	info     os.FileInfo
+	err      error  // error of lstat
+	sha      []byte // nil if the file is not hashed
+	hash_err error
+}
+
+// stat_worktree_file stats and hashes the file as update_dircache does.
+// the file is not hashed if update_dircache fails before hashing.
+func stat_worktree_file(d *Dircache, path string, do_add bool, info_only bool) *WorktreeFile {
+	wf := &WorktreeFile{}
+	wf.info, wf.err = os.Lstat(path)
+	if wf.err != nil {
+		return wf
+	}
+	if wf.info.IsDir() && nested_repository(path) == "" {
+		return wf
+	}
+	if find_dircache_entry(d, path) < 0 && do_add == false {
+		return wf
+	}
+	wf.sha, wf.hash_err = hash_worktree_file(path, wf.info, info_only == false)
+	return wf
+}
+
+// preload_worktree_files stats and hashes the files concurrently before they are added by update_dircache.
+// the index must not be changed until the files are added.
+func preload_worktree_files(d *Dircache, paths []string, do_add bool, info_only bool) {
+	files := make([]*WorktreeFile, len(paths))
+	run_workers(preload_workers(d, len(paths)), len(paths), func(i int) {
+		files[i] = stat_worktree_file(d, paths[i], do_add, info_only)
+	})
+
+	if d.preloaded == nil {
+		d.preloaded = make(map[string]*WorktreeFile)
+	}
+	for i, p := range paths {
+		d.preloaded[p] = files[i]
+	}
+}
+
+// WorktreeStatus is whether the file of an entry is deleted or modified.
+type WorktreeStatus struct {
+	deleted  bool
+	modified bool
+	info     os.FileInfo // stat data of the file. nil if the file is not checked or deleted
+	err      error
+}
+
+// preload_worktree_status checks the files of the entries concurrently.
+// the result of d.Entries[i] is the i-th element. entries for which skip returns true are not checked.
+func preload_worktree_status(d *Dircache, check_deleted bool, check_modified bool, skip func(e *DircacheEntry) bool) []WorktreeStatus {
+	status := make([]WorktreeStatus, len(d.Entries))
+	run_workers(preload_workers(d, len(d.Entries)), len(d.Entries), func(i int) {
+		e := d.Entries[i]
+		if skip(e) {
+			return
+		}
+		s := &status[i]
+		if check_deleted {
+			if s.deleted, s.err = is_deleted(string(e.PathName)); s.err != nil {
+				return
+			}
+		}
+		if check_modified {
+			if s.modified, s.err = is_modified(d, e); s.err != nil {
+				return
+			}
+			if info, err := os.Lstat(string(e.PathName)); err == nil {
+				s.info = info
+			}
+		}
+	})
+	return status
+}
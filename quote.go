@@ -0,0 +1,111 @@
+// See Also:
+// https://github.com/git/git/blob/master/quote.c
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// quote_c_style quotes the path in double quotes if it contains special characters, as git does.
+// control characters, '"' and '\' are escaped. bytes over 0x7f are escaped unless quote_path is false. (core.quotePath)
+// with quote_sp, paths which contain spaces are quoted as well.
+func quote_c_style(p string, quote_path bool, quote_sp bool) string {
+	needs := false
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+			needs = true
+		case c == '\a':
+			b.WriteString(`\a`)
+			needs = true
+		case c == '\b':
+			b.WriteString(`\b`)
+			needs = true
+		case c == '\t':
+			b.WriteString(`\t`)
+			needs = true
+		case c == '\n':
+			b.WriteString(`\n`)
+			needs = true
+		case c == '\v':
+			b.WriteString(`\v`)
+			needs = true
+		case c == '\f':
+			b.WriteString(`\f`)
+			needs = true
+		case c == '\r':
+			b.WriteString(`\r`)
+			needs = true
+		case c < 0x20 || c == 0x7f || (c > 0x7f && quote_path):
+			fmt.Fprintf(&b, "\\%03o", c)
+			needs = true
+		case c == ' ' && quote_sp:
+			b.WriteByte(c)
+			needs = true
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if needs == false {
+		return p
+	}
+	return `"` + b.String() + `"`
+}
+
+// unquote_c_style unquotes the path quoted by quote_c_style at the beginning of b.
+// It returns the path and the length of the quoted path. false is returned if b is not quoted correctly.
+func unquote_c_style(b []byte) (string, int, bool) {
+	if len(b) == 0 || b[0] != '"' {
+		return "", 0, false
+	}
+	var sb strings.Builder
+	for i := 1; i < len(b); i++ {
+		c := b[i]
+		switch c {
+		case '"':
+			return sb.String(), i + 1, true
+		case '\\':
+		default:
+			sb.WriteByte(c)
+			continue
+		}
+
+		i++
+		if i >= len(b) {
+			return "", 0, false
+		}
+		switch c = b[i]; c {
+		case 'a':
+			c = '\a'
+		case 'b':
+			c = '\b'
+		case 'f':
+			c = '\f'
+		case 'n':
+			c = '\n'
+		case 'r':
+			c = '\r'
+		case 't':
+			c = '\t'
+		case 'v':
+			c = '\v'
+		case '\\', '"':
+		case '0', '1', '2', '3':
+			// octal values with first digit over 4 overflow
+			if i+2 >= len(b) || b[i+1] < '0' || '7' < b[i+1] || b[i+2] < '0' || '7' < b[i+2] {
+				return "", 0, false
+			}
+			c = (c-'0')<<6 | (b[i+1]-'0')<<3 | (b[i+2] - '0')
+			i += 2
+		default:
+			return "", 0, false
+		}
+		sb.WriteByte(c)
+	}
+	return "", 0, false
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func read_tree_cmd(prefix string, empty bool, merge bool, tree_ishes []string) {
+	repop, err := find_git_repository(".")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
+		exit(128)
+	}
+
+	// lock and read dircache
+	d, err := lock_dircache(repop)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		exit(128)
+	}
+
+	if len(prefix) > 0 && strings.HasSuffix(prefix, "/") == false {
+		prefix += "/"
+	}
+
+	// read trees
+	var trees [][]*DircacheEntry
+	for _, name := range tree_ishes {
+		sha, err := resolve_tree_ish(repop, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: failed to unpack tree object %s\n", name)
+			exit(128)
+		}
+
+		entries, err := read_tree_dircache_entries(repop, sha, prefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+			exit(128)
+		}
+		trees = append(trees, entries)
+	}
+
+	if merge {
+		for _, e := range d.Entries {
+			if e.stage() != 0 {
+				fmt.Fprintf(os.Stderr, "%s: needs merge\n", string(e.PathName))
+				fmt.Fprintf(os.Stderr, "fatal: you need to resolve your current index first\n")
+				exit(128)
+			}
+		}
+	}
+
+	switch {
+	case empty:
+		d.Entries = nil
+	case merge && len(trees) == 1:
+		d.Entries = one_way_merge(d.Entries, trees[0])
+	case merge && len(trees) == 2:
+		d.Entries, err = two_way_merge(d.Entries, trees[0], trees[1])
+	case merge && len(trees) == 3:
+		d.Entries, err = three_way_merge(d.Entries, trees[0], trees[1], trees[2])
+	case len(prefix) > 0:
+		for _, e := range d.Entries {
+			p := string(e.PathName)
+			if strings.HasPrefix(p, prefix) || p == strings.TrimSuffix(prefix, "/") {
+				fmt.Fprintf(os.Stderr, "fatal: subdirectory '%s' already exists.\n", prefix)
+				exit(128)
+			}
+		}
+		d.Entries = append(d.Entries, trees[0]...)
+	default:
+		// later trees overlay earlier trees
+		d.Entries = nil
+		for _, t := range trees {
+			d.Entries = merge_entries_by_path(d.Entries, t)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "fatal: Cannot merge.\n")
+		exit(128)
+	}
+
+	// tracked files are replaced. the untracked cache is rebuilt by the next scan
+	if d.untracked != nil {
+		d.untracked.Root = nil
+	}
+
+	// write dircache
+	err = write_dircache(d, repop)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+		exit(128)
+	}
+}
+
+// resolve_tree_ish returns the tree object name which <name> points to.
+// commits and tags are peeled to their tree.
+func resolve_tree_ish(repop string, name string) (string, error) {
+	sha, err := resolve_object_name(repop, name)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		t, b, err := read_object_file(repop, sha)
+		if err != nil {
+			return "", err
+		}
+
+		switch t {
+		case "tree":
+			return sha, nil
+		case "commit":
+			// first line is 'tree <sha>'
+			if bytes.HasPrefix(b, []byte("tree ")) == false || len(b) < 45 {
+				return "", fmt.Errorf("%s: bad commit object", sha)
+			}
+			sha = string(b[5:45])
+		case "tag":
+			// first line is 'object <sha>'
+			if bytes.HasPrefix(b, []byte("object ")) == false || len(b) < 47 {
+				return "", fmt.Errorf("%s: bad tag object", sha)
+			}
+			sha = string(b[7:47])
+		default:
+			return "", fmt.Errorf("%s is not a tree-ish", name)
+		}
+	}
+}
+
+// read_tree_entries parses a tree object into its records.
+// sub trees are returned as entries with mode 040000.
+func read_tree_entries(repop string, sha string) ([]*FileEntry, error) {
+	t, b, err := read_object_file(repop, sha)
+	if err != nil {
+		return nil, err
+	}
+	if t != "tree" {
+		return nil, fmt.Errorf("%s is not a tree object", sha)
+	}
+
+	var entries []*FileEntry
+	for len(b) > 0 {
+		// '<mode> <name>\x00<20 bytes sha1>'
+		sp := bytes.IndexByte(b, ' ')
+		nul := bytes.IndexByte(b, 0)
+		if sp < 0 || nul < sp || len(b) < nul+21 {
+			return nil, fmt.Errorf("%s: corrupt tree object", sha)
+		}
+
+		mode, err := strconv.ParseUint(string(b[:sp]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: corrupt tree object", sha)
+		}
+
+		fe := &FileEntry{}
+		fe.Mode = uint32(mode)
+		fe.Name = string(b[sp+1 : nul])
+		copy(fe.Hash[:], b[nul+1:nul+21])
+		entries = append(entries, fe)
+
+		b = b[nul+21:]
+	}
+	return entries, nil
+}
+
+func read_tree_dircache_entries(repop string, sha string, base string) ([]*DircacheEntry, error) {
+	records, err := read_tree_entries(repop, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*DircacheEntry
+	for _, r := range records {
+		if r.Mode == 040000 {
+			sub, err := read_tree_dircache_entries(repop, fmt.Sprintf("%x", r.Hash), base+r.Name+"/")
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, sub...)
+			continue
+		}
+		entries = append(entries, new_dircache_entry(base+r.Name, r.Hash, r.Mode, 0))
+	}
+	return entries, nil
+}
+
+// merge_entries_by_path replaces entries in 'base' by entries in 'over' which have the same path.
+func merge_entries_by_path(base []*DircacheEntry, over []*DircacheEntry) []*DircacheEntry {
+	paths := make(map[string]bool)
+	for _, e := range over {
+		paths[string(e.PathName)] = true
+	}
+
+	var entries []*DircacheEntry
+	for _, e := range base {
+		if paths[string(e.PathName)] == false {
+			entries = append(entries, e)
+		}
+	}
+	return append(entries, over...)
+}
+
+func same_entry(a *DircacheEntry, b *DircacheEntry) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Mode == b.Mode && a.Sha1 == b.Sha1
+}
+
+func entries_by_path(entries []*DircacheEntry, paths *[]string, seen map[string]bool) map[string]*DircacheEntry {
+	m := make(map[string]*DircacheEntry)
+	for _, e := range entries {
+		p := string(e.PathName)
+		m[p] = e
+		if seen[p] == false {
+			seen[p] = true
+			*paths = append(*paths, p)
+		}
+	}
+	return m
+}
+
+// merged_entry keeps the index entry (and its stat data) if it has the same contents.
+func merged_entry(e *DircacheEntry, old *DircacheEntry) *DircacheEntry {
+	if same_entry(e, old) {
+		return old
+	}
+	return e
+}
+
+func staged_entry(e *DircacheEntry, stage int) *DircacheEntry {
+	return new_dircache_entry(string(e.PathName), e.Sha1, e.Mode, stage)
+}
+
+func would_be_overwritten(path string) error {
+	return fmt.Errorf("Entry '%s' would be overwritten by merge.", path)
+}
+
+// one_way_merge reads the tree, keeping stat data of unchanged index entries.
+func one_way_merge(index []*DircacheEntry, tree []*DircacheEntry) []*DircacheEntry {
+	var paths []string
+	seen := make(map[string]bool)
+	im := entries_by_path(index, &paths, seen)
+
+	var entries []*DircacheEntry
+	for _, e := range tree {
+		entries = append(entries, merged_entry(e, im[string(e.PathName)]))
+	}
+	return entries
+}
+
+// two_way_merge moves the index from tree 'head' to tree 'merge'.
+// See also 'Two Tree Merge' in git-read-tree(1).
+func two_way_merge(index []*DircacheEntry, head []*DircacheEntry, merge []*DircacheEntry) ([]*DircacheEntry, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	im := entries_by_path(index, &paths, seen)
+	hm := entries_by_path(head, &paths, seen)
+	mm := entries_by_path(merge, &paths, seen)
+
+	var entries []*DircacheEntry
+	for _, p := range paths {
+		i, h, m := im[p], hm[p], mm[p]
+
+		var result *DircacheEntry
+		switch {
+		case i == nil && h == nil:
+			result = m
+		case i == nil && same_entry(h, m):
+			result = nil
+		case i == nil && m == nil:
+			result = nil
+		case i == nil:
+			return nil, would_be_overwritten(p)
+		case same_entry(h, m) || same_entry(i, m):
+			result = i
+		case same_entry(i, h):
+			result = merged_entry(m, i)
+		default:
+			return nil, would_be_overwritten(p)
+		}
+
+		if result != nil {
+			entries = append(entries, result)
+		}
+	}
+	return entries, nil
+}
+
+// three_way_merge merges tree 'remote' into tree 'head' with the common ancestor 'base'.
+// trivial merges are resolved, the others are recorded as stage 1(base), 2(head) and 3(remote).
+// See also '3-Way Merge' in git-read-tree(1).
+func three_way_merge(index []*DircacheEntry, base []*DircacheEntry, head []*DircacheEntry, remote []*DircacheEntry) ([]*DircacheEntry, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	im := entries_by_path(index, &paths, seen)
+	om := entries_by_path(base, &paths, seen)
+	hm := entries_by_path(head, &paths, seen)
+	rm := entries_by_path(remote, &paths, seen)
+
+	var entries []*DircacheEntry
+	for _, p := range paths {
+		i, o, h, r := im[p], om[p], hm[p], rm[p]
+
+		head_match := o != nil && same_entry(o, h)
+		remote_match := o != nil && same_entry(o, r)
+
+		// changed only by remote
+		if r != nil && head_match && remote_match == false {
+			if i != nil && same_entry(i, r) == false && same_entry(i, h) == false {
+				return nil, would_be_overwritten(p)
+			}
+			entries = append(entries, merged_entry(r, i))
+			continue
+		}
+
+		if i != nil && same_entry(i, h) == false {
+			return nil, would_be_overwritten(p)
+		}
+
+		if h != nil {
+			// changed the same way by both
+			if same_entry(h, r) {
+				entries = append(entries, merged_entry(h, i))
+				continue
+			}
+			// changed only by head
+			if remote_match && head_match == false {
+				entries = append(entries, merged_entry(h, i))
+				continue
+			}
+		}
+
+		// removed by both
+		if h == nil && r == nil && o == nil {
+			continue
+		}
+
+		// conflict
+		if o != nil && (head_match == false || remote_match == false) {
+			entries = append(entries, staged_entry(o, 1))
+		}
+		if h != nil {
+			entries = append(entries, staged_entry(h, 2))
+		}
+		if r != nil {
+			entries = append(entries, staged_entry(r, 3))
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,334 @@
+// See Also:
+// https://github.com/git/git/blob/master/Documentation/technical/split-index.txt
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	SPLIT_INDEX_DEFAULT_MAX_PERCENT_CHANGE = 20                  // splitIndex.maxPercentChange
+	SHARED_INDEX_DEFAULT_EXPIRE            = 14 * 24 * time.Hour // splitIndex.sharedIndexExpire (2.weeks.ago)
+)
+
+// SplitIndex is the 'link' extension.
+// Most entries are kept in the shared index file ($GIT_DIR/sharedindex.<sha1>) and the index records the changes only.
+// The first entries of the index replace the entries of the shared index marked in Replace, in order.
+// The other entries are added to the shared index.
+type SplitIndex struct {
+	BaseSha1 [20]byte    // checksum of the shared index. all zero if the shared index is not written yet
+	Delete   *EwahBitmap // entries of the shared index to be removed
+	Replace  *EwahBitmap // entries of the shared index to be replaced
+
+	base       []*DircacheEntry // entries of the shared index as read. used to find changed entries
+	new_shared bool             // write a new shared index. (update-index --split-index)
+}
+
+func read_link_extension(data []byte) (*SplitIndex, error) {
+	if len(data) < sha1.Size {
+		return nil, fmt.Errorf("corrupt link extension (too short)")
+	}
+	s := &SplitIndex{}
+	copy(s.BaseSha1[:], data[:sha1.Size])
+
+	buf := bytes.NewReader(data[sha1.Size:])
+	if buf.Len() == 0 {
+		s.Delete = &EwahBitmap{}
+		s.Replace = &EwahBitmap{}
+		return s, nil
+	}
+	var err error
+	if s.Delete, err = read_ewah_bitmap(buf); err != nil {
+		return nil, fmt.Errorf("corrupt delete bitmap in link extension")
+	}
+	if s.Replace, err = read_ewah_bitmap(buf); err != nil {
+		return nil, fmt.Errorf("corrupt replace bitmap in link extension")
+	}
+	if buf.Len() > 0 {
+		return nil, fmt.Errorf("garbage at the end of link extension")
+	}
+	return s, nil
+}
+
+func link_extension_bytes(s *SplitIndex) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(s.BaseSha1[:])
+	buf.Write(ewah_bitmap_bytes(s.Delete))
+	buf.Write(ewah_bitmap_bytes(s.Replace))
+	return buf.Bytes()
+}
+
+func shared_index_path(repop string, sha [20]byte) string {
+	return filepath.Join(repop, fmt.Sprintf("sharedindex.%x", sha))
+}
+
+// read_shared_index reads the shared index of the split index and merges the entries of the index into it.
+func read_shared_index(repop string, d *Dircache) error {
+	s := d.split
+	if s.BaseSha1 == [20]byte{} {
+		return nil
+	}
+
+	path := shared_index_path(repop, s.BaseSha1)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: index file open failed: %v", path, err)
+	}
+	base, err := read_dircache_bytes(b)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(b[len(b)-sha1.Size:], s.BaseSha1[:]) == false {
+		return fmt.Errorf("broken index, expect %x in %s, got %x", s.BaseSha1, path, b[len(b)-sha1.Size:])
+	}
+	s.base = base.Entries
+
+	return merge_shared_index(d)
+}
+
+// merge_shared_index builds the entries from the shared index and the changes recorded in the index.
+func merge_shared_index(d *Dircache) error {
+	s := d.split
+	changes := d.Entries
+
+	// entries are copied. the entries of the shared index are kept as read
+	d.Entries = make([]*DircacheEntry, len(s.base))
+	for i, e := range s.base {
+		c := *e
+		c.base_index = i + 1
+		d.Entries[i] = &c
+	}
+
+	replaced := 0
+	err := s.Replace.each(func(pos int) error {
+		if pos >= len(d.Entries) {
+			return fmt.Errorf("position for replacement %d exceeds base index size %d", pos, len(d.Entries))
+		}
+		if replaced >= len(changes) {
+			return fmt.Errorf("too many replacements (%d vs %d)", replaced, len(changes))
+		}
+		if s.Delete.get(pos) {
+			return fmt.Errorf("entry %d is marked as both replaced and deleted", pos)
+		}
+		// the name is taken from the shared index
+		src := changes[replaced]
+		if len(src.PathName) > 0 {
+			return fmt.Errorf("corrupt link extension, entry %d should have zero length name", pos)
+		}
+		c := *src
+		c.PathName = d.Entries[pos].PathName
+		c.Flags = c.Flags&^DIRCACHE_FLAG_NAME_LENGTH | dircache_name_length(len(c.PathName))
+		c.base_index = pos + 1
+		d.Entries[pos] = &c
+		replaced++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.Delete.each(func(pos int) error {
+		if pos >= len(d.Entries) {
+			return fmt.Errorf("position for removal %d exceeds base index size %d", pos, len(d.Entries))
+		}
+		d.Entries[pos].removed = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// entries not in the shared index are sorted into
+	for i, e := range changes[replaced:] {
+		if len(e.PathName) == 0 {
+			return fmt.Errorf("corrupt link extension, entry %d should have non-zero length name", replaced+i)
+		}
+		d.Entries = append(d.Entries, e)
+	}
+	sort_dircache_entries(d)
+	return nil
+}
+
+// build_split_dircache_bytes returns the index which records changes from the shared index.
+// A new shared index is written when there is none or too many entries are not in it.
+func build_split_dircache_bytes(d *Dircache, repop string) ([]byte, error) {
+	s := d.split
+	new_shared := s.new_shared || s.BaseSha1 == [20]byte{} || too_many_not_shared_entries(d, repop)
+	if new_shared {
+		if err := write_shared_index(d, repop); err != nil {
+			return nil, err
+		}
+	}
+
+	// entries of the shared index which are used as is or replaced
+	used := make([]bool, len(s.base))
+	replace := make(map[int]*DircacheEntry)
+	var added []*DircacheEntry
+	for _, e := range d.Entries {
+		i := e.base_index - 1
+		if i < 0 || i >= len(s.base) || used[i] || bytes.Equal(s.base[i].PathName, e.PathName) == false {
+			e.base_index = 0
+			added = append(added, e)
+			continue
+		}
+		used[i] = true
+		if same_dircache_entry_data(e, s.base[i]) == false {
+			replace[i] = e
+		}
+	}
+
+	s.Delete = &EwahBitmap{}
+	s.Replace = &EwahBitmap{}
+	var entries []*DircacheEntry
+	for i := range s.base {
+		if used[i] == false {
+			s.Delete.set(i)
+		} else if e, ok := replace[i]; ok {
+			s.Replace.set(i)
+			// replacing entries are written without names
+			c := *e
+			c.PathName = nil
+			entries = append(entries, &c)
+		}
+	}
+	entries = append(entries, added...)
+
+	if new_shared == false {
+		// keep the shared index in use from being expired
+		now := time.Now()
+		os.Chtimes(shared_index_path(repop, s.BaseSha1), now, now)
+	}
+
+	split := &Dircache{}
+	split.Header = d.Header
+	split.Header.NumberOfEntries = int32(len(entries))
+	split.Entries = entries
+	split.Extensions = d.Extensions
+	split.split = s
+	split.untracked = d.untracked
+	return build_dircache_bytes(split), nil
+}
+
+// too_many_not_shared_entries reports whether entries not in the shared index exceed splitIndex.maxPercentChange.
+func too_many_not_shared_entries(d *Dircache, repop string) bool {
+	max := get_config_int(repop, "splitIndex.maxPercentChange", SPLIT_INDEX_DEFAULT_MAX_PERCENT_CHANGE)
+	if max < 0 || max > 100 {
+		max = SPLIT_INDEX_DEFAULT_MAX_PERCENT_CHANGE
+	}
+	switch max {
+	case 0:
+		return true
+	case 100:
+		return false
+	}
+
+	not_shared := 0
+	for _, e := range d.Entries {
+		if e.base_index == 0 {
+			not_shared++
+		}
+	}
+	return len(d.Entries)*max < not_shared*100
+}
+
+// write_shared_index writes all entries to a new shared index. extensions are kept in the index.
+func write_shared_index(d *Dircache, repop string) error {
+	shared := &Dircache{}
+	shared.Header = d.Header
+	shared.Header.NumberOfEntries = int32(len(d.Entries))
+	shared.Entries = d.Entries
+	b := build_dircache_bytes(shared)
+
+	s := d.split
+	copy(s.BaseSha1[:], b[len(b)-sha1.Size:])
+	lock, err := hold_lock_file(shared_index_path(repop, s.BaseSha1))
+	if err != nil {
+		return err
+	}
+	if err := lock.write(b); err != nil {
+		lock.rollback()
+		return err
+	}
+	if err := lock.commit(); err != nil {
+		return err
+	}
+
+	s.base = make([]*DircacheEntry, len(d.Entries))
+	for i, e := range d.Entries {
+		c := *e
+		s.base[i] = &c
+		e.base_index = i + 1
+	}
+	s.new_shared = false
+
+	return clean_shared_index_files(repop, s.BaseSha1)
+}
+
+// clean_shared_index_files removes shared indexes which are not used for splitIndex.sharedIndexExpire.
+func clean_shared_index_files(repop string, current [20]byte) error {
+	expire := SHARED_INDEX_DEFAULT_EXPIRE
+	if v, ok := get_config(repop, "splitIndex.sharedIndexExpire"); ok {
+		switch v {
+		case "never":
+			return nil
+		case "now":
+			expire = 0
+		}
+	}
+
+	infos, err := ioutil.ReadDir(repop)
+	if err != nil {
+		return err
+	}
+	keep := filepath.Base(shared_index_path(repop, current))
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasPrefix(name, "sharedindex.") == false || name == keep {
+			continue
+		}
+		if time.Since(info.ModTime()) >= expire {
+			if err := os.Remove(filepath.Join(repop, name)); err != nil && os.IsNotExist(err) == false {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// same_dircache_entry_data reports whether entries of the same path have the same contents, stat data and flags.
+func same_dircache_entry_data(a *DircacheEntry, b *DircacheEntry) bool {
+	return a.CTimeSeconds == b.CTimeSeconds &&
+		a.CTimeNanoSeconds == b.CTimeNanoSeconds &&
+		a.MTimeSeconds == b.MTimeSeconds &&
+		a.MTimeNanoSeconds == b.MTimeNanoSeconds &&
+		a.Dev == b.Dev &&
+		a.Inode == b.Inode &&
+		a.Mode == b.Mode &&
+		a.UID == b.UID &&
+		a.GID == b.GID &&
+		a.Size == b.Size &&
+		a.Sha1 == b.Sha1 &&
+		a.Flags&^DIRCACHE_FLAG_NAME_LENGTH == b.Flags&^DIRCACHE_FLAG_NAME_LENGTH &&
+		a.ExtendedFlags == b.ExtendedFlags
+}
+
+// tweak_split_index enables or disables the split index by core.splitIndex. nothing is changed if it's not set.
+func tweak_split_index(repop string, d *Dircache) {
+	if _, ok := get_config(repop, "core.splitIndex"); ok == false {
+		return
+	}
+	if get_config_bool(repop, "core.splitIndex", false) {
+		if d.split == nil {
+			d.split = &SplitIndex{}
+		}
+	} else {
+		d.split = nil
+	}
+}
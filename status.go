@@ -0,0 +1,608 @@
+// See Also:
+// https://git-scm.com/docs/git-status
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	STATUS_FORMAT_LONG         = "long"
+	STATUS_FORMAT_SHORT        = "short"
+	STATUS_FORMAT_PORCELAIN    = "porcelain"
+	STATUS_FORMAT_PORCELAIN_V2 = "porcelain-v2"
+)
+
+type StatusOptions struct {
+	Format         string // STATUS_FORMAT_*
+	Branch         bool   // -b: show the branch in the short formats
+	NulTerminated  bool   // -z: terminate entries with NUL and do not quote paths
+	UntrackedFiles string // -u: "no", "normal" or "all". status.showUntrackedFiles is used if ""
+}
+
+// StatusItem is a path changed in the index or in the work tree.
+type StatusItem struct {
+	Path       string
+	RenameFrom string // the path in HEAD if renamed in the index
+	Score      int    // similarity of the rename (%)
+	X          byte   // status of the index against HEAD (A, D, M, R or T). 0 if not changed
+	Y          byte   // status of the work tree against the index (A, D, M or T). 0 if not changed
+	Stages     int    // unmerged stages as bits. 1: base, 2: ours, 4: theirs. 0 if merged
+
+	head          *DircacheEntry    // entry in the tree of HEAD
+	entry         *DircacheEntry    // stage 0 entry in the index
+	unmerged      [3]*DircacheEntry // stage 1, 2 and 3 entries in the index
+	worktree_mode uint32            // mode of the file. 0 if deleted
+	new_commits   bool              // another commit is checked out in the submodule
+}
+
+// Status is the result of comparing the tree of HEAD, the index and the work tree.
+type Status struct {
+	Branch    string        // current branch. "" if HEAD is detached
+	Head      string        // object name of HEAD. "" before the first commit
+	Items     []*StatusItem // sorted by path
+	Untracked []string      // sorted. directories end with '/'
+}
+
+func status_cmd(opts StatusOptions, args []string) {
+	repop, prefix, err := setup_git_directory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
+		os.Exit(128)
+	}
+
+	// the index is updated with refreshed stat data unless another process is updating it
+	lock, _ := hold_lock_file(filepath.Join(repop, "index"))
+	d, err := load_dircache(repop)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+		exit(128)
+	}
+	d.lock = lock
+
+	pathspec, err := parse_pathspec(filepath.Dir(repop), prefix, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		exit(128)
+	}
+
+	if len(opts.UntrackedFiles) == 0 {
+		opts.UntrackedFiles = "normal"
+		if v, ok := get_config(repop, "status.showUntrackedFiles"); ok {
+			opts.UntrackedFiles = v
+		}
+	}
+	switch opts.UntrackedFiles {
+	case "no", "normal", "all":
+	default:
+		fmt.Fprintf(os.Stderr, "fatal: Invalid untracked files mode '%s'\n", opts.UntrackedFiles)
+		exit(128)
+	}
+
+	s, refreshed, err := collect_status(repop, d, pathspec, opts.UntrackedFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		exit(128)
+	}
+
+	if d.lock != nil && (refreshed || (d.untracked != nil && d.untracked.changed)) {
+		if err := write_dircache(d, repop); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+			exit(128)
+		}
+	}
+
+	quote_path := get_config_bool(repop, "core.quotePath", true)
+	switch opts.Format {
+	case STATUS_FORMAT_SHORT:
+		print_status_short(s, opts, prefix, quote_path)
+	case STATUS_FORMAT_PORCELAIN:
+		// paths are always relative to the top of the work tree
+		print_status_short(s, opts, "", quote_path)
+	case STATUS_FORMAT_PORCELAIN_V2:
+		print_status_porcelain_v2(s, opts, prefix, quote_path)
+	default:
+		print_status_long(s, opts, prefix, quote_path)
+	}
+}
+
+// read_head_branch returns the current branch ("" if HEAD is detached) and the object name of HEAD ("" before the first commit).
+func read_head_branch(repop string) (string, string) {
+	b, err := ioutil.ReadFile(filepath.Join(repop, "HEAD"))
+	if err != nil {
+		return "", ""
+	}
+	v := strings.TrimSpace(string(b))
+	branch := ""
+	if strings.HasPrefix(v, "ref: ") {
+		branch = strings.TrimPrefix(strings.TrimPrefix(v, "ref: "), "refs/heads/")
+	}
+	head, err := read_ref(repop, "HEAD")
+	if err != nil {
+		return branch, ""
+	}
+	return branch, head
+}
+
+// collect_status compares the tree of HEAD, the index and the work tree.
+// stat data of entries which are not changed are refreshed. it reports whether some entries are refreshed.
+func collect_status(repop string, d *Dircache, pathspec *Pathspec, untracked_mode string) (*Status, bool, error) {
+	s := &Status{}
+	s.Branch, s.Head = read_head_branch(repop)
+
+	var head []*DircacheEntry
+	if len(s.Head) > 0 {
+		tree, err := resolve_tree_ish(repop, s.Head)
+		if err != nil {
+			return nil, false, err
+		}
+		if head, err = read_tree_dircache_entries(repop, tree, ""); err != nil {
+			return nil, false, err
+		}
+	}
+
+	items := make(map[string]*StatusItem)
+	item := func(path string) *StatusItem {
+		it, ok := items[path]
+		if ok == false {
+			it = &StatusItem{Path: path}
+			items[path] = it
+		}
+		return it
+	}
+	for _, e := range head {
+		if pathspec.match(string(e.PathName)) {
+			item(string(e.PathName)).head = e
+		}
+	}
+
+	// the work tree is compared with the stat data of the index and files are hashed only if needed
+	skip := func(e *DircacheEntry) bool {
+		return e.removed || e.stage() != 0 || e.skip_worktree() || pathspec.match(string(e.PathName)) == false
+	}
+	worktree := preload_worktree_status(d, false, true, skip)
+
+	refreshed := false
+	for i, e := range d.Entries {
+		path := string(e.PathName)
+		if e.removed || pathspec.match(path) == false {
+			continue
+		}
+		it := item(path)
+		if e.stage() != 0 {
+			it.Stages |= 1 << uint(e.stage()-1)
+			it.unmerged[e.stage()-1] = e
+			if info, err := os.Lstat(path); err == nil {
+				it.worktree_mode = dircache_mode(info)
+			}
+			continue
+		}
+		it.entry = e
+		if skip(e) {
+			it.worktree_mode = e.Mode
+			continue
+		}
+
+		w := worktree[i]
+		if w.err != nil {
+			return nil, false, w.err
+		}
+		if w.info != nil {
+			it.worktree_mode = worktree_mode(d, e, w.info)
+		}
+		switch {
+		case w.info == nil:
+			it.Y = 'D'
+		case e.intent_to_add():
+			it.Y = 'A'
+		case w.modified && it.worktree_mode&0170000 != e.Mode&0170000:
+			it.Y = 'T'
+		case w.modified:
+			it.Y = 'M'
+			it.new_commits = e.Mode == 0160000
+		case e.assume_valid() == false && is_stat_changed(e, w.info):
+			fill_dircache_stat(e, w.info)
+			refreshed = true
+		}
+	}
+
+	for _, it := range items {
+		if it.Stages != 0 {
+			it.X, it.Y = unmerged_status(it.Stages)
+			continue
+		}
+		switch {
+		case it.entry == nil || it.entry.intent_to_add():
+			if it.head != nil {
+				it.X = 'D'
+			}
+		case it.head == nil:
+			it.X = 'A'
+		case it.head.Mode&0170000 != it.entry.Mode&0170000:
+			it.X = 'T'
+		case it.head.Mode != it.entry.Mode || it.head.Sha1 != it.entry.Sha1:
+			it.X = 'M'
+		}
+	}
+
+	for path, it := range items {
+		if it.X == 0 && it.Y == 0 {
+			delete(items, path)
+		}
+	}
+	if get_config_bool(repop, "status.renames", get_config_bool(repop, "diff.renames", true)) {
+		if err := detect_renames(repop, items); err != nil {
+			return nil, false, err
+		}
+	}
+
+	for _, it := range items {
+		s.Items = append(s.Items, it)
+	}
+	sort.Slice(s.Items, func(i, k int) bool {
+		return s.Items[i].Path < s.Items[k].Path
+	})
+
+	if untracked_mode != "no" {
+		x := new_ignore_rules(repop)
+		if err := x.add_exclude_standard(repop); err != nil {
+			return nil, false, err
+		}
+		files, err := list_untracked_files(filepath.Dir(repop), "", d, x, false)
+		if err != nil {
+			return nil, false, err
+		}
+		seen := make(map[string]bool)
+		for _, f := range files {
+			if pathspec.match(f) == false {
+				continue
+			}
+			if untracked_mode == "normal" {
+				f = untracked_directory(d, f)
+			}
+			if seen[f] == false {
+				seen[f] = true
+				s.Untracked = append(s.Untracked, f)
+			}
+		}
+		sort.Strings(s.Untracked)
+	}
+
+	return s, refreshed, nil
+}
+
+// unmerged_status returns the status letters of the unmerged path by its stages.
+func unmerged_status(stages int) (byte, byte) {
+	switch stages {
+	case 1:
+		return 'D', 'D' // both deleted
+	case 2:
+		return 'A', 'U' // added by us
+	case 3:
+		return 'U', 'D' // deleted by them
+	case 4:
+		return 'U', 'A' // added by them
+	case 5:
+		return 'D', 'U' // deleted by us
+	case 6:
+		return 'A', 'A' // both added
+	}
+	return 'U', 'U' // both modified
+}
+
+// detect_renames pairs paths deleted from and added to the index which have the same or similar contents.
+// the similarity is estimated by diffcore_rename with the default score (50%).
+func detect_renames(repop string, items map[string]*StatusItem) error {
+	var q DiffQueue
+	for _, it := range items {
+		if it.Stages != 0 {
+			continue
+		}
+		if it.X == 'D' && it.entry == nil && it.head.Mode != 0160000 {
+			q = append(q, &DiffFilepair{
+				One:    &DiffFilespec{Path: it.Path, Mode: it.head.Mode, Sha1: it.head.Sha1},
+				Two:    &DiffFilespec{Path: it.Path},
+				Status: DIFF_STATUS_DELETED,
+			})
+		}
+		if it.X == 'A' && it.entry.Mode != 0160000 {
+			q = append(q, &DiffFilepair{
+				One:    &DiffFilespec{Path: it.Path},
+				Two:    &DiffFilespec{Path: it.Path, Mode: it.entry.Mode, Sha1: it.entry.Sha1},
+				Status: DIFF_STATUS_ADDED,
+			})
+		}
+	}
+	sort.Slice(q, func(i, k int) bool {
+		return q[i].One.Path < q[k].One.Path
+	})
+
+	opts := DiffOptions{DetectRename: DIFF_DETECT_RENAME}
+	diff_setup_done(repop, &opts)
+	q, err := diffcore_rename(q, &opts)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range q {
+		if p.Status != DIFF_STATUS_RENAMED {
+			continue
+		}
+		src := items[p.One.Path]
+		dst := items[p.Two.Path]
+		dst.X = 'R'
+		dst.RenameFrom = src.Path
+		dst.Score = similarity_index(p)
+		dst.head = src.head
+		delete(items, src.Path)
+	}
+	return nil
+}
+
+// untracked_directory returns the top directory of the untracked file which has no tracked files. ('dir/')
+// the file itself is returned if all directories have tracked files.
+func untracked_directory(d *Dircache, path string) string {
+	for i := 0; i < len(path); i++ {
+		if path[i] != '/' || i == len(path)-1 {
+			continue
+		}
+		dir := path[:i+1]
+		pos := dircache_name_pos(d, dir, 0)
+		if pos < 0 {
+			pos = -pos - 1
+		}
+		tracked := false
+		for ; pos < len(d.Entries) && bytes.HasPrefix(d.Entries[pos].PathName, []byte(dir)); pos++ {
+			if d.Entries[pos].removed == false {
+				tracked = true
+				break
+			}
+		}
+		if tracked == false {
+			return dir
+		}
+	}
+	return path
+}
+
+// status_path returns the path to be shown. the path is relative to the current directory and quoted if needed.
+func status_path(path string, opts StatusOptions, prefix string, quote_path bool, quote_sp bool) string {
+	if opts.NulTerminated {
+		return path
+	}
+	return quote_c_style(relative_path(prefix, path), quote_path, quote_sp)
+}
+
+func status_letter(c byte, unchanged byte) byte {
+	if c == 0 {
+		return unchanged
+	}
+	return c
+}
+
+// print_status_short prints 'XY PATH' or 'XY ORIG_PATH -> PATH' for each path. ('??' for untracked files)
+func print_status_short(s *Status, opts StatusOptions, prefix string, quote_path bool) {
+	term := "\n"
+	if opts.NulTerminated {
+		term = "\x00"
+	}
+
+	if opts.Branch {
+		switch {
+		case len(s.Branch) == 0:
+			fmt.Printf("## HEAD (no branch)%s", term)
+		case len(s.Head) == 0:
+			fmt.Printf("## No commits yet on %s%s", s.Branch, term)
+		default:
+			fmt.Printf("## %s%s", s.Branch, term)
+		}
+	}
+
+	for _, it := range s.Items {
+		fmt.Printf("%c%c ", status_letter(it.X, ' '), status_letter(it.Y, ' '))
+		path := status_path(it.Path, opts, prefix, quote_path, true)
+		if len(it.RenameFrom) == 0 {
+			fmt.Print(path + term)
+			continue
+		}
+		from := status_path(it.RenameFrom, opts, prefix, quote_path, true)
+		if opts.NulTerminated {
+			fmt.Print(path + term + from + term)
+		} else {
+			fmt.Print(from + " -> " + path + term)
+		}
+	}
+
+	for _, f := range s.Untracked {
+		fmt.Printf("?? %s%s", status_path(f, opts, prefix, quote_path, true), term)
+	}
+}
+
+// submodule_status_field returns 'N...' for files or 'S<c><m><u>' for submodules. only new commits are checked.
+func submodule_status_field(it *StatusItem, modes ...uint32) string {
+	for _, m := range modes {
+		if m == 0160000 {
+			if it.new_commits {
+				return "SC.."
+			}
+			return "S..."
+		}
+	}
+	return "N..."
+}
+
+// print_status_porcelain_v2 prints the porcelain format version 2.
+//
+//	1 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <path>
+//	2 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <X><score> <path><sep><origPath>
+//	u <XY> <sub> <m1> <m2> <m3> <mW> <h1> <h2> <h3> <path>
+//	? <path>
+func print_status_porcelain_v2(s *Status, opts StatusOptions, prefix string, quote_path bool) {
+	term := "\n"
+	sep := "\t"
+	if opts.NulTerminated {
+		term = "\x00"
+		sep = "\x00"
+	}
+
+	if opts.Branch {
+		if len(s.Head) == 0 {
+			fmt.Printf("# branch.oid (initial)%s", term)
+		} else {
+			fmt.Printf("# branch.oid %s%s", s.Head, term)
+		}
+		if len(s.Branch) == 0 {
+			fmt.Printf("# branch.head (detached)%s", term)
+		} else {
+			fmt.Printf("# branch.head %s%s", s.Branch, term)
+		}
+	}
+
+	mode_sha := func(e *DircacheEntry) (uint32, [20]byte) {
+		if e == nil || e.intent_to_add() {
+			return 0, [20]byte{}
+		}
+		return e.Mode, e.Sha1
+	}
+
+	// unmerged entries are printed after changed entries
+	for _, unmerged := range []bool{false, true} {
+		for _, it := range s.Items {
+			if (it.Stages != 0) != unmerged {
+				continue
+			}
+			xy := fmt.Sprintf("%c%c", status_letter(it.X, '.'), status_letter(it.Y, '.'))
+			path := status_path(it.Path, opts, prefix, quote_path, false)
+
+			if it.Stages != 0 {
+				var modes [3]uint32
+				var shas [3][20]byte
+				for i, e := range it.unmerged {
+					modes[i], shas[i] = mode_sha(e)
+				}
+				fmt.Printf("u %s %s %06o %06o %06o %06o %x %x %x %s%s", xy, submodule_status_field(it, modes[0], modes[1], modes[2], it.worktree_mode),
+					modes[0], modes[1], modes[2], it.worktree_mode, shas[0], shas[1], shas[2], path, term)
+				continue
+			}
+
+			mh, hh := mode_sha(it.head)
+			mi, hi := mode_sha(it.entry)
+			mw := mi
+			if it.Y != 0 {
+				mw = it.worktree_mode
+			}
+			sub := submodule_status_field(it, mh, mi, mw)
+			if len(it.RenameFrom) == 0 {
+				fmt.Printf("1 %s %s %06o %06o %06o %x %x %s%s", xy, sub, mh, mi, mw, hh, hi, path, term)
+				continue
+			}
+			from := status_path(it.RenameFrom, opts, prefix, quote_path, false)
+			fmt.Printf("2 %s %s %06o %06o %06o %x %x %c%d %s%s%s%s", xy, sub, mh, mi, mw, hh, hi, it.X, it.Score, path, sep, from, term)
+		}
+	}
+
+	for _, f := range s.Untracked {
+		fmt.Printf("? %s%s", status_path(f, opts, prefix, quote_path, false), term)
+	}
+}
+
+// print_status_long prints the human readable format. hints are not shown. (advice.statusHints=false)
+func print_status_long(s *Status, opts StatusOptions, prefix string, quote_path bool) {
+	if len(s.Branch) > 0 {
+		fmt.Printf("On branch %s\n", s.Branch)
+	} else {
+		fmt.Printf("Not currently on any branch.\n")
+	}
+	if len(s.Head) == 0 {
+		fmt.Printf("\nNo commits yet\n\n")
+	}
+
+	path := func(p string) string {
+		return quote_c_style(relative_path(prefix, p), quote_path, false)
+	}
+	labels := map[byte]string{
+		'A': "new file:",
+		'D': "deleted:",
+		'M': "modified:",
+		'R': "renamed:",
+		'T': "typechange:",
+	}
+	unmerged_labels := map[string]string{
+		"DD": "both deleted:",
+		"AU": "added by us:",
+		"UD": "deleted by them:",
+		"UA": "added by them:",
+		"DU": "deleted by us:",
+		"AA": "both added:",
+		"UU": "both modified:",
+	}
+
+	committable := false
+	dirty := false
+	var staged, unmerged, changed []string
+	for _, it := range s.Items {
+		if it.Stages != 0 {
+			xy := string([]byte{it.X, it.Y})
+			unmerged = append(unmerged, fmt.Sprintf("%-17s%s", unmerged_labels[xy], path(it.Path)))
+			dirty = true
+			continue
+		}
+		if it.X != 0 {
+			p := path(it.Path)
+			if len(it.RenameFrom) > 0 {
+				p = path(it.RenameFrom) + " -> " + p
+			}
+			staged = append(staged, fmt.Sprintf("%-12s%s", labels[it.X], p))
+			committable = true
+		}
+		if it.Y != 0 {
+			p := path(it.Path)
+			if it.new_commits {
+				p += " (new commits)"
+			}
+			changed = append(changed, fmt.Sprintf("%-12s%s", labels[it.Y], p))
+			dirty = true
+		}
+	}
+
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Printf("%s:\n", title)
+		for _, l := range lines {
+			fmt.Printf("\t%s\n", l)
+		}
+		fmt.Printf("\n")
+	}
+	section("Changes to be committed", staged)
+	section("Unmerged paths", unmerged)
+	section("Changes not staged for commit", changed)
+
+	var untracked []string
+	for _, f := range s.Untracked {
+		untracked = append(untracked, path(f))
+	}
+	section("Untracked files", untracked)
+	if opts.UntrackedFiles == "no" && committable {
+		fmt.Printf("Untracked files not listed\n")
+	}
+
+	switch {
+	case committable:
+	case dirty:
+		fmt.Printf("no changes added to commit\n")
+	case len(s.Untracked) > 0:
+		fmt.Printf("nothing added to commit but untracked files present\n")
+	case len(s.Head) > 0 && opts.UntrackedFiles != "no":
+		fmt.Printf("nothing to commit, working tree clean\n")
+	default:
+		fmt.Printf("nothing to commit\n")
+	}
+}
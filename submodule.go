@@ -0,0 +1,384 @@
+// See Also:
+// https://git-scm.com/docs/git-submodule
+// https://git-scm.com/docs/gitmodules
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type Submodule struct {
+	Name string
+	Path string
+	URL  string
+}
+
+// nested_repository returns the repository directory of the nested work tree. "" is returned if dir is not a repository.
+// '.git' may be a file which points to the repository ('gitdir: <path>').
+func nested_repository(dir string) string {
+	for _, name := range []string{REPOSITORY_DIR_NAME, ".git"} {
+		p := filepath.Join(dir, name)
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			return p
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil || bytes.HasPrefix(b, []byte("gitdir: ")) == false {
+			continue
+		}
+		gitdir := strings.TrimSpace(string(b[len("gitdir: "):]))
+		if filepath.IsAbs(gitdir) == false {
+			gitdir = filepath.Join(dir, gitdir)
+		}
+		return gitdir
+	}
+	return ""
+}
+
+// resolve_gitlink_head returns the commit checked out in the nested repository.
+func resolve_gitlink_head(dir string) ([]byte, error) {
+	repo := nested_repository(dir)
+	if len(repo) == 0 {
+		return nil, fmt.Errorf("%s is not a git repository", dir)
+	}
+	v, err := read_ref(repo, "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("'%s/' does not have a commit checked out", dir)
+	}
+	sha, err := hex.DecodeString(v)
+	if err != nil || len(sha) != 20 {
+		return nil, fmt.Errorf("'%s/' does not have a commit checked out", dir)
+	}
+	return sha, nil
+}
+
+// read_gitmodules reads submodules defined in .gitmodules of the work tree.
+func read_gitmodules(root string) ([]*Submodule, error) {
+	b, err := ioutil.ReadFile(filepath.Join(root, ".gitmodules"))
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var modules []*Submodule
+	by_name := make(map[string]*Submodule)
+	for _, c := range parse_config(b) {
+		// 'submodule.<name>.<key>'. the name may contain dots
+		if strings.HasPrefix(c.Name, "submodule.") == false {
+			continue
+		}
+		dot := strings.LastIndex(c.Name, ".")
+		if dot <= len("submodule.") {
+			continue
+		}
+		name := c.Name[len("submodule."):dot]
+		m, ok := by_name[name]
+		if ok == false {
+			m = &Submodule{Name: name}
+			by_name[name] = m
+			modules = append(modules, m)
+		}
+		switch c.Name[dot+1:] {
+		case "path":
+			m.Path = strings.TrimSuffix(c.Value, "/")
+		case "url":
+			m.URL = c.Value
+		}
+	}
+	return modules, nil
+}
+
+// is_submodule_active reports whether the submodule is initialized. (submodule.<name>.active or submodule.<name>.url)
+func is_submodule_active(repop string, m *Submodule) bool {
+	if _, ok := get_config(repop, "submodule."+m.Name+".active"); ok {
+		return get_config_bool(repop, "submodule."+m.Name+".active", false)
+	}
+	_, ok := get_config(repop, "submodule."+m.Name+".url")
+	return ok
+}
+
+func submodule_status_cmd(cached bool, args []string) {
+	repop, prefix, err := setup_git_directory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
+		os.Exit(128)
+	}
+	root := filepath.Dir(repop)
+
+	d, err := load_dircache(repop)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+		os.Exit(128)
+	}
+
+	pathspec, err := parse_pathspec(root, prefix, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(128)
+	}
+	for _, item := range pathspec.Items {
+		if item.Magic&PATHSPEC_EXCLUDE != 0 {
+			continue
+		}
+		found := false
+		for _, e := range d.Entries {
+			if item.match(string(e.PathName)) {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			fmt.Fprintf(os.Stderr, "error: pathspec '%s' did not match any file(s) known to git\n", item.Original)
+			os.Exit(1)
+		}
+	}
+
+	modules, err := read_gitmodules(root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+		os.Exit(128)
+	}
+
+	for i, e := range d.Entries {
+		path := string(e.PathName)
+		if e.Mode != 0160000 || pathspec.match(path) == false {
+			continue
+		}
+		// unmerged entries are shown once
+		if i > 0 && bytes.Equal(d.Entries[i-1].PathName, e.PathName) {
+			continue
+		}
+
+		var m *Submodule
+		for _, s := range modules {
+			if s.Path == path {
+				m = s
+			}
+		}
+		if m == nil {
+			fmt.Fprintf(os.Stderr, "fatal: no submodule mapping found in .gitmodules for path '%s'\n", path)
+			os.Exit(128)
+		}
+
+		display := relative_path(prefix, path)
+		if e.stage() != 0 {
+			fmt.Printf("U%s %s\n", strings.Repeat("0", 40), display)
+			continue
+		}
+
+		// not initialized or not checked out
+		head, err := resolve_gitlink_head(path)
+		if is_submodule_active(repop, m) == false || err != nil {
+			fmt.Printf("-%x %s\n", e.Sha1, display)
+			continue
+		}
+
+		sha := fmt.Sprintf("%x", head)
+		flag := ' '
+		if bytes.Equal(head, e.Sha1[:]) == false {
+			flag = '+'
+			if cached {
+				sha = fmt.Sprintf("%x", e.Sha1)
+			}
+		}
+		fmt.Printf("%c%s %s (%s)\n", flag, sha, display, describe_commit(nested_repository(path), sha))
+	}
+}
+
+type describe_ref struct {
+	name      string // 'refs/tags/v1'
+	commit    string // the commit which the ref points to. tags are peeled.
+	annotated bool
+}
+
+// describe_commit names the commit by refs as 'git submodule status' does.
+// annotated tags, all tags, tags which contain the commit, and then all refs are tried in this order.
+// the abbreviated object name is returned if none of them describe the commit.
+func describe_commit(repo string, sha string) string {
+	refs := list_describe_refs(repo)
+
+	var annotated, tags, all []describe_ref
+	for _, r := range refs {
+		if strings.HasPrefix(r.name, "refs/tags/") {
+			t := r
+			t.name = strings.TrimPrefix(r.name, "refs/tags/")
+			if r.annotated {
+				annotated = append(annotated, t)
+			}
+			tags = append(tags, t)
+		}
+		a := r
+		a.name = strings.TrimPrefix(r.name, "refs/")
+		all = append(all, a)
+	}
+
+	if name, ok := describe_nearest(repo, sha, annotated); ok {
+		return name
+	}
+	if name, ok := describe_nearest(repo, sha, tags); ok {
+		return name
+	}
+	if name, ok := describe_contains(repo, sha, tags); ok {
+		return name
+	}
+	if name, ok := describe_nearest(repo, sha, all); ok {
+		return name
+	}
+	return sha[:7]
+}
+
+// describe_nearest names the commit by the nearest ancestor which a ref points to. ('<ref>-<distance>-g<abbrev>')
+func describe_nearest(repo string, sha string, refs []describe_ref) (string, bool) {
+	if len(refs) == 0 {
+		return "", false
+	}
+	names := make(map[string]string)
+	for _, r := range refs {
+		if _, ok := names[r.commit]; ok == false {
+			names[r.commit] = r.name
+		}
+	}
+
+	// breadth first search from the commit
+	queue := []string{sha}
+	seen := map[string]bool{sha: true}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if name, ok := names[c]; ok {
+			if c == sha {
+				return name, true
+			}
+			depth := len(commit_ancestors(repo, sha)) - len(commit_ancestors(repo, c))
+			return fmt.Sprintf("%s-%d-g%s", name, depth, sha[:7]), true
+		}
+		for _, p := range commit_parents(repo, c) {
+			if seen[p] == false {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return "", false
+}
+
+// describe_contains names the commit by a tag which contains it. ('<tag>~<n>')
+func describe_contains(repo string, sha string, tags []describe_ref) (string, bool) {
+	best := ""
+	best_n := -1
+	for _, t := range tags {
+		// follow the first parents
+		c := t.commit
+		for n := 0; len(c) > 0; n++ {
+			if c == sha {
+				if best_n < 0 || n < best_n {
+					best = fmt.Sprintf("%s~%d", t.name, n)
+					best_n = n
+				}
+				break
+			}
+			parents := commit_parents(repo, c)
+			if len(parents) == 0 {
+				break
+			}
+			c = parents[0]
+		}
+	}
+	return best, best_n >= 0
+}
+
+// commit_parents returns parent commits. nil is returned if the commit can not be read.
+func commit_parents(repo string, sha string) []string {
+	t, b, err := read_object_file(repo, sha)
+	if err != nil || t != "commit" {
+		return nil
+	}
+
+	var parents []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if len(line) == 0 {
+			// end of the header
+			break
+		}
+		if strings.HasPrefix(line, "parent ") {
+			parents = append(parents, strings.TrimPrefix(line, "parent "))
+		}
+	}
+	return parents
+}
+
+// commit_ancestors returns the commit and all commits reachable from it.
+func commit_ancestors(repo string, sha string) map[string]bool {
+	seen := map[string]bool{sha: true}
+	stack := []string{sha}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, p := range commit_parents(repo, c) {
+			if seen[p] == false {
+				seen[p] = true
+				stack = append(stack, p)
+			}
+		}
+	}
+	return seen
+}
+
+// list_describe_refs returns refs in loose files and packed-refs sorted by name.
+func list_describe_refs(repo string) []describe_ref {
+	values := make(map[string]string)
+
+	// packed-refs ('<sha> <ref>' lines. '^<sha>' lines are peeled tags)
+	if b, err := ioutil.ReadFile(filepath.Join(repo, "packed-refs")); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			if len(line) < 42 || line[0] == '#' || line[0] == '^' {
+				continue
+			}
+			values[line[41:]] = line[:40]
+		}
+	}
+
+	// loose refs take precedence
+	filepath.Walk(filepath.Join(repo, "refs"), func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(repo, p)
+		if err != nil {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		if v, err := read_ref(repo, name); err == nil {
+			values[name] = v
+		}
+		return nil
+	})
+
+	var refs []describe_ref
+	for name, v := range values {
+		r := describe_ref{name: name, commit: v}
+		// peel annotated tags
+		for {
+			t, b, err := read_object_file(repo, r.commit)
+			if err != nil || t != "tag" || bytes.HasPrefix(b, []byte("object ")) == false || len(b) < 47 {
+				break
+			}
+			r.commit = string(b[7:47])
+			r.annotated = true
+		}
+		refs = append(refs, r)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].name < refs[j].name })
+	return refs
+}
@@ -0,0 +1,146 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf add-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+git read-tree --empty
+TOY_GIT=$( pwd )/../toy-git
+
+function index_state() {
+  od -A n -t u1 -j 7 -N 1 $REPOSITORY_DIR_NAME/index
+  git ls-files -s
+  git ls-files --debug | grep flags
+}
+
+# run add of toy-git and git in the directory from the same index, and compare the results
+function compare_add() {
+  DIR=$1
+  shift
+  cp $REPOSITORY_DIR_NAME/index index.bak
+  ACTUAL_MESSAGE=$( cd $DIR && $TOY_GIT add "$@" 2>&1 )
+  ACTUAL_STATUS=$?
+  ACTUAL_STATE=$( index_state )
+  cp index.bak $REPOSITORY_DIR_NAME/index
+  EXPECT_MESSAGE=$( cd $DIR && git add "$@" 2>&1 )
+  EXPECT_STATUS=$?
+  EXPECT_STATE=$( index_state )
+  if [[ "$EXPECT_MESSAGE" != "$ACTUAL_MESSAGE" || "$EXPECT_STATE" != "$ACTUAL_STATE" || "$EXPECT_STATUS" != "$ACTUAL_STATUS" ]]; then
+    echo "[add] 'add $@' in $DIR failed."
+    echo -e "Expect($EXPECT_STATUS): \n$EXPECT_MESSAGE\n$EXPECT_STATE"
+    echo -e "Actual($ACTUAL_STATUS): \n$ACTUAL_MESSAGE\n$ACTUAL_STATE"
+    exit 1
+  fi
+}
+
+# create work tree
+mkdir -p add-work/sub/deep add-work/empty add-work/logs
+echo "a" > add-work/a.txt
+echo "b" > add-work/b.txt
+echo "c" > add-work/sub/c.txt
+echo "d" > add-work/sub/deep/d.txt
+echo "x" > add-work/x.log
+echo "l" > add-work/logs/l.txt
+printf "*.log\nlogs/\n" > add-work/.gitignore
+
+################
+# update-index --add with directories
+################
+cp $REPOSITORY_DIR_NAME/index index.bak
+../toy-git update-index --add add-work/sub
+ACTUAL_STATE=$( index_state )
+cp index.bak $REPOSITORY_DIR_NAME/index
+git update-index --add add-work/sub/c.txt add-work/sub/deep/d.txt
+EXPECT_STATE=$( index_state )
+if [[ "$EXPECT_STATE" != "$ACTUAL_STATE" ]]; then
+  echo "[update-index] 'update-index --add add-work/sub' failed."
+  echo -e "Expect: \n$EXPECT_STATE"
+  echo -e "Actual: \n$ACTUAL_STATE"
+  exit 1
+fi
+
+# ignored files are skipped
+cp index.bak $REPOSITORY_DIR_NAME/index
+../toy-git update-index --add add-work
+ACTUAL_STATE=$( index_state )
+cp index.bak $REPOSITORY_DIR_NAME/index
+git update-index --add add-work/.gitignore add-work/a.txt add-work/b.txt add-work/sub/c.txt add-work/sub/deep/d.txt
+EXPECT_STATE=$( index_state )
+if [[ "$EXPECT_STATE" != "$ACTUAL_STATE" ]]; then
+  echo "[update-index] 'update-index --add add-work' failed."
+  echo -e "Expect: \n$EXPECT_STATE"
+  echo -e "Actual: \n$ACTUAL_STATE"
+  exit 1
+fi
+
+# a directory needs --add
+if ../toy-git update-index add-work/sub > /dev/null 2>&1; then
+  echo "[update-index] 'update-index add-work/sub' must fail."
+  exit 1
+fi
+git read-tree --empty
+
+################
+# add
+################
+compare_add add-work
+compare_add add-work -n .
+compare_add add-work .
+compare_add add-work/sub .
+compare_add add-work -v sub a.txt
+compare_add add-work none.txt
+compare_add add-work a.txt none.txt
+compare_add add-work empty
+
+# ignored paths are reported unless -f is given
+compare_add add-work x.log
+compare_add add-work a.txt x.log
+compare_add add-work logs/l.txt
+compare_add add-work -n . logs/l.txt
+compare_add add-work -f x.log logs
+compare_add add-work '*.log'
+
+# pathspec magic
+compare_add add-work/sub ':/add-work/a.txt'
+compare_add add-work -v . ':!sub'
+
+# modified and deleted files
+git add add-work/a.txt add-work/b.txt add-work/sub
+echo "modified" > add-work/a.txt
+rm add-work/b.txt
+echo "e" > add-work/e.txt
+compare_add add-work -n .
+compare_add add-work -u -v
+compare_add add-work/sub -u -v
+compare_add add-work -u -v e.txt
+compare_add add-work -A -v .
+compare_add add-work -v a.txt b.txt
+
+# intent to add
+# (git reports racily clean files as added with -N, so the files must be older than the index)
+sleep 1
+compare_add add-work -N e.txt
+compare_add add-work -N a.txt
+compare_add add-work -N -v .
+git add -N add-work/e.txt
+compare_add add-work -v e.txt
+echo "f" > add-work/f.txt
+../toy-git add -N add-work/f.txt
+if [[ $( ../toy-git write-tree ) != $( git write-tree ) ]]; then
+  echo "[write-tree] intent to add entries must not be written."
+  exit 1
+fi
+
+# options
+compare_add add-work -A -u
+
+cd ..
@@ -0,0 +1,204 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf apply-work apply-patches apply-saved
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+export GIT_AUTHOR_NAME="toy-git" GIT_AUTHOR_EMAIL="toy-git@example.com"
+export GIT_COMMITTER_NAME="toy-git" GIT_COMMITTER_EMAIL="toy-git@example.com"
+
+function commit() {
+  ../toy-git add -A apply-work
+  TREE=$( ../toy-git write-tree )
+  if [[ -z "$COMMIT" ]]; then
+    COMMIT=$( git commit-tree -m "$1" $TREE )
+  else
+    COMMIT=$( git commit-tree -p $COMMIT -m "$1" $TREE )
+  fi
+  git update-ref refs/heads/master $COMMIT
+}
+
+# save the work tree and the index to restore them before each apply
+function save() {
+  rm -rf apply-saved
+  mkdir apply-saved
+  cp -a apply-work apply-saved/
+  cp .toy-git/index apply-saved/index
+}
+
+function restore() {
+  rm -rf apply-work
+  cp -a apply-saved/apply-work .
+  cp apply-saved/index .toy-git/index
+  git update-index -q --refresh
+}
+
+# print the work tree and the index
+function show_state() {
+  find apply-work | sort | while read f; do
+    if [[ -L "$f" ]]; then
+      echo "$f -> $( readlink "$f" )"
+    elif [[ -f "$f" ]]; then
+      echo "$f $( stat -c %A "$f" ) $( md5sum < "$f" )"
+    else
+      echo "$f/"
+    fi
+  done
+  git ls-files -s
+}
+
+# run the apply command of toy-git and git in the saved state, and compare the messages, exit codes and results
+# usage: compare_apply <directory> <args>...
+function compare_apply() {
+  DIR=$1
+  shift
+  restore
+  EXPECT=$( cd $DIR && git apply "$@" 2>&1; echo "exit $?" )
+  [[ -n "$VERBOSE" ]] && echo -e "apply $@\n$EXPECT"
+  EXPECT_STATE=$( show_state )
+  restore
+  ACTUAL=$( cd $DIR && $OLDPWD/../toy-git apply "$@" 2>&1; echo "exit $?" )
+  ACTUAL_STATE=$( show_state )
+  if [[ "$EXPECT" != "$ACTUAL" ]]; then
+    echo "[apply] 'apply $@' in $DIR failed."
+    echo -e "Expect: \n$EXPECT"
+    echo -e "Actual: \n$ACTUAL"
+    exit 1
+  fi
+  if [[ "$EXPECT_STATE" != "$ACTUAL_STATE" ]]; then
+    echo "[apply] 'apply $@' in $DIR made a different result."
+    diff <( echo "$EXPECT_STATE" ) <( echo "$ACTUAL_STATE" )
+    exit 1
+  fi
+}
+
+# create work tree
+mkdir -p apply-work/sub apply-patches
+seq 1 20 > apply-work/a
+seq 1 30 > apply-work/b
+echo "gone" > apply-work/gone
+echo "script" > apply-work/run.sh
+seq 1 10 > apply-work/sub/c
+head -c 3000 /dev/urandom > apply-work/data.bin
+commit initial
+C1=$COMMIT
+
+# create patches with git
+sed -i 's/^10$/ten/' apply-work/a
+sed -i -e 's/^3$/three/' -e 's/^25$/twenty-five/' apply-work/b
+echo "no newline" | tr -d '\n' >> apply-work/sub/c
+rm apply-work/gone
+echo "new" > apply-work/new
+chmod +x apply-work/run.sh
+head -c 100 /dev/urandom >> apply-work/data.bin
+mv apply-work/sub/c apply-work/sub/d
+ln -s a apply-work/link
+commit changed
+C2=$COMMIT
+git diff-tree -p -M $C1 $C2 > apply-patches/all.patch
+git diff-tree -p --binary -M $C1 $C2 > apply-patches/binary.patch
+git diff-tree -p -C --find-copies-harder $C1 $C2 -- apply-work/a apply-work/sub > apply-patches/copy.patch
+git diff-tree -p $C1 $C2 -- apply-work/a > apply-patches/a.patch
+git diff-tree -p $C1 $C2 -- apply-work/b > apply-patches/b.patch
+git diff-tree -p $C1 $C2 -- apply-work/new > apply-patches/new.patch
+git diff-tree -p $C1 $C2 -- apply-work/gone > apply-patches/gone.patch
+git diff-tree -p $C1 $C2 -- apply-work/sub > apply-patches/sub.patch
+( cd apply-work && git diff-tree -p --relative $C1 $C2 -- a ) > apply-patches/relative.patch
+seq 1 20 > apply-patches/a.orig
+diff -u --label a/apply-work/a --label b/apply-work/a apply-patches/a.orig apply-work/a > apply-patches/traditional.patch
+diff -u --label a --label a apply-patches/a.orig apply-work/a > apply-patches/p0.patch
+mkdir -p apply-patches/old/apply-work apply-patches/new/apply-work
+seq 1 5 > apply-patches/new/apply-work/created
+( cd apply-patches && diff -uN old/apply-work/created new/apply-work/created > created.patch )
+: > apply-patches/empty.patch
+echo "garbage" > apply-patches/garbage.patch
+
+################
+# apply to the first commit
+################
+git read-tree $C1
+rm -rf apply-work
+git checkout-index -a
+save
+for OPTS in "" "--index" "--cached" "--check" "-v" "--index -v" "--check -v" "--3way" "-R"; do
+  compare_apply . $OPTS apply-patches/all.patch
+done
+for P in binary copy a b new gone sub traditional created; do
+  compare_apply . apply-patches/$P.patch
+  compare_apply . --index apply-patches/$P.patch
+  compare_apply . --cached -v apply-patches/$P.patch
+done
+compare_apply apply-work -p0 ../apply-patches/p0.patch
+compare_apply . -p0 apply-patches/p0.patch
+compare_apply . -p2 apply-patches/a.patch
+compare_apply . apply-patches/a.patch apply-patches/b.patch
+compare_apply . -R apply-patches/a.patch
+compare_apply . apply-patches/empty.patch
+compare_apply . apply-patches/garbage.patch
+compare_apply . apply-patches/missing.patch
+compare_apply apply-work ../apply-patches/relative.patch
+compare_apply apply-work/sub ../../apply-patches/all.patch
+compare_apply apply-work/sub -v ../../apply-patches/sub.patch
+
+################
+# apply in reverse to the second commit
+################
+git read-tree $C2
+rm -rf apply-work
+git checkout-index -a
+save
+for OPTS in "-R" "-R --index" "-R --cached" "-R -v --check"; do
+  compare_apply . $OPTS apply-patches/all.patch
+  compare_apply . $OPTS apply-patches/binary.patch
+done
+compare_apply . apply-patches/a.patch
+compare_apply . --index apply-patches/new.patch
+
+################
+# offsets and fuzz
+################
+git read-tree $C1
+rm -rf apply-work
+git checkout-index -a
+( echo x; echo y; seq 1 20 ) > apply-work/a
+sed -i 's/^7$/seven/' apply-work/a
+../toy-git add apply-work/a
+save
+compare_apply . -v apply-patches/a.patch
+compare_apply . -v -C1 apply-patches/a.patch
+compare_apply . -v -C2 apply-patches/a.patch
+compare_apply . -v --index apply-patches/a.patch
+sed -i 's/^13$/thirteen/' apply-work/a
+save
+compare_apply . -v apply-patches/a.patch
+compare_apply . -C1 apply-patches/a.patch
+
+################
+# three-way merge
+################
+git read-tree $C1
+rm -rf apply-work
+git checkout-index -a
+sed -i 's/^10$/TEN/' apply-work/a
+echo "existing" > apply-work/new
+../toy-git add apply-work/a apply-work/new
+save
+compare_apply . apply-patches/a.patch
+compare_apply . --3way apply-patches/a.patch
+compare_apply . -3 -v apply-patches/new.patch
+compare_apply . -3 apply-patches/a.patch apply-patches/b.patch
+( echo x; seq 1 20 ) > apply-work/a
+../toy-git add apply-work/a
+save
+compare_apply . --3way apply-patches/a.patch
+compare_apply . --3way --cached apply-patches/a.patch
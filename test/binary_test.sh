@@ -0,0 +1,115 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf binary-work binary-apply
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+export GIT_AUTHOR_NAME="toy-git" GIT_AUTHOR_EMAIL="toy-git@example.com"
+export GIT_COMMITTER_NAME="toy-git" GIT_COMMITTER_EMAIL="toy-git@example.com"
+
+# run the diff command of toy-git and git, and compare the results
+function compare_diff() {
+  ACTUAL=$( ../toy-git "$@" 2>&1 | od -c )
+  EXPECT=$( git "$@" 2>&1 | od -c )
+  if [[ "$EXPECT" != "$ACTUAL" ]]; then
+    echo "[binary] '$@' failed."
+    echo -e "Expect: \n$( git "$@" 2>&1 | cat -v )"
+    echo -e "Actual: \n$( ../toy-git "$@" 2>&1 | cat -v )"
+    exit 1
+  fi
+}
+
+function commit() {
+  ../toy-git add -A binary-work
+  TREE=$( ../toy-git write-tree )
+  if [[ -z "$COMMIT" ]]; then
+    COMMIT=$( git commit-tree -m "$1" $TREE )
+  else
+    COMMIT=$( git commit-tree -p $COMMIT -m "$1" $TREE )
+  fi
+  git update-ref refs/heads/master $COMMIT
+}
+
+# apply the binary patch of toy-git by git to the tree of the first commit, and compare with the second
+function check_binary_patch() {
+  rm -rf binary-apply
+  mkdir binary-apply
+  ../toy-git diff-tree -r --binary "$@" > binary-apply.patch
+  (
+    cd binary-apply
+    git init -q
+    git -C .. archive --format=tar ${@: -2:1} | tar xf -
+    git add -A .
+    git apply --index ../binary-apply.patch && git write-tree
+  ) > binary-apply.out 2>&1
+  EXPECT=$( git rev-parse ${@: -1}^{tree} )
+  ACTUAL=$( tail -1 binary-apply.out )
+  rm -f binary-apply.patch binary-apply.out
+  rm -rf binary-apply
+  if [[ "$EXPECT" != "$ACTUAL" ]]; then
+    echo "[binary] binary patch of 'diff-tree -r --binary $@' can't be applied."
+    exit 1
+  fi
+}
+
+# create work tree
+mkdir -p binary-work/sub
+head -c 20000 /dev/urandom > binary-work/rand.bin
+printf 'text\0with nul\n' > binary-work/nul.txt
+seq 1 100 > binary-work/forced.dat
+printf 'looks\0binary\n' > binary-work/textattr.c
+printf 'x\0y' > binary-work/gone.bin
+head -c 5000 /dev/urandom > binary-work/big.bin
+cp binary-work/big.bin binary-work/big2.bin
+echo "data" > binary-work/sub/plain.txt
+printf '*.dat binary\n*.c diff\nsub/*.txt -diff\n' > binary-work/.gitattributes
+commit initial
+C1=$COMMIT
+
+################
+# cat-file
+################
+BLOB=$( ../toy-git hash-object -w binary-work/gone.bin )
+if ! cmp -s <( ../toy-git cat-file -p $BLOB ) binary-work/gone.bin; then
+  echo "[binary] 'cat-file -p $BLOB' doesn't print the binary contents."
+  exit 1
+fi
+
+################
+# diff of binary files
+################
+head -c 100 /dev/urandom >> binary-work/rand.bin
+printf 'text\0with nul changed\n' > binary-work/nul.txt
+seq 1 101 > binary-work/forced.dat
+printf 'looks\0binary!\n' > binary-work/textattr.c
+rm binary-work/gone.bin
+printf 'new\0file' > binary-work/new.bin
+echo "data2" > binary-work/sub/plain.txt
+mv binary-work/big2.bin binary-work/moved.bin
+chmod +x binary-work/big.bin
+for OPTS in "" "-p" "--stat" "-p --stat" "--name-status" "-M" "-M --stat" "-M -p"; do
+  compare_diff diff-files $OPTS
+done
+commit binary
+C2=$COMMIT
+for OPTS in "-r" "-p" "--stat" "-M --stat" "-M -p" "-C -p"; do
+  compare_diff diff-tree $OPTS $C1 $C2
+done
+
+################
+# binary patches
+################
+check_binary_patch $C1 $C2
+check_binary_patch -M $C1 $C2
+check_binary_patch $C2 $C1
+check_binary_patch -M $C2 $C1
@@ -0,0 +1,120 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+function compare_index() {
+  ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+  rm -f $REPOSITORY_DIR_NAME/index
+  "$@"
+  EXPECT_LS_FILES_MESSAGE=`git ls-files -s`
+  rm -f $REPOSITORY_DIR_NAME/index
+  if [[ "$EXPECT_LS_FILES_MESSAGE" != "$ACTUAL_LS_FILES_MESSAGE" ]]; then
+    echo "[update-index] '$@' failed."
+    echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+    echo -e "Actual: \n$ACTUAL_LS_FILES_MESSAGE"
+    exit 1
+  fi
+}
+
+function expect_success() {
+  "$@"
+  if [[ $? -ne 0 ]]; then
+    echo "[update-index] '$@' failed."
+    exit 1
+  fi
+}
+
+function expect_failure() {
+  "$@" > /dev/null 2>&1
+  if [[ $? -eq 0 ]]; then
+    echo "[update-index] '$@' must fail."
+    exit 1
+  fi
+}
+
+# objects which don't exist in the work tree
+A_SHA1=$( echo "generated a" | ../toy-git hash-object -w --stdin )
+B_SHA1=$( echo "generated b" | ../toy-git hash-object -w --stdin )
+MISSING_SHA1=$( echo "missing" | git hash-object --stdin )
+
+################
+# --cacheinfo
+################
+expect_success ../toy-git update-index --add --cacheinfo 100644,$A_SHA1,gen/a.txt --cacheinfo 100755,$B_SHA1,gen/b.sh
+compare_index git update-index --add --cacheinfo 100644,$A_SHA1,gen/a.txt --cacheinfo 100755,$B_SHA1,gen/b.sh
+
+# modes are canonicalized
+expect_success ../toy-git update-index --add --cacheinfo 100664,$A_SHA1,a.txt --cacheinfo 120000,$B_SHA1,link
+compare_index git update-index --add --cacheinfo 100664,$A_SHA1,a.txt --cacheinfo 120000,$B_SHA1,link
+
+# replace existing entry
+expect_success ../toy-git update-index --add --cacheinfo 100644,$A_SHA1,a.txt
+expect_success ../toy-git update-index --cacheinfo 100644,$B_SHA1,a.txt
+compare_index git update-index --add --cacheinfo 100644,$B_SHA1,a.txt
+
+expect_failure ../toy-git update-index --add --cacheinfo 040000,$A_SHA1,dir
+expect_failure ../toy-git update-index --add --cacheinfo 100644,$MISSING_SHA1,missing.txt
+expect_failure ../toy-git update-index --add --cacheinfo 100644,$A_SHA1,../outside.txt
+expect_failure ../toy-git update-index --add --cacheinfo 100644,$A_SHA1,$REPOSITORY_DIR_NAME/config
+expect_failure ../toy-git update-index --add --cacheinfo 100644,$A_SHA1
+
+# objects are not verified with --info-only
+expect_success ../toy-git update-index --add --info-only --cacheinfo 100644,$MISSING_SHA1,missing.txt
+compare_index git update-index --add --cacheinfo 100644,$MISSING_SHA1,missing.txt
+
+################
+# --index-info
+################
+INDEX_INFO=$( cat <<INFO
+100644 $A_SHA1	a.txt
+100755 blob $B_SHA1	bin/b.sh
+100644 $A_SHA1 1	conflict.txt
+100644 $B_SHA1 2	conflict.txt
+100644 $A_SHA1 3	conflict.txt
+100644 $A_SHA1	"quoted\tname.txt"
+INFO
+)
+expect_success eval 'echo "$INDEX_INFO" | ../toy-git update-index --index-info'
+compare_index eval "echo \"\$INDEX_INFO\" | git update-index --index-info"
+
+# mode 0 removes all stages of the path
+expect_success eval 'echo "$INDEX_INFO" | ../toy-git update-index --index-info'
+expect_success eval 'printf "0 0000000000000000000000000000000000000000\tconflict.txt\n100644 $B_SHA1 0\tconflict.txt\n" | ../toy-git update-index --index-info'
+compare_index eval "echo \"\$INDEX_INFO\" | git update-index --index-info; printf \"0 0000000000000000000000000000000000000000\tconflict.txt\n100644 $B_SHA1 0\tconflict.txt\n\" | git update-index --index-info"
+
+# NUL terminated lines
+expect_success eval 'printf "100644 $A_SHA1\tnew\nline.txt\0000100644 $B_SHA1\tb.txt\000" | ../toy-git update-index -z --index-info'
+compare_index eval "printf \"100644 $A_SHA1\tnew\nline.txt\0000100644 $B_SHA1\tb.txt\000\" | git update-index -z --index-info"
+
+# paths quoted by git (octal escapes for non-ASCII bytes and C escapes)
+git update-index --add --cacheinfo 100644,$A_SHA1,$'tab\there.txt' --cacheinfo 100644,$B_SHA1,'日本.txt' \
+  --cacheinfo 100644,$A_SHA1,'dq"bs\.txt' --cacheinfo 100644,$B_SHA1,$'bell\a.txt'
+GIT_QUOTED_INFO=$( git ls-files -s )
+rm -f $REPOSITORY_DIR_NAME/index
+expect_success eval 'echo "$GIT_QUOTED_INFO" | ../toy-git update-index --index-info'
+compare_index eval "echo \"\$GIT_QUOTED_INFO\" | git update-index --index-info"
+
+# bytes which are not valid UTF-8 are kept raw in quoted paths with core.quotePath=false
+git update-index --add --cacheinfo 100644,$A_SHA1,$'latin\xe9\ttab.txt'
+GIT_QUOTED_INFO=$( git -c core.quotePath=false ls-files -s )
+rm -f $REPOSITORY_DIR_NAME/index
+expect_success eval 'echo "$GIT_QUOTED_INFO" | ../toy-git update-index --index-info'
+compare_index eval "echo \"\$GIT_QUOTED_INFO\" | git update-index --index-info"
+
+expect_failure eval "echo 'malformed' | ../toy-git update-index --index-info"
+expect_failure eval "printf '100644 $A_SHA1\t\"bad\\\\q.txt\"\n' | ../toy-git update-index --index-info"
+expect_failure eval "printf '100644 $A_SHA1\t\"hex\\\\x41.txt\"\n' | ../toy-git update-index --index-info"
+expect_failure eval "echo '100644 $MISSING_SHA1	missing.txt' | ../toy-git update-index --index-info"
+
+cd - > /dev/null
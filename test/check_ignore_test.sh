@@ -0,0 +1,66 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf ignore-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+# create work tree
+mkdir -p ignore-work/build ignore-work/src
+touch ignore-work/a.o ignore-work/keep.o ignore-work/a.c ignore-work/build/out ignore-work/src/main.o
+
+cat > ignore-work/.gitignore <<'IGNORE'
+*.o
+!keep.o
+/build/
+IGNORE
+echo "!main.o" > ignore-work/src/.gitignore
+printf "\n*.c\n" >> $REPOSITORY_DIR_NAME/info/exclude
+
+../toy-git update-index --add ignore-work/a.o
+
+PATHS="ignore-work/a.o ignore-work/keep.o ignore-work/a.c ignore-work/build ignore-work/build/out ignore-work/src/main.o ignore-work/none.txt"
+
+function compare() {
+  # git reports its repository as .git
+  EXPECT_MESSAGE=$( git check-ignore "$@" )
+  EXPECT_STATUS=$?
+  EXPECT_MESSAGE=$( echo "$EXPECT_MESSAGE" | sed "s|^.git/|$REPOSITORY_DIR_NAME/|" )
+  ACTUAL_MESSAGE=$( ../toy-git check-ignore "$@" )
+  ACTUAL_STATUS=$?
+  if [[ "$EXPECT_MESSAGE" != "$ACTUAL_MESSAGE" || "$EXPECT_STATUS" != "$ACTUAL_STATUS" ]]; then
+    echo "[check-ignore] 'check-ignore $@' failed."
+    echo -e "Expect($EXPECT_STATUS): \n$EXPECT_MESSAGE"
+    echo -e "Actual($ACTUAL_STATUS): \n$ACTUAL_MESSAGE"
+    exit 1
+  fi
+}
+
+compare $PATHS
+compare -v $PATHS
+compare --no-index $PATHS
+compare -v --no-index $PATHS
+compare ignore-work/none.txt
+compare -v ignore-work/keep.o
+
+EXPECT_MESSAGE=$( echo "$PATHS" | tr ' ' '\n' | git check-ignore -v --stdin | sed "s|^.git/|$REPOSITORY_DIR_NAME/|" )
+ACTUAL_MESSAGE=$( echo "$PATHS" | tr ' ' '\n' | ../toy-git check-ignore -v --stdin )
+if [[ "$EXPECT_MESSAGE" != "$ACTUAL_MESSAGE" ]]; then
+  echo "[check-ignore] 'check-ignore -v --stdin' failed."
+  echo -e "Expect: \n$EXPECT_MESSAGE"
+  echo -e "Actual: \n$ACTUAL_MESSAGE"
+  exit 1
+fi
+
+rm -rf ignore-work
+
+cd - > /dev/null
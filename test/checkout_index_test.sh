@@ -0,0 +1,80 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+# create index
+echo "a" > a.txt
+echo "b" > b.txt
+chmod +x b.txt
+../toy-git update-index --add a.txt b.txt
+
+################
+# checkout-index <paths>
+################
+rm a.txt b.txt
+../toy-git checkout-index a.txt b.txt
+
+if [[ "$( cat a.txt )" != "a" ]]; then
+  echo "[checkout-index] 'checkout-index <paths>' did not restore a.txt."
+  exit 1
+fi
+
+if [[ ! -x b.txt ]]; then
+  echo "[checkout-index] 'checkout-index <paths>' did not restore executable bit of b.txt."
+  exit 1
+fi
+
+# stat data should be refreshed
+GIT_DIFF_FILES_MESSAGE=`git diff-files --name-only`
+if [[ "$GIT_DIFF_FILES_MESSAGE" != "" ]]; then
+  echo "[checkout-index] 'checkout-index <paths>' did not refresh stat data."
+  echo -e "Expect: \n"
+  echo -e "Actual: \n$GIT_DIFF_FILES_MESSAGE"
+  exit 1
+fi
+
+################
+# checkout-index without -f
+################
+echo "CHANGED" > a.txt
+../toy-git checkout-index a.txt 2> /dev/null
+
+if [[ "$?" -eq 0 || "$( cat a.txt )" != "CHANGED" ]]; then
+  echo "[checkout-index] 'checkout-index' overwrote modified file without -f."
+  exit 1
+fi
+
+################
+# checkout-index -a -f
+################
+rm b.txt
+../toy-git checkout-index -a -f
+
+if [[ "$( cat a.txt )" != "a" || "$( cat b.txt )" != "b" ]]; then
+  echo "[checkout-index] 'checkout-index -a -f' failed."
+  exit 1
+fi
+
+################
+# checkout-index --prefix
+################
+../toy-git checkout-index -a --prefix=checkout-prefix/
+
+if [[ "$( cat checkout-prefix/a.txt )" != "a" || "$( cat checkout-prefix/b.txt )" != "b" ]]; then
+  echo "[checkout-index] 'checkout-index --prefix' failed."
+  exit 1
+fi
+rm -rf checkout-prefix
+
+cd - > /dev/null
@@ -0,0 +1,126 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf diff-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+export GIT_AUTHOR_NAME="toy-git" GIT_AUTHOR_EMAIL="toy-git@example.com"
+export GIT_COMMITTER_NAME="toy-git" GIT_COMMITTER_EMAIL="toy-git@example.com"
+
+# run the diff command of toy-git and git in the directory, and compare the results
+function compare_diff() {
+  DIR=$1
+  shift
+  ACTUAL=$( cd $DIR && $OLDPWD/../toy-git "$@" 2>&1 | od -c )
+  EXPECT=$( cd $DIR && git "$@" 2>&1 | od -c )
+  if [[ "$EXPECT" != "$ACTUAL" ]]; then
+    echo "[diff] '$@' in '$DIR' failed."
+    echo -e "Expect: \n$( cd $DIR && git "$@" 2>&1 )"
+    echo -e "Actual: \n$( cd $DIR && $OLDPWD/../toy-git "$@" 2>&1 )"
+    exit 1
+  fi
+}
+
+function commit() {
+  ../toy-git add -A diff-work
+  TREE=$( ../toy-git write-tree )
+  if [[ -z "$COMMIT" ]]; then
+    COMMIT=$( git commit-tree -m "$1" $TREE )
+  else
+    COMMIT=$( git commit-tree -p $COMMIT -m "$1" $TREE )
+  fi
+  git update-ref refs/heads/master $COMMIT
+}
+
+# create work tree
+mkdir -p diff-work/dir
+seq 1 30 > diff-work/a.txt
+echo "b" > diff-work/b.txt
+echo "c" > diff-work/dir/c.txt
+printf 'int main() {\n\treturn 0;\n}\n' > diff-work/main.c
+echo "gone" > diff-work/gone.txt
+echo "type" > diff-work/type.txt
+commit initial
+C1=$COMMIT
+
+################
+# diff-files
+################
+sed -i 's/^5$/five/; s/^25$/XXV/' diff-work/a.txt
+echo "c2" >> diff-work/dir/c.txt
+chmod +x diff-work/b.txt
+rm diff-work/gone.txt
+rm diff-work/type.txt
+ln -s a.txt diff-work/type.txt
+printf 'int main() {\n\tint x;\n\treturn 0;\n}\n' > diff-work/main.c
+printf 'no newline' > diff-work/nonl.txt
+../toy-git update-index --add diff-work/nonl.txt
+printf 'no newline!' > diff-work/nonl.txt
+echo "space" > "diff-work/sp ace.txt"
+../toy-git update-index --add "diff-work/sp ace.txt"
+echo "space2" > "diff-work/sp ace.txt"
+for OPTS in "" "-p" "-u" "--stat" "--name-status" "--name-only" "-p --stat" "--raw -p" "--stat --raw" "-U1" "-U0" "--unified=5" "--name-only --stat"; do
+  compare_diff . diff-files $OPTS
+done
+compare_diff . diff-files -p diff-work/dir
+compare_diff diff-work/dir diff-files -p
+compare_diff diff-work/dir diff-files --name-only .
+
+################
+# diff-tree
+################
+commit second
+C2=$COMMIT
+mkdir -p diff-work/e/f
+echo "g" > diff-work/e/f/g.txt
+rm diff-work/dir/c.txt
+commit third
+C3=$COMMIT
+for OPTS in "" "-r" "-t" "-p" "--stat" "--name-status" "-r --name-only" "-p --stat"; do
+  for ARGS in "$C1 $C2" "$C2 $C3" "$C1 $C3" "$C2" "$C3" "$C1 $C3 diff-work/dir" "$C2 $C3 diff-work/e/f/g.txt"; do
+    compare_diff . diff-tree $OPTS $ARGS
+  done
+done
+
+################
+# diff-index
+################
+echo "more" >> diff-work/a.txt
+../toy-git update-index diff-work/a.txt
+echo "more2" >> diff-work/a.txt
+echo "new" > diff-work/new.txt
+../toy-git update-index --add diff-work/new.txt
+../toy-git update-index --force-remove diff-work/b.txt
+for OPTS in "" "-p" "--stat" "--name-status" "--cached" "--cached -p" "--cached --stat" "--cached --name-only"; do
+  for ARGS in "$C3" "$C1" "$C1 diff-work/dir"; do
+    compare_diff . diff-index $OPTS $ARGS
+  done
+done
+
+################
+# diff algorithms
+################
+awk 'BEGIN { srand(1); for (i = 0; i < 600; i++) print "l" int(rand() * 40) }' > diff-work/algo.txt
+commit algo
+awk 'BEGIN { srand(2) } { r = rand(); if (r < 0.1) next; if (r < 0.2) print "n" int(rand() * 40); print } r > 0.95 { print "}" }' diff-work/algo.txt > diff-work/algo.new
+mv diff-work/algo.new diff-work/algo.txt
+for OPTS in "" "--minimal" "--patience" "--histogram" "--diff-algorithm=patience" "--diff-algorithm=histogram" "--no-indent-heuristic" "--histogram -U1"; do
+  compare_diff . diff-files -p $OPTS
+done
+# the configuration of porcelain commands is not used
+git config diff.algorithm histogram
+git config diff.context 1
+compare_diff . diff-files -p
+git config diff.indentHeuristic false
+compare_diff . diff-files -p
+compare_diff . diff-files -p --indent-heuristic
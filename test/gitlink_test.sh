@@ -0,0 +1,134 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf gitlink-work
+rm -f .gitmodules
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+git read-tree --empty
+TOY_GIT=$( pwd )/../toy-git
+STDERR=$( pwd )/stderr.tmp
+GIT_COMMIT="git -c user.name=twinbird -c user.email=ixa2063@gmail.com commit -q"
+GIT_TAG="git -c user.name=twinbird -c user.email=ixa2063@gmail.com tag"
+
+# run the command of toy-git and git from the same index, and compare the outputs and the results
+function compare_index_cmd() {
+  DIR=$1
+  shift
+  cp $REPOSITORY_DIR_NAME/index index.bak
+  # stdout and stderr are compared separately. git buffers stdout
+  ACTUAL_MESSAGE=$( cd $DIR && $TOY_GIT "$@" 2> $STDERR )
+  ACTUAL_STATUS=$?
+  ACTUAL_MESSAGE="$ACTUAL_MESSAGE$( cat $STDERR )"
+  ACTUAL_STATE=$( git ls-files -s )
+  cp index.bak $REPOSITORY_DIR_NAME/index
+  EXPECT_MESSAGE=$( cd $DIR && git "$@" 2> $STDERR )
+  EXPECT_STATUS=$?
+  EXPECT_MESSAGE="$EXPECT_MESSAGE$( cat $STDERR )"
+  EXPECT_STATE=$( git ls-files -s )
+  if [[ "$EXPECT_MESSAGE" != "$ACTUAL_MESSAGE" || "$EXPECT_STATE" != "$ACTUAL_STATE" || "$EXPECT_STATUS" != "$ACTUAL_STATUS" ]]; then
+    echo "[gitlink] '$@' in $DIR failed."
+    echo -e "Expect($EXPECT_STATUS): \n$EXPECT_MESSAGE\n$EXPECT_STATE"
+    echo -e "Actual($ACTUAL_STATUS): \n$ACTUAL_MESSAGE\n$ACTUAL_STATE"
+    exit 1
+  fi
+}
+
+# create work tree with nested repositories
+mkdir -p gitlink-work/dir gitlink-work/sub gitlink-work/empty-repo
+echo "a" > gitlink-work/a.txt
+echo "b" > gitlink-work/dir/b.txt
+( cd gitlink-work/sub && git init -q && echo "s" > s.txt && git add s.txt && $GIT_COMMIT -m "first" )
+( cd gitlink-work/empty-repo && git init -q )
+
+################
+# nested repositories are added as gitlinks
+################
+compare_index_cmd . ls-files -o gitlink-work
+compare_index_cmd . update-index --add gitlink-work/sub
+compare_index_cmd . update-index --add gitlink-work/empty-repo
+compare_index_cmd gitlink-work add -v .
+rm -rf gitlink-work/empty-repo
+compare_index_cmd gitlink-work add -v .
+compare_index_cmd gitlink-work add -n sub
+git add gitlink-work
+compare_index_cmd . ls-files -o gitlink-work
+compare_index_cmd . ls-files -m gitlink-work
+
+# a new commit in the nested repository modifies the gitlink
+( cd gitlink-work/sub && echo "t" > t.txt && git add t.txt && $GIT_COMMIT -m "second" )
+compare_index_cmd . ls-files -m gitlink-work
+compare_index_cmd gitlink-work add -u -v
+compare_index_cmd . update-index gitlink-work/sub
+
+################
+# trees
+################
+TREE=$( git write-tree )
+if [[ $( ../toy-git write-tree ) != "$TREE" ]]; then
+  echo "[write-tree] gitlink must be written into the tree."
+  exit 1
+fi
+compare_index_cmd . ls-tree $TREE
+compare_index_cmd . ls-tree -r $TREE
+compare_index_cmd . ls-tree -r -t $TREE
+compare_index_cmd . ls-tree -d $TREE
+compare_index_cmd . ls-tree -r -d $TREE
+compare_index_cmd . ls-tree -l -r $TREE
+compare_index_cmd . ls-tree --name-only -r $TREE
+compare_index_cmd . ls-tree $TREE gitlink-work
+compare_index_cmd . ls-tree $TREE gitlink-work/
+compare_index_cmd . ls-tree -r $TREE gitlink-work
+compare_index_cmd . ls-tree -t $TREE gitlink-work/dir/b.txt
+compare_index_cmd . ls-tree $TREE gitlink-work/sub gitlink-work/none
+compare_index_cmd gitlink-work ls-tree $TREE
+compare_index_cmd gitlink-work ls-tree -r $TREE ../gitlink-work/dir
+compare_index_cmd . ls-tree none
+
+################
+# checkout-index
+################
+git update-index --add --cacheinfo 160000,$( cd gitlink-work/sub && git rev-parse HEAD ),gitlink-work/sub2
+../toy-git checkout-index gitlink-work/sub2
+if [[ ! -d gitlink-work/sub2 ]]; then
+  echo "[checkout-index] gitlink must be checked out as a directory."
+  exit 1
+fi
+
+################
+# submodule status
+################
+compare_index_cmd . submodule status
+printf '[submodule "sub"]\n\tpath = gitlink-work/sub\n\turl = ./gitlink-work/sub\n[submodule "sub2"]\n\tpath = gitlink-work/sub2\n\turl = ./gitlink-work/sub\n' > .gitmodules
+compare_index_cmd . submodule status
+git config submodule.sub.url ./gitlink-work/sub
+compare_index_cmd . submodule status
+compare_index_cmd gitlink-work submodule status
+compare_index_cmd gitlink-work submodule status sub
+compare_index_cmd . submodule status none
+
+# the nested repository is not at the commit of the index
+( cd gitlink-work/sub && echo "u" > u.txt && git add u.txt && $GIT_COMMIT -m "third" )
+compare_index_cmd . submodule status gitlink-work/sub
+compare_index_cmd . submodule status --cached gitlink-work/sub
+
+# described by tags
+( cd gitlink-work/sub && git tag light HEAD && $GIT_TAG -a -m "annotated" v1 HEAD~2 )
+compare_index_cmd . submodule status gitlink-work/sub
+compare_index_cmd . submodule status --cached gitlink-work/sub
+( cd gitlink-work/sub && git tag -d v1 > /dev/null && $GIT_TAG -a -m "annotated" v2 HEAD )
+compare_index_cmd . submodule status --cached gitlink-work/sub
+( cd gitlink-work/sub && git tag -d v2 light > /dev/null && git checkout -q HEAD~1 )
+compare_index_cmd . submodule status gitlink-work/sub
+
+rm -f .gitmodules stderr.tmp
+cd ..
@@ -28,7 +28,7 @@ if [[ $HASH_OBJECT_MESSAGE != $TEST_TARGET_FILE_SHA1 ]]; then
 fi
 
 # store test-file to git repository
-./toy-git hash-object -w test/test-target-file.txt
+./toy-git hash-object -w test/test-target-file.txt > /dev/null
 
 if [[ ! -e "$REPOSITORY_DIR_NAME/objects/42/39a627fe3921e9beb24954158a9c47fb5683ec" ]]; then
   echo "[hash-object] 'hash-object -w test/test-target-file.txt' does not stored git repository."
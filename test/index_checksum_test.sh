@@ -0,0 +1,110 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+INDEX="$REPOSITORY_DIR_NAME/index"
+
+# rewrite index by python expression 'b' (index bytes without checksum) and append the checksum
+function rewrite_index() {
+  python3 -c "
+import hashlib
+b = open('$INDEX', 'rb').read()[:-20]
+b = $1
+open('$INDEX', 'wb').write(b + hashlib.sha1(b).digest())
+"
+}
+
+# create index
+../toy-git update-index --add test-target-file.txt
+
+################
+# trailing checksum
+################
+EXPECT_CHECKSUM=$( head -c -20 $INDEX | sha1sum | cut -d ' ' -f 1 )
+ACTUAL_CHECKSUM=$( tail -c 20 $INDEX | od -An -tx1 | tr -d ' \n' )
+if [[ "$EXPECT_CHECKSUM" != "$ACTUAL_CHECKSUM" ]]; then
+  echo "[index-checksum] trailing checksum is wrong."
+  echo "Expect: $EXPECT_CHECKSUM"
+  echo "Actual: $ACTUAL_CHECKSUM"
+  exit 1
+fi
+
+git fsck --no-dangling > /dev/null 2>&1
+if [[ "$?" -ne 0 ]]; then
+  echo "[index-checksum] 'git fsck' rejected the index."
+  exit 1
+fi
+
+################
+# corrupted index
+################
+cp $INDEX index.bak
+python3 -c "
+b = bytearray(open('$INDEX', 'rb').read())
+b[20] ^= 0xff
+open('$INDEX', 'wb').write(b)
+"
+MESSAGE=$( ../toy-git ls-files 2>&1 )
+if [[ "$?" -eq 0 || "$MESSAGE" != *"sha1 signature"* ]]; then
+  echo "[index-checksum] corrupted index was accepted."
+  echo "Actual: $MESSAGE"
+  exit 1
+fi
+cp index.bak $INDEX
+
+################
+# bad signature and version
+################
+rewrite_index "b'DIRX' + b[4:]"
+MESSAGE=$( ../toy-git ls-files 2>&1 )
+if [[ "$?" -eq 0 || "$MESSAGE" != *"bad signature"* ]]; then
+  echo "[index-checksum] bad signature was accepted."
+  echo "Actual: $MESSAGE"
+  exit 1
+fi
+cp index.bak $INDEX
+
+rewrite_index "b[:4] + bytes([0, 0, 0, 9]) + b[8:]"
+MESSAGE=$( ../toy-git ls-files 2>&1 )
+if [[ "$?" -eq 0 || "$MESSAGE" != *"bad index version 9"* ]]; then
+  echo "[index-checksum] bad version was accepted."
+  echo "Actual: $MESSAGE"
+  exit 1
+fi
+cp index.bak $INDEX
+
+################
+# extensions
+################
+rewrite_index "b + b'ZZZZ' + bytes([0, 0, 0, 4]) + b'test'"
+touch a.txt
+../toy-git update-index --add a.txt
+
+if [[ "$( grep -c ZZZZtest <( tr -d '\000\004' < $INDEX ) )" != "1" ]]; then
+  echo "[index-checksum] optional extension was not preserved."
+  exit 1
+fi
+
+cp index.bak $INDEX
+rewrite_index "b + b'zzzz' + bytes([0, 0, 0, 4]) + b'test'"
+MESSAGE=$( ../toy-git ls-files 2>&1 )
+if [[ "$?" -eq 0 || "$MESSAGE" != *"do not understand"* ]]; then
+  echo "[index-checksum] unknown mandatory extension was accepted."
+  echo "Actual: $MESSAGE"
+  exit 1
+fi
+
+rm -f index.bak
+
+cd - > /dev/null
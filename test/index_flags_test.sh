@@ -0,0 +1,115 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf flags-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+function index_state() {
+  # index version and entries with tags
+  od -A n -t u1 -j 7 -N 1 $REPOSITORY_DIR_NAME/index
+  git ls-files -s -v
+}
+
+# run update-index of toy-git and git from the same index, and compare the results
+function compare_update_index() {
+  cp $REPOSITORY_DIR_NAME/index index.bak
+  ../toy-git update-index "$@" > /dev/null 2>&1
+  ACTUAL_STATUS=$?
+  ACTUAL_STATE=$( index_state )
+  cp index.bak $REPOSITORY_DIR_NAME/index
+  git update-index "$@" > /dev/null 2>&1
+  EXPECT_STATUS=$?
+  EXPECT_STATE=$( index_state )
+  if [[ "$EXPECT_STATE" != "$ACTUAL_STATE" || "$EXPECT_STATUS" != "$ACTUAL_STATUS" ]]; then
+    echo "[update-index] 'update-index $@' failed."
+    echo -e "Expect($EXPECT_STATUS): \n$EXPECT_STATE"
+    echo -e "Actual($ACTUAL_STATUS): \n$ACTUAL_STATE"
+    exit 1
+  fi
+}
+
+function compare_ls_files() {
+  # git sees .toy-git as an untracked directory
+  EXPECT_LS_FILES_MESSAGE=$( git ls-files "$@" | grep -v "$REPOSITORY_DIR_NAME/" )
+  LS_FILES_MESSAGE=$( ../toy-git ls-files "$@" | grep -v "$REPOSITORY_DIR_NAME/" )
+  if [[ "$EXPECT_LS_FILES_MESSAGE" != "$LS_FILES_MESSAGE" ]]; then
+    echo "[ls-files] 'ls-files $@' failed."
+    echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+    echo -e "Actual: \n$LS_FILES_MESSAGE"
+    exit 1
+  fi
+}
+
+# create work tree
+mkdir flags-work
+echo "a" > flags-work/a.txt
+echo "b" > flags-work/b.txt
+echo "c" > flags-work/c.sh
+echo "d" > flags-work/d.txt
+../toy-git update-index --add flags-work/a.txt flags-work/b.txt flags-work/c.sh flags-work/d.txt
+git update-index --refresh > /dev/null
+
+################
+# --assume-unchanged
+################
+compare_update_index --assume-unchanged flags-work/a.txt
+compare_update_index --assume-unchanged flags-work/none.txt
+compare_ls_files -v
+echo "modified" > flags-work/a.txt
+compare_ls_files -m
+compare_ls_files -t -m
+compare_update_index --no-assume-unchanged flags-work/a.txt
+compare_ls_files -m
+
+################
+# --skip-worktree (version 3)
+################
+compare_update_index --skip-worktree flags-work/b.txt
+compare_ls_files -t
+compare_ls_files -v
+rm flags-work/b.txt
+compare_ls_files -d -t
+# the work tree file of a skip-worktree entry is not added again
+echo "changed" > flags-work/b.txt
+compare_update_index flags-work/b.txt
+compare_update_index --add flags-work/b.txt
+compare_update_index --chmod=+x flags-work/b.txt
+compare_update_index --remove flags-work/b.txt
+rm flags-work/b.txt
+../toy-git checkout-index -a -f
+if [[ -e flags-work/b.txt ]]; then
+  echo "[checkout-index] 'checkout-index -a' must skip skip-worktree entries."
+  exit 1
+fi
+echo "b" > flags-work/b.txt
+compare_update_index --no-skip-worktree flags-work/b.txt
+
+################
+# --chmod
+################
+compare_update_index --chmod=+x flags-work/c.sh
+compare_update_index --chmod=+x flags-work/none.txt
+../toy-git update-index --chmod=+x flags-work/c.sh
+compare_update_index --chmod=-x flags-work/c.sh
+compare_update_index --add --chmod=+x flags-work/c.sh flags-work/a.txt
+
+################
+# --force-remove
+################
+compare_update_index --force-remove flags-work/d.txt
+compare_update_index --force-remove flags-work/d.txt flags-work/none.txt
+compare_ls_files -t -c -o -d -m
+
+rm -rf flags-work index.bak
+
+cd - > /dev/null
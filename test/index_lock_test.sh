@@ -0,0 +1,137 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf lock-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+mkdir -p lock-work
+for i in $(seq 1 40); do
+  echo "$i" > lock-work/$i.txt
+done
+
+################
+# update-index fails while index.lock exists
+################
+../toy-git update-index --add lock-work/1.txt
+cp $REPOSITORY_DIR_NAME/index index.bak
+touch $REPOSITORY_DIR_NAME/index.lock
+
+../toy-git update-index --add lock-work/2.txt 2> stderr.tmp
+STATUS=$?
+if [[ "$STATUS" -ne 128 ]]; then
+  echo "[index-lock] 'update-index' should fail with 128 while index.lock exists."
+  echo -e "Actual: $STATUS"
+  exit 1
+fi
+if ! grep -q "Another toy-git process seems to be running" stderr.tmp; then
+  echo "[index-lock] 'update-index' did not report the lock."
+  echo -e "Actual: \n$( cat stderr.tmp )"
+  exit 1
+fi
+if ! cmp -s index.bak $REPOSITORY_DIR_NAME/index; then
+  echo "[index-lock] the index was changed while index.lock exists."
+  exit 1
+fi
+if [[ ! -e $REPOSITORY_DIR_NAME/index.lock ]]; then
+  echo "[index-lock] index.lock held by another process was removed."
+  exit 1
+fi
+
+################
+# other commands which update the index fail as well
+################
+for CMD in "add lock-work/2.txt" "read-tree --empty" "checkout-index -a"; do
+  ../toy-git $CMD > /dev/null 2> stderr.tmp
+  STATUS=$?
+  if [[ "$STATUS" -ne 128 ]] || ! grep -q "index.lock': File exists" stderr.tmp; then
+    echo "[index-lock] '$CMD' should fail while index.lock exists."
+    echo -e "Actual($STATUS): \n$( cat stderr.tmp )"
+    exit 1
+  fi
+done
+
+# reading the index is not locked
+LS_FILES_MESSAGE=$( ../toy-git ls-files )
+if [[ "$LS_FILES_MESSAGE" != "lock-work/1.txt" ]]; then
+  echo "[index-lock] 'ls-files' failed while index.lock exists."
+  echo -e "Expect: \nlock-work/1.txt"
+  echo -e "Actual: \n$LS_FILES_MESSAGE"
+  exit 1
+fi
+rm -f $REPOSITORY_DIR_NAME/index.lock
+
+################
+# index.lock is removed after success and failure
+################
+../toy-git update-index --add lock-work/2.txt
+if [[ -e $REPOSITORY_DIR_NAME/index.lock ]]; then
+  echo "[index-lock] index.lock was left after 'update-index'."
+  exit 1
+fi
+../toy-git update-index lock-work/no-such-file.txt > /dev/null 2>&1
+if [[ -e $REPOSITORY_DIR_NAME/index.lock ]]; then
+  echo "[index-lock] index.lock was left after failed 'update-index'."
+  exit 1
+fi
+../toy-git add --dry-run lock-work/3.txt > /dev/null 2>&1
+if [[ -e $REPOSITORY_DIR_NAME/index.lock ]]; then
+  echo "[index-lock] index.lock was left after 'add --dry-run'."
+  exit 1
+fi
+for CMD in "ls-files -o :(bogus)x" "ls-files -o --exclude-from=/nonexistent"; do
+  ../toy-git $CMD > /dev/null 2>&1
+  if [[ "$?" -ne 128 ]]; then
+    echo "[index-lock] '$CMD' should fail."
+    exit 1
+  fi
+  if [[ -e $REPOSITORY_DIR_NAME/index.lock ]]; then
+    echo "[index-lock] index.lock was left after failed '$CMD'."
+    exit 1
+  fi
+done
+
+################
+# parallel update-index never corrupts the index
+################
+git read-tree --empty
+for i in $(seq 1 40); do
+  ../toy-git update-index --add lock-work/$i.txt 2> /dev/null &
+done
+wait
+
+git fsck --no-dangling > /dev/null 2> stderr.tmp
+if [[ "$?" -ne 0 ]] || ! git ls-files -s > /dev/null 2>> stderr.tmp; then
+  echo "[index-lock] the index was corrupted by parallel 'update-index'."
+  echo -e "Actual: \n$( cat stderr.tmp )"
+  exit 1
+fi
+if [[ -e $REPOSITORY_DIR_NAME/index.lock ]]; then
+  echo "[index-lock] index.lock was left after parallel 'update-index'."
+  exit 1
+fi
+
+# entries added by processes which took the lock are never lost
+for i in $(seq 1 40); do
+  until ../toy-git update-index --add lock-work/$i.txt 2> /dev/null; do
+    sleep 0.01
+  done &
+done
+wait
+
+LS_FILES_MESSAGE=$( git ls-files lock-work | wc -l )
+if [[ "$LS_FILES_MESSAGE" -ne 40 ]]; then
+  echo "[index-lock] entries were lost by parallel 'update-index'."
+  echo -e "Expect: \n40"
+  echo -e "Actual: \n$LS_FILES_MESSAGE"
+  exit 1
+fi
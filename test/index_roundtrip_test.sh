@@ -0,0 +1,142 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf fuzz
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+INDEX="$REPOSITORY_DIR_NAME/index"
+GIT_INDEX="$PWD/index.git"
+
+function random_name() {
+  local chars="abcdefghijklmnopqrstuvwxyz0123456789._-"
+  local name="f"
+  for (( k = 0; k < $(( RANDOM % $1 + 1 )); k++ )); do
+    name="$name${chars:$(( RANDOM % ${#chars} )):1}"
+  done
+  echo $name
+}
+
+################
+# random index round trip
+################
+for SEED in 1 2 3 4 5; do
+  RANDOM=$SEED
+  rm -rf fuzz $INDEX $GIT_INDEX
+  mkdir fuzz
+
+  FILES=""
+  for (( i = 0; i < 30; i++ )); do
+    p="fuzz"
+    for (( k = 0; k < $(( RANDOM % 4 )); k++ )); do
+      p="$p/$( random_name 10 )"
+    done
+    mkdir -p $p
+    p="$p/$( random_name 60 )"
+    if [[ -d $p ]]; then
+      continue
+    fi
+    echo "$RANDOM $p" > $p
+    if (( RANDOM % 2 )); then
+      chmod +x $p
+    fi
+    FILES="$FILES $p"
+  done
+
+  ../toy-git update-index --add $FILES
+  GIT_INDEX_FILE=$GIT_INDEX git update-index --add $FILES
+
+  # same bytes as git
+  cmp -s $INDEX $GIT_INDEX
+  if [[ "$?" -ne 0 ]]; then
+    echo "[index-roundtrip] index differs from git's index. (seed: $SEED)"
+    exit 1
+  fi
+
+  # read and write
+  cp $INDEX index.bak
+  ../toy-git update-index --index-version 2
+  if [[ "$?" -ne 0 ]]; then
+    echo "[index-roundtrip] reading index failed. (seed: $SEED)"
+    exit 1
+  fi
+  cmp -s $INDEX index.bak
+  if [[ "$?" -ne 0 ]]; then
+    echo "[index-roundtrip] index changed after read and write. (seed: $SEED)"
+    exit 1
+  fi
+
+  # version 4
+  ../toy-git update-index --index-version 4
+  GIT_INDEX_FILE=$GIT_INDEX git update-index --index-version 4
+  cmp -s $INDEX $GIT_INDEX
+  if [[ "$?" -ne 0 ]]; then
+    echo "[index-roundtrip] index version 4 differs from git's index. (seed: $SEED)"
+    exit 1
+  fi
+
+  cp $INDEX index.bak
+  ../toy-git update-index --index-version 4
+  cmp -s $INDEX index.bak
+  if [[ "$?" -ne 0 ]]; then
+    echo "[index-roundtrip] index version 4 changed after read and write. (seed: $SEED)"
+    exit 1
+  fi
+done
+rm -rf fuzz
+
+################
+# path name longer than 0xFFF
+################
+BLOB_SHA1=$( echo "long" | git hash-object -w --stdin )
+LONG_NAME=$( printf 'l%.0s' {1..250} )
+TREE_SHA1=$( printf "100644 blob $BLOB_SHA1\t$LONG_NAME\n100644 blob $BLOB_SHA1\tshort\n" | git mktree )
+for (( i = 0; i < 20; i++ )); do
+  TREE_SHA1=$( printf "100644 blob $BLOB_SHA1\t$LONG_NAME\n040000 tree $TREE_SHA1\t$LONG_NAME.$i\n" | git mktree )
+done
+
+rm -f $INDEX $GIT_INDEX
+../toy-git read-tree $TREE_SHA1
+GIT_INDEX_FILE=$GIT_INDEX git read-tree $TREE_SHA1
+
+EXPECT_LS_FILES_MESSAGE=`GIT_INDEX_FILE=$GIT_INDEX git ls-files -s`
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+if [[ "$EXPECT_LS_FILES_MESSAGE" != "$ACTUAL_LS_FILES_MESSAGE" ]]; then
+  echo "[index-roundtrip] index with long path name is broken."
+  exit 1
+fi
+
+for VERSION in 2 4; do
+  ../toy-git update-index --index-version $VERSION
+  if [[ "$?" -ne 0 ]]; then
+    echo "[index-roundtrip] reading index version $VERSION with long path name failed."
+    exit 1
+  fi
+
+  cp $INDEX index.bak
+  ../toy-git update-index --index-version $VERSION
+  cmp -s $INDEX index.bak
+  if [[ "$?" -ne 0 ]]; then
+    echo "[index-roundtrip] index version $VERSION with long path name changed after read and write."
+    exit 1
+  fi
+
+  ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+  if [[ "$EXPECT_LS_FILES_MESSAGE" != "$ACTUAL_LS_FILES_MESSAGE" ]]; then
+    echo "[index-roundtrip] index version $VERSION with long path name is broken."
+    exit 1
+  fi
+done
+
+rm -f index.bak $GIT_INDEX
+
+cd - > /dev/null
@@ -0,0 +1,102 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf update-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+function compare_index() {
+  if [[ "$EXPECT_LS_FILES_MESSAGE" != "$ACTUAL_LS_FILES_MESSAGE" ]]; then
+    echo "[update-index] '$1' failed."
+    echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+    echo -e "Actual: \n$ACTUAL_LS_FILES_MESSAGE"
+    exit 1
+  fi
+}
+
+# create many files. they are added in random order
+mkdir -p update-work/a update-work/b
+for i in $( seq 1 500 ); do
+  echo "$i" > update-work/a/$i.txt
+  echo "$i" > update-work/b/$i
+done
+FILES=$( ls -d update-work/a/* update-work/b/* | shuf --random-source=<(yes) )
+../toy-git update-index --add $FILES
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+rm -f $REPOSITORY_DIR_NAME/index
+git update-index --add $FILES
+EXPECT_LS_FILES_MESSAGE=`git ls-files -s`
+compare_index "update-index --add <files in random order>"
+
+# re-adding modified files replaces entries
+echo "modified" > update-work/a/1.txt
+echo "modified" > update-work/b/250
+../toy-git update-index update-work/b/250 update-work/a/1.txt update-work/b/250
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+git update-index update-work/b/250 update-work/a/1.txt
+EXPECT_LS_FILES_MESSAGE=`git ls-files -s`
+compare_index "update-index <modified files>"
+
+# add and remove in the middle of the index
+echo "new" > update-work/a/0.txt
+echo "new" > update-work/b/0
+../toy-git update-index --add update-work/b/0 update-work/a/0.txt
+rm update-work/a/100.txt update-work/b/100
+../toy-git update-index --remove update-work/a/100.txt update-work/b/100
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+git update-index --add update-work/b/0 update-work/a/0.txt
+git update-index --remove update-work/a/100.txt update-work/b/100
+EXPECT_LS_FILES_MESSAGE=`git ls-files -s`
+compare_index "update-index --add/--remove <files in the middle>"
+
+# a merged entry replaces unmerged entries
+../toy-git read-tree --empty
+echo "base" > update-work/c.txt
+../toy-git update-index --add update-work/c.txt
+BASE_SHA1=`../toy-git write-tree`
+echo "head" > update-work/c.txt
+../toy-git update-index update-work/c.txt
+HEAD_SHA1=`../toy-git write-tree`
+echo "remote" > update-work/c.txt
+../toy-git update-index update-work/c.txt
+REMOTE_SHA1=`../toy-git write-tree`
+echo "head" > update-work/c.txt
+../toy-git read-tree $HEAD_SHA1
+../toy-git read-tree -m $BASE_SHA1 $HEAD_SHA1 $REMOTE_SHA1
+echo "resolved" > update-work/c.txt
+../toy-git update-index update-work/c.txt
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+echo "head" > update-work/c.txt
+git read-tree $HEAD_SHA1
+git update-index --refresh > /dev/null
+git read-tree -m $BASE_SHA1 $HEAD_SHA1 $REMOTE_SHA1
+echo "resolved" > update-work/c.txt
+git update-index update-work/c.txt
+EXPECT_LS_FILES_MESSAGE=`git ls-files -s`
+compare_index "update-index <unmerged file>"
+
+# many paths are resolved in O(n log n). it took about 20 seconds when each path was checked against all pathspecs
+mkdir -p update-work/many
+( cd update-work/many && seq 1 100000 | xargs touch )
+../toy-git read-tree --empty
+( cd update-work/many && timeout 10 ../../../toy-git update-index --add --info-only $( seq 1 100000 ) )
+if [[ "$?" -ne 0 ]]; then
+  echo "[update-index] 'update-index --add --info-only <100000 files>' is too slow."
+  exit 1
+fi
+ACTUAL_LS_FILES_MESSAGE=`git ls-files update-work/many | wc -l`
+EXPECT_LS_FILES_MESSAGE=100000
+compare_index "update-index --add --info-only <100000 files>"
+
+rm -rf update-work
+
+cd - > /dev/null
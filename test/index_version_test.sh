@@ -0,0 +1,99 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+function index_version() {
+  echo $( od -An -tu1 -j7 -N1 $REPOSITORY_DIR_NAME/index )
+}
+
+# create index
+touch a.txt
+../toy-git update-index --add a.txt test-target-dir/test-target-file-nested.txt test-target-file.txt
+EXPECT_LS_FILES_MESSAGE=`git ls-files -s`
+
+################
+# update-index --index-version 4
+################
+../toy-git update-index --index-version 4
+
+if [[ "$( index_version )" != "4" ]]; then
+  echo "[index-version] 'update-index --index-version 4' did not write version 4."
+  echo "Actual: $( index_version )"
+  exit 1
+fi
+
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+if [[ "$EXPECT_LS_FILES_MESSAGE" != "$ACTUAL_LS_FILES_MESSAGE" ]]; then
+  echo "[index-version] index version 4 written by toy-git is broken."
+  echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+  echo -e "Actual: \n$ACTUAL_LS_FILES_MESSAGE"
+  exit 1
+fi
+
+################
+# read index version 4 written by git
+################
+touch b.txt
+git update-index --add b.txt
+
+EXPECT_LS_FILES_MESSAGE=`git ls-files`
+LS_FILES_MESSAGE=`../toy-git ls-files`
+if [[ "$EXPECT_LS_FILES_MESSAGE" != "$LS_FILES_MESSAGE" ]]; then
+  echo "[index-version] reading index version 4 written by git failed."
+  echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+  echo -e "Actual: \n$LS_FILES_MESSAGE"
+  exit 1
+fi
+
+################
+# version 3 extended flags
+################
+git update-index --index-version 2
+git update-index --skip-worktree a.txt
+touch c.txt
+../toy-git update-index --add c.txt
+
+if [[ "$( index_version )" != "3" ]]; then
+  echo "[index-version] extended flags did not write version 3."
+  echo "Actual: $( index_version )"
+  exit 1
+fi
+
+EXPECT_LS_FILES_MESSAGE="S a.txt
+H b.txt
+H c.txt
+H test-target-dir/test-target-file-nested.txt
+H test-target-file.txt"
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -t`
+if [[ "$EXPECT_LS_FILES_MESSAGE" != "$ACTUAL_LS_FILES_MESSAGE" ]]; then
+  echo "[index-version] skip-worktree flag was not preserved."
+  echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+  echo -e "Actual: \n$ACTUAL_LS_FILES_MESSAGE"
+  exit 1
+fi
+
+################
+# index.version config
+################
+git config index.version 4
+rm $REPOSITORY_DIR_NAME/index
+../toy-git update-index --add a.txt
+
+if [[ "$( index_version )" != "4" ]]; then
+  echo "[index-version] 'index.version' config was ignored."
+  echo "Actual: $( index_version )"
+  exit 1
+fi
+
+cd - > /dev/null
@@ -0,0 +1,65 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf ignore-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+# create work tree
+mkdir -p ignore-work/build/sub ignore-work/src/deep/logs ignore-work/doc ignore-work/logs
+touch ignore-work/a.o ignore-work/keep.o ignore-work/a.c ignore-work/a.txt
+touch ignore-work/build/out ignore-work/build/sub/out
+touch ignore-work/src/main.c ignore-work/src/main.o ignore-work/src/deep/x.tmp ignore-work/src/deep/logs/1.log
+touch ignore-work/doc/a.pdf ignore-work/doc/b.pdf ignore-work/doc/c.txt ignore-work/logs/2.log
+touch "ignore-work/trailing space "
+
+cat > ignore-work/.gitignore <<'IGNORE'
+# comment
+*.o
+!keep.o
+/build/
+**/logs/**
+doc/*.pdf
+trailing\ space\ 
+IGNORE
+cat > ignore-work/src/.gitignore <<'IGNORE'
+!main.o
+deep/*.tmp
+IGNORE
+echo "a.txt" > exclude-list.txt
+printf "\n*.c\n" >> $REPOSITORY_DIR_NAME/info/exclude
+
+../toy-git update-index --add ignore-work/a.o ignore-work/a.c
+
+function compare() {
+  # git sees .toy-git as an untracked directory
+  EXPECT_LS_FILES_MESSAGE=$( git ls-files "$@" | grep -v "^$REPOSITORY_DIR_NAME/" )
+  LS_FILES_MESSAGE=$( ../toy-git ls-files "$@" )
+  if [[ "$EXPECT_LS_FILES_MESSAGE" != "$LS_FILES_MESSAGE" ]]; then
+    echo "[ls-files] 'ls-files $@' failed."
+    echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+    echo -e "Actual: \n$LS_FILES_MESSAGE"
+    exit 1
+  fi
+}
+
+compare -o
+compare -o --exclude-standard
+compare -o -i --exclude-standard
+compare -c -i --exclude-standard
+compare -o --exclude=*.pdf --exclude=ignore-work/build
+compare -o --exclude-from=exclude-list.txt --exclude-per-directory=.gitignore
+compare -o -i --exclude=!a.txt --exclude-from=exclude-list.txt
+
+rm -rf ignore-work exclude-list.txt
+
+cd - > /dev/null
@@ -0,0 +1,105 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf stage-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+function compare() {
+  # NUL characters can't be kept in shell variables
+  EXPECT_LS_FILES_MESSAGE=$( git ls-files "$@" | od -c )
+  LS_FILES_MESSAGE=$( ../toy-git ls-files "$@" | od -c )
+  if [[ "$EXPECT_LS_FILES_MESSAGE" != "$LS_FILES_MESSAGE" ]]; then
+    echo "[ls-files] 'ls-files $@' failed."
+    echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+    echo -e "Actual: \n$LS_FILES_MESSAGE"
+    exit 1
+  fi
+}
+
+# create work tree
+mkdir -p stage-work/sub/deep
+echo "a" > stage-work/a.txt
+echo "b" > stage-work/b.c
+echo "c" > stage-work/sub/c.txt
+echo "d" > stage-work/sub/deep/d.c
+chmod +x stage-work/b.c
+../toy-git update-index --add stage-work/a.txt stage-work/b.c stage-work/sub/c.txt stage-work/sub/deep/d.c
+
+compare -s
+compare --stage
+compare -z
+compare -s -z
+compare --debug
+compare -s --debug -z
+compare -s stage-work/sub
+compare -s stage-work/sub/
+compare stage-work/a.txt stage-work/sub/deep
+compare -s "*.c"
+compare "stage-work/*/c.txt"
+compare -s none
+
+# paths with special characters are quoted unless -z is given
+mkdir -p stage-work/quote
+echo "t" > stage-work/quote/$'tab\there'
+echo "n" > stage-work/quote/$'new\nline'
+echo "q" > stage-work/quote/'dq"bs\'
+echo "u" > stage-work/quote/'日本'
+../toy-git update-index --add stage-work/quote/*
+echo "o" > stage-work/quote/$'other\tfile'
+compare stage-work/quote
+compare -s -t stage-work/quote
+compare -z stage-work/quote
+compare -o stage-work/quote
+compare -o -z stage-work/quote
+git config core.quotePath false
+compare -s stage-work/quote
+compare -o stage-work/quote
+git config --unset core.quotePath
+EXPECT_LS_FILES_MESSAGE=$( cd stage-work/quote && git ls-files -s )
+LS_FILES_MESSAGE=$( cd stage-work/quote && ../../../toy-git ls-files -s )
+if [[ "$EXPECT_LS_FILES_MESSAGE" != "$LS_FILES_MESSAGE" ]]; then
+  echo "[ls-files] 'ls-files -s' in a sub directory failed."
+  echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+  echo -e "Actual: \n$LS_FILES_MESSAGE"
+  exit 1
+fi
+../toy-git update-index --force-remove stage-work/quote/*
+
+# unmerged entries
+echo "base" > stage-work/a.txt
+../toy-git read-tree --empty
+../toy-git update-index --add stage-work/a.txt stage-work/b.c
+BASE_SHA1=`../toy-git write-tree`
+echo "head" > stage-work/a.txt
+../toy-git read-tree --empty
+../toy-git update-index --add stage-work/a.txt stage-work/b.c
+HEAD_SHA1=`../toy-git write-tree`
+echo "remote" > stage-work/a.txt
+../toy-git read-tree --empty
+../toy-git update-index --add stage-work/a.txt stage-work/b.c
+REMOTE_SHA1=`../toy-git write-tree`
+echo "head" > stage-work/a.txt
+../toy-git read-tree $HEAD_SHA1
+../toy-git read-tree -m $BASE_SHA1 $HEAD_SHA1 $REMOTE_SHA1
+
+compare -u
+compare --unmerged
+compare -s
+compare -c
+compare -u -z stage-work/a.txt
+compare -u stage-work/b.c
+compare --debug -u
+
+rm -rf stage-work
+
+cd - > /dev/null
@@ -0,0 +1,76 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf pathspec-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+function compare() {
+  EXPECT_LS_FILES_MESSAGE=$( git ls-files "$@" 2>&1 )
+  LS_FILES_MESSAGE=$( ../toy-git ls-files "$@" 2>&1 )
+  if [[ "$EXPECT_LS_FILES_MESSAGE" != "$LS_FILES_MESSAGE" ]]; then
+    echo "[pathspec] 'ls-files $@' failed."
+    echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+    echo -e "Actual: \n$LS_FILES_MESSAGE"
+    exit 1
+  fi
+}
+
+# create work tree
+mkdir -p pathspec-work/src/lib pathspec-work/Doc
+echo "a" > pathspec-work/a.c
+echo "b" > pathspec-work/B.C
+echo "c" > pathspec-work/src/main.c
+echo "d" > pathspec-work/src/lib/util.c
+echo "e" > pathspec-work/src/lib/util.h
+echo "f" > pathspec-work/Doc/readme.txt
+echo "g" > "pathspec-work/star*.txt"
+echo "h" > pathspec-work/starfish.txt
+../toy-git update-index --add pathspec-work/a.c pathspec-work/B.C pathspec-work/src/main.c pathspec-work/src/lib/util.c \
+  pathspec-work/src/lib/util.h pathspec-work/Doc/readme.txt "pathspec-work/star*.txt" pathspec-work/starfish.txt
+
+compare pathspec-work/src
+compare pathspec-work/src/
+compare ./pathspec-work/src/../Doc
+compare "*.c"
+compare "pathspec-work/*.c"
+compare "pathspec-work/src/*/util.?"
+compare ":(glob)pathspec-work/*.c"
+compare ":(glob)pathspec-work/**/*.c"
+compare ":(icase)pathspec-work/*.c"
+compare ":(icase)pathspec-work/doc"
+compare ":(literal)pathspec-work/star*.txt"
+compare "pathspec-work/star*.txt"
+compare ":(top)pathspec-work/a.c"
+compare ":/pathspec-work/Doc"
+compare pathspec-work ":(exclude)*.c"
+compare pathspec-work ":!pathspec-work/src" ":^pathspec-work/Doc"
+compare ":!*.c"
+compare ":(exclude,icase)*.c"
+compare -s ":(glob,icase)pathspec-work/*/*.C" pathspec-work/Doc
+
+# update-index expands pathspecs to entries in the index
+rm pathspec-work/a.c pathspec-work/B.C pathspec-work/src/main.c pathspec-work/src/lib/util.c
+../toy-git update-index --remove ":(glob)pathspec-work/**/*.c" ":!pathspec-work/src/main.c"
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+git update-index --remove pathspec-work/a.c pathspec-work/src/lib/util.c
+EXPECT_LS_FILES_MESSAGE=`git ls-files -s`
+if [[ "$EXPECT_LS_FILES_MESSAGE" != "$ACTUAL_LS_FILES_MESSAGE" ]]; then
+  echo "[pathspec] 'update-index <pathspec>' failed."
+  echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+  echo -e "Actual: \n$ACTUAL_LS_FILES_MESSAGE"
+  exit 1
+fi
+
+rm -rf pathspec-work
+
+cd - > /dev/null
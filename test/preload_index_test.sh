@@ -0,0 +1,90 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf preload-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+function compare() {
+  if [[ "$1" != "$2" ]]; then
+    echo "[preload-index] $3 failed."
+    echo -e "Expect: \n$1"
+    echo -e "Actual: \n$2"
+    exit 1
+  fi
+}
+
+# create work tree
+mkdir -p preload-work/a preload-work/b/c
+for i in $(seq 1 300); do
+  echo "file $i" > preload-work/a/$i.txt
+  echo "file $i" > preload-work/b/c/$i.txt
+done
+echo "same" > preload-work/same1.txt
+echo "same" > preload-work/same2.txt
+ln -s a/1.txt preload-work/link
+
+for PRELOAD in true false; do
+  git config core.preloadIndex $PRELOAD
+  git read-tree --empty
+
+  ################
+  # update-index --add with many files
+  ################
+  ../toy-git update-index --add $( cd preload-work && find . -type f -o -type l | sed 's|^\./|preload-work/|' | sort -R )
+  ACTUAL=$( git ls-files -s )
+  git read-tree --empty
+  git update-index --add $( cd preload-work && find . -type f -o -type l | sed 's|^\./|preload-work/|' )
+  EXPECT=$( git ls-files -s )
+  compare "$EXPECT" "$ACTUAL" "'update-index --add' (core.preloadIndex=$PRELOAD)"
+
+  git fsck --no-dangling 2>&1 | grep -v "^notice:" > stderr.tmp
+  compare "" "$( cat stderr.tmp )" "'fsck' after 'update-index --add' (core.preloadIndex=$PRELOAD)"
+
+  ################
+  # update-index --add with directories and add
+  ################
+  git read-tree --empty
+  ../toy-git update-index --add preload-work
+  compare "$EXPECT" "$( git ls-files -s )" "'update-index --add <dir>' (core.preloadIndex=$PRELOAD)"
+
+  git read-tree --empty
+  ../toy-git add preload-work
+  compare "$EXPECT" "$( git ls-files -s )" "'add' (core.preloadIndex=$PRELOAD)"
+
+  ################
+  # ls-files -m -d and update-index --refresh report in order
+  ################
+  sleep 1
+  for i in 5 50 150 299; do
+    echo "modified" >> preload-work/a/$i.txt
+  done
+  rm preload-work/b/c/7.txt preload-work/b/c/200.txt
+  touch preload-work/b/c/1*.txt
+
+  compare "$( git ls-files -m -d -t )" "$( ../toy-git ls-files -m -d -t )" "'ls-files -m -d -t' (core.preloadIndex=$PRELOAD)"
+
+  cp $REPOSITORY_DIR_NAME/index index.bak
+  EXPECT=$( git update-index --refresh 2>&1 )
+  EXPECT_STATE=$( git ls-files --debug )
+  cp index.bak $REPOSITORY_DIR_NAME/index
+  ACTUAL=$( ../toy-git update-index --refresh 2>&1 )
+  compare "$EXPECT" "$ACTUAL" "'update-index --refresh' (core.preloadIndex=$PRELOAD)"
+  compare "$EXPECT_STATE" "$( git ls-files --debug )" "stat data of 'update-index --refresh' (core.preloadIndex=$PRELOAD)"
+
+  # restore the work tree
+  for i in 5 50 150 299; do
+    echo "file $i" > preload-work/a/$i.txt
+  done
+  echo "file 7" > preload-work/b/c/7.txt
+  echo "file 200" > preload-work/b/c/200.txt
+done
@@ -0,0 +1,141 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+# create tree
+../toy-git update-index --add test-target-file.txt
+../toy-git update-index --add test-target-dir/test-target-file-nested.txt
+TREE_SHA1=`../toy-git write-tree`
+
+################
+# read-tree --empty
+################
+../toy-git read-tree --empty
+
+LS_FILES_MESSAGE=`../toy-git ls-files`
+if [[ "$LS_FILES_MESSAGE" != "" ]]; then
+  echo "[read-tree] 'read-tree --empty' failed."
+  echo -e "Expect: \n"
+  echo -e "Actual: \n$LS_FILES_MESSAGE"
+  exit 1
+fi
+
+################
+# read-tree <tree>
+################
+../toy-git read-tree $TREE_SHA1
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+
+git read-tree $TREE_SHA1
+EXPECT_LS_FILES_MESSAGE=`git ls-files -s`
+
+if [[ "$EXPECT_LS_FILES_MESSAGE" != "$ACTUAL_LS_FILES_MESSAGE" ]]; then
+  echo "[read-tree] 'read-tree <tree>' failed."
+  echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+  echo -e "Actual: \n$ACTUAL_LS_FILES_MESSAGE"
+  exit 1
+fi
+
+################
+# read-tree --prefix
+################
+../toy-git read-tree --prefix=sub/ $TREE_SHA1
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+
+git read-tree $TREE_SHA1
+git read-tree --prefix=sub/ $TREE_SHA1
+EXPECT_LS_FILES_MESSAGE=`git ls-files -s`
+
+if [[ "$EXPECT_LS_FILES_MESSAGE" != "$ACTUAL_LS_FILES_MESSAGE" ]]; then
+  echo "[read-tree] 'read-tree --prefix' failed."
+  echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+  echo -e "Actual: \n$ACTUAL_LS_FILES_MESSAGE"
+  exit 1
+fi
+
+################
+# read-tree -m <base> <head> <remote>
+################
+../toy-git read-tree --empty
+echo "base" > a.txt
+echo "base" > b.txt
+echo "base" > c.txt
+../toy-git update-index --add a.txt b.txt c.txt
+BASE_SHA1=`../toy-git write-tree`
+
+echo "head" > a.txt
+echo "head" > b.txt
+../toy-git read-tree --empty
+../toy-git update-index --add a.txt b.txt c.txt
+HEAD_SHA1=`../toy-git write-tree`
+
+echo "base" > b.txt
+echo "remote" > a.txt
+echo "remote" > c.txt
+../toy-git read-tree --empty
+../toy-git update-index --add a.txt b.txt c.txt
+REMOTE_SHA1=`../toy-git write-tree`
+
+echo "head" > a.txt
+echo "head" > b.txt
+echo "base" > c.txt
+../toy-git read-tree $HEAD_SHA1
+../toy-git read-tree -m $BASE_SHA1 $HEAD_SHA1 $REMOTE_SHA1
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+
+git read-tree $HEAD_SHA1
+git update-index --refresh > /dev/null
+git read-tree -m $BASE_SHA1 $HEAD_SHA1 $REMOTE_SHA1
+EXPECT_LS_FILES_MESSAGE=`git ls-files -s`
+
+if [[ "$EXPECT_LS_FILES_MESSAGE" != "$ACTUAL_LS_FILES_MESSAGE" ]]; then
+  echo "[read-tree] 'read-tree -m <base> <head> <remote>' failed."
+  echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+  echo -e "Actual: \n$ACTUAL_LS_FILES_MESSAGE"
+  exit 1
+fi
+
+# trees can not be written with unmerged entries
+EXPECT_WRITE_TREE_MESSAGE=$( git write-tree 2>&1; echo "exit $?" )
+ACTUAL_WRITE_TREE_MESSAGE=$( ../toy-git write-tree 2>&1; echo "exit $?" )
+if [[ "$EXPECT_WRITE_TREE_MESSAGE" != "$ACTUAL_WRITE_TREE_MESSAGE" ]]; then
+  echo "[read-tree] 'write-tree' after conflicting 'read-tree -m <base> <head> <remote>' failed."
+  echo -e "Expect: \n$EXPECT_WRITE_TREE_MESSAGE"
+  echo -e "Actual: \n$ACTUAL_WRITE_TREE_MESSAGE"
+  exit 1
+fi
+
+################
+# read-tree -m <head> <merge>
+################
+echo "base" > a.txt
+echo "base" > b.txt
+echo "base" > c.txt
+../toy-git read-tree $BASE_SHA1
+../toy-git read-tree -m $BASE_SHA1 $HEAD_SHA1
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+
+git read-tree $BASE_SHA1
+git update-index --refresh > /dev/null
+git read-tree -m $BASE_SHA1 $HEAD_SHA1
+EXPECT_LS_FILES_MESSAGE=`git ls-files -s`
+
+if [[ "$EXPECT_LS_FILES_MESSAGE" != "$ACTUAL_LS_FILES_MESSAGE" ]]; then
+  echo "[read-tree] 'read-tree -m <head> <merge>' failed."
+  echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+  echo -e "Actual: \n$ACTUAL_LS_FILES_MESSAGE"
+  exit 1
+fi
+
+cd - > /dev/null
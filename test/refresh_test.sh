@@ -0,0 +1,139 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+# create index
+echo "a" > a.txt
+echo "b" > b.txt
+../toy-git update-index --add a.txt b.txt
+
+################
+# ls-files -m with changed stat data only
+################
+touch -d "2000-01-01" a.txt
+
+LS_FILES_MESSAGE=`../toy-git ls-files -m`
+if [[ "$LS_FILES_MESSAGE" != "" ]]; then
+  echo "[refresh] 'ls-files -m' reported the file which has only stat changes."
+  echo -e "Expect: \n"
+  echo -e "Actual: \n$LS_FILES_MESSAGE"
+  exit 1
+fi
+
+################
+# update-index --refresh
+################
+GIT_DIFF_FILES_MESSAGE=`git diff-files --name-only`
+if [[ "$GIT_DIFF_FILES_MESSAGE" != "a.txt" ]]; then
+  echo "[refresh] stat data should be dirty before 'update-index --refresh'."
+  echo -e "Expect: \na.txt"
+  echo -e "Actual: \n$GIT_DIFF_FILES_MESSAGE"
+  exit 1
+fi
+
+../toy-git update-index --refresh
+if [[ "$?" -ne 0 ]]; then
+  echo "[refresh] 'update-index --refresh' failed without modified files."
+  exit 1
+fi
+
+GIT_DIFF_FILES_MESSAGE=`git diff-files --name-only`
+if [[ "$GIT_DIFF_FILES_MESSAGE" != "" ]]; then
+  echo "[refresh] 'update-index --refresh' did not update stat data."
+  echo -e "Expect: \n"
+  echo -e "Actual: \n$GIT_DIFF_FILES_MESSAGE"
+  exit 1
+fi
+
+################
+# update-index --refresh with modified file
+################
+echo "B" > b.txt
+
+REFRESH_MESSAGE=`../toy-git update-index --refresh`
+if [[ "$?" -eq 0 || "$REFRESH_MESSAGE" != "b.txt: needs update" ]]; then
+  echo "[refresh] 'update-index --refresh' did not report modified file."
+  echo -e "Expect: \nb.txt: needs update"
+  echo -e "Actual: \n$REFRESH_MESSAGE"
+  exit 1
+fi
+
+EXPECT_SHA1=`echo "b" | git hash-object --stdin`
+ACTUAL_SHA1=`git ls-files -s b.txt | cut -d ' ' -f 2`
+if [[ "$EXPECT_SHA1" != "$ACTUAL_SHA1" ]]; then
+  echo "[refresh] 'update-index --refresh' changed the content of the index."
+  echo -e "Expect: \n$EXPECT_SHA1"
+  echo -e "Actual: \n$ACTUAL_SHA1"
+  exit 1
+fi
+
+################
+# ls-files -m with racily clean file
+################
+echo "c" > c.txt
+../toy-git update-index --add c.txt
+echo "C" > c.txt
+
+LS_FILES_MESSAGE=`../toy-git ls-files -m`
+if [[ "$LS_FILES_MESSAGE" != "b.txt
+c.txt" ]]; then
+  echo "[refresh] 'ls-files -m' missed the modified file."
+  echo -e "Expect: \nb.txt\nc.txt"
+  echo -e "Actual: \n$LS_FILES_MESSAGE"
+  exit 1
+fi
+
+################
+# racily clean entries are smudged when the index is rewritten
+################
+# make an entry whose stat data matches the file but the contents do not, and the index as old as the file
+echo "racy" > 0racy.txt
+../toy-git update-index --add 0racy.txt
+OTHER_SHA1=`echo "RACY" | git hash-object -w --stdin`
+printf "$( echo $OTHER_SHA1 | sed 's/../\\x&/g' )" | dd of=$REPOSITORY_DIR_NAME/index bs=1 seek=52 conv=notrunc 2> /dev/null
+SIZE=`stat -c %s $REPOSITORY_DIR_NAME/index`
+CHECKSUM=`head -c $(( SIZE - 20 )) $REPOSITORY_DIR_NAME/index | sha1sum | cut -d ' ' -f 1`
+printf "$( echo $CHECKSUM | sed 's/../\\x&/g' )" | dd of=$REPOSITORY_DIR_NAME/index bs=1 seek=$(( SIZE - 20 )) conv=notrunc 2> /dev/null
+touch -r 0racy.txt $REPOSITORY_DIR_NAME/index
+
+# the index written later is newer than the file, so readers would trust the stat data
+../toy-git update-index --add a.txt
+ACTUAL_SIZE=`git ls-files --debug 0racy.txt | sed -n 's/^  size: \([0-9]*\).*/\1/p'`
+if [[ "$ACTUAL_SIZE" != "0" ]]; then
+  echo "[refresh] the racily clean entry was not smudged."
+  echo -e "Expect: \n0"
+  echo -e "Actual: \n$ACTUAL_SIZE"
+  exit 1
+fi
+GIT_DIFF_FILES_MESSAGE=`git diff-files --name-only 0racy.txt`
+if [[ "$GIT_DIFF_FILES_MESSAGE" != "0racy.txt" ]]; then
+  echo "[refresh] git trusts the stat data of the racily clean entry."
+  echo -e "Expect: \n0racy.txt"
+  echo -e "Actual: \n$GIT_DIFF_FILES_MESSAGE"
+  exit 1
+fi
+
+# entries of files hashed just now are not smudged
+echo "fresh" > 0racy.txt
+../toy-git update-index --add 0racy.txt
+ACTUAL_SIZE=`git ls-files --debug 0racy.txt | sed -n 's/^  size: \([0-9]*\).*/\1/p'`
+if [[ "$ACTUAL_SIZE" != "6" ]]; then
+  echo "[refresh] the entry of the file added just now was smudged."
+  echo -e "Expect: \n6"
+  echo -e "Actual: \n$ACTUAL_SIZE"
+  exit 1
+fi
+rm -f 0racy.txt
+
+cd - > /dev/null
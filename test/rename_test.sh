@@ -0,0 +1,96 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf rename-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+export GIT_AUTHOR_NAME="toy-git" GIT_AUTHOR_EMAIL="toy-git@example.com"
+export GIT_COMMITTER_NAME="toy-git" GIT_COMMITTER_EMAIL="toy-git@example.com"
+
+# run the diff command of toy-git and git, and compare the results
+function compare_diff() {
+  ACTUAL=$( ../toy-git "$@" 2>&1 | od -c )
+  EXPECT=$( git "$@" 2>&1 | od -c )
+  if [[ "$EXPECT" != "$ACTUAL" ]]; then
+    echo "[rename] '$@' failed."
+    echo -e "Expect: \n$( git "$@" 2>&1 )"
+    echo -e "Actual: \n$( ../toy-git "$@" 2>&1 )"
+    exit 1
+  fi
+}
+
+function commit() {
+  ../toy-git add -A rename-work
+  TREE=$( ../toy-git write-tree )
+  if [[ -z "$COMMIT" ]]; then
+    COMMIT=$( git commit-tree -m "$1" $TREE )
+  else
+    COMMIT=$( git commit-tree -p $COMMIT -m "$1" $TREE )
+  fi
+  git update-ref refs/heads/master $COMMIT
+}
+
+# create work tree
+mkdir -p rename-work/src/a rename-work/src/b rename-work/dir
+seq 1 100 > rename-work/src/a/big.txt
+seq 1 50 > rename-work/src/b/mid.txt
+seq 200 260 > rename-work/same1.txt
+cp rename-work/same1.txt rename-work/same2.txt
+printf 'no newline\nline' > rename-work/nonl.txt
+echo "x" > rename-work/dir/x.txt
+echo "y" > rename-work/dir/y.txt
+seq 1 40 | sed 's/$/ crlf\r/' > rename-work/crlf.txt
+ln -s src/a/big.txt rename-work/link
+printf 'a\nb\nc\nd\ne\nf\ng\nh\n' > rename-work/copysrc.txt
+: > rename-work/empty
+commit initial
+C1=$COMMIT
+
+# renames with and without changes, and copies
+mkdir -p rename-work/src/c
+mv rename-work/src/a/big.txt rename-work/src/c/big.txt
+echo "101" >> rename-work/src/c/big.txt
+mv rename-work/src/b/mid.txt rename-work/moved-mid.txt
+sed -i 's/^25$/twenty five/' rename-work/moved-mid.txt
+mv rename-work/same1.txt rename-work/renamed-same.txt
+rm rename-work/nonl.txt
+printf 'no newline\nline2' > rename-work/nonl2.txt
+mv rename-work/dir rename-work/newdir
+mv rename-work/crlf.txt rename-work/crlf2.txt
+sed -i 's/^1 crlf/one crlf/' rename-work/crlf2.txt
+mv rename-work/link rename-work/link2
+cp rename-work/copysrc.txt rename-work/copied.txt
+echo "i" >> rename-work/copysrc.txt
+mv rename-work/empty rename-work/empty2
+printf 'totally\ndifferent\n' > rename-work/other.txt
+commit renames
+C2=$COMMIT
+
+for OPTS in "-M" "-M90" "-M10%" "-M.3" "-C" "-C30" "--find-renames" "--find-renames=80" "--find-copies"; do
+  for FORMAT in "" "-p" "--stat" "--name-status" "--name-only" "-p --stat"; do
+    compare_diff diff-tree -r $OPTS $FORMAT $C1 $C2
+  done
+done
+compare_diff diff-tree -M $C1 $C2
+compare_diff diff-tree -M -t $C1 $C2
+compare_diff diff-tree -M $C2
+compare_diff diff-tree -Mx $C1 $C2
+
+# the index and the work tree
+git read-tree $C1
+compare_diff diff-index -M $C1
+compare_diff diff-index -M --cached -p $C1
+git read-tree $C2
+compare_diff diff-index -M --cached -p $C1
+compare_diff diff-index -C --cached --stat $C1
+compare_diff diff-index -M --name-status $C1
@@ -0,0 +1,117 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf split-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+function shared_index_count() {
+  echo $( ls $REPOSITORY_DIR_NAME | grep -c "^sharedindex\." )
+}
+
+function compare_ls_files() {
+  EXPECT_LS_FILES_MESSAGE=$( git ls-files -s )
+  LS_FILES_MESSAGE=$( ../toy-git ls-files -s )
+  if [[ "$EXPECT_LS_FILES_MESSAGE" != "$LS_FILES_MESSAGE" ]]; then
+    echo "[split-index] $1"
+    echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+    echo -e "Actual: \n$LS_FILES_MESSAGE"
+    exit 1
+  fi
+}
+
+# create work tree
+mkdir -p split-work/dir
+for i in 1 2 3 4 5 6 7 8 9 10; do
+  echo "$i" > split-work/$i.txt
+done
+echo "x" > split-work/dir/x.txt
+
+################
+# split index written by toy-git
+################
+../toy-git update-index --add split-work/*.txt
+../toy-git update-index --split-index
+if [[ "$( shared_index_count )" != "1" ]]; then
+  echo "[split-index] 'update-index --split-index' must write a shared index."
+  exit 1
+fi
+EXPECT=$( git ls-files -s split-work )
+compare_ls_files "index split by toy-git is broken."
+
+# changes are recorded in the index
+echo "changed" > split-work/1.txt
+../toy-git update-index split-work/1.txt
+../toy-git update-index --force-remove split-work/2.txt
+../toy-git update-index --add split-work/dir/x.txt
+if [[ "$( shared_index_count )" != "1" ]]; then
+  echo "[split-index] a few changes must not write a new shared index."
+  exit 1
+fi
+compare_ls_files "replaced, deleted and added entries are broken."
+git update-index --force-remove split-work/3.txt
+compare_ls_files "changes written by git are broken."
+
+# too many new entries write a new shared index
+COUNT=$( shared_index_count )
+for i in 11 12 13; do
+  echo "$i" > split-work/$i.txt
+done
+../toy-git update-index --add split-work/11.txt split-work/12.txt split-work/13.txt split-work/2.txt
+compare_ls_files "index after new shared index is broken."
+if [[ "$( shared_index_count )" != "$(( COUNT + 1 ))" ]]; then
+  echo "[split-index] too many changes must write a new shared index."
+  exit 1
+fi
+
+# old shared indexes are removed
+git config splitIndex.sharedIndexExpire now
+../toy-git update-index --split-index
+if [[ "$( shared_index_count )" != "1" ]]; then
+  echo "[split-index] unused shared indexes must be removed."
+  exit 1
+fi
+git config --unset splitIndex.sharedIndexExpire
+
+################
+# split index written by git
+################
+../toy-git update-index --no-split-index
+git update-index --split-index
+echo "git" > split-work/7.txt
+git update-index split-work/7.txt
+git update-index --force-remove split-work/8.txt
+compare_ls_files "reading index split by git failed."
+echo "toy" > split-work/9.txt
+../toy-git update-index split-work/9.txt
+compare_ls_files "updating index split by git failed."
+
+################
+# core.splitIndex
+################
+git config core.splitIndex false
+../toy-git update-index split-work/9.txt
+if od -c $REPOSITORY_DIR_NAME/index | grep -q "l   i   n   k"; then
+  echo "[split-index] core.splitIndex=false must not write the link extension."
+  exit 1
+fi
+compare_ls_files "index unsplit by core.splitIndex is broken."
+git config core.splitIndex true
+../toy-git update-index split-work/9.txt
+if ! od -c $REPOSITORY_DIR_NAME/index | grep -q "l   i   n   k"; then
+  echo "[split-index] core.splitIndex=true must write the link extension."
+  exit 1
+fi
+compare_ls_files "index split by core.splitIndex is broken."
+git config --unset core.splitIndex
+
+cd ..
@@ -0,0 +1,156 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf status-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+export GIT_AUTHOR_NAME="toy-git" GIT_AUTHOR_EMAIL="toy-git@example.com"
+export GIT_COMMITTER_NAME="toy-git" GIT_COMMITTER_EMAIL="toy-git@example.com"
+
+# run status of toy-git and git in the directory, and compare the results
+function compare_status() {
+  DIR=$1
+  shift
+  ACTUAL=$( cd $DIR && $OLDPWD/../toy-git status "$@" status-work | od -c )
+  EXPECT=$( cd $DIR && git -c advice.statusHints=false status "$@" status-work | od -c )
+  if [[ "$EXPECT" != "$ACTUAL" ]]; then
+    echo "[status] 'status $@' in '$DIR' failed."
+    echo -e "Expect: \n$( cd $DIR && git -c advice.statusHints=false status "$@" status-work )"
+    echo -e "Actual: \n$( cd $DIR && $OLDPWD/../toy-git status "$@" status-work )"
+    exit 1
+  fi
+}
+
+function compare_all() {
+  for OPTS in "" "-s" "-s -b" "--porcelain" "--porcelain -b" "--porcelain=v2" "--porcelain=v2 --branch" "-z" "-s -z" "--porcelain=v2 -z" "-uall" "-s -uno" "--untracked-files=no" "--porcelain=v2 -uall"; do
+    compare_status . $OPTS
+  done
+}
+
+# create work tree
+mkdir -p status-work/dir/sub
+echo "a" > status-work/a.txt
+echo "b" > status-work/b.txt
+echo "c" > status-work/dir/c.txt
+echo "d" > status-work/dir/sub/d.txt
+echo "exec" > status-work/exec.sh
+echo "link" > status-work/link
+echo "rename" > status-work/old.txt
+echo "staged" > status-work/staged.txt
+seq 1 30 > status-work/edited.txt
+seq 1 10 > status-work/rewritten.txt
+
+################
+# before the first commit
+################
+compare_all
+../toy-git update-index --add status-work/a.txt status-work/dir/c.txt
+compare_all
+compare_status status-work/dir
+compare_status status-work/dir -s
+compare_status status-work/dir --porcelain=v2
+
+################
+# changes against HEAD
+################
+../toy-git add status-work
+TREE=$( ../toy-git write-tree )
+COMMIT=$( git commit-tree -m initial $TREE )
+git update-ref refs/heads/master $COMMIT
+compare_all
+
+echo "modified" >> status-work/a.txt
+echo "staged" >> status-work/staged.txt
+../toy-git update-index status-work/staged.txt
+echo "both" >> status-work/staged.txt
+rm status-work/b.txt
+chmod +x status-work/exec.sh
+rm status-work/link
+ln -s a.txt status-work/link
+mv status-work/old.txt status-work/new.txt
+../toy-git update-index --remove status-work/old.txt
+../toy-git update-index --add status-work/new.txt
+mv status-work/edited.txt status-work/dir/moved.txt
+sed -i 's/^15$/fifteen/' status-work/dir/moved.txt
+../toy-git update-index --remove status-work/edited.txt
+../toy-git update-index --add status-work/dir/moved.txt
+rm status-work/rewritten.txt
+seq 101 110 > status-work/dir/rewritten2.txt
+../toy-git update-index --remove status-work/rewritten.txt
+../toy-git update-index --add status-work/dir/rewritten2.txt
+rm status-work/dir/sub/d.txt
+../toy-git update-index --remove status-work/dir/sub/d.txt
+echo "new" > status-work/added.txt
+../toy-git update-index --add status-work/added.txt
+rm status-work/added.txt
+echo "ita" > status-work/ita.txt
+../toy-git add -N status-work/ita.txt
+mkdir -p status-work/untracked/deep status-work/dir/new
+echo "u" > status-work/untracked/deep/u.txt
+echo "u" > status-work/dir/new/u.txt
+echo "u" > status-work/dir/u.txt
+echo "space" > "status-work/with space.txt"
+echo "tab" > "status-work/with	tab.txt"
+echo "ignored" > status-work/ignored.log
+echo "*.log" > status-work/.gitignore
+compare_all
+compare_status status-work/dir
+compare_status status-work/dir -s
+compare_status status-work/dir --porcelain
+compare_status status-work/dir --porcelain=v2 -uall
+git config status.renames false
+compare_status . -s
+compare_status . --porcelain=v2
+git config --unset status.renames
+git config diff.renames false
+compare_status . -s
+git config status.renames true
+compare_status . --porcelain=v2
+git config --unset status.renames
+git config --unset diff.renames
+
+################
+# stat data is refreshed
+################
+touch status-work/dir/c.txt
+../toy-git status > /dev/null
+GIT_DIFF_FILES_MESSAGE=$( git diff-files --name-only status-work/dir )
+if [[ "$GIT_DIFF_FILES_MESSAGE" != "" ]]; then
+  echo "[status] 'status' did not refresh stat data."
+  echo -e "Expect: \n"
+  echo -e "Actual: \n$GIT_DIFF_FILES_MESSAGE"
+  exit 1
+fi
+
+################
+# unmerged paths
+################
+A=$( git hash-object -w status-work/a.txt )
+B=$( git hash-object -w status-work/dir/c.txt )
+printf "0 $A\tstatus-work/dir/c.txt\n" | ../toy-git update-index --index-info
+printf "100644 $A 1\tstatus-work/dir/c.txt\n100644 $B 2\tstatus-work/dir/c.txt\n100644 $A 3\tstatus-work/dir/c.txt\n" | ../toy-git update-index --index-info
+printf "100644 $A 2\tstatus-work/ours.txt\n100644 $B 3\tstatus-work/theirs.txt\n100644 $A 1\tstatus-work/gone.txt\n" | ../toy-git update-index --index-info
+printf "100644 $A 1\tstatus-work/dir/du.txt\n100644 $B 3\tstatus-work/dir/du.txt\n100644 $A 2\tstatus-work/aa.txt\n100644 $B 3\tstatus-work/aa.txt\n" | ../toy-git update-index --index-info
+compare_all
+
+################
+# clean work tree
+################
+git reset -q --hard
+git clean -q -f -d status-work
+compare_all
+git config status.showUntrackedFiles no
+echo "u" > status-work/u.txt
+compare_all
+git config --unset status.showUntrackedFiles
+compare_all
@@ -0,0 +1,75 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf subdir-work subdir-link
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+TOY_GIT=$( cd .. && pwd )/toy-git
+
+function compare() {
+  EXPECT_LS_FILES_MESSAGE=$( git ls-files "$@" 2>&1 )
+  LS_FILES_MESSAGE=$( $TOY_GIT ls-files "$@" 2>&1 )
+  if [[ "$EXPECT_LS_FILES_MESSAGE" != "$LS_FILES_MESSAGE" ]]; then
+    echo "[subdirectory] 'ls-files $@' in $( pwd ) failed."
+    echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+    echo -e "Actual: \n$LS_FILES_MESSAGE"
+    exit 1
+  fi
+}
+
+# create work tree
+mkdir -p subdir-work/sub/deep subdir-work/other
+echo "a" > subdir-work/a.txt
+echo "b" > subdir-work/sub/b.txt
+echo "c" > subdir-work/sub/deep/c.txt
+echo "d" > subdir-work/other/d.txt
+ln -s subdir-work/sub subdir-link
+
+# paths are recorded relative to the top of the work tree
+cd subdir-work/sub
+$TOY_GIT update-index --add b.txt ./deep/c.txt ../a.txt $( pwd )/../other/d.txt
+cd ../..
+ACTUAL_LS_FILES_MESSAGE=`git ls-files -s`
+git read-tree --empty
+git update-index --add subdir-work/sub/b.txt subdir-work/sub/deep/c.txt subdir-work/a.txt subdir-work/other/d.txt
+EXPECT_LS_FILES_MESSAGE=`git ls-files -s`
+if [[ "$EXPECT_LS_FILES_MESSAGE" != "$ACTUAL_LS_FILES_MESSAGE" ]]; then
+  echo "[subdirectory] 'update-index --add' in subdirectory failed."
+  echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+  echo -e "Actual: \n$ACTUAL_LS_FILES_MESSAGE"
+  exit 1
+fi
+
+# paths are shown relative to the current directory
+cd subdir-work/sub
+compare
+compare -s
+compare ..
+compare ../other ../a.txt
+compare "../*.txt"
+compare ":/subdir-work/other"
+compare deep/
+compare $( pwd )/deep
+compare ../../..
+cd ../..
+
+# symbolic link to a directory in the work tree
+cd subdir-link
+compare
+compare ../subdir-work/a.txt
+compare $( pwd )/b.txt
+cd ..
+
+rm -rf subdir-work subdir-link
+
+cd ..
@@ -0,0 +1,110 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf symlink-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+git read-tree --empty
+
+# run update-index of toy-git and git from the same index, and compare the results
+function compare_update_index() {
+  cp $REPOSITORY_DIR_NAME/index index.bak
+  ../toy-git update-index "$@" > /dev/null 2>&1
+  ACTUAL_STATUS=$?
+  ACTUAL_STATE=$( git ls-files -s )
+  cp index.bak $REPOSITORY_DIR_NAME/index
+  git update-index "$@" > /dev/null 2>&1
+  EXPECT_STATUS=$?
+  EXPECT_STATE=$( git ls-files -s )
+  if [[ "$EXPECT_STATE" != "$ACTUAL_STATE" || "$EXPECT_STATUS" != "$ACTUAL_STATUS" ]]; then
+    echo "[update-index] 'update-index $@' failed."
+    echo -e "Expect($EXPECT_STATUS): \n$EXPECT_STATE"
+    echo -e "Actual($ACTUAL_STATUS): \n$ACTUAL_STATE"
+    exit 1
+  fi
+}
+
+function compare_ls_files() {
+  EXPECT_LS_FILES_MESSAGE=$( git ls-files "$@" )
+  LS_FILES_MESSAGE=$( ../toy-git ls-files "$@" )
+  if [[ "$EXPECT_LS_FILES_MESSAGE" != "$LS_FILES_MESSAGE" ]]; then
+    echo "[ls-files] 'ls-files $@' failed."
+    echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+    echo -e "Actual: \n$LS_FILES_MESSAGE"
+    exit 1
+  fi
+}
+
+# create work tree
+mkdir -p symlink-work/dir
+echo "target" > symlink-work/target.txt
+echo "other" > symlink-work/other.txt
+ln -s target.txt symlink-work/link
+ln -s none.txt symlink-work/dangling
+ln -s dir symlink-work/dirlink
+
+################
+# symbolic links are hashed by the link target
+################
+compare_update_index --add symlink-work/target.txt symlink-work/other.txt symlink-work/link symlink-work/dangling symlink-work/dirlink
+../toy-git update-index --add symlink-work/target.txt symlink-work/other.txt symlink-work/link symlink-work/dangling symlink-work/dirlink
+compare_ls_files -s
+git update-index --refresh > /dev/null
+
+# contents of the target do not change the link
+echo "modified" > symlink-work/target.txt
+compare_ls_files -m
+compare_ls_files -d
+
+# a new link target changes the link
+ln -sfn other.txt symlink-work/link
+compare_ls_files -m
+compare_update_index symlink-work/link
+compare_update_index --remove symlink-work/dangling
+rm symlink-work/target.txt
+compare_ls_files -d
+compare_ls_files -m
+git update-index --remove symlink-work/target.txt symlink-work/link
+
+################
+# checkout-index
+################
+rm symlink-work/dangling
+../toy-git checkout-index symlink-work/dangling
+if [[ $( readlink symlink-work/dangling ) != "none.txt" ]]; then
+  echo "[checkout-index] 'checkout-index symlink-work/dangling' must create the symbolic link."
+  exit 1
+fi
+compare_ls_files -m
+
+################
+# core.symlinks=false
+################
+git config core.symlinks false
+rm symlink-work/dangling
+../toy-git checkout-index symlink-work/dangling
+if [[ -L symlink-work/dangling || $( cat symlink-work/dangling ) != "none.txt" ]]; then
+  echo "[checkout-index] 'checkout-index symlink-work/dangling' must create the plain file with core.symlinks=false."
+  exit 1
+fi
+compare_ls_files -m
+compare_update_index symlink-work/dangling
+echo -n "other.txt" > symlink-work/dangling
+compare_ls_files -m
+compare_update_index symlink-work/dangling
+
+# the type change is detected with core.symlinks=true
+git config core.symlinks true
+compare_ls_files -m
+compare_update_index symlink-work/dangling
+
+cd ..
@@ -0,0 +1,144 @@
+#!/bin/bash
+
+REPOSITORY_DIR_NAME=".toy-git"
+
+cd test
+rm -rf .toy-git
+unlink .git > /dev/null 2>&1
+rm -rf .git
+rm -rf untracked-work
+
+# initialize repository
+../toy-git init > /dev/null
+
+# create alias for testing by git command
+ln -s ./.toy-git .git
+
+function has_untracked_cache() {
+  od -c $REPOSITORY_DIR_NAME/index | grep -q "U   N   T   R"
+}
+
+function compare() {
+  # git sees .toy-git as an untracked directory
+  EXPECT_LS_FILES_MESSAGE=$( git ls-files -o --exclude-standard | grep -v "^$REPOSITORY_DIR_NAME/" )
+  LS_FILES_MESSAGE=$( ../toy-git ls-files -o --exclude-standard )
+  if [[ "$EXPECT_LS_FILES_MESSAGE" != "$LS_FILES_MESSAGE" ]]; then
+    echo "[untracked-cache] $1"
+    echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+    echo -e "Actual: \n$LS_FILES_MESSAGE"
+    exit 1
+  fi
+  # the second run reads the cache
+  LS_FILES_MESSAGE=$( ../toy-git ls-files -o --exclude-standard )
+  if [[ "$EXPECT_LS_FILES_MESSAGE" != "$LS_FILES_MESSAGE" ]]; then
+    echo "[untracked-cache] $1 (cached)"
+    echo -e "Expect: \n$EXPECT_LS_FILES_MESSAGE"
+    echo -e "Actual: \n$LS_FILES_MESSAGE"
+    exit 1
+  fi
+}
+
+# create work tree
+mkdir -p untracked-work/src/deep untracked-work/build untracked-work/empty
+touch untracked-work/a.txt untracked-work/b.o untracked-work/src/main.c untracked-work/src/deep/x.tmp
+touch untracked-work/build/out
+printf "*.o\n/build/\n" > untracked-work/.gitignore
+( cd untracked-work && mkdir nested && cd nested && git init -q )
+
+../toy-git update-index --add untracked-work/a.txt
+../toy-git update-index --untracked-cache
+if ! has_untracked_cache; then
+  echo "[untracked-cache] 'update-index --untracked-cache' must write the extension."
+  exit 1
+fi
+
+compare "listing untracked files with the cache failed."
+if [[ "$( git ls-files -s )" != "$( ../toy-git ls-files -s )" ]]; then
+  echo "[untracked-cache] index written with the untracked cache is broken."
+  exit 1
+fi
+
+# git reads the cache of toy-git
+EXPECT_STATUS=$( ../toy-git ls-files -o --exclude-standard | sed 's/^/?? /' )
+ACTUAL_STATUS=$( git -c status.showUntrackedFiles=all status --porcelain | grep "^??" | grep -v " $REPOSITORY_DIR_NAME/" )
+if [[ "$EXPECT_STATUS" != "$ACTUAL_STATUS" ]]; then
+  echo "[untracked-cache] git status with the cache of toy-git failed."
+  echo -e "Expect: \n$EXPECT_STATUS"
+  echo -e "Actual: \n$ACTUAL_STATUS"
+  exit 1
+fi
+
+# files are created and removed
+touch untracked-work/src/deep/y.c untracked-work/empty/z.txt
+rm untracked-work/src/main.c
+compare "created and removed files are not listed correctly."
+
+# files are added to or removed from the index
+../toy-git update-index --add untracked-work/src/deep/y.c
+compare "a file added to the index is listed."
+../toy-git update-index --force-remove untracked-work/a.txt
+compare "a file removed from the index is not listed."
+
+# exclude files are changed
+echo "*.tmp" > untracked-work/src/.gitignore
+compare "a new .gitignore is not applied."
+echo "!x.tmp" >> untracked-work/src/.gitignore
+compare "a changed .gitignore is not applied."
+printf "\n*.c\n" >> $REPOSITORY_DIR_NAME/info/exclude
+compare "a changed info/exclude is not applied."
+
+# the cache written by 'git status' records untracked directories
+git status --porcelain > /dev/null
+compare "the cache of git status is not rebuilt."
+
+################
+# --no-untracked-cache and core.untrackedCache
+################
+../toy-git update-index --no-untracked-cache
+if has_untracked_cache; then
+  echo "[untracked-cache] 'update-index --no-untracked-cache' must remove the extension."
+  exit 1
+fi
+git config core.untrackedCache true
+../toy-git ls-files -o --exclude-standard > /dev/null
+if ! has_untracked_cache; then
+  echo "[untracked-cache] core.untrackedCache=true must add the extension."
+  exit 1
+fi
+compare "listing untracked files with core.untrackedCache failed."
+git config core.untrackedCache false
+../toy-git update-index --add untracked-work/a.txt
+if has_untracked_cache; then
+  echo "[untracked-cache] core.untrackedCache=false must remove the extension."
+  exit 1
+fi
+git config --unset core.untrackedCache
+
+################
+# concurrent updates of the index
+################
+# the index updated after ls-files loaded it must not be overwritten by the untracked cache
+git config core.untrackedCache true
+mkdir -p untracked-work/many
+for i in $(seq 1 3000); do
+  mkdir untracked-work/many/$i
+  touch untracked-work/many/$i/f
+done
+touch untracked-work/racy.txt
+for i in $(seq 1 10); do
+  ../toy-git update-index --force-remove untracked-work/racy.txt
+  ../toy-git update-index --untracked-cache
+  ../toy-git ls-files -o --exclude-standard > /dev/null &
+  # update-index fails while ls-files holds the lock. a successful update must survive
+  until ../toy-git update-index --add untracked-work/racy.txt 2> /dev/null; do :; done
+  wait
+  if [[ -z "$( ../toy-git ls-files untracked-work/racy.txt )" ]]; then
+    echo "[untracked-cache] the entry added while 'ls-files -o' is running was lost."
+    exit 1
+  fi
+done
+rm -rf untracked-work/many untracked-work/racy.txt
+../toy-git update-index --force-remove untracked-work/racy.txt
+git config --unset core.untrackedCache
+
+cd ..
@@ -0,0 +1,495 @@
+// See Also:
+// https://github.com/git/git/blob/master/Documentation/technical/index-format.txt (Untracked cache)
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	UNTRACKED_CACHE_SHOW_OTHER_DIRECTORIES = uint32(1 << 1) // untracked directories are recorded as 'dir/' (git status)
+	UNTRACKED_CACHE_HIDE_EMPTY_DIRECTORIES = uint32(1 << 2)
+)
+
+// StatData is the stat data recorded in the untracked cache. (the same as index entries from ctime to size)
+type StatData struct {
+	CTimeSeconds     uint32
+	CTimeNanoSeconds uint32
+	MTimeSeconds     uint32
+	MTimeNanoSeconds uint32
+	Dev              uint32
+	Inode            uint32
+	UID              uint32
+	GID              uint32
+	Size             uint32
+}
+
+// OidStat is the stat data and the blob of an exclude file. Sha1 is all zero if the file doesn't exist.
+type OidStat struct {
+	Stat StatData
+	Sha1 [20]byte
+}
+
+// UntrackedCache is the 'UNTR' extension.
+// Untracked files of each directory are cached with the stat data of the directory,
+// so directories which are not changed are not read again.
+type UntrackedCache struct {
+	Idents        []string           // environments where the cache can be used. ('Location <work tree>, system <OS>')
+	InfoExclude   OidStat            // $GIT_DIR/info/exclude
+	ExcludesFile  OidStat            // core.excludesFile
+	DirFlags      uint32             // flags of the directory scan. toy-git lists all untracked files (0)
+	ExcludePerDir string             // per-directory exclude file. (.gitignore)
+	Root          *UntrackedCacheDir // nil until the work tree is scanned
+
+	changed bool // the index should be written
+}
+
+type UntrackedCacheDir struct {
+	Name        string
+	Untracked   []string             // untracked files in the directory ('name/' for nested repositories)
+	Dirs        []*UntrackedCacheDir // scanned sub directories sorted by name
+	Valid       bool                 // Untracked is up to date with Stat
+	CheckOnly   bool
+	Stat        StatData // stat data of the directory
+	ExcludeSha1 [20]byte // blob of the per-directory exclude file. all zero if it doesn't exist
+}
+
+func read_untracked_extension(data []byte) (*UntrackedCache, error) {
+	buf := bytes.NewReader(data)
+	uc := &UntrackedCache{}
+
+	// NUL terminated strings preceded by their size
+	n, err := read_varint(buf)
+	if err != nil || n > uint64(buf.Len()) {
+		return nil, fmt.Errorf("corrupt untracked cache")
+	}
+	idents := make([]byte, n)
+	buf.Read(idents)
+	for _, ident := range bytes.Split(bytes.TrimSuffix(idents, []byte{0}), []byte{0}) {
+		uc.Idents = append(uc.Idents, string(ident))
+	}
+
+	if err := binary.Read(buf, binary.BigEndian, &uc.InfoExclude.Stat); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(buf, binary.BigEndian, &uc.ExcludesFile.Stat); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(buf, binary.BigEndian, &uc.DirFlags); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(buf, binary.BigEndian, &uc.InfoExclude.Sha1); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(buf, binary.BigEndian, &uc.ExcludesFile.Sha1); err != nil {
+		return nil, err
+	}
+	per_dir, err := read_until_nul(buf)
+	if err != nil {
+		return nil, err
+	}
+	uc.ExcludePerDir = string(per_dir)
+
+	// the work tree is not scanned yet
+	if buf.Len() == 0 {
+		return uc, nil
+	}
+	n, err = read_varint(buf)
+	if err != nil || n > uint64(buf.Len()) {
+		return nil, fmt.Errorf("corrupt untracked cache")
+	}
+	if n == 0 {
+		return uc, nil
+	}
+
+	// directory blocks in depth-first order
+	var dirs []*UntrackedCacheDir
+	var read_dir func() (*UntrackedCacheDir, error)
+	read_dir = func() (*UntrackedCacheDir, error) {
+		untracked_nr, err := read_varint(buf)
+		if err != nil || untracked_nr > uint64(buf.Len()) {
+			return nil, fmt.Errorf("corrupt untracked cache")
+		}
+		dirs_nr, err := read_varint(buf)
+		if err != nil || dirs_nr > uint64(buf.Len()) {
+			return nil, fmt.Errorf("corrupt untracked cache")
+		}
+		name, err := read_until_nul(buf)
+		if err != nil {
+			return nil, err
+		}
+		ucd := &UntrackedCacheDir{Name: string(name)}
+		dirs = append(dirs, ucd)
+		for i := uint64(0); i < untracked_nr; i++ {
+			f, err := read_until_nul(buf)
+			if err != nil {
+				return nil, err
+			}
+			ucd.Untracked = append(ucd.Untracked, string(f))
+		}
+		for i := uint64(0); i < dirs_nr; i++ {
+			sub, err := read_dir()
+			if err != nil {
+				return nil, err
+			}
+			ucd.Dirs = append(ucd.Dirs, sub)
+		}
+		return ucd, nil
+	}
+	if uc.Root, err = read_dir(); err != nil {
+		return nil, err
+	}
+	if uint64(len(dirs)) != n {
+		return nil, fmt.Errorf("corrupt untracked cache")
+	}
+
+	valid, err := read_ewah_bitmap(buf)
+	if err != nil {
+		return nil, err
+	}
+	check_only, err := read_ewah_bitmap(buf)
+	if err != nil {
+		return nil, err
+	}
+	sha1_valid, err := read_ewah_bitmap(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	// stat data of valid directories and then blobs of the exclude files
+	err = valid.each(func(i int) error {
+		if i >= len(dirs) {
+			return fmt.Errorf("corrupt untracked cache")
+		}
+		dirs[i].Valid = true
+		return binary.Read(buf, binary.BigEndian, &dirs[i].Stat)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = sha1_valid.each(func(i int) error {
+		if i >= len(dirs) {
+			return fmt.Errorf("corrupt untracked cache")
+		}
+		return binary.Read(buf, binary.BigEndian, &dirs[i].ExcludeSha1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, ucd := range dirs {
+		ucd.CheckOnly = check_only.get(i)
+		if ucd.Valid == false {
+			ucd.Untracked = nil
+		}
+	}
+	return uc, nil
+}
+
+func untracked_extension_bytes(uc *UntrackedCache) []byte {
+	buf := new(bytes.Buffer)
+
+	var idents []byte
+	for _, ident := range uc.Idents {
+		idents = append(append(idents, ident...), 0)
+	}
+	buf.Write(encode_varint(uint64(len(idents))))
+	buf.Write(idents)
+	binary.Write(buf, binary.BigEndian, uc.InfoExclude.Stat)
+	binary.Write(buf, binary.BigEndian, uc.ExcludesFile.Stat)
+	binary.Write(buf, binary.BigEndian, uc.DirFlags)
+	buf.Write(uc.InfoExclude.Sha1[:])
+	buf.Write(uc.ExcludesFile.Sha1[:])
+	buf.WriteString(uc.ExcludePerDir)
+	buf.WriteByte(0)
+
+	if uc.Root == nil {
+		buf.Write(encode_varint(0))
+		return buf.Bytes()
+	}
+
+	blocks := new(bytes.Buffer)
+	stats := new(bytes.Buffer)
+	shas := new(bytes.Buffer)
+	valid := &EwahBitmap{}
+	check_only := &EwahBitmap{}
+	sha1_valid := &EwahBitmap{}
+	n := 0
+	var write_dir func(ucd *UntrackedCacheDir)
+	write_dir = func(ucd *UntrackedCacheDir) {
+		i := n
+		n++
+		untracked := ucd.Untracked
+		if ucd.Valid {
+			valid.set(i)
+			binary.Write(stats, binary.BigEndian, ucd.Stat)
+		} else {
+			untracked = nil
+		}
+		if ucd.CheckOnly && ucd.Valid {
+			check_only.set(i)
+		}
+		if ucd.ExcludeSha1 != [20]byte{} {
+			sha1_valid.set(i)
+			shas.Write(ucd.ExcludeSha1[:])
+		}
+
+		blocks.Write(encode_varint(uint64(len(untracked))))
+		blocks.Write(encode_varint(uint64(len(ucd.Dirs))))
+		blocks.WriteString(ucd.Name)
+		blocks.WriteByte(0)
+		for _, f := range untracked {
+			blocks.WriteString(f)
+			blocks.WriteByte(0)
+		}
+		for _, sub := range ucd.Dirs {
+			write_dir(sub)
+		}
+	}
+	write_dir(uc.Root)
+
+	// bitmaps have a bit for each directory
+	for _, b := range []*EwahBitmap{valid, check_only, sha1_valid} {
+		for len(b.Bits) < n {
+			b.Bits = append(b.Bits, false)
+		}
+	}
+
+	buf.Write(encode_varint(uint64(n)))
+	buf.Write(blocks.Bytes())
+	buf.Write(ewah_bitmap_bytes(valid))
+	buf.Write(ewah_bitmap_bytes(check_only))
+	buf.Write(ewah_bitmap_bytes(sha1_valid))
+	buf.Write(stats.Bytes())
+	buf.Write(shas.Bytes())
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+// untracked_cache_ident returns the environment where the cache is created.
+// the cache is not used when the work tree is moved.
+func untracked_cache_ident(root string) string {
+	if r, err := filepath.EvalSymlinks(root); err == nil {
+		root = r
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	var uts syscall.Utsname
+	sysname := ""
+	if err := syscall.Uname(&uts); err == nil {
+		for _, c := range uts.Sysname {
+			if c == 0 {
+				break
+			}
+			sysname += string(byte(c))
+		}
+	}
+	return fmt.Sprintf("Location %s, system %s", root, sysname)
+}
+
+// add_untracked_cache enables the untracked cache. (update-index --untracked-cache)
+func add_untracked_cache(repop string, d *Dircache) {
+	ident := untracked_cache_ident(filepath.Dir(repop))
+	if d.untracked == nil {
+		d.untracked = &UntrackedCache{ExcludePerDir: ".gitignore"}
+	}
+	for _, i := range d.untracked.Idents {
+		if i == ident {
+			return
+		}
+	}
+	d.untracked.Idents = append(d.untracked.Idents, ident)
+	d.untracked.changed = true
+}
+
+// tweak_untracked_cache enables or disables the untracked cache by core.untrackedCache.
+// nothing is changed if it's not set or 'keep'.
+func tweak_untracked_cache(repop string, d *Dircache) {
+	v, ok := get_config(repop, "core.untrackedCache")
+	if ok == false || strings.ToLower(v) == "keep" {
+		return
+	}
+	if get_config_bool(repop, "core.untrackedCache", false) {
+		add_untracked_cache(repop, d)
+	} else {
+		d.untracked = nil
+	}
+}
+
+// invalidate_untracked_cache invalidates the directory of the path, which is added to or removed from the index.
+func invalidate_untracked_cache(d *Dircache, path string) {
+	uc := d.untracked
+	if uc == nil || uc.Root == nil {
+		return
+	}
+	uc.invalidate_path(uc.Root, path)
+}
+
+// invalidate_path invalidates the directory of the path under ucd.
+// leading directories are invalidated too if they record untracked directories.
+func (uc *UntrackedCache) invalidate_path(ucd *UntrackedCacheDir, path string) bool {
+	slash := strings.IndexByte(path, '/')
+	if slash < 0 {
+		ucd.invalidate()
+		uc.changed = true
+		return uc.DirFlags&UNTRACKED_CACHE_SHOW_OTHER_DIRECTORIES != 0
+	}
+
+	invalidated := uc.DirFlags&UNTRACKED_CACHE_SHOW_OTHER_DIRECTORIES != 0
+	if sub := ucd.lookup(path[:slash]); sub != nil {
+		invalidated = uc.invalidate_path(sub, path[slash+1:])
+	}
+	if invalidated {
+		ucd.invalidate()
+		uc.changed = true
+	}
+	return invalidated
+}
+
+func (ucd *UntrackedCacheDir) invalidate() {
+	ucd.Valid = false
+	ucd.Untracked = nil
+}
+
+// invalidate_all invalidates the directory and all sub directories. (the exclude rules are changed)
+func (ucd *UntrackedCacheDir) invalidate_all() {
+	ucd.invalidate()
+	for _, sub := range ucd.Dirs {
+		sub.invalidate_all()
+	}
+}
+
+func (ucd *UntrackedCacheDir) lookup(name string) *UntrackedCacheDir {
+	i := sort.Search(len(ucd.Dirs), func(i int) bool { return ucd.Dirs[i].Name >= name })
+	if i < len(ucd.Dirs) && ucd.Dirs[i].Name == name {
+		return ucd.Dirs[i]
+	}
+	return nil
+}
+
+// usable_untracked_cache returns the untracked cache if it can be used with the ignore rules. nil is returned otherwise.
+// the cache is reset when the global exclude files are changed.
+func usable_untracked_cache(d *Dircache, x *IgnoreRules) *UntrackedCache {
+	uc := d.untracked
+	if uc == nil || x.standard == false || uc.ExcludePerDir != x.per_dir {
+		return nil
+	}
+
+	ident := untracked_cache_ident(x.root)
+	found := false
+	for _, i := range uc.Idents {
+		found = found || i == ident
+	}
+	if found == false {
+		fmt.Fprintf(os.Stderr, "warning: untracked cache is disabled on this system or location\n")
+		return nil
+	}
+
+	// the cache of 'git status' records untracked directories instead of their files
+	if uc.DirFlags != 0 {
+		uc.DirFlags = 0
+		uc.Root = nil
+		uc.changed = true
+	}
+
+	info_exclude := exclude_file_oid_stat(d, x.info_exclude, uc.InfoExclude)
+	excludes_file := exclude_file_oid_stat(d, x.excludes_file, uc.ExcludesFile)
+	if info_exclude.Sha1 != uc.InfoExclude.Sha1 || excludes_file.Sha1 != uc.ExcludesFile.Sha1 {
+		if uc.Root != nil {
+			uc.Root.invalidate_all()
+		}
+	}
+	if info_exclude != uc.InfoExclude || excludes_file != uc.ExcludesFile {
+		uc.InfoExclude = info_exclude
+		uc.ExcludesFile = excludes_file
+		uc.changed = true
+	}
+
+	if uc.Root == nil {
+		uc.Root = &UntrackedCacheDir{}
+		uc.changed = true
+	}
+	return uc
+}
+
+// exclude_file_oid_stat returns the stat data and the blob of the exclude file.
+// the file is not hashed again if the stat data is not changed.
+func exclude_file_oid_stat(d *Dircache, path string, old OidStat) OidStat {
+	var o OidStat
+	info, err := os.Stat(path)
+	if err != nil {
+		return o
+	}
+	o.Stat = stat_data(info)
+	if o.Stat == old.Stat && is_racy_stat_data(d, o.Stat) == false {
+		o.Sha1 = old.Sha1
+		return o
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return OidStat{}
+	}
+	o.Sha1 = blob_sha1(b)
+	return o
+}
+
+// valid_dir reports whether the untracked files of the directory are cached and the directory is not changed.
+// Otherwise the directory is invalidated with new stat data to be scanned again.
+func (uc *UntrackedCache) valid_dir(d *Dircache, x *IgnoreRules, dir string, ucd *UntrackedCacheDir) bool {
+	// patterns of the per-directory exclude file affect all sub directories
+	sha := x.per_directory_sha1(dir)
+	if sha != ucd.ExcludeSha1 {
+		ucd.invalidate_all()
+		ucd.ExcludeSha1 = sha
+		uc.changed = true
+	}
+
+	info, err := os.Lstat(filepath.Join(x.root, dir))
+	if err != nil {
+		ucd.invalidate()
+		return false
+	}
+	st := stat_data(info)
+	if ucd.Valid && st == ucd.Stat && is_racy_stat_data(d, st) == false {
+		return true
+	}
+	ucd.invalidate()
+	ucd.Stat = st
+	uc.changed = true
+	return false
+}
+
+// update_dir records the result of the directory scan.
+func (uc *UntrackedCache) update_dir(ucd *UntrackedCacheDir, untracked []string, dirs []*UntrackedCacheDir) {
+	ucd.Untracked = untracked
+	ucd.Dirs = dirs
+	ucd.Valid = true
+	uc.changed = true
+}
+
+func stat_data(info os.FileInfo) StatData {
+	var e DircacheEntry
+	fill_dircache_stat(&e, info)
+	return StatData{e.CTimeSeconds, e.CTimeNanoSeconds, e.MTimeSeconds, e.MTimeNanoSeconds, e.Dev, e.Inode, e.UID, e.GID, e.Size}
+}
+
+// is_racy_stat_data reports whether the file may be changed in the same timestamp as the index was written.
+func is_racy_stat_data(d *Dircache, st StatData) bool {
+	return is_racily_clean(d, &DircacheEntry{MTimeSeconds: st.MTimeSeconds, MTimeNanoSeconds: st.MTimeNanoSeconds})
+}
+
+// blob_sha1 returns the object name of the contents as a blob.
+func blob_sha1(b []byte) [20]byte {
+	var sha [20]byte
+	key, _ := hash_object(false, bytes.NewReader(b))
+	copy(sha[:], key)
+	return sha
+}
@@ -4,7 +4,9 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,12 +15,48 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
+)
+
+const (
+	DIRCACHE_FLAG_ASSUME_VALID = uint16(0b1000000000000000) // [1-bit: assume-valid flag]
+	DIRCACHE_FLAG_EXTENDED     = uint16(0b0100000000000000) // [1-bit: extended flag(must be zero in version 2)]
+	DIRCACHE_FLAG_STAGE        = uint16(0b0011000000000000) // [2-bit: stage(during merge)]
+	DIRCACHE_FLAG_NAME_LENGTH  = uint16(0b0000111111111111) // [12-bit: name length]
+
+	DIRCACHE_EXTENDED_FLAG_SKIP_WORKTREE = uint16(0b0100000000000000) // [1-bit: skip-worktree flag(used by sparse checkout)]
+	DIRCACHE_EXTENDED_FLAG_INTENT_TO_ADD = uint16(0b0010000000000000) // [1-bit: intent-to-add flag(used by "git add -N")]
+
+	DIRCACHE_DEFAULT_VERSION = 2
 )
 
 type Dircache struct {
-	Header  DircacheHeader
-	Entries []*DircacheEntry
+	Header     DircacheHeader
+	Entries    []*DircacheEntry // sorted by path and stage
+	Extensions []*DircacheExtension
+	MTime      time.Time // modified time of the index file. (for racy-git detection)
+
+	// entries added out of order. they are merged into Entries when the index is written.
+	pending map[dircache_key]*DircacheEntry
+
+	split     *SplitIndex     // 'link' extension. entries are shared with $GIT_DIR/sharedindex.<sha1>
+	untracked *UntrackedCache // 'UNTR' extension
+
+	no_symlinks   bool // core.symlinks=false. symbolic links are checked out as plain files.
+	preload_index bool // core.preloadIndex. files are checked concurrently
+
+	// files stat'ed and hashed in advance. they are consumed by update_dircache
+	preloaded map[string]*WorktreeFile
+
+	lock *LockFile // $GIT_DIR/index.lock held while the index is updated
+}
+
+type dircache_key struct {
+	path  string
+	stage int
 }
 
 type DircacheHeader struct {
@@ -27,6 +65,12 @@ type DircacheHeader struct {
 	NumberOfEntries int32
 }
 
+type DircacheExtension struct {
+	Signature [4]byte // If the first byte is 'A'..'Z' the extension is optional and can be ignored.
+	Size      uint32
+	Data      []byte
+}
+
 type DircacheEntry struct {
 	CTimeSeconds     uint32
 	CTimeNanoSeconds uint32
@@ -39,30 +83,95 @@ type DircacheEntry struct {
 	GID              uint32
 	Size             uint32
 	Sha1             [20]byte
-	Flags            uint16 // [1-bit: assume-valid flag] [1-bit: extended flag(must be zero)] [2-bit: stage(during merge)] [12-bit: name length]
+	Flags            uint16 // [1-bit: assume-valid flag] [1-bit: extended flag(must be zero in version 2)] [2-bit: stage(during merge)] [12-bit: name length]
+	ExtendedFlags    uint16 // (version 3 or later) [1-bit: reserved] [1-bit: skip-worktree flag] [1-bit: intent-to-add flag] [13-bit: unused]
 	PathName         []byte // variable length. size is 'Size'
 	ZeroPaddingSize  int    // for 8 byte alignment
+
+	removed    bool // dropped when the index is written (CE_REMOVE in git)
+	uptodate   bool // the stat data was taken from the file just now (CE_UPTODATE in git)
+	base_index int  // position+1 of the entry in the shared index. 0 for entries not in the shared index
+}
+
+// lock_dircache takes $GIT_DIR/index.lock and loads the index.
+// the lock is taken before loading so that concurrent updates are not lost.
+func lock_dircache(path string) (*Dircache, error) {
+	lock, err := hold_lock_file(filepath.Join(path, "index"))
+	if err != nil {
+		return nil, err
+	}
+	d, err := load_dircache(path)
+	if err != nil {
+		lock.rollback()
+		return nil, err
+	}
+	d.lock = lock
+	return d, nil
 }
 
 func load_dircache(path string) (*Dircache, error) {
-	contents, err := ioutil.ReadFile(filepath.Join(path, "index"))
+	f, err := os.Open(filepath.Join(path, "index"))
 	if err != nil && os.IsNotExist(err) {
 		// return new dircache
 		d := &Dircache{}
 		d.Header.Signature = [4]byte{'D', 'I', 'R', 'C'}
-		d.Header.Version = 2
+		d.Header.Version = int32(get_config_int(path, "index.version", DIRCACHE_DEFAULT_VERSION))
+		if d.Header.Version < 2 || d.Header.Version > 4 {
+			d.Header.Version = DIRCACHE_DEFAULT_VERSION
+		}
 		d.Header.NumberOfEntries = 0
+		d.no_symlinks = get_config_bool(path, "core.symlinks", true) == false
+		d.preload_index = get_config_bool(path, "core.preloadIndex", true)
+		tweak_split_index(path, d)
+		tweak_untracked_cache(path, d)
 		return d, nil
 	} else if err != nil {
 		return nil, err
 	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	contents, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
 
 	// read exist dircache
-	return read_dircache_bytes(contents)
+	d, err := read_dircache_bytes(contents)
+	if err != nil {
+		return nil, err
+	}
+	if d.split != nil {
+		if err := read_shared_index(path, d); err != nil {
+			return nil, err
+		}
+	}
+	d.MTime = info.ModTime()
+	d.no_symlinks = get_config_bool(path, "core.symlinks", true) == false
+	d.preload_index = get_config_bool(path, "core.preloadIndex", true)
+	tweak_split_index(path, d)
+	tweak_untracked_cache(path, d)
+	return d, nil
 }
 
 func read_dircache_bytes(b []byte) (*Dircache, error) {
-	buf := bytes.NewReader(b)
+	if len(b) < 12+sha1.Size {
+		return nil, fmt.Errorf("index file smaller than expected")
+	}
+
+	// 160-bit SHA-1 over the content of the index file before this checksum.
+	// (all zero when git skips hashing. See also 'index.skipHash')
+	content := b[:len(b)-sha1.Size]
+	checksum := b[len(b)-sha1.Size:]
+	sum := sha1.Sum(content)
+	if bytes.Equal(checksum, sum[:]) == false && bytes.Equal(checksum, make([]byte, sha1.Size)) == false {
+		return nil, fmt.Errorf("bad index file sha1 signature: index file corrupt")
+	}
+
+	buf := bytes.NewReader(content)
 	var d Dircache
 
 	// Header
@@ -70,7 +179,15 @@ func read_dircache_bytes(b []byte) (*Dircache, error) {
 	binary.Read(buf, binary.BigEndian, &d.Header.Version)
 	binary.Read(buf, binary.BigEndian, &d.Header.NumberOfEntries)
 
+	if d.Header.Signature != [4]byte{'D', 'I', 'R', 'C'} {
+		return nil, fmt.Errorf("bad signature 0x%08x: index file corrupt", binary.BigEndian.Uint32(d.Header.Signature[:]))
+	}
+	if d.Header.Version < 2 || d.Header.Version > 4 {
+		return nil, fmt.Errorf("bad index version %d: index file corrupt", d.Header.Version)
+	}
+
 	// Entries
+	var prev_name []byte
 	for i := int32(0); i < d.Header.NumberOfEntries; i++ {
 		var e DircacheEntry
 		n := 0
@@ -123,25 +240,110 @@ func read_dircache_bytes(b []byte) (*Dircache, error) {
 		}
 		n += 2
 
-		name_len := e.Flags
-		name_len &= uint16(0b00001111111111111111) // [12-bit: name length]
+		// version 3 or later: extended flags
+		if e.Flags&DIRCACHE_FLAG_EXTENDED != 0 {
+			if d.Header.Version < 3 {
+				return nil, fmt.Errorf("extended flag is set in index version %d", d.Header.Version)
+			}
+			if err := binary.Read(buf, binary.BigEndian, &e.ExtendedFlags); err != nil {
+				return nil, err
+			}
+			n += 2
+		}
+
+		// version 4: path name is prefix-compressed and not padded
+		if d.Header.Version == 4 {
+			strip, err := read_varint(buf)
+			if err != nil {
+				return nil, err
+			}
+			if strip > uint64(len(prev_name)) {
+				return nil, fmt.Errorf("malformed name field in the index, near path '%s'", string(prev_name))
+			}
+			suffix, err := read_until_nul(buf)
+			if err != nil {
+				return nil, err
+			}
+			name := make([]byte, 0, len(prev_name)-int(strip)+len(suffix))
+			name = append(name, prev_name[:len(prev_name)-int(strip)]...)
+			e.PathName = append(name, suffix...)
+			prev_name = e.PathName
 
-		b := make([]byte, name_len)
-		if _, err := io.ReadAtLeast(buf, b, int(name_len)); err != nil {
-			return nil, err
+			d.Entries = append(d.Entries, &e)
+			continue
 		}
-		e.PathName = b
-		n += int(name_len)
 
-		// padding
-		for k := 0; k < (8 - n%8); k++ {
-			var padding byte
-			binary.Read(buf, binary.BigEndian, padding)
+		name_len := e.Flags & DIRCACHE_FLAG_NAME_LENGTH
+		padding := 0
+
+		if name_len < DIRCACHE_FLAG_NAME_LENGTH {
+			b := make([]byte, name_len)
+			if _, err := io.ReadFull(buf, b); err != nil {
+				return nil, err
+			}
+			e.PathName = b
+			n += int(name_len)
+			padding = 8 - n%8
+		} else {
+			// the name is 0xFFF bytes or longer. it is terminated by the first NUL
+			b, err := read_until_nul(buf)
+			if err != nil {
+				return nil, err
+			}
+			e.PathName = b
+			n += len(b)
+			padding = 8 - n%8 - 1
+		}
+
+		// 1-8 nul bytes as necessary to pad the entry to a multiple of eight bytes
+		// while keeping the name NUL-terminated.
+		pad := make([]byte, padding)
+		if _, err := io.ReadFull(buf, pad); err != nil {
+			return nil, err
+		}
+		if bytes.Count(pad, []byte{0}) != len(pad) {
+			return nil, fmt.Errorf("malformed name field in the index, near path '%s'", string(e.PathName))
 		}
 
 		d.Entries = append(d.Entries, &e)
 	}
 
+	// Extensions
+	for buf.Len() > 0 {
+		var ext DircacheExtension
+		if err := binary.Read(buf, binary.BigEndian, &ext.Signature); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(buf, binary.BigEndian, &ext.Size); err != nil {
+			return nil, err
+		}
+		if int64(ext.Size) > int64(buf.Len()) {
+			return nil, fmt.Errorf("index extension %s is truncated: index file corrupt", string(ext.Signature[:]))
+		}
+		ext.Data = make([]byte, ext.Size)
+		if _, err := io.ReadFull(buf, ext.Data); err != nil {
+			return nil, err
+		}
+
+		switch string(ext.Signature[:]) {
+		case "link": // split index
+			s, err := read_link_extension(ext.Data)
+			if err != nil {
+				return nil, err
+			}
+			d.split = s
+			continue
+		case "UNTR": // untracked cache. it is just dropped if we can't read it
+			d.untracked, _ = read_untracked_extension(ext.Data)
+			continue
+		}
+
+		if ext.Signature[0] < 'A' || 'Z' < ext.Signature[0] {
+			return nil, fmt.Errorf("index uses %s extension, which we do not understand", string(ext.Signature[:]))
+		}
+		d.Extensions = append(d.Extensions, &ext)
+	}
+
 	return &d, nil
 }
 
@@ -154,6 +356,7 @@ func build_dircache_bytes(d *Dircache) []byte {
 	binary.Write(buf, binary.BigEndian, d.Header.NumberOfEntries)
 
 	// Entries
+	var prev_name []byte
 	for _, e := range d.Entries {
 		s := 0
 		binary.Write(buf, binary.BigEndian, e.CTimeSeconds)
@@ -178,65 +381,425 @@ func build_dircache_bytes(d *Dircache) []byte {
 		s += 4
 		binary.Write(buf, binary.BigEndian, e.Sha1)
 		s += 20
-		binary.Write(buf, binary.BigEndian, e.Flags)
+		flags := e.Flags &^ DIRCACHE_FLAG_NAME_LENGTH
+		flags |= dircache_name_length(len(e.PathName))
+		binary.Write(buf, binary.BigEndian, flags)
 		s += 2
-		binary.Write(buf, binary.BigEndian, e.PathName)
-		s += len(e.PathName)
+		if e.Flags&DIRCACHE_FLAG_EXTENDED != 0 {
+			binary.Write(buf, binary.BigEndian, e.ExtendedFlags)
+			s += 2
+		}
 
-		// padding
-		for i := 0; i < (8 - s%8); i++ {
+		// version 4: path name is prefix-compressed and not padded
+		if d.Header.Version == 4 {
+			common := 0
+			for common < len(prev_name) && common < len(e.PathName) && prev_name[common] == e.PathName[common] {
+				common++
+			}
+			buf.Write(encode_varint(uint64(len(prev_name) - common)))
+			buf.Write(e.PathName[common:])
 			buf.WriteByte(byte(0))
+			prev_name = e.PathName
+			continue
+		}
+
+		buf.Write(e.PathName)
+		s += len(e.PathName)
+
+		// padding (1-8 nul bytes)
+		buf.Write(make([]byte, 8-s%8))
+	}
+
+	// Extensions
+	extensions := d.Extensions
+	if d.split != nil && d.split.BaseSha1 != [20]byte{} {
+		ext := &DircacheExtension{Signature: [4]byte{'l', 'i', 'n', 'k'}, Data: link_extension_bytes(d.split)}
+		extensions = append([]*DircacheExtension{ext}, extensions...)
+	}
+	if d.untracked != nil {
+		ext := &DircacheExtension{Signature: [4]byte{'U', 'N', 'T', 'R'}, Data: untracked_extension_bytes(d.untracked)}
+		extensions = append(extensions, ext)
+	}
+	for _, ext := range extensions {
+		if is_stale_dircache_extension(ext) {
+			continue
 		}
+		binary.Write(buf, binary.BigEndian, ext.Signature)
+		binary.Write(buf, binary.BigEndian, uint32(len(ext.Data)))
+		buf.Write(ext.Data)
 	}
 
+	// checksum
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+
 	return buf.Bytes()
 }
 
-func write_dircache(d *Dircache, repop string) error {
-	// sort by filename
-	sort.Slice(d.Entries, func(i, k int) bool {
-		return bytes.Compare(d.Entries[i].PathName, d.Entries[k].PathName) < 0
+// is_stale_dircache_extension reports whether the extension describes entries we may have changed.
+// these are dropped on write and git rebuilds them. the others are preserved as is.
+func is_stale_dircache_extension(ext *DircacheExtension) bool {
+	switch string(ext.Signature[:]) {
+	case "TREE": // cache tree
+		return true
+	case "EOIE": // end of index entry (offset of extensions)
+		return true
+	case "IEOT": // index entry offset table
+		return true
+	}
+	return false
+}
+
+// read_varint reads the offset encoded integer used by index version 4.
+func read_varint(r io.ByteReader) (uint64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	val := uint64(c & 127)
+	for c&128 != 0 {
+		c, err = r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		val += 1
+		val = (val << 7) + uint64(c&127)
+	}
+	return val, nil
+}
+
+func encode_varint(val uint64) []byte {
+	var b [16]byte
+	pos := len(b) - 1
+	b[pos] = byte(val & 127)
+	for val >>= 7; val != 0; val >>= 7 {
+		val--
+		pos--
+		b[pos] = byte(128 | (val & 127))
+	}
+	return b[pos:]
+}
+
+func read_until_nul(r io.ByteReader) ([]byte, error) {
+	var b []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == 0 {
+			return b, nil
+		}
+		b = append(b, c)
+	}
+}
+
+func (e *DircacheEntry) stage() int {
+	return int((e.Flags & DIRCACHE_FLAG_STAGE) >> 12)
+}
+
+// assume_valid reports whether the file is assumed to be unchanged. (update-index --assume-unchanged)
+func (e *DircacheEntry) assume_valid() bool {
+	return e.Flags&DIRCACHE_FLAG_ASSUME_VALID != 0
+}
+
+// skip_worktree reports whether the file is out of the work tree. (update-index --skip-worktree)
+func (e *DircacheEntry) skip_worktree() bool {
+	return e.ExtendedFlags&DIRCACHE_EXTENDED_FLAG_SKIP_WORKTREE != 0
+}
+
+// intent_to_add reports whether only the path is recorded without the contents. (add -N)
+func (e *DircacheEntry) intent_to_add() bool {
+	return e.ExtendedFlags&DIRCACHE_EXTENDED_FLAG_INTENT_TO_ADD != 0
+}
+
+func new_dircache_entry(path string, sha [20]byte, mode uint32, stage int) *DircacheEntry {
+	e := &DircacheEntry{}
+	e.Mode = mode
+	e.Sha1 = sha
+	e.PathName = []byte(path)
+
+	var flag uint16
+	flag |= DIRCACHE_FLAG_STAGE & (uint16(stage) << 12)
+	flag |= dircache_name_length(len(path))
+	e.Flags = flag
+
+	return e
+}
+
+// dircache_name_length returns the name length field of flags.
+// If the length is 0xFFF or longer, 0xFFF is stored.
+func dircache_name_length(length int) uint16 {
+	if length < int(DIRCACHE_FLAG_NAME_LENGTH) {
+		return uint16(length)
+	}
+	return DIRCACHE_FLAG_NAME_LENGTH
+}
+
+// sort_dircache_entries merges pending entries into Entries and drops removed entries.
+func sort_dircache_entries(d *Dircache) {
+	entries := make([]*DircacheEntry, 0, len(d.Entries)+len(d.pending))
+	for _, e := range d.Entries {
+		if e.removed == false {
+			entries = append(entries, e)
+		}
+	}
+	for _, e := range d.pending {
+		entries = append(entries, e)
+	}
+	d.Entries = entries
+	d.pending = nil
+
+	// sort by filename and stage
+	sort.SliceStable(d.Entries, func(i, k int) bool {
+		return compare_dircache_entry(d.Entries[i].PathName, d.Entries[i].stage(), d.Entries[k].PathName, d.Entries[k].stage()) < 0
 	})
+}
+
+func write_dircache(d *Dircache, repop string) error {
+	// merge pending entries and drop removed entries
+	sort_dircache_entries(d)
 
 	// set entry nunber
 	d.Header.NumberOfEntries = int32(len(d.Entries))
 
-	b := build_dircache_bytes(d)
+	// the stat data of racily clean entries can not be trusted once the index gets newer than the files
+	root := filepath.Dir(repop)
+	for _, e := range d.Entries {
+		if e.uptodate == false && e.Mode != 0160000 && is_racily_clean(d, e) {
+			smudge_racily_clean_entry(d, e, root)
+		}
+	}
 
-	indexp := filepath.Join(repop, "index")
-	err := ioutil.WriteFile(indexp, b, 0644)
-	if err != nil {
+	// extended flags need version 3 or later.
+	// version 2 and 3 are switched by whether extended flags are used (same as git)
+	extended := false
+	for _, e := range d.Entries {
+		if e.ExtendedFlags != 0 {
+			e.Flags |= DIRCACHE_FLAG_EXTENDED
+			extended = true
+		} else {
+			e.Flags &^= DIRCACHE_FLAG_EXTENDED
+		}
+	}
+	if d.Header.Version == 2 || d.Header.Version == 3 {
+		if extended {
+			d.Header.Version = 3
+		} else {
+			d.Header.Version = 2
+		}
+	}
+
+	var b []byte
+	if d.split != nil {
+		// only changes from the shared index are written
+		var err error
+		b, err = build_split_dircache_bytes(d, repop)
+		if err != nil {
+			return err
+		}
+	} else {
+		b = build_dircache_bytes(d)
+	}
+
+	// the index is replaced at once by renaming index.lock
+	if d.lock == nil {
+		lock, err := hold_lock_file(filepath.Join(repop, "index"))
+		if err != nil {
+			return err
+		}
+		d.lock = lock
+	}
+	lock := d.lock
+	d.lock = nil
+	if err := lock.write(b); err != nil {
+		lock.rollback()
 		return err
 	}
+	if err := lock.commit(); err != nil {
+		return fmt.Errorf("unable to write new index file: %v", err)
+	}
 
 	return nil
 }
 
-func update_index_cmd(do_add bool, do_remove bool, paths []string) {
-	repop, err := find_git_repository(".")
+type UpdateIndexOptions struct {
+	Add               bool     // --add
+	Remove            bool     // --remove
+	ForceRemove       bool     // --force-remove: remove the file from the index even if it exists in the work tree
+	Refresh           bool     // --refresh
+	AssumeUnchanged   bool     // --assume-unchanged: set the assume-valid bit of the paths
+	NoAssumeUnchanged bool     // --no-assume-unchanged
+	SkipWorktree      bool     // --skip-worktree: set the skip-worktree bit of the paths
+	NoSkipWorktree    bool     // --no-skip-worktree
+	Chmod             string   // --chmod=(+|-)x
+	InfoOnly          bool     // --info-only: do not write objects of files, and do not verify objects of --cacheinfo and --index-info
+	IndexVersion      int      // --index-version
+	CacheInfo         []string // --cacheinfo <mode>,<object>,<path>
+	IndexInfo         bool     // --index-info: read index information from stdin
+	NulTerminated     bool     // -z: lines of --index-info are terminated with NUL
+	SplitIndex        bool     // --split-index: write a new shared index and record changes from it
+	NoSplitIndex      bool     // --no-split-index
+	UntrackedCache    bool     // --untracked-cache: enable the untracked cache
+	NoUntrackedCache  bool     // --no-untracked-cache
+}
+
+func update_index_cmd(opts UpdateIndexOptions, paths []string) {
+	repop, prefix, err := setup_git_directory()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "fatal: not a git repository (or any of the parent directories): %s", repop)
-		os.Exit(128)
+		exit(128)
 	}
 
-	// read dircache
-	d, err := load_dircache(repop)
+	// lock and read dircache
+	d, err := lock_dircache(repop)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
-		os.Exit(128)
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		exit(128)
+	}
+
+	if opts.IndexVersion != 0 {
+		d.Header.Version = int32(opts.IndexVersion)
+	}
+
+	if opts.SplitIndex {
+		if _, ok := get_config(repop, "core.splitIndex"); ok && get_config_bool(repop, "core.splitIndex", true) == false {
+			fmt.Fprintf(os.Stderr, "warning: core.splitIndex is set to false; remove or change it, if you really want to enable split index\n")
+		}
+		if d.split != nil {
+			d.split.new_shared = true
+		} else {
+			d.split = &SplitIndex{}
+		}
+	}
+	if opts.NoSplitIndex {
+		if get_config_bool(repop, "core.splitIndex", false) {
+			fmt.Fprintf(os.Stderr, "warning: core.splitIndex is set to true; remove or change it, if you really want to disable split index\n")
+		}
+		d.split = nil
+	}
+	if opts.UntrackedCache {
+		if _, ok := get_config(repop, "core.untrackedCache"); ok && get_config_bool(repop, "core.untrackedCache", true) == false {
+			fmt.Fprintf(os.Stderr, "warning: core.untrackedCache is set to false; remove or change it, if you really want to enable the untracked cache\n")
+		}
+		add_untracked_cache(repop, d)
+	}
+	if opts.NoUntrackedCache {
+		if get_config_bool(repop, "core.untrackedCache", false) {
+			fmt.Fprintf(os.Stderr, "warning: core.untrackedCache is set to true; remove or change it, if you really want to disable the untracked cache\n")
+		}
+		d.untracked = nil
+	}
+
+	needs_update := false
+	if opts.Refresh {
+		needs_update, err = refresh_dircache(d)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+			exit(128)
+		}
+	}
+
+	// --cacheinfo <mode>,<object>,<path>
+	for _, c := range opts.CacheInfo {
+		f := strings.SplitN(c, ",", 3)
+		if len(f) != 3 {
+			fmt.Fprintf(os.Stderr, "error: option 'cacheinfo' expects <mode>,<sha1>,<path>\n")
+			exit(129)
+		}
+		mode, err := strconv.ParseUint(f[0], 8, 32)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: option 'cacheinfo' expects <mode>,<sha1>,<path>\n")
+			exit(129)
+		}
+		sha, ok := parse_sha1_hex(f[1])
+		if ok == false {
+			fmt.Fprintf(os.Stderr, "error: option 'cacheinfo' expects <mode>,<sha1>,<path>\n")
+			exit(129)
+		}
+		if err := add_cacheinfo(repop, d, uint32(mode), sha, f[2], 0, opts.InfoOnly); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			fmt.Fprintf(os.Stderr, "fatal: git update-index: --cacheinfo cannot add %s\n", f[2])
+			exit(128)
+		}
+	}
+
+	pathspec, err := parse_pathspec(filepath.Dir(repop), prefix, paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		exit(128)
+	}
+
+	// directories are added recursively with --add
+	mark_only := opts.AssumeUnchanged || opts.NoAssumeUnchanged || opts.SkipWorktree || opts.NoSkipWorktree
+	var dirs []string
+	if opts.Add && mark_only == false && opts.ForceRemove == false {
+		dirs = add_directories(repop, d, pathspec, opts)
+	}
+
+	// paths in the directories are already added
+	var targets []string
+expand_loop:
+	for _, p := range pathspec.expand(d) {
+		for _, dir := range dirs {
+			if len(dir) == 0 || p == dir || strings.HasPrefix(p, dir+"/") {
+				continue expand_loop
+			}
+		}
+		targets = append(targets, p)
+	}
+
+	// files are stat'ed and hashed concurrently
+	if mark_only == false && opts.ForceRemove == false && (opts.Add || opts.Remove == false) {
+		preload_worktree_files(d, targets, opts.Add, opts.InfoOnly)
 	}
 
 	// update or add or remove dircache
-	for _, p := range paths {
-		if do_add {
-			update_dircache(d, p, true)
-		} else if do_remove {
+	for _, p := range targets {
+		// only flags are changed
+		if mark_only {
+			if err := mark_dircache_entry(d, p, opts); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+				exit(128)
+			}
+			continue
+		}
+
+		if opts.ForceRemove {
+			remove_dircache_entry(d, p)
+			continue
+		}
+
+		// the work tree file of a skip-worktree entry is assumed to be good. it is left as it is unless --remove is given
+		if idx := find_dircache_entry(d, p); idx >= 0 && d.Entries[idx].skip_worktree() {
+			e := d.Entries[idx]
+			if opts.Remove {
+				remove_dircache_entry(d, p)
+				e = nil
+			}
+			chmod_dircache_entry(e, p, opts.Chmod)
+			continue
+		}
+
+		var e *DircacheEntry
+		if opts.Add {
+			e = update_dircache(d, p, true, opts.InfoOnly)
+		} else if opts.Remove {
 			if err := remove_dircache(d, p); err != nil {
 				fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
-				os.Exit(128)
+				exit(128)
 			}
 		} else {
-			update_dircache(d, p, false)
+			e = update_dircache(d, p, false, opts.InfoOnly)
+		}
+
+		chmod_dircache_entry(e, p, opts.Chmod)
+	}
+
+	if opts.IndexInfo {
+		if err := read_index_info(repop, d, os.Stdin, opts.NulTerminated, opts.InfoOnly); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+			exit(128)
 		}
 	}
 
@@ -244,43 +807,401 @@ func update_index_cmd(do_add bool, do_remove bool, paths []string) {
 	err = write_dircache(d, repop)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
-		os.Exit(1)
+		exit(1)
+	}
+
+	if needs_update {
+		exit(1)
+	}
+}
+
+// read_index_info reads lines in one of the following formats and updates the index.
+//
+//	<mode> SP <sha1> TAB <path>                  (the format of --cacheinfo)
+//	<mode> SP <type> SP <sha1> TAB <path>        (the output of ls-tree)
+//	<mode> SP <sha1> SP <stage> TAB <path>       (the output of ls-files --stage)
+//
+// mode 0 removes all stages of the path. paths are relative to the top of the work tree.
+func read_index_info(repop string, d *Dircache, r io.Reader, nul_terminated bool, info_only bool) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	term := byte('\n')
+	if nul_terminated {
+		term = 0
+	}
+
+	for _, l := range bytes.Split(b, []byte{term}) {
+		line := string(l)
+		if len(line) == 0 {
+			continue
+		}
+
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return fmt.Errorf("malformed index info %s", line)
+		}
+		path := line[tab+1:]
+		if nul_terminated == false && strings.HasPrefix(path, "\"") {
+			unquoted, _, ok := unquote_c_style([]byte(path))
+			if ok == false {
+				return fmt.Errorf("git update-index: bad quoting of path name")
+			}
+			path = unquoted
+		}
+
+		f := strings.Split(line[:tab], " ")
+		stage := 0
+		switch len(f) {
+		case 2:
+		case 3:
+			// '<mode> <type> <sha1>' or '<mode> <sha1> <stage>'
+			if len(f[1]) == 40 {
+				n, err := strconv.Atoi(f[2])
+				if err != nil || n < 0 || n > 3 {
+					return fmt.Errorf("malformed index info %s", line)
+				}
+				stage = n
+				f = f[:2]
+			} else {
+				f = []string{f[0], f[2]}
+			}
+		default:
+			return fmt.Errorf("malformed index info %s", line)
+		}
+
+		mode, err := strconv.ParseUint(f[0], 8, 32)
+		if err != nil {
+			return fmt.Errorf("malformed index info %s", line)
+		}
+		sha, ok := parse_sha1_hex(f[1])
+		if ok == false {
+			return fmt.Errorf("malformed index info %s", line)
+		}
+
+		if mode == 0 {
+			// remove the path to place higher stage entries
+			remove_dircache_entry(d, path)
+			continue
+		}
+
+		if err := add_cacheinfo(repop, d, uint32(mode), sha, path, stage, info_only); err != nil {
+			return fmt.Errorf("git update-index: unable to update %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// add_cacheinfo adds the entry of the object to the index without the file in the work tree.
+// the object must exist unless info_only is set. (gitlinks are never verified)
+func add_cacheinfo(repop string, d *Dircache, mode uint32, sha [20]byte, path string, stage int, info_only bool) error {
+	if verify_path(path) == false {
+		return fmt.Errorf("Invalid path '%s'", path)
+	}
+
+	var obj_type string
+	switch mode & 0170000 {
+	case 0100000:
+		// only 0755 and 0644 are valid for regular files
+		if mode&0100 != 0 {
+			mode = 0100755
+		} else {
+			mode = 0100644
+		}
+		obj_type = "blob"
+	case 0120000:
+		mode = 0120000
+		obj_type = "blob"
+	case 0160000:
+		mode = 0160000
+	default:
+		return fmt.Errorf("invalid mode %06o for '%s'", mode, path)
+	}
+
+	if info_only == false && len(obj_type) > 0 {
+		t, _, err := read_object_file(repop, fmt.Sprintf("%x", sha))
+		if err != nil || t != obj_type {
+			return fmt.Errorf("invalid object %06o %x for '%s'", mode, sha, path)
+		}
+	}
+
+	add_dircache_entry(d, new_dircache_entry(path, sha, mode, stage))
+	return nil
+}
+
+// parse_sha1_hex parses 40 hex digits of an object name.
+func parse_sha1_hex(s string) ([20]byte, bool) {
+	var sha [20]byte
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(sha) {
+		return sha, false
 	}
+	copy(sha[:], b)
+	return sha, true
 }
 
-func update_dircache(d *Dircache, path string, do_add bool) {
+// verify_path reports whether the path can be recorded in the index.
+// empty components, '.', '..' and '.git' are not allowed.
+func verify_path(path string) bool {
+	if len(path) == 0 || strings.HasSuffix(path, "/") {
+		return false
+	}
+	for _, c := range strings.Split(path, "/") {
+		if len(c) == 0 || c == "." || c == ".." || strings.EqualFold(c, ".git") || c == REPOSITORY_DIR_NAME {
+			return false
+		}
+	}
+	return true
+}
+
+// refresh_dircache updates stat data of unchanged entries without changing their contents.
+// It reports whether some entries need update or merge.
+func refresh_dircache(d *Dircache) (bool, error) {
+	// files are checked concurrently and reported in order
+	status := preload_worktree_status(d, false, true, func(e *DircacheEntry) bool {
+		return e.assume_valid() || e.skip_worktree() || e.stage() != 0
+	})
+
+	needs_update := false
+	for i, e := range d.Entries {
+		path := string(e.PathName)
+
+		// entries which must not be checked with the work tree
+		if e.assume_valid() || e.skip_worktree() {
+			continue
+		}
+
+		if e.stage() != 0 {
+			if i == 0 || bytes.Equal(d.Entries[i-1].PathName, e.PathName) == false {
+				fmt.Printf("%s: needs merge\n", path)
+			}
+			needs_update = true
+			continue
+		}
+
+		if status[i].err != nil {
+			return false, status[i].err
+		}
+		if status[i].modified {
+			fmt.Printf("%s: needs update\n", path)
+			needs_update = true
+			continue
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			return false, err
+		}
+		fill_dircache_stat(e, info)
+	}
+	return needs_update, nil
+}
+
+// add_directories adds files in the directories named by the pathspec and returns the directories.
+// '.git', '.toy-git' and ignored files are skipped.
+func add_directories(repop string, d *Dircache, ps *Pathspec, opts UpdateIndexOptions) []string {
+	var dirs []string
+	var x *IgnoreRules
+	for _, item := range ps.Items {
+		if item.Magic&PATHSPEC_EXCLUDE != 0 || item.Wildcard {
+			continue
+		}
+		dir := strings.TrimSuffix(item.Match, "/")
+		if len(dir) > 0 {
+			if info, err := os.Lstat(dir); err != nil || info.IsDir() == false {
+				continue
+			}
+			// nested repositories are added as gitlinks
+			if len(nested_repository(dir)) > 0 {
+				continue
+			}
+		}
+
+		if x == nil {
+			x = new_ignore_rules(repop)
+			if err := x.add_exclude_standard(repop); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+				exit(128)
+			}
+		}
+		files, err := list_directory_files(filepath.Dir(repop), dir, d, x)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: internal error: %v\n", err)
+			exit(128)
+		}
+		var matched []string
+		for _, f := range files {
+			if ps.match(f) {
+				matched = append(matched, f)
+			}
+		}
+		preload_worktree_files(d, matched, true, opts.InfoOnly)
+		for _, f := range matched {
+			e := update_dircache(d, f, true, opts.InfoOnly)
+			chmod_dircache_entry(e, f, opts.Chmod)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// chmod_dircache_entry sets the executable bit of the entry by '+x' or '-x'. nothing is done for "".
+func chmod_dircache_entry(e *DircacheEntry, path string, chmod string) {
+	if len(chmod) == 0 {
+		return
+	}
+	if e == nil || e.Mode&0170000 != 0100000 {
+		fmt.Fprintf(os.Stderr, "fatal: git update-index: cannot chmod %s '%s'\n", chmod, path)
+		exit(128)
+	}
+	if chmod == "+x" {
+		e.Mode = 0100755
+	} else {
+		e.Mode = 0100644
+	}
+}
+
+// update_dircache adds or updates the entry of the file and returns it.
+func update_dircache(d *Dircache, path string, do_add bool, info_only bool) *DircacheEntry {
+	// file stat (and hash, if preloaded)
+	wf, ok := d.preloaded[path]
+	if ok {
+		delete(d.preloaded, path)
+	} else {
+		wf = stat_worktree_file(d, path, do_add, info_only)
+	}
+	info, err := wf.info, wf.err
+	if err == nil && info.IsDir() && nested_repository(path) == "" {
+		fmt.Fprintf(os.Stderr, "error: %s: is a directory - add files inside instead\n", path)
+		fmt.Fprintf(os.Stderr, "fatal: Unable to process path %s\n", path)
+		exit(128)
+	}
+
 	// already added?
 	if find_dircache_entry(d, path) < 0 && do_add == false {
 		fmt.Fprintf(os.Stderr, "error: %s: does not exist and --remove not passed\n", path)
 		fmt.Fprintf(os.Stderr, "fatal: Unable to process path %s\n", path)
-		os.Exit(128)
+		exit(128)
 	}
-
-	// file stat
-	info, err := os.Lstat(path)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "internal error: %v\n", err)
 		fmt.Fprintf(os.Stderr, "fatal: Unable to process path %s\n", path)
-		os.Exit(128)
+		exit(128)
 	}
 
-	// create hash-object
-	f, err := os.Open(path)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "internal error: %v\n", err)
+	// create hash-object (the checked out commit for nested repositories)
+	sha, err := wf.sha, wf.hash_err
+	if sha == nil && err == nil {
+		sha, err = hash_worktree_file(path, info, info_only == false)
+	}
+	if err != nil && info.IsDir() {
+		fmt.Fprintf(os.Stderr, "error: %s: is a directory - add files inside instead\n", path)
 		fmt.Fprintf(os.Stderr, "fatal: Unable to process path %s\n", path)
-		os.Exit(128)
+		exit(128)
 	}
-	defer f.Close()
-	sha, err := hash_object(true, f)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "internal error: %v\n", err)
 		fmt.Fprintf(os.Stderr, "fatal: Unable to process path %s\n", path)
-		os.Exit(128)
+		exit(128)
 	}
 
+	var old *DircacheEntry
+	if idx := find_dircache_entry(d, path); idx >= 0 {
+		old = d.Entries[idx]
+	}
 	e := &DircacheEntry{}
+	fill_dircache_stat(e, info)
+	e.Mode = worktree_mode(d, old, info)
+
+	for i, v := range sha {
+		e.Sha1[i] = v
+	}
 
+	var flag uint16
+	flag |= uint16(0b0000000000000000)      // [1-bit: assume-valid flag]
+	flag |= uint16(0b0000000000000000)      // [1-bit: extended flag(must be zero in version 2)]
+	flag |= uint16(0b0000000000000000)      // [2-bit: stage(during merge)]
+	flag |= dircache_name_length(len(path)) // [12-bit: name length]
+	e.Flags = flag
+
+	e.PathName = []byte(path)
+	add_dircache_entry(d, e)
+	return e
+}
+
+// dircache_mode returns the mode of the file to be recorded in the index.
+func dircache_mode(info os.FileInfo) uint32 {
+	var modeFlag uint32
+	// 4-bit object type valid values in binary are 1000 (regular file), 1010 (symbolic link) and 1110 (gitlink)
+	if info.Mode()&os.ModeSymlink != 0 {
+		modeFlag |= uint32(0b00000000000000001010000000000000)
+	} else if info.IsDir() {
+		// gitlink (the directory of a nested repository)
+		modeFlag |= uint32(0b00000000000000001110000000000000)
+	} else {
+		// regular file
+		modeFlag |= uint32(0b00000000000000001000000000000000)
+	}
+	// 3-bit unused
+	// 9-bit unix permission. Only 0755 and 0644 are valid for regular files. Symbolic links and gitlinks have value 0 in this field.
+	if info.Mode()&os.ModeSymlink == 0 && info.IsDir() == false {
+		perm := uint32(0644)
+		if info.Mode()&0100 != 0 {
+			perm = uint32(0755)
+		}
+		modeFlag |= perm
+	}
+	return modeFlag
+}
+
+// worktree_mode returns the mode of the file to be recorded for the entry.
+// without core.symlinks, a plain file checked out for a symbolic link keeps the mode of the entry.
+func worktree_mode(d *Dircache, e *DircacheEntry, info os.FileInfo) uint32 {
+	if d.no_symlinks && e != nil && e.Mode&0170000 == 0120000 && info.Mode().IsRegular() {
+		return e.Mode
+	}
+	return dircache_mode(info)
+}
+
+// hash_worktree_file hashes the file as a blob. the link target is hashed for symbolic links.
+// the commit checked out in a nested repository is returned for directories.
+func hash_worktree_file(path string, info os.FileInfo, write bool) ([]byte, error) {
+	if info.IsDir() {
+		return resolve_gitlink_head(path)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		return hash_object(write, strings.NewReader(target))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return hash_object(write, f)
+}
+
+// smudge_racily_clean_entry clears the size of the entry whose stat data matches the file but contents do not,
+// so that readers of the index written later do not trust the stat data. (ce_smudge_racily_clean_entry in git)
+func smudge_racily_clean_entry(d *Dircache, e *DircacheEntry, root string) {
+	path := filepath.Join(root, string(e.PathName))
+	info, err := os.Lstat(path)
+	if err != nil || worktree_mode(d, e, info) != e.Mode || is_stat_changed(e, info) {
+		return
+	}
+	sha, err := hash_worktree_file(path, info, false)
+	if err == nil && bytes.Equal(sha, e.Sha1[:]) == false {
+		e.Size = 0
+	}
+}
+
+// fill_dircache_stat sets stat data of the file to the entry.
+func fill_dircache_stat(e *DircacheEntry, info os.FileInfo) {
 	switch runtime.GOOS {
 	case "windows":
 		log.Fatal("windows not suppported")
@@ -296,55 +1217,87 @@ func update_dircache(d *Dircache, path string, do_add bool) {
 		e.Dev = uint32(internal_info.Dev)
 		e.Inode = uint32(internal_info.Ino)
 
-		var modeFlag uint32
-		// 4-bit object type valid values in binary are 1000 (regular file), 1010 (symbolic link) and 1110 (gitlink)
-		if info.Mode()&os.ModeSymlink != 0 {
-			modeFlag |= uint32(0b00000000000000001010000000000000)
-		} else {
-			// regular file (git link is current unsupported)
-			modeFlag |= uint32(0b00000000000000001000000000000000)
-		}
-		// 3-bit unused
-		// 9-bit unix permission. Only 0755 and 0644 are valid for regular files. Symbolic links and gitlinks have value 0 in this field.
-		if info.Mode()&os.ModeSymlink == 0 {
-			perm := uint32(0644)
-			perm |= (uint32(0111) & uint32(info.Mode()))
-			modeFlag |= perm
-		}
-		e.Mode = modeFlag
-
 		e.UID = internal_info.Uid
 		e.GID = internal_info.Gid
 		e.Size = uint32(info.Size())
+	}
+	e.uptodate = info.Mode().IsRegular()
+}
 
-		for i, v := range sha {
-			e.Sha1[i] = v
-		}
-
-		var flag uint16
-		flag |= uint16(0b0000000000000000)     // [1-bit: assume-valid flag]
-		flag |= uint16(0b0000000000000000)     // [1-bit: extended flag(must be zero)]
-		flag |= uint16(0b0000000000000000)     // [2-bit: stage(during merge)]
-		mask := uint16(0b00001111111111111111) // [12-bit: name length]
-		flag |= mask & uint16(len(path))
-		e.Flags = flag
-
-		e.PathName = []byte(path)
-		d.Entries = append(d.Entries, e)
+// compare_dircache_entry compares entries by path and then stage. (the order of entries in the index)
+func compare_dircache_entry(path1 []byte, stage1 int, path2 []byte, stage2 int) int {
+	if c := bytes.Compare(path1, path2); c != 0 {
+		return c
 	}
+	return stage1 - stage2
 }
 
-func find_dircache_entry(d *Dircache, path string) int {
+// dircache_name_pos searches the entry of the path and stage in sorted entries by binary search.
+// It returns the position if found. Otherwise -(position to insert)-1 is returned (same as git's index_name_pos).
+func dircache_name_pos(d *Dircache, path string, stage int) int {
 	pathb := []byte(path)
+	pos := sort.Search(len(d.Entries), func(i int) bool {
+		return compare_dircache_entry(d.Entries[i].PathName, d.Entries[i].stage(), pathb, stage) >= 0
+	})
+	if pos < len(d.Entries) && compare_dircache_entry(d.Entries[pos].PathName, d.Entries[pos].stage(), pathb, stage) == 0 {
+		return pos
+	}
+	return -pos - 1
+}
 
-	for i, d := range d.Entries {
-		if bytes.Equal(d.PathName, pathb) {
-			return i
+// find_dircache_entry returns the position of the first entry of the path in any stage. -1 if not found.
+// entries added out of order are not found until the index is written.
+func find_dircache_entry(d *Dircache, path string) int {
+	pos := dircache_name_pos(d, path, 0)
+	if pos < 0 {
+		pos = -pos - 1
+	}
+	for ; pos < len(d.Entries) && string(d.Entries[pos].PathName) == path; pos++ {
+		if d.Entries[pos].removed == false {
+			return pos
 		}
 	}
 	return -1
 }
 
+// add_dircache_entry adds the entry to the index, or replaces the entry of the same path and stage in place.
+// a merged entry (stage 0) replaces unmerged entries of the path.
+// entries which can't be appended in order are kept aside and sorted once when the index is written.
+func add_dircache_entry(d *Dircache, e *DircacheEntry) {
+	path := string(e.PathName)
+	stage := e.stage()
+
+	pos := dircache_name_pos(d, path, stage)
+	if pos >= 0 {
+		// the entry in the shared index is replaced
+		e.base_index = d.Entries[pos].base_index
+		d.Entries[pos] = e
+	} else {
+		invalidate_untracked_cache(d, path)
+		pos = -pos - 1
+		key := dircache_key{path, stage}
+		if _, ok := d.pending[key]; ok == false && pos == len(d.Entries) {
+			d.Entries = append(d.Entries, e)
+		} else {
+			if d.pending == nil {
+				d.pending = make(map[dircache_key]*DircacheEntry)
+			}
+			d.pending[key] = e
+		}
+	}
+
+	if stage == 0 {
+		for i := pos; i < len(d.Entries) && string(d.Entries[i].PathName) == path; i++ {
+			if d.Entries[i].stage() != 0 {
+				d.Entries[i].removed = true
+			}
+		}
+		for s := 1; s <= 3; s++ {
+			delete(d.pending, dircache_key{path, s})
+		}
+	}
+}
+
 func remove_dircache(d *Dircache, path string) error {
 	// already added in cache?
 	idx := find_dircache_entry(d, path)
@@ -352,8 +1305,8 @@ func remove_dircache(d *Dircache, path string) error {
 		return fmt.Errorf("%s is not found in index\n", path)
 	}
 
-	// already deleted on filesystem?
-	_, err := os.Stat(path)
+	// already deleted on filesystem? (a dangling symbolic link is not deleted)
+	_, err := os.Lstat(path)
 	if err == nil {
 		return nil
 	}
@@ -361,7 +1314,43 @@ func remove_dircache(d *Dircache, path string) error {
 		return err
 	}
 
-	d.Entries = append(d.Entries[:idx], d.Entries[idx+1:]...)
+	remove_dircache_entry(d, path)
+	return nil
+}
+
+// remove_dircache_entry removes all stages of the path from the index.
+func remove_dircache_entry(d *Dircache, path string) {
+	invalidate_untracked_cache(d, path)
+	if idx := find_dircache_entry(d, path); idx >= 0 {
+		for ; idx < len(d.Entries) && string(d.Entries[idx].PathName) == path; idx++ {
+			d.Entries[idx].removed = true
+		}
+	}
+	for s := 0; s <= 3; s++ {
+		delete(d.pending, dircache_key{path, s})
+	}
+}
+
+// mark_dircache_entry sets or clears the assume-valid and skip-worktree bits of the entry.
+func mark_dircache_entry(d *Dircache, path string, opts UpdateIndexOptions) error {
+	idx := dircache_name_pos(d, path, 0)
+	if idx < 0 || d.Entries[idx].removed {
+		return fmt.Errorf("Unable to mark file %s", path)
+	}
+	e := d.Entries[idx]
 
+	if opts.AssumeUnchanged {
+		e.Flags |= DIRCACHE_FLAG_ASSUME_VALID
+	}
+	if opts.NoAssumeUnchanged {
+		e.Flags &^= DIRCACHE_FLAG_ASSUME_VALID
+	}
+	// extended flags are written in version 3 or later
+	if opts.SkipWorktree {
+		e.ExtendedFlags |= DIRCACHE_EXTENDED_FLAG_SKIP_WORKTREE
+	}
+	if opts.NoSkipWorktree {
+		e.ExtendedFlags &^= DIRCACHE_EXTENDED_FLAG_SKIP_WORKTREE
+	}
 	return nil
 }
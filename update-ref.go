@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func update_ref_cmd(ref string, nvalue string) {
@@ -45,3 +48,59 @@ func update_ref(repo string, ref string, nvalue string) error {
 
 	return nil
 }
+
+func read_ref(repo string, ref string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(repo, ref))
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(string(b))
+
+	// symbolic ref (ex. 'ref: refs/heads/master')
+	if strings.HasPrefix(v, "ref: ") {
+		return read_ref(repo, strings.TrimPrefix(v, "ref: "))
+	}
+	return v, nil
+}
+
+func resolve_object_name(repo string, name string) (string, error) {
+	// refs
+	candidates := []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name}
+	for _, c := range candidates {
+		if c != "HEAD" && strings.HasPrefix(c, "refs/") == false {
+			continue
+		}
+		if v, err := read_ref(repo, c); err == nil {
+			return v, nil
+		}
+	}
+
+	if _, err := hex.DecodeString(name + strings.Repeat("0", len(name)%2)); err != nil || len(name) < 4 || len(name) > 40 {
+		return "", fmt.Errorf("Not a valid object name %s", name)
+	}
+	name = strings.ToLower(name)
+
+	// full object name
+	if len(name) == 40 {
+		return name, nil
+	}
+
+	// abbreviated object name
+	files, err := ioutil.ReadDir(filepath.Join(repo, "objects", name[:2]))
+	if err != nil {
+		return "", fmt.Errorf("Not a valid object name %s", name)
+	}
+	found := ""
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), name[2:]) {
+			if found != "" {
+				return "", fmt.Errorf("short SHA1 %s is ambiguous", name)
+			}
+			found = name[:2] + f.Name()
+		}
+	}
+	if found == "" {
+		return "", fmt.Errorf("Not a valid object name %s", name)
+	}
+	return found, nil
+}
@@ -0,0 +1,229 @@
+// Port of git's wildmatch.
+// See Also:
+// https://github.com/git/git/blob/master/wildmatch.c
+package main
+
+import (
+	"strings"
+)
+
+const (
+	WM_CASEFOLD = 1 << iota // case insensitive match
+	WM_PATHNAME             // '*' and '?' do not match '/'. '**' matches across directories.
+)
+
+const (
+	wm_match = iota
+	wm_nomatch
+	wm_abort_all
+	wm_abort_to_starstar
+)
+
+// wildmatch reports whether the text matches the shell glob pattern.
+func wildmatch(pattern string, text string, flags int) bool {
+	return dowild(pattern, 0, text, 0, flags) == wm_match
+}
+
+func wm_fold(c byte, flags int) byte {
+	if flags&WM_CASEFOLD != 0 && 'A' <= c && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
+func dowild(p string, pi int, t string, ti int, flags int) int {
+	for ; pi < len(p); pi, ti = pi+1, ti+1 {
+		p_ch := wm_fold(p[pi], flags)
+		if ti >= len(t) && p_ch != '*' {
+			return wm_abort_all
+		}
+
+		switch p_ch {
+		case '\\':
+			// literal match with the following character
+			pi++
+			if pi >= len(p) || wm_fold(t[ti], flags) != wm_fold(p[pi], flags) {
+				return wm_nomatch
+			}
+		case '?':
+			// match anything but '/'
+			if flags&WM_PATHNAME != 0 && t[ti] == '/' {
+				return wm_nomatch
+			}
+		case '*':
+			match_slash := false
+			pi++
+			if pi < len(p) && p[pi] == '*' {
+				prev := pi - 2
+				for pi < len(p) && p[pi] == '*' {
+					pi++
+				}
+				if flags&WM_PATHNAME == 0 {
+					// without WM_PATHNAME, '*' == '**'
+					match_slash = true
+				} else if (prev < 0 || p[prev] == '/') &&
+					(pi >= len(p) || p[pi] == '/' || (p[pi] == '\\' && pi+1 < len(p) && p[pi+1] == '/')) {
+					// "**/" matches zero directories too
+					if pi < len(p) && p[pi] == '/' && dowild(p, pi+1, t, ti, flags) == wm_match {
+						return wm_match
+					}
+					match_slash = true
+				} else {
+					// '**' which is not a whole path component is same as '*'
+					match_slash = false
+				}
+			} else {
+				match_slash = flags&WM_PATHNAME == 0
+			}
+
+			if pi >= len(p) {
+				// trailing "**" matches everything. trailing "*" matches only if there are no more slash.
+				if match_slash == false && strings.IndexByte(t[ti:], '/') >= 0 {
+					return wm_abort_to_starstar
+				}
+				return wm_match
+			} else if match_slash == false && p[pi] == '/' {
+				// one asterisk followed by a slash matches the next directory
+				slash := strings.IndexByte(t[ti:], '/')
+				if slash < 0 {
+					return wm_abort_all
+				}
+				ti += slash
+				// the slash is consumed by the top-level for loop
+				continue
+			}
+
+			for ; ti < len(t); ti++ {
+				matched := dowild(p, pi, t, ti, flags)
+				if matched != wm_nomatch {
+					if match_slash == false || matched != wm_abort_to_starstar {
+						return matched
+					}
+				} else if match_slash == false && t[ti] == '/' {
+					return wm_abort_to_starstar
+				}
+			}
+			return wm_abort_all
+		case '[':
+			n, matched, ok := match_class(p[pi:], t[ti], flags)
+			if ok == false {
+				return wm_abort_all
+			}
+			if matched == false || (flags&WM_PATHNAME != 0 && t[ti] == '/') {
+				return wm_nomatch
+			}
+			pi += n - 1
+		default:
+			if wm_fold(t[ti], flags) != p_ch {
+				return wm_nomatch
+			}
+		}
+	}
+
+	if ti < len(t) {
+		return wm_nomatch
+	}
+	return wm_match
+}
+
+// match_class matches the bracket expression at the head of p with c.
+// It returns the length of the expression, whether c is matched and whether the expression is valid.
+func match_class(p string, c byte, flags int) (int, bool, bool) {
+	i := 1
+	negated := false
+	if i < len(p) && (p[i] == '!' || p[i] == '^') {
+		negated = true
+		i++
+	}
+
+	c = wm_fold(c, flags)
+	matched := false
+	// the first ']' is a literal
+	for first := true; i < len(p); i, first = i+1, false {
+		p_ch := p[i]
+		if p_ch == ']' && first == false {
+			return i + 1, matched != negated, true
+		}
+
+		switch {
+		case p_ch == '\\':
+			i++
+			if i >= len(p) {
+				return 0, false, false
+			}
+			if wm_fold(p[i], flags) == c {
+				matched = true
+			}
+		case i+2 < len(p) && p[i+1] == '-' && p[i+2] != ']':
+			lo := wm_fold(p_ch, flags)
+			i += 2
+			if p[i] == '\\' {
+				i++
+				if i >= len(p) {
+					return 0, false, false
+				}
+			}
+			hi := wm_fold(p[i], flags)
+			if lo <= c && c <= hi {
+				matched = true
+			}
+		case p_ch == '[' && i+1 < len(p) && p[i+1] == ':':
+			end := strings.Index(p[i+2:], ":]")
+			if end < 0 {
+				// didn't find ":]", so treat like a normal set
+				if c == '[' {
+					matched = true
+				}
+				continue
+			}
+			m, ok := match_char_class(p[i+2:i+2+end], c, flags)
+			if ok == false {
+				// malformed character class name
+				return 0, false, false
+			}
+			if m {
+				matched = true
+			}
+			i += 2 + end + 1
+		default:
+			if wm_fold(p_ch, flags) == c {
+				matched = true
+			}
+		}
+	}
+	return 0, false, false
+}
+
+func match_char_class(name string, c byte, flags int) (bool, bool) {
+	is_lower := 'a' <= c && c <= 'z'
+	is_upper := 'A' <= c && c <= 'Z'
+	is_digit := '0' <= c && c <= '9'
+
+	switch name {
+	case "alnum":
+		return is_lower || is_upper || is_digit, true
+	case "alpha":
+		return is_lower || is_upper, true
+	case "blank":
+		return c == ' ' || c == '\t', true
+	case "cntrl":
+		return c < 0x20 || c == 0x7f, true
+	case "digit":
+		return is_digit, true
+	case "graph":
+		return 0x20 < c && c < 0x7f, true
+	case "lower":
+		return is_lower || (flags&WM_CASEFOLD != 0 && is_upper), true
+	case "print":
+		return 0x20 <= c && c < 0x7f, true
+	case "punct":
+		return 0x20 < c && c < 0x7f && (is_lower || is_upper || is_digit) == false, true
+	case "space":
+		return strings.IndexByte(" \t\n\r\f\v", c) >= 0, true
+	case "upper":
+		return is_upper || (flags&WM_CASEFOLD != 0 && is_lower), true
+	case "xdigit":
+		return is_digit || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F'), true
+	}
+	return false, false
+}
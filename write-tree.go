@@ -98,11 +98,33 @@ func build_tree(d *Dircache) *DirectoryEntry {
 	root.Name = "root"
 
 	for _, e := range d.Entries {
+		// intent-to-add entries are not recorded in trees
+		if e.intent_to_add() {
+			continue
+		}
 		addPath(root, string(e.PathName), e.Sha1, e.Mode)
 	}
 	return root
 }
 
+// verify_dircache reports whether the index has no unmerged entries, which can not be recorded in trees.
+// unmerged entries are reported up to 10.
+func verify_dircache(d *Dircache) bool {
+	funny := 0
+	for _, e := range d.Entries {
+		if e.stage() == 0 {
+			continue
+		}
+		funny++
+		if funny > 10 {
+			fmt.Fprintf(os.Stderr, "...\n")
+			break
+		}
+		fmt.Fprintf(os.Stderr, "%s: unmerged (%x)\n", e.PathName, e.Sha1)
+	}
+	return funny == 0
+}
+
 func print_tree(d *DirectoryEntry, nest int) {
 	for _, e := range d.Entries {
 		for i := 0; i < nest; i++ {
@@ -174,6 +196,10 @@ func write_tree_cmd() {
 		os.Exit(128)
 	}
 
+	if verify_dircache(d) == false {
+		fmt.Fprintf(os.Stderr, "fatal: git-write-tree: error building trees\n")
+		os.Exit(128)
+	}
 	t := build_tree(d)
 
 	key, err := write_tree_object(t)
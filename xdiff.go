@@ -0,0 +1,1265 @@
+// See Also:
+// https://github.com/git/git/tree/master/xdiff
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	DIFF_ALGORITHM_MYERS     = "myers"
+	DIFF_ALGORITHM_MINIMAL   = "minimal"
+	DIFF_ALGORITHM_PATIENCE  = "patience"
+	DIFF_ALGORITHM_HISTOGRAM = "histogram"
+)
+
+const (
+	XDL_MAX_COST_MIN    = 256
+	XDL_HEUR_MIN_COST   = 256
+	XDL_SNAKE_CNT       = 20
+	XDL_K_HEUR          = 4
+	XDL_MAX_EQLIMIT     = 1024
+	XDL_SIMSCAN_WINDOW  = 100
+	XDL_KPDIS_RUN       = 4
+	XDL_LINE_MAX        = math.MaxInt64
+	XDL_FUNC_LINE_MAX   = 80 // length of the function name in hunk headers
+	HISTOGRAM_MAX_CHAIN = 64
+)
+
+// XdiffOptions selects the algorithm to compare lines.
+type XdiffOptions struct {
+	Algorithm       string // DIFF_ALGORITHM_*. myers if ""
+	IndentHeuristic bool   // shift ambiguous changes to match the indentation
+}
+
+// xdfile is a file split into lines.
+// ha is the class of each line. lines in the same class have the same contents.
+// rchg marks changed lines. it has a sentinel at both ends and the i-th line is rchg[i+1].
+type xdfile struct {
+	recs [][]byte
+	ha   []int
+	rchg []bool
+	nrec int
+
+	// lines to be compared by the myers algorithm. common lines at both ends are trimmed.
+	dstart, dend int
+	rindex       []int // line numbers of the lines compared
+	hareff       []int // classes of the lines compared
+}
+
+func (f *xdfile) changed(i int) bool {
+	return f.rchg[i+1]
+}
+
+func (f *xdfile) set_changed(i int, v bool) {
+	f.rchg[i+1] = v
+}
+
+// xdenv is a pair of files to be compared.
+type xdenv struct {
+	xdf1, xdf2 xdfile
+	count1     []int // number of lines in the first file for each class
+	count2     []int
+}
+
+// xdchange is a run of changed lines. i1 and i2 are the first line in each file.
+type xdchange struct {
+	i1, i2     int
+	chg1, chg2 int
+}
+
+// split_lines splits b into lines which keep their line terminators.
+func split_lines(b []byte) [][]byte {
+	var lines [][]byte
+	for len(b) > 0 {
+		n := bytes.IndexByte(b, '\n')
+		if n < 0 {
+			n = len(b) - 1
+		}
+		lines = append(lines, b[:n+1])
+		b = b[n+1:]
+	}
+	return lines
+}
+
+// new_xdenv classifies the lines of both files.
+// classes are numbered in the order they appear in the first file and then the second.
+func new_xdenv(recs1 [][]byte, recs2 [][]byte) *xdenv {
+	env := &xdenv{}
+	classes := make(map[string]int)
+	classify := func(f *xdfile, recs [][]byte, counts *[]int) {
+		f.recs = recs
+		f.nrec = len(recs)
+		f.ha = make([]int, len(recs))
+		f.rchg = make([]bool, len(recs)+2)
+		for i, r := range recs {
+			c, ok := classes[string(r)]
+			if ok == false {
+				c = len(classes)
+				classes[string(r)] = c
+				env.count1 = append(env.count1, 0)
+				env.count2 = append(env.count2, 0)
+			}
+			f.ha[i] = c
+			(*counts)[c]++
+		}
+		f.dstart = 0
+		f.dend = len(recs) - 1
+	}
+	classify(&env.xdf1, recs1, &env.count1)
+	classify(&env.xdf2, recs2, &env.count2)
+	return env
+}
+
+// xdiff compares the lines of a and b, and returns the changes.
+func xdiff(a [][]byte, b [][]byte, opts XdiffOptions) (*xdenv, []xdchange) {
+	env := new_xdenv(a, b)
+	switch opts.Algorithm {
+	case DIFF_ALGORITHM_PATIENCE:
+		patience_diff(env, 1, env.xdf1.nrec, 1, env.xdf2.nrec)
+	case DIFF_ALGORITHM_HISTOGRAM:
+		histogram_diff(env, 1, env.xdf1.nrec, 1, env.xdf2.nrec)
+	default:
+		myers_diff(env, opts.Algorithm == DIFF_ALGORITHM_MINIMAL)
+	}
+	change_compact(&env.xdf1, &env.xdf2, opts.IndentHeuristic)
+	change_compact(&env.xdf2, &env.xdf1, opts.IndentHeuristic)
+	return env, build_script(env)
+}
+
+// build_script collects the changed lines into runs.
+func build_script(env *xdenv) []xdchange {
+	var changes []xdchange
+	i1, i2 := 0, 0
+	for i1 < env.xdf1.nrec || i2 < env.xdf2.nrec {
+		if env.xdf1.changed(i1) || env.xdf2.changed(i2) {
+			c := xdchange{i1: i1, i2: i2}
+			for ; i1 < env.xdf1.nrec && env.xdf1.changed(i1); i1++ {
+				c.chg1++
+			}
+			for ; i2 < env.xdf2.nrec && env.xdf2.changed(i2); i2++ {
+				c.chg2++
+			}
+			changes = append(changes, c)
+			continue
+		}
+		i1++
+		i2++
+	}
+	return changes
+}
+
+// bogosqrt approximates the square root of n.
+func bogosqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+// myers_diff compares the files by the myers algorithm.
+// common lines at both ends are trimmed, and lines which do not appear in the other file are marked as changed beforehand.
+func myers_diff(env *xdenv, need_min bool) {
+	trim_ends(&env.xdf1, &env.xdf2)
+	cleanup_records(env, need_min)
+
+	ndiags := len(env.xdf1.hareff) + len(env.xdf2.hareff) + 3
+	xenv := &xdalgoenv{
+		mxcost:    bogosqrt(ndiags),
+		snake_cnt: XDL_SNAKE_CNT,
+		heur_min:  XDL_HEUR_MIN_COST,
+		kvdf:      make([]int, ndiags),
+		kvdb:      make([]int, ndiags),
+		kvoff:     len(env.xdf2.hareff) + 1,
+	}
+	if xenv.mxcost < XDL_MAX_COST_MIN {
+		xenv.mxcost = XDL_MAX_COST_MIN
+	}
+	recs_cmp(&env.xdf1, 0, len(env.xdf1.hareff), &env.xdf2, 0, len(env.xdf2.hareff), need_min, xenv)
+}
+
+// myers_diff_range compares the lines line1..line1+count1-1 and line2..line2+count2-1 (1-based) by the myers algorithm.
+// it is the fallback of the patience and histogram algorithms.
+func myers_diff_range(env *xdenv, line1 int, count1 int, line2 int, count2 int) {
+	sub := new_xdenv(env.xdf1.recs[line1-1:line1-1+count1], env.xdf2.recs[line2-1:line2-1+count2])
+	myers_diff(sub, false)
+	copy(env.xdf1.rchg[line1:line1+count1], sub.xdf1.rchg[1:count1+1])
+	copy(env.xdf2.rchg[line2:line2+count2], sub.xdf2.rchg[1:count2+1])
+}
+
+func trim_ends(xdf1 *xdfile, xdf2 *xdfile) {
+	lim := xdf1.nrec
+	if xdf2.nrec < lim {
+		lim = xdf2.nrec
+	}
+	i := 0
+	for ; i < lim && xdf1.ha[i] == xdf2.ha[i]; i++ {
+	}
+	xdf1.dstart, xdf2.dstart = i, i
+
+	lim -= i
+	for i = 0; i < lim && xdf1.ha[xdf1.nrec-1-i] == xdf2.ha[xdf2.nrec-1-i]; i++ {
+	}
+	xdf1.dend = xdf1.nrec - i - 1
+	xdf2.dend = xdf2.nrec - i - 1
+}
+
+// cleanup_records discards lines which have no match in the other file, and lines which have too many matches
+// among discarded lines. discarded lines are marked as changed and the rest are compared.
+func cleanup_records(env *xdenv, need_min bool) {
+	classify := func(xdf *xdfile, counts []int) []byte {
+		dis := make([]byte, xdf.nrec+1)
+		mlim := bogosqrt(xdf.nrec)
+		if mlim > XDL_MAX_EQLIMIT {
+			mlim = XDL_MAX_EQLIMIT
+		}
+		for i := xdf.dstart; i <= xdf.dend; i++ {
+			nm := counts[xdf.ha[i]]
+			switch {
+			case nm == 0:
+				dis[i] = 0
+			case nm >= mlim && need_min == false:
+				dis[i] = 2
+			default:
+				dis[i] = 1
+			}
+		}
+		return dis
+	}
+	dis1 := classify(&env.xdf1, env.count2)
+	dis2 := classify(&env.xdf2, env.count1)
+
+	keep := func(xdf *xdfile, dis []byte) {
+		xdf.rindex = nil
+		xdf.hareff = nil
+		for i := xdf.dstart; i <= xdf.dend; i++ {
+			if dis[i] == 1 || (dis[i] == 2 && clean_mmatch(dis, i, xdf.dstart, xdf.dend) == false) {
+				xdf.rindex = append(xdf.rindex, i)
+				xdf.hareff = append(xdf.hareff, xdf.ha[i])
+			} else {
+				xdf.set_changed(i, true)
+			}
+		}
+	}
+	keep(&env.xdf1, dis1)
+	keep(&env.xdf2, dis2)
+}
+
+// clean_mmatch reports whether the line with many matches should be discarded.
+// it is discarded when it is in the middle of a run of lines which have no match.
+func clean_mmatch(dis []byte, i int, s int, e int) bool {
+	if i-s > XDL_SIMSCAN_WINDOW {
+		s = i - XDL_SIMSCAN_WINDOW
+	}
+	if e-i > XDL_SIMSCAN_WINDOW {
+		e = i + XDL_SIMSCAN_WINDOW
+	}
+
+	rdis0, rpdis0 := 0, 1
+	for r := 1; i-r >= s; r++ {
+		if dis[i-r] == 0 {
+			rdis0++
+		} else if dis[i-r] == 2 {
+			rpdis0++
+		} else {
+			break
+		}
+	}
+	if rdis0 == 0 {
+		return false
+	}
+	rdis1, rpdis1 := 0, 1
+	for r := 1; i+r <= e; r++ {
+		if dis[i+r] == 0 {
+			rdis1++
+		} else if dis[i+r] == 2 {
+			rpdis1++
+		} else {
+			break
+		}
+	}
+	if rdis1 == 0 {
+		return false
+	}
+	rdis1 += rdis0
+	rpdis1 += rpdis0
+	return rpdis1*XDL_KPDIS_RUN < rpdis1+rdis1
+}
+
+// xdalgoenv is the state of the myers algorithm.
+// kvdf and kvdb are the furthest reaching paths of the forward and backward search indexed by diagonal + kvoff.
+type xdalgoenv struct {
+	mxcost    int
+	snake_cnt int
+	heur_min  int
+	kvdf      []int
+	kvdb      []int
+	kvoff     int
+}
+
+type xdpsplit struct {
+	i1, i2         int
+	min_lo, min_hi bool
+}
+
+// recs_cmp marks the lines in the box (off1, off2)-(lim1, lim2) which are not on the shortest path as changed.
+func recs_cmp(dd1 *xdfile, off1 int, lim1 int, dd2 *xdfile, off2 int, lim2 int, need_min bool, xenv *xdalgoenv) {
+	ha1, ha2 := dd1.hareff, dd2.hareff
+
+	// shrink the box by walking through each diagonal snake
+	for ; off1 < lim1 && off2 < lim2 && ha1[off1] == ha2[off2]; off1, off2 = off1+1, off2+1 {
+	}
+	for ; off1 < lim1 && off2 < lim2 && ha1[lim1-1] == ha2[lim2-1]; lim1, lim2 = lim1-1, lim2-1 {
+	}
+
+	// if one dimension is empty, all lines in the other are changed
+	if off1 == lim1 {
+		for ; off2 < lim2; off2++ {
+			dd2.set_changed(dd2.rindex[off2], true)
+		}
+	} else if off2 == lim2 {
+		for ; off1 < lim1; off1++ {
+			dd1.set_changed(dd1.rindex[off1], true)
+		}
+	} else {
+		spl := split(ha1, off1, lim1, ha2, off2, lim2, need_min, xenv)
+		recs_cmp(dd1, off1, spl.i1, dd2, off2, spl.i2, spl.min_lo, xenv)
+		recs_cmp(dd1, spl.i1, lim1, dd2, spl.i2, lim2, spl.min_hi, xenv)
+	}
+}
+
+// split finds the middle snake of the box by searching forward from the top left and backward from the bottom right.
+// when the search is too expensive, it gives up the optimal path and splits at a good enough point.
+func split(ha1 []int, off1 int, lim1 int, ha2 []int, off2 int, lim2 int, need_min bool, xenv *xdalgoenv) xdpsplit {
+	kvdf := func(d int) *int { return &xenv.kvdf[d+xenv.kvoff] }
+	kvdb := func(d int) *int { return &xenv.kvdb[d+xenv.kvoff] }
+
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax := fmid, fmid
+	bmin, bmax := bmid, bmid
+	var spl xdpsplit
+
+	*kvdf(fmid) = off1
+	*kvdb(bmid) = lim1
+
+	for ec := 1; ; ec++ {
+		got_snake := false
+
+		// extend the diagonal domain by one
+		if fmin > dmin {
+			fmin--
+			*kvdf(fmin - 1) = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			*kvdf(fmax + 1) = -1
+		} else {
+			fmax--
+		}
+
+		for d := fmax; d >= fmin; d -= 2 {
+			var i1 int
+			if *kvdf(d - 1) >= *kvdf(d + 1) {
+				i1 = *kvdf(d - 1) + 1
+			} else {
+				i1 = *kvdf(d + 1)
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for ; i1 < lim1 && i2 < lim2 && ha1[i1] == ha2[i2]; i1, i2 = i1+1, i2+1 {
+			}
+			if i1-prev1 > xenv.snake_cnt {
+				got_snake = true
+			}
+			*kvdf(d) = i1
+			if odd && bmin <= d && d <= bmax && *kvdb(d) <= i1 {
+				return xdpsplit{i1: i1, i2: i2, min_lo: true, min_hi: true}
+			}
+		}
+
+		if bmin > dmin {
+			bmin--
+			*kvdb(bmin - 1) = XDL_LINE_MAX
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			*kvdb(bmax + 1) = XDL_LINE_MAX
+		} else {
+			bmax--
+		}
+
+		for d := bmax; d >= bmin; d -= 2 {
+			var i1 int
+			if *kvdb(d - 1) < *kvdb(d + 1) {
+				i1 = *kvdb(d - 1)
+			} else {
+				i1 = *kvdb(d + 1) - 1
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for ; i1 > off1 && i2 > off2 && ha1[i1-1] == ha2[i2-1]; i1, i2 = i1-1, i2-1 {
+			}
+			if prev1-i1 > xenv.snake_cnt {
+				got_snake = true
+			}
+			*kvdb(d) = i1
+			if odd == false && fmin <= d && d <= fmax && i1 <= *kvdf(d) {
+				return xdpsplit{i1: i1, i2: i2, min_lo: true, min_hi: true}
+			}
+		}
+
+		if need_min {
+			continue
+		}
+
+		// if the edit cost is above the heuristic trigger and we got a good snake,
+		// look for a diagonal which reached far enough
+		if got_snake && ec > xenv.heur_min {
+			best := 0
+			for d := fmax; d >= fmin; d -= 2 {
+				dd := d - fmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kvdf(d)
+				i2 := i1 - d
+				v := (i1 - off1) + (i2 - off2) - dd
+
+				if v > XDL_K_HEUR*ec && v > best &&
+					off1+xenv.snake_cnt <= i1 && i1 < lim1 &&
+					off2+xenv.snake_cnt <= i2 && i2 < lim2 {
+					for k := 1; ha1[i1-k] == ha2[i2-k]; k++ {
+						if k == xenv.snake_cnt {
+							best = v
+							spl.i1 = i1
+							spl.i2 = i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				spl.min_lo = true
+				spl.min_hi = false
+				return spl
+			}
+
+			best = 0
+			for d := bmax; d >= bmin; d -= 2 {
+				dd := d - bmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kvdb(d)
+				i2 := i1 - d
+				v := (lim1 - i1) + (lim2 - i2) - dd
+
+				if v > XDL_K_HEUR*ec && v > best &&
+					off1 < i1 && i1 <= lim1-xenv.snake_cnt &&
+					off2 < i2 && i2 <= lim2-xenv.snake_cnt {
+					for k := 0; ha1[i1+k] == ha2[i2+k]; k++ {
+						if k == xenv.snake_cnt-1 {
+							best = v
+							spl.i1 = i1
+							spl.i2 = i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				spl.min_lo = false
+				spl.min_hi = true
+				return spl
+			}
+		}
+
+		// enough is enough. take the furthest reaching path
+		if ec >= xenv.mxcost {
+			fbest, fbest1 := -1, -1
+			for d := fmax; d >= fmin; d -= 2 {
+				i1 := *kvdf(d)
+				if i1 > lim1 {
+					i1 = lim1
+				}
+				i2 := i1 - d
+				if lim2 < i2 {
+					i1, i2 = lim2+d, lim2
+				}
+				if fbest < i1+i2 {
+					fbest = i1 + i2
+					fbest1 = i1
+				}
+			}
+
+			bbest, bbest1 := XDL_LINE_MAX, XDL_LINE_MAX
+			for d := bmax; d >= bmin; d -= 2 {
+				i1 := *kvdb(d)
+				if i1 < off1 {
+					i1 = off1
+				}
+				i2 := i1 - d
+				if i2 < off2 {
+					i1, i2 = off2+d, off2
+				}
+				if i1+i2 < bbest {
+					bbest = i1 + i2
+					bbest1 = i1
+				}
+			}
+
+			if (lim1+lim2)-bbest < fbest-(off1+off2) {
+				return xdpsplit{i1: fbest1, i2: fbest - fbest1, min_lo: true, min_hi: false}
+			}
+			return xdpsplit{i1: bbest1, i2: bbest - bbest1, min_lo: false, min_hi: true}
+		}
+	}
+}
+
+// patience_entry is a line which appears in the first file of the range.
+// line2 is the line in the second file if the line is unique in both files, or -1 if it is not unique.
+type patience_entry struct {
+	line1, line2   int
+	next, previous *patience_entry
+}
+
+// patience_diff matches lines which are unique in both files, and compares the lines between them recursively.
+// line numbers are 1-based.
+func patience_diff(env *xdenv, line1 int, count1 int, line2 int, count2 int) {
+	if count1 == 0 {
+		for ; count2 > 0; count2-- {
+			env.xdf2.set_changed(line2-1, true)
+			line2++
+		}
+		return
+	} else if count2 == 0 {
+		for ; count1 > 0; count1-- {
+			env.xdf1.set_changed(line1-1, true)
+			line1++
+		}
+		return
+	}
+
+	// lines in the order of the first occurrence in the first file
+	entries := make(map[int]*patience_entry)
+	var first, last *patience_entry
+	has_matches := false
+	for i := line1; i < line1+count1; i++ {
+		ha := env.xdf1.ha[i-1]
+		if e, ok := entries[ha]; ok {
+			e.line2 = -1
+			continue
+		}
+		e := &patience_entry{line1: i}
+		entries[ha] = e
+		if first == nil {
+			first = e
+		}
+		if last != nil {
+			last.next = e
+			e.previous = last
+		}
+		last = e
+	}
+	for i := line2; i < line2+count2; i++ {
+		e, ok := entries[env.xdf2.ha[i-1]]
+		if ok == false {
+			continue
+		}
+		has_matches = true
+		if e.line2 != 0 {
+			e.line2 = -1
+		} else {
+			e.line2 = i
+		}
+	}
+
+	if has_matches == false {
+		for ; count1 > 0; count1-- {
+			env.xdf1.set_changed(line1-1, true)
+			line1++
+		}
+		for ; count2 > 0; count2-- {
+			env.xdf2.set_changed(line2-1, true)
+			line2++
+		}
+		return
+	}
+
+	if lcs := patience_longest_common_sequence(first); lcs != nil {
+		patience_walk_common_sequence(env, lcs, line1, count1, line2, count2)
+	} else {
+		myers_diff_range(env, line1, count1, line2, count2)
+	}
+}
+
+// patience_longest_common_sequence finds the longest sequence of unique lines in the same order in both files.
+func patience_longest_common_sequence(first *patience_entry) *patience_entry {
+	var sequence []*patience_entry
+	for e := first; e != nil; e = e.next {
+		if e.line2 <= 0 {
+			continue
+		}
+		// binary search for the last element whose line2 is less than e.line2
+		left, right := -1, len(sequence)
+		for left+1 < right {
+			middle := left + (right-left)/2
+			if sequence[middle].line2 > e.line2 {
+				right = middle
+			} else {
+				left = middle
+			}
+		}
+		e.previous = nil
+		if left >= 0 {
+			e.previous = sequence[left]
+		}
+		if left+1 == len(sequence) {
+			sequence = append(sequence, e)
+		} else {
+			sequence[left+1] = e
+		}
+	}
+	if len(sequence) == 0 {
+		return nil
+	}
+
+	e := sequence[len(sequence)-1]
+	e.next = nil
+	for e.previous != nil {
+		e.previous.next = e
+		e = e.previous
+	}
+	return e
+}
+
+func patience_walk_common_sequence(env *xdenv, first *patience_entry, line1 int, count1 int, line2 int, count2 int) {
+	end1, end2 := line1+count1, line2+count2
+	match := func(l1, l2 int) bool {
+		return env.xdf1.ha[l1-1] == env.xdf2.ha[l2-1]
+	}
+
+	for {
+		// grow the ranges of common lines
+		var next1, next2 int
+		if first != nil {
+			next1, next2 = first.line1, first.line2
+			for next1 > line1 && next2 > line2 && match(next1-1, next2-1) {
+				next1--
+				next2--
+			}
+		} else {
+			next1, next2 = end1, end2
+		}
+		for line1 < next1 && line2 < next2 && match(line1, line2) {
+			line1++
+			line2++
+		}
+
+		if next1 > line1 || next2 > line2 {
+			patience_diff(env, line1, next1-line1, line2, next2-line2)
+		}
+		if first == nil {
+			return
+		}
+
+		for first.next != nil && first.next.line1 == first.line1+1 && first.next.line2 == first.line2+1 {
+			first = first.next
+		}
+		line1 = first.line1 + 1
+		line2 = first.line2 + 1
+		first = first.next
+	}
+}
+
+// histogram_record is a chain of the occurrences of a line in the first file.
+type histogram_record struct {
+	ptr, cnt int
+	next     *histogram_record
+}
+
+// histindex is the occurrences of lines in the first file of the range.
+type histindex struct {
+	records    []*histogram_record // chains by hash
+	line_map   []*histogram_record // record of each line
+	next_ptrs  []int               // next occurrence of the same line
+	table_bits uint
+	ptr_shift  int
+	cnt        int
+	has_common bool
+}
+
+func hashbits(size int) uint {
+	val, bits := 1, uint(0)
+	for ; val < size && bits < 32; val, bits = val<<1, bits+1 {
+	}
+	if bits == 0 {
+		return 1
+	}
+	return bits
+}
+
+func (index *histindex) table_hash(ha int) int {
+	v := uint64(ha)
+	return int(((v >> (64 - index.table_bits)) + v) & (1<<index.table_bits - 1))
+}
+
+// histogram_diff splits the range by the longest common sequence of the lines which occur least, and compares both sides recursively.
+// line numbers are 1-based.
+func histogram_diff(env *xdenv, line1 int, count1 int, line2 int, count2 int) {
+	for {
+		if count1 <= 0 && count2 <= 0 {
+			return
+		}
+		if count1 == 0 {
+			for ; count2 > 0; count2-- {
+				env.xdf2.set_changed(line2-1, true)
+				line2++
+			}
+			return
+		} else if count2 == 0 {
+			for ; count1 > 0; count1-- {
+				env.xdf1.set_changed(line1-1, true)
+				line1++
+			}
+			return
+		}
+
+		var begin1, end1, begin2, end2 int
+		fallback := histogram_find_lcs(env, line1, count1, line2, count2, &begin1, &end1, &begin2, &end2)
+		if fallback {
+			myers_diff_range(env, line1, count1, line2, count2)
+			return
+		}
+		if begin1 == 0 && begin2 == 0 {
+			for ; count1 > 0; count1-- {
+				env.xdf1.set_changed(line1-1, true)
+				line1++
+			}
+			for ; count2 > 0; count2-- {
+				env.xdf2.set_changed(line2-1, true)
+				line2++
+			}
+			return
+		}
+
+		histogram_diff(env, line1, begin1-line1, line2, begin2-line2)
+		count1 = line1 + count1 - 1 - end1
+		line1 = end1 + 1
+		count2 = line2 + count2 - 1 - end2
+		line2 = end2 + 1
+	}
+}
+
+// histogram_find_lcs finds the longest common sequence. it reports whether the myers algorithm should be used instead,
+// which is when all common lines occur too many times.
+func histogram_find_lcs(env *xdenv, line1 int, count1 int, line2 int, count2 int, begin1, end1, begin2, end2 *int) bool {
+	index := &histindex{}
+	index.table_bits = hashbits(count1)
+	index.records = make([]*histogram_record, 1<<index.table_bits)
+	index.line_map = make([]*histogram_record, count1)
+	index.next_ptrs = make([]int, count1)
+	index.ptr_shift = line1
+
+	ha1 := func(l int) int { return env.xdf1.ha[l-1] }
+	ha2 := func(l int) int { return env.xdf2.ha[l-1] }
+	lend1, lend2 := line1+count1-1, line2+count2-1
+
+	// scan the first file from the end so that the chains are in the order of lines
+	for ptr := lend1; line1 <= ptr; ptr-- {
+		tbl_idx := index.table_hash(ha1(ptr))
+		found := false
+		chain_len := 0
+		for rec := index.records[tbl_idx]; rec != nil; rec = rec.next {
+			if ha1(rec.ptr) == ha1(ptr) {
+				index.next_ptrs[ptr-index.ptr_shift] = rec.ptr
+				rec.ptr = ptr
+				rec.cnt++
+				index.line_map[ptr-index.ptr_shift] = rec
+				found = true
+				break
+			}
+			chain_len++
+		}
+		if found {
+			continue
+		}
+		if chain_len == HISTOGRAM_MAX_CHAIN {
+			return true
+		}
+		rec := &histogram_record{ptr: ptr, cnt: 1, next: index.records[tbl_idx]}
+		index.records[tbl_idx] = rec
+		index.line_map[ptr-index.ptr_shift] = rec
+	}
+
+	index.cnt = HISTOGRAM_MAX_CHAIN + 1
+	cnt := func(ptr int) int { return index.line_map[ptr-index.ptr_shift].cnt }
+	next_ptr := func(ptr int) int { return index.next_ptrs[ptr-index.ptr_shift] }
+
+	for b_ptr := line2; b_ptr <= lend2; {
+		b_next := b_ptr + 1
+		for rec := index.records[index.table_hash(ha2(b_ptr))]; rec != nil; rec = rec.next {
+			if rec.cnt > index.cnt {
+				if index.has_common == false {
+					index.has_common = ha1(rec.ptr) == ha2(b_ptr)
+				}
+				continue
+			}
+
+			as := rec.ptr
+			if ha1(as) != ha2(b_ptr) {
+				continue
+			}
+
+			index.has_common = true
+			for {
+				np := next_ptr(as)
+				bs := b_ptr
+				ae := as
+				be := bs
+				rc := rec.cnt
+
+				for line1 < as && line2 < bs && ha1(as-1) == ha2(bs-1) {
+					as--
+					bs--
+					if 1 < rc && cnt(as) < rc {
+						rc = cnt(as)
+					}
+				}
+				for ae < lend1 && be < lend2 && ha1(ae+1) == ha2(be+1) {
+					ae++
+					be++
+					if 1 < rc && cnt(ae) < rc {
+						rc = cnt(ae)
+					}
+				}
+
+				if b_next <= be {
+					b_next = be + 1
+				}
+				if *end1-*begin1 < ae-as || rc < index.cnt {
+					*begin1, *begin2, *end1, *end2 = as, bs, ae, be
+					index.cnt = rc
+				}
+
+				if np == 0 {
+					break
+				}
+				for np != 0 && np <= ae {
+					np = next_ptr(np)
+				}
+				if np == 0 {
+					break
+				}
+				as = np
+			}
+		}
+		b_ptr = b_next
+	}
+
+	return index.has_common && HISTOGRAM_MAX_CHAIN < index.cnt
+}
+
+// xdgroup is a run of changed lines [start, end) in a file. it may be empty.
+type xdgroup struct {
+	start, end int
+}
+
+func group_init(xdf *xdfile) xdgroup {
+	g := xdgroup{}
+	for xdf.changed(g.end) {
+		g.end++
+	}
+	return g
+}
+
+// group_next moves to the next group. it returns false at the end of the file.
+func group_next(xdf *xdfile, g *xdgroup) bool {
+	if g.end == xdf.nrec {
+		return false
+	}
+	g.start = g.end + 1
+	for g.end = g.start; xdf.changed(g.end); g.end++ {
+	}
+	return true
+}
+
+func group_previous(xdf *xdfile, g *xdgroup) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	for g.start = g.end; xdf.changed(g.start - 1); g.start-- {
+	}
+	return true
+}
+
+// group_slide_down moves the group down by one line if the line after the group is the same as the first line.
+func group_slide_down(xdf *xdfile, g *xdgroup) bool {
+	if g.end < xdf.nrec && xdf.ha[g.start] == xdf.ha[g.end] {
+		xdf.set_changed(g.start, false)
+		g.start++
+		xdf.set_changed(g.end, true)
+		g.end++
+		for xdf.changed(g.end) {
+			g.end++
+		}
+		return true
+	}
+	return false
+}
+
+func group_slide_up(xdf *xdfile, g *xdgroup) bool {
+	if g.start > 0 && xdf.ha[g.start-1] == xdf.ha[g.end-1] {
+		g.start--
+		xdf.set_changed(g.start, true)
+		g.end--
+		xdf.set_changed(g.end, false)
+		for xdf.changed(g.start - 1) {
+			g.start--
+		}
+		return true
+	}
+	return false
+}
+
+// change_compact slides groups of changed lines in xdf to merge them, to align them with the changes in xdfo,
+// or to place them at the most natural indentation.
+func change_compact(xdf *xdfile, xdfo *xdfile, indent_heuristic bool) {
+	g := group_init(xdf)
+	og := group_init(xdfo)
+
+	for {
+		if g.end != g.start {
+			var groupsize, earliest_end int
+			end_matching_other := -1
+			for {
+				groupsize = g.end - g.start
+				end_matching_other = -1
+
+				// shift the group backward as much as possible
+				for group_slide_up(xdf, &g) {
+					group_previous(xdfo, &og)
+				}
+				earliest_end = g.end
+				if og.end > og.start {
+					end_matching_other = g.end
+				}
+
+				// and forward as far as possible
+				for group_slide_down(xdf, &g) {
+					group_next(xdfo, &og)
+					if og.end > og.start {
+						end_matching_other = g.end
+					}
+				}
+				if groupsize == g.end-g.start {
+					break
+				}
+			}
+
+			if g.end == earliest_end {
+				// no shifting was possible
+			} else if end_matching_other != -1 {
+				// align with the last group of changes in the other file
+				for og.end == og.start {
+					group_slide_up(xdf, &g)
+					group_previous(xdfo, &og)
+				}
+			} else if indent_heuristic {
+				shift := earliest_end
+				if g.end-groupsize-1 > shift {
+					shift = g.end - groupsize - 1
+				}
+				if g.end-INDENT_HEURISTIC_MAX_SLIDING > shift {
+					shift = g.end - INDENT_HEURISTIC_MAX_SLIDING
+				}
+				best_shift := -1
+				var best_score split_score
+				for ; shift <= g.end; shift++ {
+					score := split_score{}
+					score_add_split(measure_split(xdf, shift), &score)
+					score_add_split(measure_split(xdf, shift-groupsize), &score)
+					if best_shift == -1 || score_cmp(score, best_score) <= 0 {
+						best_score = score
+						best_shift = shift
+					}
+				}
+				for g.end > best_shift {
+					group_slide_up(xdf, &g)
+					group_previous(xdfo, &og)
+				}
+			}
+		}
+
+		if group_next(xdf, &g) == false {
+			break
+		}
+		group_next(xdfo, &og)
+	}
+}
+
+const (
+	INDENT_MAX                          = 200
+	INDENT_MAX_BLANKS                   = 20
+	INDENT_HEURISTIC_MAX_SLIDING        = 100
+	START_OF_FILE_PENALTY               = 1
+	END_OF_FILE_PENALTY                 = 21
+	TOTAL_BLANK_WEIGHT                  = -30
+	POST_BLANK_WEIGHT                   = 6
+	RELATIVE_INDENT_PENALTY             = -4
+	RELATIVE_INDENT_WITH_BLANK_PENALTY  = 10
+	RELATIVE_OUTDENT_PENALTY            = 24
+	RELATIVE_OUTDENT_WITH_BLANK_PENALTY = 17
+	RELATIVE_DEDENT_PENALTY             = 23
+	RELATIVE_DEDENT_WITH_BLANK_PENALTY  = 17
+	INDENT_WEIGHT                       = 60
+)
+
+// split_measurement describes the lines around a split between two lines.
+type split_measurement struct {
+	end_of_file bool
+	indent      int // indent of the line after the split. -1 if blank
+	pre_blank   int // blank lines before the split
+	pre_indent  int // indent of the first non-blank line before the split
+	post_blank  int // blank lines after the line after the split
+	post_indent int
+}
+
+type split_score struct {
+	effective_indent int
+	penalty          int
+}
+
+// get_indent returns the indent of the line. -1 if the line is blank.
+func get_indent(rec []byte) int {
+	ret := 0
+	for _, c := range rec {
+		switch c {
+		case ' ':
+			ret++
+		case '\t':
+			ret += 8 - ret%8
+		case '\n', '\v', '\f', '\r':
+			// ignore other whitespace characters
+		default:
+			return ret
+		}
+		if ret >= INDENT_MAX {
+			return INDENT_MAX
+		}
+	}
+	return -1
+}
+
+func measure_split(xdf *xdfile, split int) split_measurement {
+	m := split_measurement{}
+	if split >= xdf.nrec {
+		m.end_of_file = true
+		m.indent = -1
+	} else {
+		m.indent = get_indent(xdf.recs[split])
+	}
+
+	m.pre_indent = -1
+	for i := split - 1; i >= 0; i-- {
+		m.pre_indent = get_indent(xdf.recs[i])
+		if m.pre_indent != -1 {
+			break
+		}
+		m.pre_blank++
+		if m.pre_blank == INDENT_MAX_BLANKS {
+			m.pre_indent = 0
+			break
+		}
+	}
+
+	m.post_indent = -1
+	for i := split + 1; i < xdf.nrec; i++ {
+		m.post_indent = get_indent(xdf.recs[i])
+		if m.post_indent != -1 {
+			break
+		}
+		m.post_blank++
+		if m.post_blank == INDENT_MAX_BLANKS {
+			m.post_indent = 0
+			break
+		}
+	}
+	return m
+}
+
+func score_add_split(m split_measurement, s *split_score) {
+	if m.pre_indent == -1 && m.pre_blank == 0 {
+		s.penalty += START_OF_FILE_PENALTY
+	}
+	if m.end_of_file {
+		s.penalty += END_OF_FILE_PENALTY
+	}
+
+	post_blank := 0
+	if m.indent == -1 {
+		post_blank = 1 + m.post_blank
+	}
+	total_blank := m.pre_blank + post_blank
+
+	s.penalty += TOTAL_BLANK_WEIGHT * total_blank
+	s.penalty += POST_BLANK_WEIGHT * post_blank
+
+	indent := m.indent
+	if indent == -1 {
+		indent = m.post_indent
+	}
+	any_blanks := total_blank != 0
+
+	s.effective_indent += indent
+
+	switch {
+	case indent == -1 || m.pre_indent == -1 || indent == m.pre_indent:
+		// no additional adjustments needed
+	case indent > m.pre_indent:
+		if any_blanks {
+			s.penalty += RELATIVE_INDENT_WITH_BLANK_PENALTY
+		} else {
+			s.penalty += RELATIVE_INDENT_PENALTY
+		}
+	case m.post_indent != -1 && m.post_indent > indent:
+		if any_blanks {
+			s.penalty += RELATIVE_OUTDENT_WITH_BLANK_PENALTY
+		} else {
+			s.penalty += RELATIVE_OUTDENT_PENALTY
+		}
+	default:
+		if any_blanks {
+			s.penalty += RELATIVE_DEDENT_WITH_BLANK_PENALTY
+		} else {
+			s.penalty += RELATIVE_DEDENT_PENALTY
+		}
+	}
+}
+
+func score_cmp(s1 split_score, s2 split_score) int {
+	cmp_indents := 0
+	if s1.effective_indent > s2.effective_indent {
+		cmp_indents = 1
+	} else if s1.effective_indent < s2.effective_indent {
+		cmp_indents = -1
+	}
+	return INDENT_WEIGHT*cmp_indents + (s1.penalty - s2.penalty)
+}
+
+// func_line returns the line as a function name in hunk headers if it begins with an alphabet, '_' or '$'.
+func func_line(rec []byte) (string, bool) {
+	if len(rec) == 0 {
+		return "", false
+	}
+	c := rec[0]
+	if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == '$' {
+		if len(rec) > XDL_FUNC_LINE_MAX {
+			rec = rec[:XDL_FUNC_LINE_MAX]
+		}
+		return string(bytes.TrimRight(rec, " \t\n\v\f\r")), true
+	}
+	return "", false
+}
+
+// emit_unified_diff writes the changes as hunks of the unified format with ctxlen lines of context.
+func emit_unified_diff(w io.Writer, env *xdenv, changes []xdchange, ctxlen int) {
+	xdf1, xdf2 := &env.xdf1, &env.xdf2
+	emit := func(xdf *xdfile, i int, prefix string) {
+		rec := xdf.recs[i]
+		fmt.Fprintf(w, "%s%s", prefix, rec)
+		if rec[len(rec)-1] != '\n' {
+			fmt.Fprintf(w, "\n\\ No newline at end of file\n")
+		}
+	}
+
+	funcname := ""
+	funclineprev := -1
+	for h := 0; h < len(changes); {
+		// changes closer than 2 * ctxlen lines are in the same hunk
+		e := h
+		for e+1 < len(changes) && changes[e+1].i1-(changes[e].i1+changes[e].chg1) <= 2*ctxlen {
+			e++
+		}
+		xch, xche := changes[h], changes[e]
+
+		s1 := xch.i1 - ctxlen
+		if s1 < 0 {
+			s1 = 0
+		}
+		s2 := xch.i2 - ctxlen
+		if s2 < 0 {
+			s2 = 0
+		}
+		lctx := ctxlen
+		if n := xdf1.nrec - (xche.i1 + xche.chg1); n < lctx {
+			lctx = n
+		}
+		if n := xdf2.nrec - (xche.i2 + xche.chg2); n < lctx {
+			lctx = n
+		}
+		e1 := xche.i1 + xche.chg1 + lctx
+		e2 := xche.i2 + xche.chg2 + lctx
+
+		// the function name is searched backward from the line before the hunk to the previous hunk
+		for l := s1 - 1; l != funclineprev && l >= 0; l-- {
+			if f, ok := func_line(xdf1.recs[l]); ok {
+				funcname = f
+				break
+			}
+		}
+		funclineprev = s1 - 1
+
+		fmt.Fprintf(w, "@@ -%s +%s @@", hunk_range(s1+1, e1-s1), hunk_range(s2+1, e2-s2))
+		if len(funcname) > 0 {
+			fmt.Fprintf(w, " %s", funcname)
+		}
+		fmt.Fprintf(w, "\n")
+
+		for ; s2 < xch.i2; s2++ {
+			emit(xdf2, s2, " ")
+		}
+		for i := h; i <= e; i++ {
+			c := changes[i]
+			if i > h {
+				prev := changes[i-1]
+				for s2 = prev.i2 + prev.chg2; s2 < c.i2; s2++ {
+					emit(xdf2, s2, " ")
+				}
+			}
+			for s1 = c.i1; s1 < c.i1+c.chg1; s1++ {
+				emit(xdf1, s1, "-")
+			}
+			for s2 = c.i2; s2 < c.i2+c.chg2; s2++ {
+				emit(xdf2, s2, "+")
+			}
+		}
+		for s2 = xche.i2 + xche.chg2; s2 < e2; s2++ {
+			emit(xdf2, s2, " ")
+		}
+
+		h = e + 1
+	}
+}
+
+// hunk_range formats the start line and the number of lines of a hunk. the count is omitted if it is 1.
+func hunk_range(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
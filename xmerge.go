@@ -0,0 +1,297 @@
+// See Also:
+// https://github.com/git/git/blob/master/xdiff/xmerge.c
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+const (
+	DEFAULT_CONFLICT_MARKER_SIZE = 7
+
+	XDL_MERGE_MINIMAL = 0
+	XDL_MERGE_EAGER   = 1
+	XDL_MERGE_ZEALOUS = 2 // the level used by the default merge driver
+)
+
+// xdmerge is a region changed by either or both sides.
+// i1/chg1 and i2/chg2 point at the postimage of each side, and i0/chg0 at the common ancestor.
+type xdmerge struct {
+	mode     int // 0: conflict, 1: take side #1, 2: take side #2, 4: both sides made the same change
+	i0, chg0 int
+	i1, chg1 int
+	i2, chg2 int
+}
+
+// MergeFile is a side of the three-way merge.
+type MergeFile struct {
+	Data  []byte
+	Label string // shown after the conflict markers
+}
+
+// xdl_merge merges the changes from orig to mf1 and from orig to mf2.
+// conflicting regions are shown with conflict markers. It returns the result and the number of conflicts.
+func xdl_merge(orig MergeFile, mf1 MergeFile, mf2 MergeFile, level int) ([]byte, int) {
+	recs0 := split_lines(orig.Data)
+	xe1, xscr1 := xdiff(recs0, split_lines(mf1.Data), XdiffOptions{})
+	xe2, xscr2 := xdiff(recs0, split_lines(mf2.Data), XdiffOptions{})
+	if len(xscr1) == 0 {
+		return mf2.Data, 0
+	}
+	if len(xscr2) == 0 {
+		return mf1.Data, 0
+	}
+
+	var changes []*xdmerge
+	for len(xscr1) > 0 && len(xscr2) > 0 {
+		x1, x2 := xscr1[0], xscr2[0]
+		if x1.i1+x1.chg1 < x2.i1 {
+			changes = xdl_append_merge(changes, 1, x1.i1, x1.chg1, x1.i2, x1.chg2, x2.i2-x2.i1+x1.i1, x1.chg1)
+			xscr1 = xscr1[1:]
+			continue
+		}
+		if x2.i1+x2.chg1 < x1.i1 {
+			changes = xdl_append_merge(changes, 2, x2.i1, x2.chg1, x1.i2-x1.i1+x2.i1, x2.chg1, x2.i2, x2.chg2)
+			xscr2 = xscr2[1:]
+			continue
+		}
+		if level == XDL_MERGE_MINIMAL || x1.i1 != x2.i1 || x1.chg1 != x2.chg1 || x1.chg2 != x2.chg2 ||
+			xdl_merge_cmp_lines(xe1, x1.i2, xe2, x2.i2, x1.chg2) == false {
+			// conflict. the region covers both changes
+			off := x1.i1 - x2.i1
+			ffo := off + x1.chg1 - x2.chg1
+			i0, i1, i2 := x1.i1, x1.i2, x2.i2
+			if off > 0 {
+				i0 -= off
+				i1 -= off
+			} else {
+				i2 += off
+			}
+			chg0 := x1.i1 + x1.chg1 - i0
+			chg1 := x1.i2 + x1.chg2 - i1
+			chg2 := x2.i2 + x2.chg2 - i2
+			if ffo < 0 {
+				chg0 -= ffo
+				chg1 -= ffo
+			} else {
+				chg2 += ffo
+			}
+			changes = xdl_append_merge(changes, 0, i0, chg0, i1, chg1, i2, chg2)
+		}
+
+		i1 := x1.i1 + x1.chg1
+		i2 := x2.i1 + x2.chg1
+		if i1 >= i2 {
+			xscr2 = xscr2[1:]
+		}
+		if i2 >= i1 {
+			xscr1 = xscr1[1:]
+		}
+	}
+	for _, x1 := range xscr1 {
+		changes = xdl_append_merge(changes, 1, x1.i1, x1.chg1, x1.i2, x1.chg2, x1.i1+xe2.xdf2.nrec-xe2.xdf1.nrec, x1.chg1)
+	}
+	for _, x2 := range xscr2 {
+		changes = xdl_append_merge(changes, 2, x2.i1, x2.chg1, x2.i1+xe1.xdf2.nrec-xe1.xdf1.nrec, x2.chg1, x2.i2, x2.chg2)
+	}
+
+	if level >= XDL_MERGE_ZEALOUS {
+		changes = xdl_refine_conflicts(xe1, xe2, changes)
+		changes = xdl_simplify_non_conflicts(changes)
+	}
+
+	conflicts := 0
+	for _, m := range changes {
+		if m.mode == 0 {
+			conflicts++
+		}
+	}
+	return xdl_fill_merge_buffer(xe1, mf1.Label, xe2, mf2.Label, changes), conflicts
+}
+
+// xdl_append_merge adds the region, or extends the last region if they touch each other.
+func xdl_append_merge(changes []*xdmerge, mode int, i0 int, chg0 int, i1 int, chg1 int, i2 int, chg2 int) []*xdmerge {
+	if len(changes) > 0 {
+		m := changes[len(changes)-1]
+		if i1 <= m.i1+m.chg1 || i2 <= m.i2+m.chg2 {
+			if mode != m.mode {
+				m.mode = 0
+			}
+			m.chg0 = i0 + chg0 - m.i0
+			m.chg1 = i1 + chg1 - m.i1
+			m.chg2 = i2 + chg2 - m.i2
+			return changes
+		}
+	}
+	return append(changes, &xdmerge{mode: mode, i0: i0, chg0: chg0, i1: i1, chg1: chg1, i2: i2, chg2: chg2})
+}
+
+// xdl_merge_cmp_lines reports whether both sides have the same lines in the regions.
+func xdl_merge_cmp_lines(xe1 *xdenv, i1 int, xe2 *xdenv, i2 int, line_count int) bool {
+	for i := 0; i < line_count; i++ {
+		if bytes.Equal(xe1.xdf2.recs[i1+i], xe2.xdf2.recs[i2+i]) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// xdl_refine_conflicts compares both sides of each conflict, and narrows the conflict to the lines which differ.
+// a conflict whose sides are the same is resolved.
+func xdl_refine_conflicts(xe1 *xdenv, xe2 *xdenv, changes []*xdmerge) []*xdmerge {
+	var refined []*xdmerge
+	for _, m := range changes {
+		// no sense refining a conflict when one side is empty
+		if m.mode != 0 || m.chg1 == 0 || m.chg2 == 0 {
+			refined = append(refined, m)
+			continue
+		}
+
+		_, xscr := xdiff(xe1.xdf2.recs[m.i1:m.i1+m.chg1], xe2.xdf2.recs[m.i2:m.i2+m.chg2], XdiffOptions{})
+		if len(xscr) == 0 {
+			// the changes are identical
+			m.mode = 4
+			refined = append(refined, m)
+			continue
+		}
+		i1, i2 := m.i1, m.i2
+		for k, x := range xscr {
+			if k > 0 {
+				m = &xdmerge{mode: 0}
+			}
+			m.i1 = x.i1 + i1
+			m.chg1 = x.chg1
+			m.i2 = x.i2 + i2
+			m.chg2 = x.chg2
+			refined = append(refined, m)
+		}
+	}
+	return refined
+}
+
+// xdl_simplify_non_conflicts moves 3 or less lines between conflicts into the conflicts,
+// because it takes as many lines as showing them outside.
+func xdl_simplify_non_conflicts(changes []*xdmerge) []*xdmerge {
+	if len(changes) == 0 {
+		return changes
+	}
+	simplified := []*xdmerge{changes[0]}
+	for _, next := range changes[1:] {
+		m := simplified[len(simplified)-1]
+		begin := m.i1 + m.chg1
+		end := next.i1
+		if m.mode != 0 || next.mode != 0 || end-begin > 3 {
+			simplified = append(simplified, next)
+			continue
+		}
+		m.chg1 = next.i1 + next.chg1 - m.i1
+		m.chg2 = next.i2 + next.chg2 - m.i2
+	}
+	return simplified
+}
+
+// xdl_fill_merge_buffer builds the result on the postimage of side #1.
+func xdl_fill_merge_buffer(xe1 *xdenv, name1 string, xe2 *xdenv, name2 string, changes []*xdmerge) []byte {
+	var b bytes.Buffer
+	i := 0
+	for _, m := range changes {
+		switch {
+		case m.mode == 0:
+			fill_conflict_hunk(&b, xe1, name1, xe2, name2, i, m)
+		case m.mode&3 != 0:
+			xdl_recs_copy(&b, xe1.xdf2.recs[i:m.i1], false, false)
+			if m.mode&1 != 0 {
+				xdl_recs_copy(&b, xe1.xdf2.recs[m.i1:m.i1+m.chg1], is_cr_needed(xe1, xe2, m), m.mode&2 != 0)
+			}
+			if m.mode&2 != 0 {
+				xdl_recs_copy(&b, xe2.xdf2.recs[m.i2:m.i2+m.chg2], false, false)
+			}
+		default:
+			continue
+		}
+		i = m.i1 + m.chg1
+	}
+	xdl_recs_copy(&b, xe1.xdf2.recs[i:], false, false)
+	return b.Bytes()
+}
+
+// fill_conflict_hunk writes the lines before the conflict and the conflict with markers.
+func fill_conflict_hunk(b *bytes.Buffer, xe1 *xdenv, name1 string, xe2 *xdenv, name2 string, i int, m *xdmerge) {
+	eol := "\n"
+	if is_cr_needed(xe1, xe2, m) {
+		eol = "\r\n"
+	}
+	marker := func(c string, name string) {
+		b.WriteString(strings.Repeat(c, DEFAULT_CONFLICT_MARKER_SIZE))
+		if len(name) > 0 {
+			b.WriteString(" " + name)
+		}
+		b.WriteString(eol)
+	}
+
+	xdl_recs_copy(b, xe1.xdf2.recs[i:m.i1], false, false)
+	marker("<", name1)
+	xdl_recs_copy(b, xe1.xdf2.recs[m.i1:m.i1+m.chg1], eol == "\r\n", true)
+	marker("=", "")
+	xdl_recs_copy(b, xe2.xdf2.recs[m.i2:m.i2+m.chg2], eol == "\r\n", true)
+	marker(">", name2)
+}
+
+// xdl_recs_copy writes the lines. with add_nl, the missing newline of the last line is added.
+func xdl_recs_copy(b *bytes.Buffer, recs [][]byte, needs_cr bool, add_nl bool) {
+	if len(recs) == 0 {
+		return
+	}
+	for _, r := range recs {
+		b.Write(r)
+	}
+	if last := recs[len(recs)-1]; add_nl && (len(last) == 0 || last[len(last)-1] != '\n') {
+		if needs_cr {
+			b.WriteByte('\r')
+		}
+		b.WriteByte('\n')
+	}
+}
+
+// is_eol_crlf returns 1 if the i-th line ends with CRLF, 0 if it ends with LF and -1 if unknown.
+// the last line without newline is decided by the previous line.
+func is_eol_crlf(file *xdfile, i int) int {
+	crlf := func(r []byte) int {
+		if len(r) > 1 && r[len(r)-2] == '\r' {
+			return 1
+		}
+		return 0
+	}
+	if i < file.nrec-1 {
+		return crlf(file.recs[i])
+	}
+	if file.nrec == 0 {
+		return -1
+	}
+	if r := file.recs[i]; len(r) > 0 && r[len(r)-1] == '\n' {
+		return crlf(r)
+	}
+	if i == 0 {
+		return -1
+	}
+	return crlf(file.recs[i-1])
+}
+
+// is_cr_needed reports whether the conflict markers end with CRLF. they follow the lines around the conflict.
+func is_cr_needed(xe1 *xdenv, xe2 *xdenv, m *xdmerge) bool {
+	prev := func(i int) int {
+		if i > 0 {
+			return i - 1
+		}
+		return 0
+	}
+	needs_cr := is_eol_crlf(&xe1.xdf2, prev(m.i1))
+	if needs_cr != 0 {
+		needs_cr = is_eol_crlf(&xe2.xdf2, prev(m.i2))
+	}
+	if needs_cr != 0 {
+		needs_cr = is_eol_crlf(&xe1.xdf1, 0)
+	}
+	return needs_cr > 0
+}